@@ -1,24 +1,85 @@
 package main
 
 import (
-	"crypto/tls"
+	"errors"
 	"fmt"
 	"log"
 	"net/http"
 	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
 
+	jsoniter "github.com/json-iterator/go"
+
+	"github.com/neosperience/shipper/common"
+	"github.com/neosperience/shipper/credentials"
+	"github.com/neosperience/shipper/diff"
+	"github.com/neosperience/shipper/environments"
+	"github.com/neosperience/shipper/history"
+	"github.com/neosperience/shipper/logging"
+	"github.com/neosperience/shipper/metrics"
+	"github.com/neosperience/shipper/result"
 	"github.com/neosperience/shipper/targets"
 	azure_target "github.com/neosperience/shipper/targets/azure"
 	bitbucket_target "github.com/neosperience/shipper/targets/bitbucket"
+	codecommit_target "github.com/neosperience/shipper/targets/codecommit"
+	filesystem_target "github.com/neosperience/shipper/targets/filesystem"
+	gerrit_target "github.com/neosperience/shipper/targets/gerrit"
+	git_target "github.com/neosperience/shipper/targets/git"
 	gitea_target "github.com/neosperience/shipper/targets/gitea"
 	github_target "github.com/neosperience/shipper/targets/github"
 	gitlab_target "github.com/neosperience/shipper/targets/gitlab"
+	"github.com/neosperience/shipper/templater"
 	helm_templater "github.com/neosperience/shipper/templater/helm"
 	json_templater "github.com/neosperience/shipper/templater/json"
 	kustomize_templater "github.com/neosperience/shipper/templater/kustomize"
+	"github.com/neosperience/shipper/tracing"
 	"github.com/urfave/cli/v2"
 )
 
+// version is set at build time
+var version = "dev"
+
+// repositoryFlags are the flags identifying the repository of each target, for logging
+var repositoryFlags = map[string]string{
+	"gitlab":           "gitlab-project",
+	"github":           "github-project",
+	"gitea":            "gitea-project",
+	"bitbucket-cloud":  "bitbucket-project",
+	"bitbucket-server": "bitbucket-server-project",
+	"azure":            "azure-repository-id",
+	"git":              "git-endpoint",
+	"codecommit":       "codecommit-repository",
+	"gerrit":           "gerrit-project",
+	"filesystem":       "filesystem-root",
+}
+
+// runResult is the outcome of the run, reported with the exit code and the result file
+var runResult = &result.Result{}
+
+// detailedExitCode makes runs without changes exit with result.CodeNoChanges instead of success
+var detailedExitCode bool
+
+// exitHandlers are called in reverse order with the error shipper exits with, including early exits by check and assert
+var exitHandlers []func(err error)
+
+func onExit(handler func(err error)) {
+	exitHandlers = append(exitHandlers, handler)
+}
+
+// exit finishes the run, logging err if any, and exits with the code matching the outcome
+func exit(err error) {
+	code := runResult.Finish(err, detailedExitCode)
+	for index := len(exitHandlers) - 1; index >= 0; index-- {
+		exitHandlers[index](err)
+	}
+	if err != nil {
+		logging.Error("Fatal error", "error", err, "category", code.Category())
+	}
+	os.Exit(int(code))
+}
+
 func oneOrMany[T any](arr []T, index int) T {
 	if len(arr) == 1 {
 		return arr[0]
@@ -26,85 +87,761 @@ func oneOrMany[T any](arr []T, index int) T {
 	return arr[index]
 }
 
-func app(c *cli.Context) error {
-	// Modify default HTTP client transport to not check for certificates if asked to do so
-	insecureCert := c.Bool("no-verify-tls")
-	if insecureCert {
-		http.DefaultTransport.(*http.Transport).TLSClientConfig = &tls.Config{InsecureSkipVerify: true}
+// action runs a command, classifying the error it fails with
+func action(command string, run func(c *cli.Context, s *session) error) cli.ActionFunc {
+	return func(c *cli.Context) error {
+		s, err := setup(c, command)
+		if err == nil {
+			err = run(c, s)
+		}
+		if err != nil {
+			// Classify errors here, so they can be told apart from usage errors reported by the CLI library
+			return result.WithCode(result.CodeOf(err), err)
+		}
+		return nil
+	}
+}
+
+// withDefaultCommand makes "deploy" the command to run if none is specified, so invocations predating
+// commands keep working
+func withDefaultCommand(args []string) []string {
+	if len(args) > 1 {
+		switch first := args[1]; {
+		case !strings.HasPrefix(first, "-"):
+			return args
+		case first == "-h" || first == "--help" || first == "-v" || first == "--version":
+			return args
+		}
 	}
+	return append([]string{args[0], "deploy"}, args[1:]...)
+}
 
-	// Create payload
-	payload := targets.NewPayload(c.String("repo-branch"), c.String("commit-author"), c.String("commit-message"))
+// session is what commands share once options are parsed: the target repository and telemetry
+type session struct {
+	target     string
+	repository targets.Repository
+	// connect returns other repositories of the target, by the value of its repository option
+	connect environments.Connector
+	tracer  *tracing.Tracer
+	metrics *metrics.Metrics
+}
+
+// setup configures logging, telemetry and credentials, and connects to the target repository
+func setup(c *cli.Context, command string) (*session, error) {
+	// Report the outcome of the run
+	target := c.String("repo-kind")
+	runResult.Command = command
+	runResult.Templater = c.String("templater")
+	runResult.Target = target
+	runResult.Repository = c.String(repositoryFlags[target])
+	runResult.Branch = c.String("repo-branch")
+	detailedExitCode = c.Bool("detailed-exit-code")
+	if resultFile := c.String("result-file"); resultFile != "" {
+		onExit(func(err error) {
+			if err := runResult.WriteFile(resultFile); err != nil {
+				logging.Error("error writing result file", "file", resultFile, "error", err)
+			}
+		})
+	}
+
+	// Setup logging before anything else is logged, so it uses the requested format
+	level, err := logging.ParseLevel(c.String("log-level"))
+	check(err, "Error parsing log level")
+	format, err := logging.ParseFormat(c.String("log-format"))
+	check(err, "Error parsing log format")
+	logger := logging.New(os.Stderr, level, format)
+	logging.SetDefault(logger)
+	log.SetFlags(0)
+	log.SetOutput(logger.Writer(logging.LevelInfo))
+
+	// Setup tracing, the run span covers everything up to the commit
+	tracer, err := setupTracing(c)
+	check(err, "Error setting up tracing")
+	run := tracer.Start("shipper "+command,
+		"templater", c.String("templater"),
+		"target", c.String("repo-kind"),
+		"branch", c.String("repo-branch"),
+	)
+	onExit(func(err error) {
+		run.Finish(err)
+		if err := tracer.Flush(); err != nil {
+			logging.Warn("error exporting traces", "error", err)
+		}
+	})
+
+	// Setup metrics, recording the outcome of deployments once they're done
+	runMetrics, publishMetrics, err := setupMetrics(c)
+	check(err, "Error setting up metrics")
+	onExit(func(err error) {
+		if command == "deploy" || command == "promote" || command == "rollback" {
+			outcome := metrics.OutcomeSuccess
+			switch runResult.Status {
+			case result.StatusNoChanges:
+				outcome = metrics.OutcomeNoChanges
+			case result.StatusFailed:
+				outcome = metrics.OutcomeFailure
+			}
+			environment, app := deploymentLabels(c, command)
+			runMetrics.RecordDeployment(target, environment, app, outcome)
+		}
+		if err := publishMetrics(); err != nil {
+			logging.Warn("error publishing metrics", "error", err)
+		}
+	})
+
+	// Build the HTTP clients used to reach the target and Vault with their own TLS and proxy settings
+	var harRecorder *common.HARRecorder
+	if harFile := c.String("debug-http-har"); harFile != "" {
+		harRecorder = common.NewHARRecorder("shipper", version)
+		onExit(func(err error) {
+			if err := harRecorder.WriteFile(harFile); err != nil {
+				logging.Error("error writing HAR file", "file", harFile, "error", err)
+			}
+		})
+	}
+	middlewares := func(provider string) []common.Middleware {
+		list := []common.Middleware{
+			common.UserAgent("shipper/" + version),
+			common.RedactErrors(),
+			common.RetryNotify(c.Int("http-retries"), time.Second, runMetrics.RetryNotifier(provider)),
+			runMetrics.Middleware(provider),
+			tracer.Middleware(),
+		}
+		if c.Bool("debug-http") {
+			list = append(list, common.DebugHTTP(logging.Logf(logging.LevelInfo), common.DefaultDebugBodyLimit))
+		}
+		if harRecorder != nil {
+			list = append(list, harRecorder.Middleware())
+		}
+		return list
+	}
+	clientOptions := common.ClientOptions{
+		CABundle:           c.String("tls-ca-bundle"),
+		ClientCertificate:  c.String("tls-client-cert"),
+		ClientKey:          c.String("tls-client-key"),
+		MinTLSVersion:      c.String("tls-min-version"),
+		InsecureSkipVerify: c.Bool("no-verify-tls"),
+		ProxyURL:           c.String("proxy"),
+		NoProxy:            c.String("no-proxy"),
+		Middlewares:        middlewares(target),
+	}
+	client, err := common.NewHTTPClient(clientOptions)
+	check(err, "Error setting up HTTP client")
+
+	// Setup Vault for credentials stored there
+	if address := c.String("vault-addr"); address != "" {
+		// Vault shares proxy settings with the target but has its own trust store
+		vaultOptions := clientOptions
+		vaultOptions.CABundle = c.String("vault-ca-bundle")
+		vaultOptions.ClientCertificate = ""
+		vaultOptions.ClientKey = ""
+		vaultOptions.Middlewares = middlewares("vault")
+		vaultClient, err := common.NewHTTPClient(vaultOptions)
+		check(err, "Error setting up Vault HTTP client")
+
+		credentials.ConfigureVault(credentials.VaultConfig{
+			Client:    vaultClient,
+			Address:   address,
+			Namespace: c.String("vault-namespace"),
+			Token:     secret(c, "vault-token", address, credentials.Token),
+			RoleID:    c.String("vault-role-id"),
+			SecretID:  secret(c, "vault-secret-id", address, credentials.Token),
+			JWT:       secret(c, "vault-jwt", address, credentials.Token),
+			Role:      c.String("vault-role"),
+			AuthMount: c.String("vault-auth-mount"),
+		})
+	}
 
 	// Get target repository interface
+	assert(target == "filesystem" || c.String("repo-branch") != "", "Repository branch must be specified")
+	logging.SetDefault(logger.With("target", target, "repository", runResult.Repository, "branch", runResult.Branch))
+	repository, err := connect(c, target, runResult.Repository, client)
+	if err != nil {
+		return nil, err
+	}
+
+	// Other repositories of the same target share credentials and connection options
+	repositories := map[string]targets.Repository{"": tracing.WrapRepository(tracer, repository)}
+	return &session{
+		target:     target,
+		repository: repositories[""],
+		connect: func(id string) (targets.Repository, error) {
+			if repository, ok := repositories[id]; ok {
+				return repository, nil
+			}
+			repository, err := connect(c, target, id, client)
+			if err != nil {
+				return nil, err
+			}
+			repositories[id] = tracing.WrapRepository(tracer, repository)
+			return repositories[id], nil
+		},
+		tracer:  tracer,
+		metrics: runMetrics,
+	}, nil
+}
+
+// connect creates the repository interface of a target, id is the value of the option identifying the
+// repository (see repositoryFlags)
+func connect(c *cli.Context, target string, id string, client *http.Client) (targets.Repository, error) {
 	var repository targets.Repository
-	target := c.String("repo-kind")
 	switch target {
 	case "gitlab":
 		uri := c.String("gitlab-endpoint")
 		assert(uri != "", "Gitlab endpoint must be specified when using Gitlab")
 
-		project := c.String("gitlab-project")
+		project := id
 		assert(project != "", "Gitlab project ID must be specified when using Gitlab")
 
-		apikey := c.String("gitlab-key")
+		apikey := secret(c, "gitlab-key", uri, credentials.Token)
+		tokenKind, err := gitlab_target.ParseTokenKind(c.String("gitlab-token-kind"))
+		check(err, "Error parsing Gitlab token kind")
+
+		// Job tokens can't push commits on most GitLab versions, so they are only used when asked to
+		if apikey == "" && c.Bool("gitlab-job-token") {
+			apikey = os.Getenv("CI_JOB_TOKEN")
+			assert(apikey != "", "CI_JOB_TOKEN must be set when using --gitlab-job-token")
+			tokenKind = gitlab_target.TokenJob
+		}
 		assert(apikey != "", "Gitlab API key must be specified when using Gitlab")
 
-		repository = gitlab_target.NewAPIClient(uri, project, apikey)
+		gitlab := gitlab_target.NewAPIClient(uri, project, apikey, client)
+		gitlab.SetTokenKind(tokenKind)
+		repository = gitlab
 	case "github":
 		uri := c.String("github-endpoint")
 		assert(uri != "", "GitHub endpoint must be specified when using GitHub")
 
-		project := c.String("github-project")
+		project := id
 		assert(project != "", "GitHub project ID must be specified when using GitHub")
 
-		apikey := c.String("github-key")
+		if appID := c.String("github-app-id"); appID != "" {
+			privateKey := c.String("github-app-key")
+			assert(privateKey != "", "GitHub App private key must be specified when using GitHub App authentication")
+
+			keyData, err := readPEM(privateKey)
+			check(err, "Error reading GitHub App private key")
+
+			github, err := github_target.NewAppClient(uri, project, appID, keyData, c.Int64("github-app-installation-id"), client)
+			check(err, "Error setting up GitHub App authentication")
+			repository = github
+			break
+		}
+
+		apikey := secret(c, "github-key", uri, credentials.UserPassword)
 		assert(apikey != "", "GitHub credentials must be specified when using GitHub")
 
-		repository = github_target.NewAPIClient(uri, project, apikey)
+		repository = github_target.NewAPIClient(uri, project, apikey, client)
 	case "gitea":
 		uri := c.String("gitea-endpoint")
 		assert(uri != "", "Gitea endpoint must be specified when using Gitea")
 
-		project := c.String("gitea-project")
+		project := id
 		assert(project != "", "Gitea project ID must be specified when using Gitea")
 
-		apikey := c.String("gitea-key")
+		apikey := secret(c, "gitea-key", uri, credentials.UserPassword)
 		assert(apikey != "", "Gitea credentials must be specified when using Gitea")
 
-		repository = gitea_target.NewAPIClient(uri, project, apikey)
+		repository = gitea_target.NewAPIClient(uri, project, apikey, client)
 	case "bitbucket-cloud":
-		credentials := c.String("bitbucket-key")
-		assert(credentials != "", "Bitbucket cloud credentials must be specified when using Bitbucket cloud")
+		uri := c.String("bitbucket-endpoint")
+		assert(uri != "", "Bitbucket cloud endpoint must be specified when using Bitbucket cloud")
+
+		key := secret(c, "bitbucket-key", uri, credentials.UserPassword)
+		assert(key != "", "Bitbucket cloud credentials must be specified when using Bitbucket cloud")
 
-		project := c.String("bitbucket-project")
+		project := id
 		assert(project != "", "Bitbucket project path must be specified when using Bitbucket cloud")
 
-		repository = bitbucket_target.NewCloudAPIClient(project, credentials)
-	case "azure":
-		credentials := c.String("azure-key")
-		assert(credentials != "", "Azure credentials must be specified when using Azure")
+		repository = bitbucket_target.NewCloudAPIClient(uri, project, key, client)
+	case "bitbucket-server":
+		uri := c.String("bitbucket-server-endpoint")
+		assert(uri != "", "Bitbucket server endpoint must be specified when using Bitbucket server")
+
+		project := id
+		assert(project != "", "Bitbucket server project path must be specified when using Bitbucket server")
 
+		token := secret(c, "bitbucket-server-key", uri, credentials.Token)
+		assert(token != "", "Bitbucket server access token must be specified when using Bitbucket server")
+
+		repository = bitbucket_target.NewServerAPIClient(uri, project, token, client)
+	case "azure":
 		projectID := c.String("azure-project-id")
 		assert(projectID != "", "Azure DevOps Project ID must be specified when using Azure")
 
-		repositoryID := c.String("azure-repository-id")
+		repositoryID := id
 		assert(repositoryID != "", "Azure DevOps repository ID must be specified when using Azure")
 
-		repository = azure_target.NewAPIClient(projectID, repositoryID, credentials)
+		uri := c.String("azure-endpoint")
+		assert(uri != "", "Azure DevOps endpoint must be specified when using Azure")
+
+		// On Azure DevOps Server, the collection takes the place of the organization
+		if collection := c.String("azure-collection"); collection != "" {
+			projectID = collection + "/" + projectID
+		}
+
+		var azure *azure_target.AzureRepository
+		if clientID := c.String("azure-client-id"); clientID != "" {
+			tenantID := c.String("azure-tenant-id")
+			assert(tenantID != "" || c.String("azure-token-endpoint") != "", "Azure tenant ID must be specified when using a service principal")
+
+			principal := azure_target.ServicePrincipal{
+				TenantID:      tenantID,
+				ClientID:      clientID,
+				ClientSecret:  secret(c, "azure-client-secret", principalEndpoint(c), credentials.Token),
+				TokenEndpoint: c.String("azure-token-endpoint"),
+			}
+			var err error
+			if certificate := c.String("azure-client-certificate"); certificate != "" {
+				principal.Certificate, err = readPEM(certificate)
+				check(err, "Error reading Azure client certificate")
+			}
+
+			azure, err = azure_target.NewServicePrincipalClient(uri, projectID, repositoryID, principal, client)
+			check(err, "Error setting up Azure service principal authentication")
+		} else {
+			key := secret(c, "azure-key", uri, credentials.UserPassword)
+			assert(key != "", "Azure credentials must be specified when using Azure")
+
+			azure = azure_target.NewAPIClient(uri, projectID, repositoryID, key, client)
+		}
+		azure.SetAPIVersion(c.String("azure-api-version"))
+		repository = azure
+	case "git":
+		uri := id
+		assert(uri != "", "Git repository URL must be specified when using Git")
+
+		token := secret(c, "git-token", uri, credentials.Token)
+		if token != "" {
+			repository = git_target.NewSmartHTTPTokenClient(uri, token, client)
+		} else {
+			repository = git_target.NewSmartHTTPClient(uri, secret(c, "git-key", uri, credentials.UserPassword), client)
+		}
+	case "codecommit":
+		repositoryName := id
+		assert(repositoryName != "", "CodeCommit repository name must be specified when using CodeCommit")
+
+		profile := c.String("codecommit-profile")
+		region := c.String("codecommit-region")
+		if region == "" {
+			var err error
+			region, err = codecommit_target.LoadRegion(profile)
+			check(err, "Error loading AWS region")
+		}
+		assert(region != "", "AWS region must be specified when using CodeCommit")
+
+		awsCredentials, err := codecommit_target.LoadCredentials(profile)
+		check(err, "Error loading AWS credentials")
+		common.RegisterSecret(awsCredentials.SecretAccessKey)
+		common.RegisterSecret(awsCredentials.SessionToken)
+
+		repository = codecommit_target.NewAPIClient(c.String("codecommit-endpoint"), region, repositoryName, awsCredentials, client)
+	case "gerrit":
+		uri := c.String("gerrit-endpoint")
+		assert(uri != "", "Gerrit endpoint must be specified when using Gerrit")
+
+		project := id
+		assert(project != "", "Gerrit project must be specified when using Gerrit")
+
+		key := secret(c, "gerrit-key", uri, credentials.UserPassword)
+		assert(key != "", "Gerrit credentials must be specified when using Gerrit")
+
+		labels, err := gerrit_target.ParseLabels(c.StringSlice("gerrit-labels"))
+		check(err, "Error parsing Gerrit labels")
+
+		gerrit := gerrit_target.NewAPIClient(uri, project, key, client)
+		gerrit.SetReview(labels, c.Bool("gerrit-submit"))
+		repository = gerrit
+	case "filesystem":
+		root := id
+		assert(root != "", "Root directory must be specified when using the local filesystem")
+
+		filesystem := filesystem_target.NewFilesystemRepository(root)
+		filesystem.SetStaging(c.Bool("filesystem-stage"))
+		repository = filesystem
+	default:
+		return nil, fmt.Errorf("repository option not supported: %s", target)
+	}
+
+	// Commands opening pull requests work with any target supporting them
+	if branch := c.String("pr-branch"); branch != "" {
+		requester, ok := repository.(targets.PullRequester)
+		assert(ok, "Pull requests are not supported when using %s", target)
+		requester.SetPullRequestBranch(branch)
+	}
+	return repository, nil
+}
+
+// render updates files with the templater, returning the files it changed
+func (s *session) render(c *cli.Context) (targets.FileList, error) {
+	policy, err := downgradePolicy(c)
+	check(err, "Error parsing downgrade policy")
+
+	name := c.String("templater")
+	span := s.tracer.Start("templater "+name, "templater", name)
+	newFiles, err := updateFiles(c, s.repository, name, policy)
+	span.Finish(err)
+	// Decisions are reported even if an update was refused
+	for _, decision := range policy.Decisions() {
+		runResult.Changes = append(runResult.Changes, result.Change{
+			File:     decision.File,
+			Name:     decision.Name,
+			From:     decision.From,
+			To:       decision.To,
+			Decision: string(decision.Outcome),
+		})
+		if decision.Outcome == templater.OutcomeDowngradeAllowed {
+			logging.Warn("moving tag backwards", "file", decision.File, "name", decision.Name, "from", decision.From, "to", decision.To)
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	if len(newFiles) < 1 {
+		runResult.Status = result.StatusNoChanges
+	}
+	runResult.Files = newFiles.Names()
+	return newFiles, nil
+}
+
+// deploy commits the updated files to the repository
+func deploy(c *cli.Context, s *session) error {
+	payload := targets.NewPayload(c.String("repo-branch"), c.String("commit-author"), c.String("commit-message"))
+
+	newFiles, err := s.render(c)
+	if err != nil {
+		return err
+	}
+	_ = payload.Files.Add(newFiles)
+
+	if len(payload.Files) < 1 {
+		logging.Info("no changes to commit, exiting")
+		return nil
+	}
+
+	logging.Info("pushing changes", "files", strings.Join(payload.Files.Names(), ","), "author", payload.Author, "message", payload.Message)
+
+	start := time.Now()
+	err = s.repository.Commit(payload)
+	s.metrics.ObserveCommit(s.target, time.Since(start))
+	return err
+}
+
+// printDiff prints the changes the templater makes as a unified diff
+func printDiff(c *cli.Context, s *session) error {
+	originals := &originalFiles{Repository: s.repository, files: make(targets.FileList)}
+	s.repository = originals
+
+	newFiles, err := s.render(c)
+	if err != nil {
+		return err
+	}
+
+	for _, path := range newFiles.Names() {
+		oldName := "a/" + path
+		original, ok := originals.files[path]
+		if !ok {
+			oldName = "/dev/null"
+		}
+		fmt.Fprint(c.App.Writer, diff.Unified(oldName, "b/"+path, original, newFiles[path], diff.DefaultContext))
+	}
+	return nil
+}
+
+// validate checks that the templater can update the files, logging the ones that would change
+func validate(c *cli.Context, s *session) error {
+	// Rendering creates missing keys, so paths are looked up first
+	name := c.String("templater")
+	span := s.tracer.Start("templater "+name, "templater", name)
+	err := checkPaths(c, s.repository, name)
+	span.Finish(err)
+	if err != nil {
+		return err
+	}
+
+	newFiles, err := s.render(c)
+	if err != nil {
+		return err
+	}
+
+	if len(newFiles) < 1 {
+		logging.Info("configuration is valid, no files would change")
+		return nil
+	}
+	logging.Info("configuration is valid", "files", strings.Join(newFiles.Names(), ","))
+	return nil
+}
+
+// get prints the images and tags currently set in the files
+func get(c *cli.Context, s *session) error {
+	output := c.String("output")
+	assert(output == "text" || output == "json", "Output format must be either \"text\" or \"json\"")
+
+	name := c.String("templater")
+	span := s.tracer.Start("templater "+name, "templater", name)
+	images, err := lookupImages(c, s.repository, name)
+	span.Finish(err)
+	if err != nil {
+		return err
+	}
+	runResult.Status = result.StatusOK
+
+	if output == "json" {
+		byt, err := jsoniter.ConfigFastest.MarshalIndent(images, "", "  ")
+		if err != nil {
+			return fmt.Errorf("error encoding images: %w", err)
+		}
+		_, err = fmt.Fprintln(c.App.Writer, string(byt))
+		return err
+	}
+
+	w := tabwriter.NewWriter(c.App.Writer, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "FILE\tNAME\tIMAGE\tTAG")
+	for _, image := range images {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", image.File, image.Name, image.Image, image.Tag)
+	}
+	return w.Flush()
+}
+
+// printStatus prints the versions of apps in the environments of the configuration file
+func printStatus(c *cli.Context, s *session) error {
+	output := c.String("output")
+	assert(output == "text" || output == "json" || output == "markdown", "Output format must be one of \"text\", \"json\" or \"markdown\"")
+
+	config, err := environments.LoadConfig(c.String("config"))
+	check(err, "Error loading environments configuration")
+
+	// Environments that can't be read are shown in the matrix before failing
+	// Spans of concurrent reads would be nested under each other
+	matrix, readErr := environments.Read(s.connect, config, c.String("repo-branch"), s.tracer == nil)
+	runResult.Status = result.StatusOK
+	switch output {
+	case "json":
+		err = matrix.WriteJSON(c.App.Writer)
+	case "markdown":
+		err = matrix.WriteMarkdown(c.App.Writer)
 	default:
-		return fmt.Errorf("repository option not supported: %s", target)
+		err = matrix.WriteText(c.App.Writer)
+	}
+	if readErr != nil {
+		return readErr
+	}
+	return err
+}
+
+// promote copies the versions of apps from an environment to another, committing them to the destination
+func promote(c *cli.Context, s *session) error {
+	config, err := environments.LoadConfig(c.String("config"))
+	check(err, "Error loading environments configuration")
+
+	from, to := c.String("from"), c.String("to")
+	span := s.tracer.Start("promote", "from", from, "to", to)
+	promotion, err := environments.Promote(s.connect, config, environments.PromoteOptions{
+		From:          from,
+		To:            to,
+		Apps:          c.StringSlice("app"),
+		DefaultBranch: c.String("repo-branch"),
+		TagsOnly:      c.Bool("tags-only"),
+	})
+	span.Finish(err)
+	if err != nil {
+		return err
+	}
+
+	runResult.Templater = promotion.To.Templater
+	runResult.Branch = promotion.Branch
+	if promotion.To.Repository != "" {
+		runResult.Repository = promotion.To.Repository
+	}
+	runResult.Files = promotion.Files.Names()
+	for _, change := range promotion.Changes {
+		logging.Info("promoting app", "app", change.App, "from", change.From.Tag, "to", change.To.Tag, "image", change.To.Image)
+	}
+	if len(promotion.Files) < 1 {
+		runResult.Status = result.StatusNoChanges
+		logging.Info("no changes to commit, exiting")
+		return nil
+	}
+
+	payload := targets.NewPayload(promotion.Branch, c.String("commit-author"), promotion.Message(c.String("commit-message")))
+	_ = payload.Files.Add(promotion.Files)
+	logging.Info("pushing changes", "files", strings.Join(payload.Files.Names(), ","), "environment", promotion.To.Name, "environment-branch", payload.Branch, "source-commit", promotion.SourceCommit)
+
+	start := time.Now()
+	err = promotion.Repository.Commit(payload)
+	s.metrics.ObserveCommit(s.target, time.Since(start))
+	return err
+}
+
+// rollback restores the images deployed before the current ones, as found in the history of the files
+func rollback(c *cli.Context, s *session) error {
+	name := c.String("templater")
+	current, err := lookupImages(c, s.repository, name)
+	if err != nil {
+		return err
+	}
+
+	limit := c.Int("history-limit")
+	assert(limit > 0, "History limit must be positive")
+	span := s.tracer.Start("history", "limit", limit)
+	entries, err := history.Read(s.repository, imageFiles(current), c.String("repo-branch"), limit, func(repository targets.Repository) ([]templater.Image, error) {
+		return lookupImages(c, repository, name)
+	})
+	span.Finish(err)
+	if errors.Is(err, targets.ErrUnsupported) {
+		return fmt.Errorf("history is not supported when using %s: %w", s.target, err)
+	}
+	if err != nil {
+		return err
+	}
+	previous, err := history.Previous(entries, current)
+	if err != nil {
+		return fmt.Errorf("nothing to roll back to in the last %d commits: %w", limit, err)
+	}
+
+	span = s.tracer.Start("templater "+name, "templater", name)
+	newFiles, err := restoreImages(c, s.repository, name, current, previous.Images)
+	span.Finish(err)
+	if err != nil {
+		return err
+	}
+	runResult.Files = newFiles.Names()
+
+	lines := []string{}
+	for index, image := range previous.Images {
+		if image == current[index] {
+			continue
+		}
+		change := result.Change{File: image.File, Name: image.Name, From: describeImage(current[index]), To: describeImage(image)}
+		runResult.Changes = append(runResult.Changes, change)
+		lines = append(lines, fmt.Sprintf("%s: %s -> %s", change.Name, change.From, change.To))
+		logging.Info("rolling back image", "file", change.File, "name", change.Name, "from", change.From, "to", change.To, "commit", previous.Commit.ID)
+	}
+	if len(newFiles) < 1 {
+		runResult.Status = result.StatusNoChanges
+		logging.Info("no changes to commit, exiting")
+		return nil
+	}
+
+	subject := c.String("commit-message")
+	if subject == "" {
+		subject = "Roll back " + strings.Join(lines, ", ")
+	}
+	message := fmt.Sprintf("%s\n\n%s\n\nRestored from %s", subject, strings.Join(lines, "\n"), previous.Commit.ID)
+	payload := targets.NewPayload(c.String("repo-branch"), c.String("commit-author"), message)
+	_ = payload.Files.Add(newFiles)
+	logging.Info("pushing changes", "files", strings.Join(payload.Files.Names(), ","), "author", payload.Author, "message", subject)
+
+	start := time.Now()
+	err = s.repository.Commit(payload)
+	s.metrics.ObserveCommit(s.target, time.Since(start))
+	return err
+}
+
+// printHistory prints the images deployed by the commits changing the files, newest first
+func printHistory(c *cli.Context, s *session) error {
+	output := c.String("output")
+	assert(output == "text" || output == "json", "Output format must be either \"text\" or \"json\"")
+
+	name := c.String("templater")
+	current, err := lookupImages(c, s.repository, name)
+	if err != nil {
+		return err
+	}
+
+	limit := c.Int("history-limit")
+	assert(limit > 0, "History limit must be positive")
+	span := s.tracer.Start("history", "limit", limit)
+	entries, err := history.Read(s.repository, imageFiles(current), c.String("repo-branch"), limit, func(repository targets.Repository) ([]templater.Image, error) {
+		return lookupImages(c, repository, name)
+	})
+	span.Finish(err)
+	if errors.Is(err, targets.ErrUnsupported) {
+		return fmt.Errorf("history is not supported when using %s: %w", s.target, err)
+	}
+	if err != nil {
+		return err
 	}
+	runResult.Status = result.StatusOK
 
-	// Get provider to use
-	templater := c.String("templater")
+	if output == "json" {
+		return history.WriteJSON(c.App.Writer, entries)
+	}
+	return history.WriteText(c.App.Writer, entries)
+}
+
+// imageFiles returns the files images were read from, without duplicates
+func imageFiles(images []templater.Image) []string {
+	files := []string{}
+	seen := make(map[string]bool)
+	for _, image := range images {
+		if !seen[image.File] {
+			seen[image.File] = true
+			files = append(files, image.File)
+		}
+	}
+	return files
+}
+
+// describeImage formats an image as "image:tag", or just the tag if the file has no image
+func describeImage(image templater.Image) string {
+	if image.Image == "" {
+		return image.Tag
+	}
+	return image.Image + ":" + image.Tag
+}
+
+// originalFiles is a repository remembering the content files had before being updated
+type originalFiles struct {
+	targets.Repository
+	files targets.FileList
+}
+
+func (o *originalFiles) Get(path string, ref string) ([]byte, error) {
+	data, err := o.Repository.Get(path, ref)
+	if err == nil {
+		if _, ok := o.files[path]; !ok {
+			o.files[path] = data
+		}
+	}
+	return data, err
+}
+
+// downgradePolicy returns the policy checking that tags don't move backwards, or nil if they are not checked
+func downgradePolicy(c *cli.Context) (*templater.Policy, error) {
+	action, err := templater.ParseAction(c.String("downgrade-policy"))
+	if err != nil {
+		return nil, err
+	}
+	if action == templater.ActionAllow {
+		return nil, nil
+	}
+	// Tags are still compared to report downgrades
+	if c.Bool("allow-downgrade") {
+		action = templater.ActionAllow
+	}
+
+	ordering, pattern := c.String("tag-ordering"), c.String("tag-regex")
+	if ordering == "auto" && pattern != "" {
+		ordering = "regex"
+	}
+	assert(ordering != "regex" || pattern != "", "--tag-regex must be specified when ordering tags by regex")
+	order, err := templater.ParseOrdering(ordering, pattern)
+	if err != nil {
+		return nil, err
+	}
+	return templater.NewPolicy(order, action), nil
+}
+
+// updateFiles runs the templater, returning the files it changed
+func updateFiles(c *cli.Context, repository targets.Repository, name string, policy *templater.Policy) (targets.FileList, error) {
 	branch := c.String("repo-branch")
 
 	images := c.StringSlice("container-image")
 	tags := c.StringSlice("container-tag")
 	assert(len(images) == len(tags), "An equal number of --container-image and --container-tag must be specified")
 
-	switch templater {
+	switch name {
 	case "helm":
 		valuesFile := c.StringSlice("helm-values-file")
 		assert(valuesFile != nil && len(valuesFile) > 0, "values.yaml path must be specified when using Helm")
@@ -128,11 +865,9 @@ func app(c *cli.Context) error {
 		newFiles, err := helm_templater.UpdateHelmChart(repository, helm_templater.HelmProviderOptions{
 			Ref:     branch,
 			Updates: updates,
+			Policy:  policy,
 		})
-		if err != nil {
-			return err
-		}
-		_ = payload.Files.Add(newFiles)
+		return newFiles, err
 	case "kustomize":
 		kustomizationFiles := c.StringSlice("kustomize-file")
 		assert(kustomizationFiles != nil && len(kustomizationFiles) > 0, "kustomization.yaml path must be specified when using Kustomize")
@@ -149,11 +884,9 @@ func app(c *cli.Context) error {
 		newFiles, err := kustomize_templater.UpdateKustomization(repository, kustomize_templater.KustomizeProviderOptions{
 			Ref:     branch,
 			Updates: updates,
+			Policy:  policy,
 		})
-		if err != nil {
-			return err
-		}
-		_ = payload.Files.Add(newFiles)
+		return newFiles, err
 	case "json":
 		jsonFiles := c.StringSlice("json-file")
 		assert(jsonFiles != nil && len(jsonFiles) > 0, "At least one JSON file path must be specified when using JSON")
@@ -170,227 +903,1143 @@ func app(c *cli.Context) error {
 		newFiles, err := json_templater.UpdateJSONFile(repository, json_templater.JSONProviderOptions{
 			Ref:     branch,
 			Updates: updates,
+			Policy:  policy,
 		})
-		if err != nil {
-			return err
-		}
-		_ = payload.Files.Add(newFiles)
+		return newFiles, err
 	default:
-		return fmt.Errorf("templater option not supported: %s", templater)
+		return nil, fmt.Errorf("templater option not supported: %s", name)
 	}
+}
 
-	if len(payload.Files) < 1 {
-		log.Println("no changes to commit, exiting")
-		return nil
-	}
+// restoreImages sets the images read by lookupImages back to previous ones, returning the files it changed
+func restoreImages(c *cli.Context, repository targets.Repository, name string, current []templater.Image, previous []templater.Image) (targets.FileList, error) {
+	branch := c.String("repo-branch")
+
+	switch name {
+	case "helm":
+		tagPaths := c.StringSlice("helm-tag-path")
+		updates := []helm_templater.HelmUpdate{}
+		for index, image := range previous {
+			if image == current[index] {
+				continue
+			}
+			updates = append(updates, helm_templater.HelmUpdate{
+				ValuesFile: image.File,
+				Image:      image.Image,
+				ImagePath:  image.Name,
+				Tag:        image.Tag,
+				TagPath:    oneOrMany(tagPaths, index),
+			})
+		}
+
+		return helm_templater.UpdateHelmChart(repository, helm_templater.HelmProviderOptions{
+			Ref:     branch,
+			Updates: updates,
+		})
+	case "kustomize":
+		updates := []kustomize_templater.KustomizeUpdate{}
+		for index, image := range previous {
+			if image == current[index] {
+				continue
+			}
+			update := kustomize_templater.KustomizeUpdate{
+				KustomizationFile: image.File,
+				Image:             image.Name,
+				NewTag:            image.Tag,
+			}
+			if image.Image != current[index].Image {
+				update.NewImage = image.Image
+			}
+			updates = append(updates, update)
+		}
 
-	log.Printf("Pushing changes\n%s", payload)
+		return kustomize_templater.UpdateKustomization(repository, kustomize_templater.KustomizeProviderOptions{
+			Ref:     branch,
+			Updates: updates,
+		})
+	case "json":
+		updates := []json_templater.FileUpdate{}
+		for index, image := range previous {
+			if image == current[index] {
+				continue
+			}
+			updates = append(updates, json_templater.FileUpdate{
+				File: image.File,
+				Path: image.Name,
+				Tag:  image.Tag,
+			})
+		}
 
-	return repository.Commit(payload)
+		return json_templater.UpdateJSONFile(repository, json_templater.JSONProviderOptions{
+			Ref:     branch,
+			Updates: updates,
+		})
+	default:
+		return nil, fmt.Errorf("templater option not supported: %s", name)
+	}
 }
 
-func main() {
+// checkPaths checks that the values updateFiles would set already exist in the files. Kustomize images
+// missing from a kustomization are added to it, so they are not checked.
+func checkPaths(c *cli.Context, repository targets.Repository, name string) error {
+	branch := c.String("repo-branch")
+	images := c.StringSlice("container-image")
+	assert(len(images) == len(c.StringSlice("container-tag")), "An equal number of --container-image and --container-tag must be specified")
+
+	var err error
+	switch name {
+	case "helm":
+		valuesFile := c.StringSlice("helm-values-file")
+		assert(len(valuesFile) > 0, "values.yaml path must be specified when using Helm")
+		imagePaths := c.StringSlice("helm-image-path")
+		tagPaths := c.StringSlice("helm-tag-path")
+
+		assert(len(tagPaths) == len(imagePaths), "An equal number of --helm-image-path and --helm-tag-path must be specified")
+		assert(len(imagePaths) == 1 || len(imagePaths) == len(images), "There can on be either one global --helm-image-path or one per each --container-image")
+
+		lookups := make([]helm_templater.HelmLookup, len(images))
+		for index := 0; index < len(images); index += 1 {
+			lookups[index] = helm_templater.HelmLookup{
+				ValuesFile: oneOrMany(valuesFile, index),
+				ImagePath:  oneOrMany(imagePaths, index),
+				TagPath:    oneOrMany(tagPaths, index),
+			}
+		}
+		_, err = helm_templater.GetHelmImages(repository, helm_templater.HelmGetOptions{
+			Ref:     branch,
+			Lookups: lookups,
+		})
+	case "json":
+		jsonFiles := c.StringSlice("json-file")
+		assert(len(jsonFiles) > 0, "At least one JSON file path must be specified when using JSON")
+
+		lookups := make([]json_templater.JSONLookup, len(images))
+		for index := 0; index < len(images); index += 1 {
+			lookups[index] = json_templater.JSONLookup{
+				File: oneOrMany(jsonFiles, index),
+				Path: images[index],
+			}
+		}
+		_, err = json_templater.GetJSONValues(repository, json_templater.JSONGetOptions{
+			Ref:     branch,
+			Lookups: lookups,
+		})
+	}
+	if errors.Is(err, templater.ErrImageNotFound) {
+		return result.WithCode(result.CodeValidation, err)
+	}
+	return err
+}
+
+// lookupImages reads the images and tags currently set in the files of the templater
+func lookupImages(c *cli.Context, repository targets.Repository, name string) ([]templater.Image, error) {
+	branch := c.String("repo-branch")
+	images := c.StringSlice("container-image")
+
+	switch name {
+	case "helm":
+		valuesFile := c.StringSlice("helm-values-file")
+		assert(len(valuesFile) > 0, "values.yaml path must be specified when using Helm")
+		imagePaths := c.StringSlice("helm-image-path")
+		tagPaths := c.StringSlice("helm-tag-path")
+
+		assert(len(tagPaths) == len(imagePaths), "An equal number of --helm-image-path and --helm-tag-path must be specified")
+		assert(len(valuesFile) == 1 || len(imagePaths) == 1 || len(valuesFile) == len(imagePaths), "There can on be either one global --helm-image-path or one per each --helm-values-file")
+
+		count := len(valuesFile)
+		if len(imagePaths) > count {
+			count = len(imagePaths)
+		}
+		lookups := make([]helm_templater.HelmLookup, count)
+		for index := 0; index < count; index += 1 {
+			lookups[index] = helm_templater.HelmLookup{
+				ValuesFile: oneOrMany(valuesFile, index),
+				ImagePath:  oneOrMany(imagePaths, index),
+				TagPath:    oneOrMany(tagPaths, index),
+			}
+		}
+
+		return helm_templater.GetHelmImages(repository, helm_templater.HelmGetOptions{
+			Ref:     branch,
+			Lookups: lookups,
+		})
+	case "kustomize":
+		kustomizationFiles := c.StringSlice("kustomize-file")
+		assert(len(kustomizationFiles) > 0, "kustomization.yaml path must be specified when using Kustomize")
+		assert(len(images) > 0, "At least one --container-image must be specified when using Kustomize")
+
+		lookups := make([]kustomize_templater.KustomizeLookup, len(images))
+		for index := 0; index < len(images); index += 1 {
+			lookups[index] = kustomize_templater.KustomizeLookup{
+				KustomizationFile: oneOrMany(kustomizationFiles, index),
+				Image:             images[index],
+			}
+		}
+
+		return kustomize_templater.GetKustomizeImages(repository, kustomize_templater.KustomizeGetOptions{
+			Ref:     branch,
+			Lookups: lookups,
+		})
+	case "json":
+		jsonFiles := c.StringSlice("json-file")
+		assert(len(jsonFiles) > 0, "At least one JSON file path must be specified when using JSON")
+		assert(len(images) > 0, "At least one --container-image must be specified when using JSON")
+
+		lookups := make([]json_templater.JSONLookup, len(images))
+		for index := 0; index < len(images); index += 1 {
+			lookups[index] = json_templater.JSONLookup{
+				File: oneOrMany(jsonFiles, index),
+				Path: images[index],
+			}
+		}
+
+		return json_templater.GetJSONValues(repository, json_templater.JSONGetOptions{
+			Ref:     branch,
+			Lookups: lookups,
+		})
+	default:
+		return nil, fmt.Errorf("templater option not supported: %s", name)
+	}
+}
+
+func main() {
 	app := &cli.App{
-		Flags: []cli.Flag{
-			// Global options
-			&cli.StringFlag{
-				Name:     "templater",
-				Aliases:  []string{"p"},
-				Usage:    `Template system (available: "helm", "kustomize", "json")`,
-				EnvVars:  []string{"SHIPPER_PROVIDER"},
-				Required: true,
-			},
-			&cli.StringFlag{
-				Name:     "repo-kind",
-				Aliases:  []string{"t"},
-				Value:    "gitlab",
-				Usage:    `Repository type (available: "gitlab", "github", "gitea", "bitbucket-cloud", "azure")`,
-				EnvVars:  []string{"SHIPPER_REPO_KIND"},
-				Required: true,
-			},
-			&cli.StringFlag{
-				Name:     "repo-branch",
-				Aliases:  []string{"b"},
-				Usage:    "Repository branch",
-				EnvVars:  []string{"SHIPPER_REPO_BRANCH"},
-				Required: true,
-			},
-			&cli.StringFlag{
-				Name:    "commit-author",
-				Aliases: []string{"a"},
-				Usage:   "Commit author in \"name <email>\" format",
-				EnvVars: []string{"SHIPPER_COMMIT_AUTHOR"},
-				Value:   "Shipper agent <shipper@example.com>",
-			},
-			&cli.StringFlag{
-				Name:    "commit-message",
-				Aliases: []string{"m"},
-				Usage:   "Commit message",
-				EnvVars: []string{"SHIPPER_COMMIT_MESSAGE"},
-				Value:   "Deploy",
-			},
-			&cli.StringSliceFlag{
-				Name:     "container-image",
-				Aliases:  []string{"ci"},
-				Usage:    "Container image",
-				EnvVars:  []string{"SHIPPER_CONTAINER_IMAGE", "SHIPPER_CONTAINER_IMAGES"},
-				Required: true,
-			},
-			&cli.StringSliceFlag{
-				Name:     "container-tag",
-				Aliases:  []string{"ct"},
-				Usage:    "Container tag",
-				EnvVars:  []string{"SHIPPER_CONTAINER_TAG", "SHIPPER_CONTAINER_TAGS"},
-				Required: true,
-			},
-			&cli.BoolFlag{
-				Name:    "no-verify-tls",
-				Usage:   "If provided, skip X.509 certificate validation on HTTPS requests",
-				EnvVars: []string{"SHIPPER_NO_VERIFY_TLS"},
-				Value:   false,
-			},
-			// Helm options
-			&cli.StringSliceFlag{
-				Name:    "helm-values-file",
-				Aliases: []string{"hpath"},
-				Usage:   "[helm] Path to values.yaml file",
-				EnvVars: []string{"SHIPPER_HELM_VALUES_FILE", "SHIPPER_HELM_VALUES_FILES"},
-			},
-			&cli.StringSliceFlag{
-				Name:    "helm-image-path",
-				Aliases: []string{"himg"},
-				Usage:   "[helm] Container image path",
-				EnvVars: []string{"SHIPPER_HELM_IMAGE_PATH", "SHIPPER_HELM_IMAGE_PATHS"},
-				Value:   cli.NewStringSlice("image.repository"),
-			},
-			&cli.StringSliceFlag{
-				Name:    "helm-tag-path",
-				Aliases: []string{"htag"},
-				Usage:   "[helm] Container tag path",
-				EnvVars: []string{"SHIPPER_HELM_TAG_PATH", "SHIPPER_HELM_TAG_PATHS"},
-				Value:   cli.NewStringSlice("image.tag"),
-			},
-			// Kustomize options
-			&cli.StringSliceFlag{
-				Name:    "kustomize-file",
-				Aliases: []string{"kfile"},
-				Usage:   "[kustomize] Path to kustomization.yaml file",
-				EnvVars: []string{"SHIPPER_KUSTOMIZE_FILE", "SHIPPER_KUSTOMIZE_FILES"},
+		Usage:   "Update container images in GitOps repositories",
+		Version: version,
+		Commands: []*cli.Command{
+			{
+				Name:   "deploy",
+				Usage:  "Update container images and commit the changes (default if no command is given)",
+				Flags:  flags(updateFlags()...),
+				Action: action("deploy", deploy),
 			},
-			// JSON options
-			&cli.StringSliceFlag{
-				Name:    "json-file",
-				Aliases: []string{"jfile"},
-				Usage:   "[json] Path to JSON file",
-				EnvVars: []string{"SHIPPER_JSON_FILE", "SHIPPER_JSON_FILES"},
+			{
+				Name:   "diff",
+				Usage:  "Print the changes deploy would commit as a unified diff, without committing them",
+				Flags:  flags(updateFlags()...),
+				Action: action("diff", printDiff),
 			},
-			// Gitlab options
-			&cli.StringFlag{
-				Name:    "gitlab-endpoint",
-				Aliases: []string{"gl-uri"},
-				Usage:   "[gitlab] Gitlab API endpoint, including \"/api/v4\"",
-				EnvVars: []string{"SHIPPER_GITLAB_ENDPOINT"},
-				Value:   "https://gitlab.com/api/v4",
+			{
+				Name:   "validate",
+				Usage:  "Check options and that the files to update exist and can be parsed, without committing",
+				Flags:  flags(updateFlags()...),
+				Action: action("validate", validate),
 			},
-			&cli.StringFlag{
-				Name:    "gitlab-key",
-				Aliases: []string{"gl-key"},
-				Usage:   "[gitlab] A valid API key with commit access",
-				EnvVars: []string{"SHIPPER_GITLAB_KEY"},
+			{
+				Name:   "get",
+				Usage:  "Print the images and tags currently deployed",
+				Flags:  flags(getFlags()...),
+				Action: action("get", get),
 			},
-			&cli.StringFlag{
-				Name:    "gitlab-project",
-				Aliases: []string{"gl-pid"},
-				Usage:   "[gitlab] Project ID in \"org/project\" format",
-				EnvVars: []string{"SHIPPER_GITLAB_PROJECT"},
+			{
+				Name:   "status",
+				Usage:  "Print the versions of apps across environments, highlighting the ones that differ",
+				Flags:  flags(statusFlags()...),
+				Action: action("status", printStatus),
 			},
-			// GitHub options
-			&cli.StringFlag{
-				Name:    "github-endpoint",
-				Aliases: []string{"gh-uri"},
-				Usage:   "[github] GitHub API endpoint (include \"/api/v3\" if using Enterprise Server)",
-				EnvVars: []string{"SHIPPER_GITHUB_ENDPOINT"},
-				Value:   "https://api.github.com",
+			{
+				Name:   "promote",
+				Usage:  "Copy the versions of apps from an environment to another and commit the changes",
+				Flags:  flags(promoteFlags()...),
+				Action: action("promote", promote),
 			},
-			&cli.StringFlag{
-				Name:    "github-key",
-				Aliases: []string{"gh-key"},
-				Usage:   "[github] Username/password pair in \"username:password\" format (use a personal access token!)",
-				EnvVars: []string{"SHIPPER_GITHUB_KEY"},
+			{
+				Name:   "history",
+				Usage:  "Print the images deployed by each commit changing the files, newest first",
+				Flags:  flags(historyFlags()...),
+				Action: action("history", printHistory),
 			},
-			&cli.StringFlag{
-				Name:    "github-project",
-				Aliases: []string{"gh-pid"},
-				Usage:   "[github] Project ID in \"org/project\" format",
-				EnvVars: []string{"SHIPPER_GITHUB_PROJECT"},
-			},
-			// Gitea options
-			&cli.StringFlag{
-				Name:    "gitea-endpoint",
-				Aliases: []string{"ge-uri"},
-				Usage:   "[gitea] Gitea API endpoint (include \"/api/v1\")",
-				EnvVars: []string{"SHIPPER_GITEA_ENDPOINT"},
-			},
-			&cli.StringFlag{
-				Name:    "gitea-key",
-				Aliases: []string{"ge-key"},
-				Usage:   "[gitea] Username/application token pair in \"username:token\" format",
-				EnvVars: []string{"SHIPPER_GITEA_KEY"},
-			},
-			&cli.StringFlag{
-				Name:    "gitea-project",
-				Aliases: []string{"ge-pid"},
-				Usage:   "[gitea] Project ID in \"org/project\" format",
-				EnvVars: []string{"SHIPPER_GITEA_PROJECT"},
-			},
-			// Bitbucket options
-			&cli.StringFlag{
-				Name:    "bitbucket-key",
-				Aliases: []string{"bb-key"},
-				Usage:   "[bitbucket-cloud] Username/password pair in \"username:password\" format (use app passwords!)",
-				EnvVars: []string{"SHIPPER_GITLAB_KEY"},
-			},
-			&cli.StringFlag{
-				Name:    "bitbucket-project",
-				Aliases: []string{"bb-pid"},
-				Usage:   "[bitbucket-cloud] Project path in \"org/project\" format",
-				EnvVars: []string{"SHIPPER_GITLAB_PROJECT"},
-			},
-			// Azure DevOps options
-			&cli.StringFlag{
-				Name:    "azure-project-id",
-				Aliases: []string{"az-pid"},
-				Usage:   "[azure-devops] Organization and Project ID, in \"org/project\" format",
-				EnvVars: []string{"SHIPPER_AZURE_PROJECT_ID"},
-			},
-			&cli.StringFlag{
-				Name:    "azure-repository-id",
-				Aliases: []string{"az-rid"},
-				Usage:   "[azure-devops] Repository ID (if unsure, use the project ID)",
-				EnvVars: []string{"SHIPPER_AZURE_REPOSITORY_ID"},
-			},
-			&cli.StringFlag{
-				Name:    "azure-key",
-				Aliases: []string{"az-key"},
-				Usage:   "[azure-devops] Username/application token pair in \"username:token\" format",
-				EnvVars: []string{"SHIPPER_AZURE_KEY"},
+			{
+				Name:   "rollback",
+				Usage:  "Restore the images deployed before the current ones, as found in the history of the files, and commit the changes",
+				Flags:  flags(rollbackFlags()...),
+				Action: action("rollback", rollback),
 			},
 		},
-		Action: app,
 	}
 
-	check(app.Run(os.Args), "Fatal error")
+	err := app.Run(withDefaultCommand(os.Args))
+	var coded *result.Error
+	if err != nil && !errors.As(err, &coded) {
+		err = result.WithCode(result.CodeValidation, err)
+	}
+	exit(err)
+}
+
+// flags returns the options shared by all commands, with the options of the command listed first.
+// A new list is created for each command, as flags keep the parsed values.
+func flags(command ...cli.Flag) []cli.Flag {
+	general := []cli.Flag{
+		&cli.StringFlag{
+			Name:     "repo-kind",
+			Aliases:  []string{"t"},
+			Value:    "gitlab",
+			Usage:    `Repository type (available: "gitlab", "github", "gitea", "bitbucket-cloud", "bitbucket-server", "azure", "git", "codecommit", "gerrit", "filesystem")`,
+			EnvVars:  []string{"SHIPPER_REPO_KIND"},
+			Required: true,
+		},
+		&cli.StringFlag{
+			Name:    "repo-branch",
+			Aliases: []string{"b"},
+			Usage:   "Repository branch (ignored by \"filesystem\")",
+			EnvVars: []string{"SHIPPER_REPO_BRANCH"},
+		},
+	}
+	shared := []cli.Flag{
+		&cli.BoolFlag{
+			Name:    "no-verify-tls",
+			Usage:   "If provided, skip X.509 certificate validation on HTTPS requests",
+			EnvVars: []string{"SHIPPER_NO_VERIFY_TLS"},
+			Value:   false,
+		},
+		&cli.StringFlag{
+			Name:    "result-file",
+			Usage:   "If provided, write the outcome of the run to this file as JSON",
+			EnvVars: []string{"SHIPPER_RESULT_FILE"},
+		},
+		&cli.BoolFlag{
+			Name:    "detailed-exit-code",
+			Usage:   "If provided, exit with code 7 instead of 0 when there are no changes to commit",
+			EnvVars: []string{"SHIPPER_DETAILED_EXIT_CODE"},
+			Value:   false,
+		},
+		&cli.StringFlag{
+			Name:    "log-level",
+			Usage:   `Minimum level of logged messages (available: "debug", "info", "warn", "error")`,
+			EnvVars: []string{"SHIPPER_LOG_LEVEL"},
+			Value:   "info",
+		},
+		&cli.StringFlag{
+			Name:    "log-format",
+			Usage:   `Log output format (available: "text", "json")`,
+			EnvVars: []string{"SHIPPER_LOG_FORMAT"},
+			Value:   "text",
+		},
+		&cli.BoolFlag{
+			Name:    "debug-http",
+			Usage:   "If provided, log every HTTP request and response, with credentials redacted",
+			EnvVars: []string{"SHIPPER_DEBUG_HTTP"},
+			Value:   false,
+		},
+		&cli.StringFlag{
+			Name:    "debug-http-har",
+			Usage:   "Record every HTTP request and response to a HAR file, with credentials redacted",
+			EnvVars: []string{"SHIPPER_DEBUG_HTTP_HAR"},
+		},
+		&cli.StringFlag{
+			Name:    "otel-endpoint",
+			Usage:   "If provided, export OpenTelemetry traces to this OTLP/HTTP collector endpoint (eg. http://localhost:4318)",
+			EnvVars: []string{"SHIPPER_OTEL_ENDPOINT", "OTEL_EXPORTER_OTLP_ENDPOINT"},
+		},
+		&cli.StringFlag{
+			Name:    "otel-headers",
+			Usage:   "Comma-separated key=value headers to send to the OpenTelemetry collector",
+			EnvVars: []string{"SHIPPER_OTEL_HEADERS", "OTEL_EXPORTER_OTLP_HEADERS"},
+		},
+		&cli.StringFlag{
+			Name:    "otel-service-name",
+			Usage:   "Service name of exported traces",
+			EnvVars: []string{"SHIPPER_OTEL_SERVICE_NAME", "OTEL_SERVICE_NAME"},
+			Value:   "shipper",
+		},
+		&cli.StringFlag{
+			Name:    "otel-traceparent",
+			Usage:   "W3C traceparent of an existing trace to join, such as the CI pipeline's",
+			EnvVars: []string{"SHIPPER_OTEL_TRACEPARENT", "TRACEPARENT"},
+		},
+		&cli.StringFlag{
+			Name:    "metrics-pushgateway",
+			Usage:   "If provided, push metrics about the deployment to this Prometheus Pushgateway URL",
+			EnvVars: []string{"SHIPPER_METRICS_PUSHGATEWAY"},
+		},
+		&cli.StringFlag{
+			Name:    "metrics-file",
+			Usage:   "If provided, write metrics about the deployment to this file in the OpenMetrics text format",
+			EnvVars: []string{"SHIPPER_METRICS_FILE"},
+		},
+		&cli.StringFlag{
+			Name:    "metrics-job",
+			Usage:   "Job label of the metrics",
+			EnvVars: []string{"SHIPPER_METRICS_JOB"},
+			Value:   "shipper",
+		},
+		&cli.StringSliceFlag{
+			Name:    "metrics-label",
+			Usage:   "Grouping label added to the metrics as key=value, such as app=api or env=production (can be repeated)",
+			EnvVars: []string{"SHIPPER_METRICS_LABELS"},
+		},
+		&cli.IntFlag{
+			Name:    "http-retries",
+			Usage:   "How many times to retry requests failing because of network errors, rate limiting or temporary server errors",
+			EnvVars: []string{"SHIPPER_HTTP_RETRIES"},
+			Value:   3,
+		},
+		&cli.StringFlag{
+			Name:    "tls-ca-bundle",
+			Usage:   "PEM file with additional CA certificates to trust when connecting to the repository",
+			EnvVars: []string{"SHIPPER_TLS_CA_BUNDLE"},
+		},
+		&cli.StringFlag{
+			Name:    "tls-client-cert",
+			Usage:   "PEM client certificate for mutual TLS with the repository",
+			EnvVars: []string{"SHIPPER_TLS_CLIENT_CERT"},
+		},
+		&cli.StringFlag{
+			Name:    "tls-client-key",
+			Usage:   "PEM private key of the client certificate (defaults to reading it from the certificate file)",
+			EnvVars: []string{"SHIPPER_TLS_CLIENT_KEY"},
+		},
+		&cli.StringFlag{
+			Name:    "tls-min-version",
+			Usage:   "Minimum TLS version to accept (1.0, 1.1, 1.2 or 1.3)",
+			EnvVars: []string{"SHIPPER_TLS_MIN_VERSION"},
+		},
+		&cli.StringFlag{
+			Name:    "proxy",
+			Usage:   "Proxy URL for all requests (defaults to HTTP_PROXY/HTTPS_PROXY)",
+			EnvVars: []string{"SHIPPER_PROXY"},
+		},
+		&cli.StringFlag{
+			Name:    "no-proxy",
+			Usage:   "Comma-separated hosts, domains and CIDRs to connect to without proxy (defaults to NO_PROXY)",
+			EnvVars: []string{"SHIPPER_NO_PROXY"},
+		},
+		// Helm options
+		&cli.StringSliceFlag{
+			Name:    "helm-values-file",
+			Aliases: []string{"hpath"},
+			Usage:   "[helm] Path to values.yaml file",
+			EnvVars: []string{"SHIPPER_HELM_VALUES_FILE", "SHIPPER_HELM_VALUES_FILES"},
+		},
+		&cli.StringSliceFlag{
+			Name:    "helm-image-path",
+			Aliases: []string{"himg"},
+			Usage:   "[helm] Container image path",
+			EnvVars: []string{"SHIPPER_HELM_IMAGE_PATH", "SHIPPER_HELM_IMAGE_PATHS"},
+			Value:   cli.NewStringSlice("image.repository"),
+		},
+		&cli.StringSliceFlag{
+			Name:    "helm-tag-path",
+			Aliases: []string{"htag"},
+			Usage:   "[helm] Container tag path",
+			EnvVars: []string{"SHIPPER_HELM_TAG_PATH", "SHIPPER_HELM_TAG_PATHS"},
+			Value:   cli.NewStringSlice("image.tag"),
+		},
+		// Kustomize options
+		&cli.StringSliceFlag{
+			Name:    "kustomize-file",
+			Aliases: []string{"kfile"},
+			Usage:   "[kustomize] Path to kustomization.yaml file",
+			EnvVars: []string{"SHIPPER_KUSTOMIZE_FILE", "SHIPPER_KUSTOMIZE_FILES"},
+		},
+		// JSON options
+		&cli.StringSliceFlag{
+			Name:    "json-file",
+			Aliases: []string{"jfile"},
+			Usage:   "[json] Path to JSON file",
+			EnvVars: []string{"SHIPPER_JSON_FILE", "SHIPPER_JSON_FILES"},
+		},
+		// Gitlab options
+		&cli.StringFlag{
+			Name:    "gitlab-endpoint",
+			Aliases: []string{"gl-uri"},
+			Usage:   "[gitlab] Gitlab API endpoint, including \"/api/v4\"",
+			EnvVars: []string{"SHIPPER_GITLAB_ENDPOINT", "CI_API_V4_URL"},
+			Value:   "https://gitlab.com/api/v4",
+		},
+		&cli.StringFlag{
+			Name:    "gitlab-key",
+			Aliases: []string{"gl-key"},
+			Usage:   "[gitlab] A valid API key with commit access",
+			EnvVars: []string{"SHIPPER_GITLAB_KEY"},
+		},
+		&cli.BoolFlag{
+			Name:    "gitlab-job-token",
+			Usage:   "[gitlab] Use the CI/CD job token from CI_JOB_TOKEN when no API key is specified",
+			EnvVars: []string{"SHIPPER_GITLAB_JOB_TOKEN"},
+		},
+		&cli.StringFlag{
+			Name:    "gitlab-token-kind",
+			Aliases: []string{"gl-kind"},
+			Usage:   "[gitlab] Kind of API key (available: \"auto\", \"private\" for access tokens, \"job\" for CI job tokens, \"oauth\" for OAuth2 tokens)",
+			EnvVars: []string{"SHIPPER_GITLAB_TOKEN_KIND"},
+			Value:   string(gitlab_target.TokenAuto),
+		},
+		&cli.StringFlag{
+			Name:    "gitlab-project",
+			Aliases: []string{"gl-pid"},
+			Usage:   "[gitlab] Project ID in \"org/project\" format",
+			EnvVars: []string{"SHIPPER_GITLAB_PROJECT"},
+		},
+		// GitHub options
+		&cli.StringFlag{
+			Name:    "github-endpoint",
+			Aliases: []string{"gh-uri"},
+			Usage:   "[github] GitHub API endpoint (include \"/api/v3\" if using Enterprise Server)",
+			EnvVars: []string{"SHIPPER_GITHUB_ENDPOINT"},
+			Value:   "https://api.github.com",
+		},
+		&cli.StringFlag{
+			Name:    "github-key",
+			Aliases: []string{"gh-key"},
+			Usage:   "[github] Username/password pair in \"username:password\" format (use a personal access token!)",
+			EnvVars: []string{"SHIPPER_GITHUB_KEY"},
+		},
+		&cli.StringFlag{
+			Name:    "github-project",
+			Aliases: []string{"gh-pid"},
+			Usage:   "[github] Project ID in \"org/project\" format",
+			EnvVars: []string{"SHIPPER_GITHUB_PROJECT"},
+		},
+		&cli.StringFlag{
+			Name:    "github-app-id",
+			Aliases: []string{"gh-app"},
+			Usage:   "[github] GitHub App ID, if specified authenticate as the App installation instead of using --github-key",
+			EnvVars: []string{"SHIPPER_GITHUB_APP_ID"},
+		},
+		&cli.StringFlag{
+			Name:    "github-app-key",
+			Aliases: []string{"gh-app-key"},
+			Usage:   "[github] GitHub App private key, either PEM-encoded or as a path to a PEM file",
+			EnvVars: []string{"SHIPPER_GITHUB_APP_KEY"},
+		},
+		&cli.Int64Flag{
+			Name:    "github-app-installation-id",
+			Aliases: []string{"gh-app-inst"},
+			Usage:   "[github] GitHub App installation ID, looked up from the repository if not specified",
+			EnvVars: []string{"SHIPPER_GITHUB_APP_INSTALLATION_ID"},
+		},
+		// Gitea options
+		&cli.StringFlag{
+			Name:    "gitea-endpoint",
+			Aliases: []string{"ge-uri"},
+			Usage:   "[gitea] Gitea API endpoint (include \"/api/v1\")",
+			EnvVars: []string{"SHIPPER_GITEA_ENDPOINT"},
+		},
+		&cli.StringFlag{
+			Name:    "gitea-key",
+			Aliases: []string{"ge-key"},
+			Usage:   "[gitea] Username/application token pair in \"username:token\" format",
+			EnvVars: []string{"SHIPPER_GITEA_KEY"},
+		},
+		&cli.StringFlag{
+			Name:    "gitea-project",
+			Aliases: []string{"ge-pid"},
+			Usage:   "[gitea] Project ID in \"org/project\" format",
+			EnvVars: []string{"SHIPPER_GITEA_PROJECT"},
+		},
+		// Bitbucket options
+		&cli.StringFlag{
+			Name:    "bitbucket-endpoint",
+			Aliases: []string{"bb-uri"},
+			Usage:   "[bitbucket-cloud] Bitbucket Cloud API endpoint",
+			EnvVars: []string{"SHIPPER_BITBUCKET_ENDPOINT"},
+			Value:   bitbucket_target.DefaultCloudEndpoint,
+		},
+		&cli.StringFlag{
+			Name:    "bitbucket-key",
+			Aliases: []string{"bb-key"},
+			Usage:   "[bitbucket-cloud] Username/password pair in \"username:password\" format (use app passwords!)",
+			EnvVars: []string{"SHIPPER_GITLAB_KEY"},
+		},
+		&cli.StringFlag{
+			Name:    "bitbucket-project",
+			Aliases: []string{"bb-pid"},
+			Usage:   "[bitbucket-cloud] Project path in \"org/project\" format",
+			EnvVars: []string{"SHIPPER_GITLAB_PROJECT"},
+		},
+		// Bitbucket Server options
+		&cli.StringFlag{
+			Name:    "bitbucket-server-endpoint",
+			Aliases: []string{"bbs-uri"},
+			Usage:   "[bitbucket-server] Bitbucket Server/Data Center base URL (eg. \"https://bitbucket.example.com\")",
+			EnvVars: []string{"SHIPPER_BITBUCKET_SERVER_ENDPOINT"},
+		},
+		&cli.StringFlag{
+			Name:    "bitbucket-server-key",
+			Aliases: []string{"bbs-key"},
+			Usage:   "[bitbucket-server] Personal or repository access token with write permissions",
+			EnvVars: []string{"SHIPPER_BITBUCKET_SERVER_KEY"},
+		},
+		&cli.StringFlag{
+			Name:    "bitbucket-server-project",
+			Aliases: []string{"bbs-pid"},
+			Usage:   "[bitbucket-server] Repository path in \"PROJECT/repository\" format",
+			EnvVars: []string{"SHIPPER_BITBUCKET_SERVER_PROJECT"},
+		},
+		// Azure DevOps options
+		&cli.StringFlag{
+			Name:    "azure-endpoint",
+			Aliases: []string{"az-uri"},
+			Usage:   "[azure-devops] Azure DevOps instance URL, change it for Azure DevOps Server (eg. \"https://tfs.example.com/tfs\")",
+			EnvVars: []string{"SHIPPER_AZURE_ENDPOINT"},
+			Value:   azure_target.DefaultEndpoint,
+		},
+		&cli.StringFlag{
+			Name:    "azure-collection",
+			Aliases: []string{"az-col"},
+			Usage:   "[azure-devops] Azure DevOps Server collection (eg. \"DefaultCollection\"), if specified --azure-project-id must not include the organization",
+			EnvVars: []string{"SHIPPER_AZURE_COLLECTION"},
+		},
+		&cli.StringFlag{
+			Name:    "azure-api-version",
+			Aliases: []string{"az-api"},
+			Usage:   "[azure-devops] REST API version to use (eg. \"4.1\" for TFS 2018), negotiated with the server if not specified",
+			EnvVars: []string{"SHIPPER_AZURE_API_VERSION"},
+		},
+		&cli.StringFlag{
+			Name:    "azure-project-id",
+			Aliases: []string{"az-pid"},
+			Usage:   "[azure-devops] Organization and Project ID, in \"org/project\" format",
+			EnvVars: []string{"SHIPPER_AZURE_PROJECT_ID"},
+		},
+		&cli.StringFlag{
+			Name:    "azure-repository-id",
+			Aliases: []string{"az-rid"},
+			Usage:   "[azure-devops] Repository ID (if unsure, use the project ID)",
+			EnvVars: []string{"SHIPPER_AZURE_REPOSITORY_ID"},
+		},
+		&cli.StringFlag{
+			Name:    "azure-key",
+			Aliases: []string{"az-key"},
+			Usage:   "[azure-devops] Username/application token pair in \"username:token\" format",
+			EnvVars: []string{"SHIPPER_AZURE_KEY"},
+		},
+		&cli.StringFlag{
+			Name:    "azure-tenant-id",
+			Aliases: []string{"az-tenant"},
+			Usage:   "[azure-devops] Microsoft Entra ID tenant of the service principal",
+			EnvVars: []string{"SHIPPER_AZURE_TENANT_ID"},
+		},
+		&cli.StringFlag{
+			Name:    "azure-client-id",
+			Aliases: []string{"az-client"},
+			Usage:   "[azure-devops] Service principal client ID, if specified authenticate as the service principal instead of using --azure-key",
+			EnvVars: []string{"SHIPPER_AZURE_CLIENT_ID"},
+		},
+		&cli.StringFlag{
+			Name:    "azure-client-secret",
+			Aliases: []string{"az-secret"},
+			Usage:   "[azure-devops] Service principal client secret",
+			EnvVars: []string{"SHIPPER_AZURE_CLIENT_SECRET"},
+		},
+		&cli.StringFlag{
+			Name:    "azure-client-certificate",
+			Aliases: []string{"az-cert"},
+			Usage:   "[azure-devops] Service principal certificate and private key, either PEM-encoded or as a path to a PEM file, used if no client secret is specified",
+			EnvVars: []string{"SHIPPER_AZURE_CLIENT_CERTIFICATE"},
+		},
+		&cli.StringFlag{
+			Name:    "azure-token-endpoint",
+			Aliases: []string{"az-token-uri"},
+			Usage:   "[azure-devops] OAuth2 token endpoint for service principals, defaults to the tenant's endpoint on " + azure_target.DefaultAuthorityHost,
+			EnvVars: []string{"SHIPPER_AZURE_TOKEN_ENDPOINT"},
+		},
+		// Generic Git (smart HTTP) options
+		&cli.StringFlag{
+			Name:    "git-endpoint",
+			Aliases: []string{"git-uri"},
+			Usage:   "[git] Repository URL, as used for \"git clone\" (eg. \"https://git.example.com/org/repo.git\")",
+			EnvVars: []string{"SHIPPER_GIT_ENDPOINT"},
+		},
+		&cli.StringFlag{
+			Name:    "git-key",
+			Usage:   "[git] Username/password pair in \"username:password\" format",
+			EnvVars: []string{"SHIPPER_GIT_KEY"},
+		},
+		&cli.StringFlag{
+			Name:    "git-token",
+			Usage:   "[git] Bearer token, used instead of --git-key if specified",
+			EnvVars: []string{"SHIPPER_GIT_TOKEN"},
+		},
+		// AWS CodeCommit options
+		&cli.StringFlag{
+			Name:    "codecommit-repository",
+			Aliases: []string{"cc-repo"},
+			Usage:   "[codecommit] Repository name",
+			EnvVars: []string{"SHIPPER_CODECOMMIT_REPOSITORY"},
+		},
+		&cli.StringFlag{
+			Name:    "codecommit-region",
+			Aliases: []string{"cc-region"},
+			Usage:   "[codecommit] AWS region, taken from the AWS environment variables/profile if not specified",
+			EnvVars: []string{"SHIPPER_CODECOMMIT_REGION"},
+		},
+		&cli.StringFlag{
+			Name:    "codecommit-profile",
+			Aliases: []string{"cc-profile"},
+			Usage:   "[codecommit] AWS profile to read credentials from, if not using environment variables (default: $AWS_PROFILE or \"default\")",
+			EnvVars: []string{"SHIPPER_CODECOMMIT_PROFILE"},
+		},
+		&cli.StringFlag{
+			Name:    "codecommit-endpoint",
+			Aliases: []string{"cc-uri"},
+			Usage:   "[codecommit] CodeCommit API endpoint, defaults to the regional endpoint (eg. \"https://codecommit.eu-west-1.amazonaws.com\")",
+			EnvVars: []string{"SHIPPER_CODECOMMIT_ENDPOINT"},
+		},
+		// Gerrit options
+		&cli.StringFlag{
+			Name:    "gerrit-endpoint",
+			Aliases: []string{"gr-uri"},
+			Usage:   "[gerrit] Gerrit base URL (eg. \"https://review.example.com\")",
+			EnvVars: []string{"SHIPPER_GERRIT_ENDPOINT"},
+		},
+		&cli.StringFlag{
+			Name:    "gerrit-key",
+			Aliases: []string{"gr-key"},
+			Usage:   "[gerrit] Username/HTTP password pair in \"username:password\" format",
+			EnvVars: []string{"SHIPPER_GERRIT_KEY"},
+		},
+		&cli.StringFlag{
+			Name:    "gerrit-project",
+			Aliases: []string{"gr-pid"},
+			Usage:   "[gerrit] Project name (eg. \"org/project\")",
+			EnvVars: []string{"SHIPPER_GERRIT_PROJECT"},
+		},
+		&cli.StringSliceFlag{
+			Name:    "gerrit-labels",
+			Aliases: []string{"gr-vote"},
+			Usage:   "[gerrit] Votes to cast on the uploaded change in \"Label=value\" format (eg. \"Code-Review=+2\")",
+			EnvVars: []string{"SHIPPER_GERRIT_LABELS"},
+		},
+		&cli.BoolFlag{
+			Name:    "gerrit-submit",
+			Usage:   "[gerrit] If provided, submit the change once its submit requirements are met",
+			EnvVars: []string{"SHIPPER_GERRIT_SUBMIT"},
+			Value:   false,
+		},
+		// Local filesystem options
+		&cli.StringFlag{
+			Name:    "filesystem-root",
+			Aliases: []string{"fs-root"},
+			Usage:   "[filesystem] Directory file paths are relative to, eg. the root of a cloned repository",
+			EnvVars: []string{"SHIPPER_FILESYSTEM_ROOT"},
+			Value:   ".",
+		},
+		&cli.BoolFlag{
+			Name:    "filesystem-stage",
+			Aliases: []string{"fs-stage"},
+			Usage:   "[filesystem] If provided, stage modified files with \"git add\" so they can be committed externally",
+			EnvVars: []string{"SHIPPER_FILESYSTEM_STAGE"},
+			Value:   false,
+		},
+		// Vault options
+		&cli.StringFlag{
+			Name:    "vault-addr",
+			Usage:   "[vault] Vault server address, required to load credentials with \"vault://<path>#<field>\"",
+			EnvVars: []string{"SHIPPER_VAULT_ADDR", "VAULT_ADDR"},
+		},
+		&cli.StringFlag{
+			Name:    "vault-ca-bundle",
+			Usage:   "[vault] PEM file with additional CA certificates to trust when connecting to Vault",
+			EnvVars: []string{"SHIPPER_VAULT_CA_BUNDLE", "VAULT_CACERT"},
+		},
+		&cli.StringFlag{
+			Name:    "vault-namespace",
+			Usage:   "[vault] Vault Enterprise namespace",
+			EnvVars: []string{"SHIPPER_VAULT_NAMESPACE", "VAULT_NAMESPACE"},
+		},
+		&cli.StringFlag{
+			Name:    "vault-token",
+			Usage:   "[vault] Vault token, if not specified log in with AppRole or JWT",
+			EnvVars: []string{"SHIPPER_VAULT_TOKEN", "VAULT_TOKEN"},
+		},
+		&cli.StringFlag{
+			Name:    "vault-role-id",
+			Usage:   "[vault] AppRole role ID",
+			EnvVars: []string{"SHIPPER_VAULT_ROLE_ID"},
+		},
+		&cli.StringFlag{
+			Name:    "vault-secret-id",
+			Usage:   "[vault] AppRole secret ID",
+			EnvVars: []string{"SHIPPER_VAULT_SECRET_ID"},
+		},
+		&cli.StringFlag{
+			Name:    "vault-jwt",
+			Usage:   "[vault] JWT to log in with the JWT/OIDC auth method (eg. a CI job ID token)",
+			EnvVars: []string{"SHIPPER_VAULT_JWT"},
+		},
+		&cli.StringFlag{
+			Name:    "vault-role",
+			Usage:   "[vault] Role to log in as with the JWT/OIDC auth method",
+			EnvVars: []string{"SHIPPER_VAULT_ROLE"},
+		},
+		&cli.StringFlag{
+			Name:    "vault-auth-mount",
+			Usage:   "[vault] Path the AppRole or JWT/OIDC auth method is mounted at (default: \"approle\" or \"jwt\")",
+			EnvVars: []string{"SHIPPER_VAULT_AUTH_MOUNT"},
+		},
+	}
+	return append(append(command, general...), shared...)
+}
+
+// templaterFlag returns the option choosing the templater, for commands working on a single kind of file
+func templaterFlag() cli.Flag {
+	return &cli.StringFlag{
+		Name:     "templater",
+		Aliases:  []string{"p"},
+		Usage:    `Template system (available: "helm", "kustomize", "json")`,
+		EnvVars:  []string{"SHIPPER_PROVIDER"},
+		Required: true,
+	}
+}
+
+// updateFlags returns the options of commands updating images
+func updateFlags() []cli.Flag {
+	return []cli.Flag{
+		templaterFlag(),
+		&cli.StringFlag{
+			Name:    "commit-author",
+			Aliases: []string{"a"},
+			Usage:   "Commit author in \"name <email>\" format",
+			EnvVars: []string{"SHIPPER_COMMIT_AUTHOR"},
+			Value:   "Shipper agent <shipper@example.com>",
+		},
+		&cli.StringFlag{
+			Name:    "commit-message",
+			Aliases: []string{"m"},
+			Usage:   "Commit message",
+			EnvVars: []string{"SHIPPER_COMMIT_MESSAGE"},
+			Value:   "Deploy",
+		},
+		&cli.StringFlag{
+			Name:    "pr-branch",
+			Usage:   "If specified, commit to this branch instead and open a pull request towards --repo-branch (\"gitlab\" and \"bitbucket-server\" only)",
+			EnvVars: []string{"SHIPPER_PR_BRANCH"},
+		},
+		&cli.StringSliceFlag{
+			Name:     "container-image",
+			Aliases:  []string{"ci"},
+			Usage:    "Container image",
+			EnvVars:  []string{"SHIPPER_CONTAINER_IMAGE", "SHIPPER_CONTAINER_IMAGES"},
+			Required: true,
+		},
+		&cli.StringSliceFlag{
+			Name:     "container-tag",
+			Aliases:  []string{"ct"},
+			Usage:    "Container tag",
+			EnvVars:  []string{"SHIPPER_CONTAINER_TAG", "SHIPPER_CONTAINER_TAGS"},
+			Required: true,
+		},
+		&cli.StringFlag{
+			Name:    "downgrade-policy",
+			Usage:   `What to do with updates moving a tag backwards (available: "allow", "skip", "refuse")`,
+			EnvVars: []string{"SHIPPER_DOWNGRADE_POLICY"},
+			Value:   "allow",
+		},
+		&cli.BoolFlag{
+			Name:    "allow-downgrade",
+			Usage:   "Apply updates moving a tag backwards whatever the downgrade policy, still reporting them",
+			EnvVars: []string{"SHIPPER_ALLOW_DOWNGRADE"},
+		},
+		&cli.StringFlag{
+			Name:    "tag-ordering",
+			Usage:   `How tags are compared by the downgrade policy (available: "auto", "semver", "calver", "numeric", "regex")`,
+			EnvVars: []string{"SHIPPER_TAG_ORDERING"},
+			Value:   "auto",
+		},
+		&cli.StringFlag{
+			Name:    "tag-regex",
+			Usage:   "Regular expression whose capture groups are compared in order, such as timestamps embedded in tags (implies --tag-ordering regex)",
+			EnvVars: []string{"SHIPPER_TAG_REGEX"},
+		},
+	}
+}
+
+// getFlags returns the options of the get command
+func getFlags() []cli.Flag {
+	return []cli.Flag{
+		templaterFlag(),
+		&cli.StringSliceFlag{
+			Name:    "container-image",
+			Aliases: []string{"ci"},
+			Usage:   "Container image to look up, required by \"kustomize\" (image name) and \"json\" (key)",
+			EnvVars: []string{"SHIPPER_CONTAINER_IMAGE", "SHIPPER_CONTAINER_IMAGES"},
+		},
+		&cli.StringFlag{
+			Name:    "output",
+			Aliases: []string{"o"},
+			Usage:   `Output format (available: "text", "json")`,
+			EnvVars: []string{"SHIPPER_OUTPUT"},
+			Value:   "text",
+		},
+	}
+}
+
+// statusFlags returns the options of the status command
+func statusFlags() []cli.Flag {
+	return []cli.Flag{
+		&cli.StringFlag{
+			Name:     "config",
+			Aliases:  []string{"c"},
+			Usage:    "YAML file listing the environments to compare",
+			EnvVars:  []string{"SHIPPER_STATUS_CONFIG"},
+			Required: true,
+		},
+		&cli.StringFlag{
+			Name:    "output",
+			Aliases: []string{"o"},
+			Usage:   `Output format (available: "text", "json", "markdown")`,
+			EnvVars: []string{"SHIPPER_OUTPUT"},
+			Value:   "text",
+		},
+	}
+}
+
+// promoteFlags returns the options of the promote command
+func promoteFlags() []cli.Flag {
+	return []cli.Flag{
+		&cli.StringFlag{
+			Name:     "config",
+			Aliases:  []string{"c"},
+			Usage:    "YAML file listing the environments",
+			EnvVars:  []string{"SHIPPER_STATUS_CONFIG"},
+			Required: true,
+		},
+		&cli.StringFlag{
+			Name:     "from",
+			Usage:    "Environment to read the versions from",
+			EnvVars:  []string{"SHIPPER_PROMOTE_FROM"},
+			Required: true,
+		},
+		&cli.StringFlag{
+			Name:     "to",
+			Usage:    "Environment to update",
+			EnvVars:  []string{"SHIPPER_PROMOTE_TO"},
+			Required: true,
+		},
+		&cli.StringSliceFlag{
+			Name:    "app",
+			Usage:   "App to promote, all the ones of both environments if not specified",
+			EnvVars: []string{"SHIPPER_PROMOTE_APPS"},
+		},
+		&cli.BoolFlag{
+			Name:    "tags-only",
+			Usage:   "Only promote tags, keeping the images of the destination environment",
+			EnvVars: []string{"SHIPPER_PROMOTE_TAGS_ONLY"},
+		},
+		&cli.StringFlag{
+			Name:    "commit-author",
+			Aliases: []string{"a"},
+			Usage:   "Commit author in \"name <email>\" format",
+			EnvVars: []string{"SHIPPER_COMMIT_AUTHOR"},
+			Value:   "Shipper agent <shipper@example.com>",
+		},
+		&cli.StringFlag{
+			Name:    "commit-message",
+			Aliases: []string{"m"},
+			Usage:   "First line of the commit message, the environments are named if not specified",
+			EnvVars: []string{"SHIPPER_COMMIT_MESSAGE"},
+		},
+		&cli.StringFlag{
+			Name:    "pr-branch",
+			Usage:   "If specified, commit to this branch instead and open a pull request towards the destination branch (\"gitlab\" and \"bitbucket-server\" only)",
+			EnvVars: []string{"SHIPPER_PR_BRANCH"},
+		},
+	}
+}
+
+// historyFlags returns the options of the history command
+func historyFlags() []cli.Flag {
+	return []cli.Flag{
+		templaterFlag(),
+		&cli.StringSliceFlag{
+			Name:    "container-image",
+			Aliases: []string{"ci"},
+			Usage:   "Container image to look up, required by \"kustomize\" (image name) and \"json\" (key)",
+			EnvVars: []string{"SHIPPER_CONTAINER_IMAGE", "SHIPPER_CONTAINER_IMAGES"},
+		},
+		&cli.IntFlag{
+			Name:    "history-limit",
+			Usage:   "Number of commits changing the files to read",
+			EnvVars: []string{"SHIPPER_HISTORY_LIMIT"},
+			Value:   100,
+		},
+		&cli.StringFlag{
+			Name:    "output",
+			Aliases: []string{"o"},
+			Usage:   `Output format (available: "text", "json")`,
+			EnvVars: []string{"SHIPPER_OUTPUT"},
+			Value:   "text",
+		},
+	}
 }
 
+// rollbackFlags returns the options of the rollback command
+func rollbackFlags() []cli.Flag {
+	return []cli.Flag{
+		templaterFlag(),
+		&cli.StringSliceFlag{
+			Name:    "container-image",
+			Aliases: []string{"ci"},
+			Usage:   "Container image to roll back, required by \"kustomize\" (image name) and \"json\" (key)",
+			EnvVars: []string{"SHIPPER_CONTAINER_IMAGE", "SHIPPER_CONTAINER_IMAGES"},
+		},
+		&cli.IntFlag{
+			Name:    "history-limit",
+			Usage:   "Number of commits changing the files to look for previous images in",
+			EnvVars: []string{"SHIPPER_HISTORY_LIMIT"},
+			Value:   100,
+		},
+		&cli.StringFlag{
+			Name:    "commit-author",
+			Aliases: []string{"a"},
+			Usage:   "Commit author in \"name <email>\" format",
+			EnvVars: []string{"SHIPPER_COMMIT_AUTHOR"},
+			Value:   "Shipper agent <shipper@example.com>",
+		},
+		&cli.StringFlag{
+			Name:    "commit-message",
+			Aliases: []string{"m"},
+			Usage:   "First line of the commit message, the rolled back images are named if not specified",
+			EnvVars: []string{"SHIPPER_COMMIT_MESSAGE"},
+		},
+		&cli.StringFlag{
+			Name:    "pr-branch",
+			Usage:   "If specified, commit to this branch instead and open a pull request towards the repository branch (\"gitlab\" and \"bitbucket-server\" only)",
+			EnvVars: []string{"SHIPPER_PR_BRANCH"},
+		},
+	}
+}
+
+// setupTracing creates a tracer exporting spans to the configured OpenTelemetry collector,
+// or returns nil if tracing is not enabled
+func setupTracing(c *cli.Context) (*tracing.Tracer, error) {
+	endpoint := c.String("otel-endpoint")
+	if endpoint == "" {
+		return nil, nil
+	}
+	headers, err := tracing.ParseHeaders(c.String("otel-headers"))
+	if err != nil {
+		return nil, fmt.Errorf("error parsing --otel-headers: %w", err)
+	}
+	client, err := telemetryClient(c)
+	if err != nil {
+		return nil, err
+	}
+
+	tracer := tracing.NewTracer(tracing.NewOTLPExporter(endpoint, headers, c.String("otel-service-name"), version, client))
+	if traceparent := c.String("otel-traceparent"); traceparent != "" {
+		parent, err := tracing.ParseTraceparent(traceparent)
+		if err != nil {
+			logging.Warn("ignoring parent trace, starting a new one", "error", err)
+		} else {
+			tracer.SetParent(parent)
+		}
+	}
+	return tracer, nil
+}
+
+// setupMetrics creates the metrics recorded during the run and a function publishing them to the configured
+// Pushgateway and file, or returns nil metrics if neither is configured
+func setupMetrics(c *cli.Context) (*metrics.Metrics, func() error, error) {
+	gateway := c.String("metrics-pushgateway")
+	file := c.String("metrics-file")
+	if gateway == "" && file == "" {
+		return nil, func() error { return nil }, nil
+	}
+	job := c.String("metrics-job")
+	labels, err := metrics.ParseLabels(c.StringSlice("metrics-label"))
+	if err != nil {
+		return nil, nil, fmt.Errorf("error parsing --metrics-label: %w", err)
+	}
+	client, err := telemetryClient(c)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	recorded := metrics.NewMetrics()
+	publish := func() error {
+		if file != "" {
+			// Without a Pushgateway, the job and grouping labels are added to the samples
+			err := recorded.WriteFile(file, append([]metrics.Label{{Name: "job", Value: job}}, labels...)...)
+			if err != nil {
+				return err
+			}
+		}
+		if gateway != "" {
+			return recorded.Push(client, gateway, job, labels...)
+		}
+		return nil
+	}
+	return recorded, publish, nil
+}
+
+// deploymentLabels returns the environment and app a command deploys to, as known from its options or,
+// failing that, from the "environment" and "app" metrics labels
+func deploymentLabels(c *cli.Context, command string) (string, string) {
+	environment, app := "", ""
+	labels, _ := metrics.ParseLabels(c.StringSlice("metrics-label"))
+	for _, label := range labels {
+		switch label.Name {
+		case "environment":
+			environment = label.Value
+		case "app":
+			app = label.Value
+		}
+	}
+
+	if command == "promote" {
+		environment = c.String("to")
+		// Promoting several apps at once is a single deployment
+		if apps := c.StringSlice("app"); len(apps) == 1 {
+			app = apps[0]
+		}
+	}
+	return environment, app
+}
+
+// telemetryClient creates the HTTP client used to export traces and metrics, with the proxy settings of the target
+func telemetryClient(c *cli.Context) (*http.Client, error) {
+	return common.NewHTTPClient(common.ClientOptions{
+		ProxyURL:    c.String("proxy"),
+		NoProxy:     c.String("no-proxy"),
+		Middlewares: []common.Middleware{common.UserAgent("shipper/" + version)},
+	})
+}
+
+// secret returns the value of a credentials flag, loading it from the external source it references, if any
+func secret(c *cli.Context, flag string, endpoint string, format credentials.Format) string {
+	value := c.String(flag)
+	if value == "" {
+		return ""
+	}
+	resolved, err := credentials.Resolve(value, endpoint, format)
+	check(err, "Error loading --%s", flag)
+	return resolved
+}
+
+// principalEndpoint returns the token endpoint of the Azure service principal, for credential lookups
+func principalEndpoint(c *cli.Context) string {
+	if endpoint := c.String("azure-token-endpoint"); endpoint != "" {
+		return endpoint
+	}
+	return azure_target.DefaultAuthorityHost
+}
+
+// readPEM returns PEM-encoded data specified either directly or as a path to a PEM file
+func readPEM(value string) ([]byte, error) {
+	if strings.HasPrefix(strings.TrimSpace(value), "-----BEGIN") {
+		return []byte(value), nil
+	}
+	return os.ReadFile(value)
+}
+
+// check exits if err is not nil. Errors while setting up are caused by invalid options,
+// unless they come from a service such as Vault.
 func check(err error, format string, args ...any) {
 	if err != nil {
-		args = append(args, err.Error())
-		log.Fatalf(format+": %s", args...)
+		code := result.CodeOf(err)
+		if code == result.CodeError {
+			code = result.CodeValidation
+		}
+		exit(result.WithCode(code, fmt.Errorf("%s: %w", fmt.Sprintf(format, args...), err)))
 	}
 }
 
+// assert exits with a validation error if cond is false
 func assert(cond bool, format string, args ...any) {
 	if !cond {
-		log.Fatalf(format, args...)
+		exit(result.WithCode(result.CodeValidation, fmt.Errorf(format, args...)))
 	}
 }
@@ -0,0 +1,37 @@
+package common
+
+import (
+	"encoding/base64"
+	"net/http"
+)
+
+// Authenticator adds credentials to a request, each target declares the strategy its provider expects
+type Authenticator interface {
+	Authenticate(req *http.Request) error
+}
+
+// AuthenticatorFunc adapts a function to the Authenticator interface, for strategies that need
+// to fetch or refresh tokens before each request
+type AuthenticatorFunc func(req *http.Request) error
+
+func (f AuthenticatorFunc) Authenticate(req *http.Request) error {
+	return f(req)
+}
+
+// HeaderAuth sets a header to a fixed value (eg. GitLab's PRIVATE-TOKEN)
+func HeaderAuth(name string, value string) Authenticator {
+	return AuthenticatorFunc(func(req *http.Request) error {
+		req.Header.Set(name, value)
+		return nil
+	})
+}
+
+// BasicAuth uses HTTP Basic authentication, credentials must be in "username:password" format
+func BasicAuth(credentials string) Authenticator {
+	return HeaderAuth("Authorization", "Basic "+base64.StdEncoding.EncodeToString([]byte(credentials)))
+}
+
+// BearerAuth uses a bearer token
+func BearerAuth(token string) Authenticator {
+	return HeaderAuth("Authorization", "Bearer "+token)
+}
@@ -0,0 +1,150 @@
+package common
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+)
+
+var (
+	ErrInvalidTLSVersion = Categorize(ErrValidation, errors.New("invalid TLS version"))
+)
+
+var tlsVersions = map[string]uint16{
+	"1.0": tls.VersionTLS10,
+	"1.1": tls.VersionTLS11,
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+// ClientOptions are the TLS and proxy settings of an HTTP client
+type ClientOptions struct {
+	// CABundle is the path to a PEM file with additional trusted CA certificates
+	CABundle string
+	// ClientCertificate and ClientKey are paths to the PEM-encoded client certificate and key for mutual TLS.
+	// If ClientKey is empty, the key is read from the certificate file.
+	ClientCertificate string
+	ClientKey         string
+	// MinTLSVersion is the minimum TLS version to accept (eg. "1.2")
+	MinTLSVersion string
+	// InsecureSkipVerify disables certificate validation
+	InsecureSkipVerify bool
+
+	// ProxyURL is the proxy to use for all requests, if empty proxies are taken from the environment
+	// (HTTP_PROXY, HTTPS_PROXY and NO_PROXY)
+	ProxyURL string
+	// NoProxy is a comma-separated list of hosts, domains and CIDRs to connect to directly
+	NoProxy string
+
+	// Middlewares wrap the transport, the first one being the outermost
+	Middlewares []Middleware
+}
+
+// NewHTTPClient creates an HTTP client with its own transport configured with options
+func NewHTTPClient(options ClientOptions) (*http.Client, error) {
+	tlsConfig := &tls.Config{
+		InsecureSkipVerify: options.InsecureSkipVerify,
+	}
+
+	if options.CABundle != "" {
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			pool = x509.NewCertPool()
+		}
+		byt, err := os.ReadFile(options.CABundle)
+		if err != nil {
+			return nil, fmt.Errorf("error reading CA bundle: %w", err)
+		}
+		if !pool.AppendCertsFromPEM(byt) {
+			return nil, fmt.Errorf("no valid certificates found in CA bundle %s", options.CABundle)
+		}
+		tlsConfig.RootCAs = pool
+	}
+
+	if options.ClientCertificate != "" {
+		key := options.ClientKey
+		if key == "" {
+			key = options.ClientCertificate
+		}
+		certificate, err := tls.LoadX509KeyPair(options.ClientCertificate, key)
+		if err != nil {
+			return nil, fmt.Errorf("error loading client certificate: %w", err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{certificate}
+	}
+
+	if options.MinTLSVersion != "" {
+		version, ok := tlsVersions[options.MinTLSVersion]
+		if !ok {
+			return nil, fmt.Errorf("%w: %s", ErrInvalidTLSVersion, options.MinTLSVersion)
+		}
+		tlsConfig.MinVersion = version
+	}
+
+	proxy, err := proxyFunc(options.ProxyURL, options.NoProxy)
+	if err != nil {
+		return nil, err
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = tlsConfig
+	transport.Proxy = proxy
+	return &http.Client{Transport: Chain(transport, options.Middlewares...)}, nil
+}
+
+// proxyFunc returns the proxy selection function for a transport
+func proxyFunc(proxyURL string, noProxy string) (func(*http.Request) (*url.URL, error), error) {
+	if proxyURL == "" && noProxy == "" {
+		return http.ProxyFromEnvironment, nil
+	}
+
+	var proxy *url.URL
+	if proxyURL != "" {
+		var err error
+		proxy, err = url.Parse(proxyURL)
+		if err != nil {
+			return nil, fmt.Errorf("error parsing proxy URL: %w", err)
+		}
+	}
+
+	return func(req *http.Request) (*url.URL, error) {
+		if bypassProxy(req.URL.Hostname(), noProxy) {
+			return nil, nil
+		}
+		if proxy == nil {
+			return http.ProxyFromEnvironment(req)
+		}
+		return proxy, nil
+	}, nil
+}
+
+// bypassProxy checks if host matches a NO_PROXY-style list: "*", hosts, domains (matching subdomains too) or CIDRs
+func bypassProxy(host string, noProxy string) bool {
+	ip := net.ParseIP(host)
+	for _, entry := range strings.Split(noProxy, ",") {
+		entry = strings.ToLower(strings.TrimSpace(entry))
+		switch {
+		case entry == "":
+			continue
+		case entry == "*":
+			return true
+		case ip != nil && strings.Contains(entry, "/"):
+			if _, network, err := net.ParseCIDR(entry); err == nil && network.Contains(ip) {
+				return true
+			}
+		default:
+			domain := strings.TrimPrefix(entry, ".")
+			host := strings.ToLower(host)
+			if host == domain || strings.HasSuffix(host, "."+domain) {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,154 @@
+package common
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/neosperience/shipper/test"
+)
+
+// writeCABundle writes the certificate of a TLS test server to a PEM file
+func writeCABundle(t *testing.T, server *httptest.Server) string {
+	path := filepath.Join(t.TempDir(), "ca.pem")
+	test.MustSucceed(t, os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw}), 0600), "Failed writing CA bundle")
+	return path
+}
+
+// writeClientCertificate creates a self-signed client certificate, returning its certificate and key files
+func writeClientCertificate(t *testing.T) (*x509.Certificate, string, string) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	test.MustSucceed(t, err, "Failed generating client key")
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "shipper-client"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+		KeyUsage:     x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		IsCA:         true,
+
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	test.MustSucceed(t, err, "Failed creating client certificate")
+	certificate, err := x509.ParseCertificate(der)
+	test.MustSucceed(t, err, "Failed parsing client certificate")
+
+	dir := t.TempDir()
+	certificatePath := filepath.Join(dir, "client.pem")
+	keyPath := filepath.Join(dir, "client-key.pem")
+	test.MustSucceed(t, os.WriteFile(certificatePath, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600), "Failed writing client certificate")
+	test.MustSucceed(t, os.WriteFile(keyPath, pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)}), 0600), "Failed writing client key")
+	return certificate, certificatePath, keyPath
+}
+
+func TestNewHTTPClientCABundle(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {}))
+	defer server.Close()
+
+	// Default client must not trust the test server
+	client, err := NewHTTPClient(ClientOptions{})
+	test.MustSucceed(t, err, "Failed creating client")
+	_, err = HTTPRequest(client, "GET", server.URL, nil, nil)
+	test.MustFail(t, err, "Request to untrusted server supposed to fail but succeeded")
+
+	client, err = NewHTTPClient(ClientOptions{CABundle: writeCABundle(t, server)})
+	test.MustSucceed(t, err, "Failed creating client with CA bundle")
+	_, err = HTTPRequest(client, "GET", server.URL, nil, nil)
+	test.MustSucceed(t, err, "Request to server trusted with CA bundle failed")
+
+	client, err = NewHTTPClient(ClientOptions{InsecureSkipVerify: true})
+	test.MustSucceed(t, err, "Failed creating insecure client")
+	_, err = HTTPRequest(client, "GET", server.URL, nil, nil)
+	test.MustSucceed(t, err, "Request with certificate validation disabled failed")
+
+	_, err = NewHTTPClient(ClientOptions{CABundle: filepath.Join(t.TempDir(), "missing.pem")})
+	test.MustFail(t, err, "Creating client with a missing CA bundle supposed to fail but succeeded")
+}
+
+func TestNewHTTPClientMutualTLS(t *testing.T) {
+	clientCertificate, certificatePath, keyPath := writeClientCertificate(t)
+	pool := x509.NewCertPool()
+	pool.AddCert(clientCertificate)
+
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {}))
+	server.TLS = &tls.Config{
+		ClientAuth: tls.RequireAndVerifyClientCert,
+		ClientCAs:  pool,
+	}
+	server.StartTLS()
+	defer server.Close()
+	caBundle := writeCABundle(t, server)
+
+	client, err := NewHTTPClient(ClientOptions{CABundle: caBundle})
+	test.MustSucceed(t, err, "Failed creating client")
+	_, err = HTTPRequest(client, "GET", server.URL, nil, nil)
+	test.MustFail(t, err, "Request without client certificate supposed to fail but succeeded")
+
+	client, err = NewHTTPClient(ClientOptions{CABundle: caBundle, ClientCertificate: certificatePath, ClientKey: keyPath})
+	test.MustSucceed(t, err, "Failed creating client with client certificate")
+	_, err = HTTPRequest(client, "GET", server.URL, nil, nil)
+	test.MustSucceed(t, err, "Request with client certificate failed")
+}
+
+func TestNewHTTPClientMinTLSVersion(t *testing.T) {
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {}))
+	server.TLS = &tls.Config{MaxVersion: tls.VersionTLS12}
+	server.StartTLS()
+	defer server.Close()
+	caBundle := writeCABundle(t, server)
+
+	client, err := NewHTTPClient(ClientOptions{CABundle: caBundle, MinTLSVersion: "1.2"})
+	test.MustSucceed(t, err, "Failed creating client")
+	_, err = HTTPRequest(client, "GET", server.URL, nil, nil)
+	test.MustSucceed(t, err, "Request with supported TLS version failed")
+
+	client, err = NewHTTPClient(ClientOptions{CABundle: caBundle, MinTLSVersion: "1.3"})
+	test.MustSucceed(t, err, "Failed creating client")
+	_, err = HTTPRequest(client, "GET", server.URL, nil, nil)
+	test.MustFail(t, err, "Request with unsupported TLS version supposed to fail but succeeded")
+
+	_, err = NewHTTPClient(ClientOptions{MinTLSVersion: "2.0"})
+	test.MustFail(t, err, "Creating client with invalid TLS version supposed to fail but succeeded")
+}
+
+func TestNewHTTPClientProxy(t *testing.T) {
+	proxied := false
+	proxy := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		// Proxied requests have the absolute URL of the target
+		test.AssertExpected(t, req.URL.Host, "git.example.com", "Proxied request host doesn't match expected value")
+		proxied = true
+	}))
+	defer proxy.Close()
+
+	client, err := NewHTTPClient(ClientOptions{ProxyURL: proxy.URL, NoProxy: "internal.example.com,10.0.0.0/8"})
+	test.MustSucceed(t, err, "Failed creating client with proxy")
+	_, err = HTTPRequest(client, "GET", "http://git.example.com/api", nil, nil)
+	test.MustSucceed(t, err, "Proxied request failed")
+	test.AssertExpected(t, proxied, true, "Request didn't go through the proxy")
+
+	transport := client.Transport.(*http.Transport)
+	for host, expected := range map[string]bool{
+		"git.example.com":          true,
+		"internal.example.com":     false,
+		"api.internal.example.com": false,
+		"10.1.2.3":                 false,
+		"192.168.1.1":              true,
+	} {
+		proxyURL, err := transport.Proxy(&http.Request{URL: &url.URL{Scheme: "https", Host: host}})
+		test.MustSucceed(t, err, "Failed getting proxy")
+		test.AssertExpected(t, proxyURL != nil, expected, "Proxy use doesn't match expected value for "+host)
+	}
+}
@@ -0,0 +1,352 @@
+package common
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	jsoniter "github.com/json-iterator/go"
+)
+
+// DefaultDebugBodyLimit is how much of each body is logged by DebugHTTP
+const DefaultDebugBodyLimit = 4096
+
+// Headers whose value is always hidden, as they carry credentials
+var sensitiveHeaders = map[string]bool{
+	"Authorization":        true,
+	"Proxy-Authorization":  true,
+	"Private-Token":        true,
+	"Job-Token":            true,
+	"X-Vault-Token":        true,
+	"X-Amz-Security-Token": true,
+	"Cookie":               true,
+	"Set-Cookie":           true,
+}
+
+// Credentials in JSON and form-encoded bodies, such as OAuth2 token requests and responses or Vault logins,
+// which can't be registered as secrets before they are sent or received
+var (
+	sensitiveJSONFieldRegex = regexp.MustCompile(`("(?:access_token|refresh_token|id_token|token|client_token|client_secret|client_assertion|secret_id|password|jwt)"\s*:\s*)"[^"]*"`)
+	sensitiveFormFieldRegex = regexp.MustCompile(`((?:^|&)(?:access_token|refresh_token|client_secret|client_assertion|password)=)[^&]*`)
+)
+
+// redactHeaders returns a copy of headers with credentials redacted
+func redactHeaders(headers http.Header) http.Header {
+	redacted := make(http.Header, len(headers))
+	for name, values := range headers {
+		copied := make([]string, len(values))
+		for index, value := range values {
+			if sensitiveHeaders[http.CanonicalHeaderKey(name)] {
+				copied[index] = RedactedPlaceholder
+			} else {
+				copied[index] = Redact(value)
+			}
+		}
+		redacted[name] = copied
+	}
+	return redacted
+}
+
+// redactBody returns a printable version of a body with credentials redacted, truncated to limit bytes (if positive)
+func redactBody(body []byte, limit int) string {
+	if len(body) == 0 {
+		return ""
+	}
+	if !utf8.Valid(body) {
+		return fmt.Sprintf("<%d bytes of binary data>", len(body))
+	}
+	text := Redact(string(body))
+	text = sensitiveJSONFieldRegex.ReplaceAllString(text, `$1"`+RedactedPlaceholder+`"`)
+	text = sensitiveFormFieldRegex.ReplaceAllString(text, "${1}"+RedactedPlaceholder)
+	if limit > 0 && len(text) > limit {
+		// Cut before the rune crossing the limit, so the output stays valid UTF-8
+		cut := limit
+		for cut > 0 && !utf8.RuneStart(text[cut]) {
+			cut--
+		}
+		text = fmt.Sprintf("%s... (%d more bytes)", text[:cut], len(text)-cut)
+	}
+	return text
+}
+
+// exchange is a request with its response, captured for debugging
+type exchange struct {
+	req          *http.Request
+	requestBody  []byte
+	res          *http.Response
+	responseBody []byte
+	err          error
+	start        time.Time
+	duration     time.Duration
+}
+
+// capture performs a request through next, reading both bodies while leaving them readable for the caller
+func capture(next http.RoundTripper, req *http.Request) (*exchange, *http.Response, error) {
+	ex := &exchange{req: req, start: time.Now()}
+	if req.GetBody != nil {
+		if body, err := req.GetBody(); err == nil {
+			ex.requestBody, _ = ioutil.ReadAll(body)
+			_ = body.Close()
+		}
+	}
+
+	res, err := next.RoundTrip(req)
+	ex.res, ex.err = res, err
+	if res != nil {
+		ex.responseBody, _ = ioutil.ReadAll(res.Body)
+		_ = res.Body.Close()
+		res.Body = ioutil.NopCloser(bytes.NewReader(ex.responseBody))
+	}
+	ex.duration = time.Since(ex.start)
+	return ex, res, err
+}
+
+// DebugHTTP logs every request and response with headers and bodies truncated to bodyLimit bytes
+// (no limit if 0) using logf (eg. log.Printf). Credentials are redacted from headers, bodies and URLs.
+func DebugHTTP(logf func(format string, v ...any), bodyLimit int) Middleware {
+	return func(next http.RoundTripper) http.RoundTripper {
+		return RoundTripperFunc(func(req *http.Request) (*http.Response, error) {
+			ex, res, err := capture(next, req)
+
+			b := new(strings.Builder)
+			fmt.Fprintf(b, "> %s %s\n", req.Method, Redact(req.URL.Redacted()))
+			writeHeaders(b, "> ", req.Header)
+			if body := redactBody(ex.requestBody, bodyLimit); body != "" {
+				fmt.Fprintf(b, "> \n%s\n", body)
+			}
+			if err != nil {
+				fmt.Fprintf(b, "< failed after %s: %s", ex.duration.Round(time.Millisecond), Redact(err.Error()))
+			} else {
+				fmt.Fprintf(b, "< %s %s in %s\n", res.Proto, res.Status, ex.duration.Round(time.Millisecond))
+				writeHeaders(b, "< ", res.Header)
+				if body := redactBody(ex.responseBody, bodyLimit); body != "" {
+					fmt.Fprintf(b, "< \n%s", body)
+				}
+			}
+			logf("HTTP exchange\n%s", strings.TrimRight(b.String(), "\n"))
+			return res, err
+		})
+	}
+}
+
+func writeHeaders(w io.Writer, prefix string, headers http.Header) {
+	redacted := redactHeaders(headers)
+	names := make([]string, 0, len(redacted))
+	for name := range redacted {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		for _, value := range redacted[name] {
+			fmt.Fprintf(w, "%s%s: %s\n", prefix, name, value)
+		}
+	}
+}
+
+// HARRecorder records requests in HTTP Archive (HAR 1.2) format, with credentials redacted
+type HARRecorder struct {
+	creator string
+	version string
+
+	mutex   sync.Mutex
+	entries []harEntry
+}
+
+type harLog struct {
+	Log struct {
+		Version string     `json:"version"`
+		Creator harCreator `json:"creator"`
+		Entries []harEntry `json:"entries"`
+	} `json:"log"`
+}
+
+type harCreator struct {
+	Name    string `json:"name"`
+	Version string `json:"version"`
+}
+
+type harEntry struct {
+	StartedDateTime string      `json:"startedDateTime"`
+	Time            float64     `json:"time"`
+	Request         harRequest  `json:"request"`
+	Response        harResponse `json:"response"`
+	Cache           struct{}    `json:"cache"`
+	Timings         harTimings  `json:"timings"`
+	Comment         string      `json:"comment,omitempty"`
+}
+
+type harNameValue struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+type harRequest struct {
+	Method      string         `json:"method"`
+	URL         string         `json:"url"`
+	HTTPVersion string         `json:"httpVersion"`
+	Cookies     []harNameValue `json:"cookies"`
+	Headers     []harNameValue `json:"headers"`
+	QueryString []harNameValue `json:"queryString"`
+	PostData    *harPostData   `json:"postData,omitempty"`
+	HeadersSize int            `json:"headersSize"`
+	BodySize    int            `json:"bodySize"`
+}
+
+type harPostData struct {
+	MimeType string `json:"mimeType"`
+	Text     string `json:"text"`
+}
+
+type harResponse struct {
+	Status      int            `json:"status"`
+	StatusText  string         `json:"statusText"`
+	HTTPVersion string         `json:"httpVersion"`
+	Cookies     []harNameValue `json:"cookies"`
+	Headers     []harNameValue `json:"headers"`
+	Content     harContent     `json:"content"`
+	RedirectURL string         `json:"redirectURL"`
+	HeadersSize int            `json:"headersSize"`
+	BodySize    int            `json:"bodySize"`
+}
+
+type harContent struct {
+	Size     int    `json:"size"`
+	MimeType string `json:"mimeType"`
+	Text     string `json:"text,omitempty"`
+}
+
+type harTimings struct {
+	Send    float64 `json:"send"`
+	Wait    float64 `json:"wait"`
+	Receive float64 `json:"receive"`
+}
+
+// NewHARRecorder creates a HARRecorder, creator and version identify the program in the archive
+func NewHARRecorder(creator string, version string) *HARRecorder {
+	return &HARRecorder{
+		creator: creator,
+		version: version,
+		entries: []harEntry{},
+	}
+}
+
+// Middleware returns a middleware that records requests in the archive
+func (h *HARRecorder) Middleware() Middleware {
+	return func(next http.RoundTripper) http.RoundTripper {
+		return RoundTripperFunc(func(req *http.Request) (*http.Response, error) {
+			ex, res, err := capture(next, req)
+			h.record(ex)
+			return res, err
+		})
+	}
+}
+
+func harHeaders(headers http.Header) []harNameValue {
+	redacted := redactHeaders(headers)
+	pairs := []harNameValue{}
+	for name, values := range redacted {
+		for _, value := range values {
+			pairs = append(pairs, harNameValue{Name: name, Value: value})
+		}
+	}
+	sort.Slice(pairs, func(i, j int) bool {
+		return pairs[i].Name < pairs[j].Name
+	})
+	return pairs
+}
+
+func (h *HARRecorder) record(ex *exchange) {
+	milliseconds := float64(ex.duration) / float64(time.Millisecond)
+	entry := harEntry{
+		StartedDateTime: ex.start.Format(time.RFC3339Nano),
+		Time:            milliseconds,
+		Request: harRequest{
+			Method:      ex.req.Method,
+			URL:         Redact(ex.req.URL.Redacted()),
+			HTTPVersion: "HTTP/1.1",
+			Cookies:     []harNameValue{},
+			Headers:     harHeaders(ex.req.Header),
+			QueryString: []harNameValue{},
+			HeadersSize: -1,
+			BodySize:    len(ex.requestBody),
+		},
+		Response: harResponse{
+			Cookies:     []harNameValue{},
+			Headers:     []harNameValue{},
+			HeadersSize: -1,
+			BodySize:    -1,
+		},
+		Timings: harTimings{Wait: milliseconds},
+	}
+	for name, values := range ex.req.URL.Query() {
+		for _, value := range values {
+			entry.Request.QueryString = append(entry.Request.QueryString, harNameValue{Name: name, Value: Redact(value)})
+		}
+	}
+	if len(ex.requestBody) > 0 {
+		entry.Request.PostData = &harPostData{
+			MimeType: ex.req.Header.Get("Content-Type"),
+			Text:     redactBody(ex.requestBody, 0),
+		}
+	}
+
+	if ex.err != nil {
+		entry.Comment = Redact(ex.err.Error())
+	} else {
+		entry.Response.Status = ex.res.StatusCode
+		entry.Response.StatusText = strings.TrimSpace(strings.TrimPrefix(ex.res.Status, fmt.Sprint(ex.res.StatusCode)))
+		entry.Response.HTTPVersion = ex.res.Proto
+		entry.Response.Headers = harHeaders(ex.res.Header)
+		entry.Response.RedirectURL = ex.res.Header.Get("Location")
+		entry.Response.BodySize = len(ex.responseBody)
+		entry.Response.Content = harContent{
+			Size:     len(ex.responseBody),
+			MimeType: ex.res.Header.Get("Content-Type"),
+			Text:     redactBody(ex.responseBody, 0),
+		}
+	}
+	if ex.req.Proto != "" {
+		entry.Request.HTTPVersion = ex.req.Proto
+	}
+
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	h.entries = append(h.entries, entry)
+}
+
+// Write writes the archive as JSON
+func (h *HARRecorder) Write(w io.Writer) error {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	var archive harLog
+	archive.Log.Version = "1.2"
+	archive.Log.Creator = harCreator{Name: h.creator, Version: h.version}
+	archive.Log.Entries = h.entries
+
+	encoder := jsoniter.ConfigCompatibleWithStandardLibrary.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(archive); err != nil {
+		return fmt.Errorf("error encoding HAR: %w", err)
+	}
+	return nil
+}
+
+// WriteFile writes the archive to a file
+func (h *HARRecorder) WriteFile(path string) error {
+	file, err := os.Create(path)
+	if err != nil {
+		return fmt.Errorf("error creating HAR file: %w", err)
+	}
+	defer file.Close()
+	return h.Write(file)
+}
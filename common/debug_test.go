@@ -0,0 +1,111 @@
+package common
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"unicode/utf8"
+
+	jsoniter "github.com/json-iterator/go"
+	"github.com/neosperience/shipper/test"
+)
+
+// testDebugServer issues a token like an OAuth2 token endpoint would
+func testDebugServer(t *testing.T) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		_, err := ioutil.ReadAll(req.Body)
+		test.MustSucceed(t, err, "Failed reading request body")
+		rw.Header().Set("Content-Type", "application/json")
+		fmt.Fprintf(rw, `{"access_token": "issued-token", "scope": "%s"}`, strings.Repeat("repo ", 20))
+	}))
+}
+
+func TestDebugHTTP(t *testing.T) {
+	server := testDebugServer(t)
+	defer server.Close()
+
+	RegisterSecret("debug-api-key")
+	var logged []string
+	client := &http.Client{Transport: Chain(server.Client().Transport, DebugHTTP(func(format string, v ...any) {
+		logged = append(logged, fmt.Sprintf(format, v...))
+	}, 100))}
+
+	res, err := NewClient(client, HeaderAuth("PRIVATE-TOKEN", "debug-api-key")).Request("POST", server.URL+"/token?key=debug-api-key", strings.NewReader("grant_type=client_credentials&client_secret=form-secret"), http.Header{
+		"Content-Type": {"application/x-www-form-urlencoded"},
+	})
+	test.MustSucceed(t, err, "Request through debug middleware failed")
+
+	// The body must still be readable by the caller
+	body, err := ioutil.ReadAll(res.Body)
+	test.MustSucceed(t, err, "Failed reading response body")
+	if !strings.Contains(string(body), "issued-token") {
+		t.Fatalf("Response body was not preserved: %s", body)
+	}
+
+	test.AssertExpected(t, len(logged), 1, "Exchange was not logged")
+	for _, secret := range []string{"debug-api-key", "form-secret", "issued-token"} {
+		if strings.Contains(logged[0], secret) {
+			t.Fatalf("Secret %s was not redacted from log:\n%s", secret, logged[0])
+		}
+	}
+	for _, expected := range []string{
+		"> POST " + server.URL + "/token?key=" + RedactedPlaceholder,
+		"> Private-Token: " + RedactedPlaceholder,
+		"client_secret=" + RedactedPlaceholder,
+		"< HTTP/1.1 200 OK",
+		"more bytes)",
+	} {
+		if !strings.Contains(logged[0], expected) {
+			t.Fatalf("Log doesn't contain %q:\n%s", expected, logged[0])
+		}
+	}
+}
+
+func TestHARRecorder(t *testing.T) {
+	server := testDebugServer(t)
+	defer server.Close()
+
+	recorder := NewHARRecorder("shipper", "test")
+	client := &http.Client{Transport: Chain(server.Client().Transport, recorder.Middleware())}
+	_, err := NewClient(client, BearerAuth("har-bearer-token")).Request("POST", server.URL+"/token", strings.NewReader(`{"password": "har-password"}`), http.Header{
+		"Content-Type": {"application/json"},
+	})
+	test.MustSucceed(t, err, "Request through HAR recorder failed")
+
+	// Unreachable servers are recorded as well
+	_, err = HTTPRequest(client, "GET", "http://localhost:1/unreachable", nil, nil)
+	test.MustFail(t, err, "Request to unreachable server supposed to fail but succeeded")
+
+	b := new(bytes.Buffer)
+	test.MustSucceed(t, recorder.Write(b), "Failed writing HAR")
+	for _, secret := range []string{"har-bearer-token", "har-password", "issued-token"} {
+		if strings.Contains(b.String(), secret) {
+			t.Fatalf("Secret %s was not redacted from HAR:\n%s", secret, b.String())
+		}
+	}
+
+	var archive harLog
+	test.MustSucceed(t, jsoniter.ConfigCompatibleWithStandardLibrary.Unmarshal(b.Bytes(), &archive), "Failed decoding HAR")
+	test.AssertExpected(t, archive.Log.Version, "1.2", "HAR version doesn't match expected value")
+	test.AssertExpected(t, len(archive.Log.Entries), 2, "HAR entries don't match expected number")
+
+	entry := archive.Log.Entries[0]
+	test.AssertExpected(t, entry.Request.Method, "POST", "Recorded method doesn't match expected value")
+	test.AssertExpected(t, entry.Request.PostData.Text, `{"password": "`+RedactedPlaceholder+`"}`, "Recorded request body doesn't match expected value")
+	test.AssertExpected(t, entry.Response.Status, http.StatusOK, "Recorded status doesn't match expected value")
+	test.AssertExpected(t, entry.Response.Content.MimeType, "application/json", "Recorded content type doesn't match expected value")
+	if archive.Log.Entries[1].Comment == "" {
+		t.Fatalf("Failed request was recorded without an error")
+	}
+}
+
+func TestRedactBodyTruncation(t *testing.T) {
+	// "è" takes two bytes, the limit falls in its middle
+	text := redactBody([]byte("caffè latte"), 5)
+	test.AssertExpected(t, text, "caff... (8 more bytes)", "Truncated body doesn't match expected value")
+	test.AssertExpected(t, utf8.ValidString(text), true, "Truncated body is not valid UTF-8")
+}
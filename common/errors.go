@@ -0,0 +1,39 @@
+package common
+
+import "errors"
+
+// Categories of errors, used to pick the exit code of a failed run. Packages mark their errors with
+// Categorize, so errors.Is matches them against the category without knowing where they come from.
+var (
+	// ErrValidation is an error caused by invalid options or input files
+	ErrValidation = errors.New("invalid input")
+	// ErrAuth is an error caused by missing or invalid credentials
+	ErrAuth = errors.New("authentication failed")
+	// ErrConflict is an error caused by a concurrent change, retrying may succeed
+	ErrConflict = errors.New("concurrent change")
+	// ErrPolicy is an error caused by a change being refused
+	ErrPolicy = errors.New("change refused")
+)
+
+// categorizedError is an error belonging to a category, without the category appearing in its message
+type categorizedError struct {
+	err      error
+	category error
+}
+
+// Categorize returns an error with the same message as err, matching both err and category with errors.Is
+func Categorize(category error, err error) error {
+	return &categorizedError{err: err, category: category}
+}
+
+func (e *categorizedError) Error() string {
+	return e.err.Error()
+}
+
+func (e *categorizedError) Is(target error) bool {
+	return target == e.category
+}
+
+func (e *categorizedError) Unwrap() error {
+	return e.err
+}
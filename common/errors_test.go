@@ -0,0 +1,21 @@
+package common
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/neosperience/shipper/test"
+)
+
+func TestCategorize(t *testing.T) {
+	errRejected := errors.New("push rejected")
+	categorized := Categorize(ErrPolicy, errRejected)
+	err := fmt.Errorf("error pushing: %w", categorized)
+
+	test.AssertExpected(t, err.Error(), "error pushing: push rejected", "Categorized error message doesn't match expected value")
+	test.AssertExpected(t, errors.Is(err, categorized), true, "Categorized error doesn't match itself")
+	test.AssertExpected(t, errors.Is(err, errRejected), true, "Categorized error doesn't match the error it wraps")
+	test.AssertExpected(t, errors.Is(err, ErrPolicy), true, "Categorized error doesn't match its category")
+	test.AssertExpected(t, errors.Is(err, ErrConflict), false, "Categorized error matches another category")
+}
@@ -8,6 +8,35 @@ import (
 )
 
 func HTTPRequest(client *http.Client, method string, requestURI string, body io.Reader, headers http.Header) (*http.Response, error) {
+	return NewClient(client, nil).Request(method, requestURI, body, headers)
+}
+
+// Client performs requests to a provider, authenticating them with the provider's strategy
+type Client struct {
+	client *http.Client
+	auth   Authenticator
+}
+
+// NewClient creates a Client. If client is nil a default HTTP client is used, if auth is nil requests
+// are sent without credentials.
+func NewClient(client *http.Client, auth Authenticator) *Client {
+	if client == nil {
+		client = &http.Client{}
+	}
+	return &Client{
+		client: client,
+		auth:   auth,
+	}
+}
+
+// HTTPClient returns the underlying HTTP client, for requests that need a different authentication
+func (c *Client) HTTPClient() *http.Client {
+	return c.client
+}
+
+// Request performs an authenticated request. Responses with an error status code are returned along
+// with an error, so callers can check the status code (eg. for 404s).
+func (c *Client) Request(method string, requestURI string, body io.Reader, headers http.Header) (*http.Response, error) {
 	// Create request
 	req, err := http.NewRequest(method, requestURI, body)
 	if err != nil {
@@ -17,16 +46,38 @@ func HTTPRequest(client *http.Client, method string, requestURI string, body io.
 	for k, v := range headers {
 		req.Header[k] = v
 	}
+	if c.auth != nil {
+		if err := c.auth.Authenticate(req); err != nil {
+			return nil, err
+		}
+	}
 
 	// Perform request and check for errors
-	res, err := client.Do(req)
+	res, err := c.client.Do(req)
 	if err != nil {
 		return nil, fmt.Errorf("error performing request: %w", err)
 	}
 	if res.StatusCode >= 400 {
 		body, _ := ioutil.ReadAll(res.Body)
 		_ = res.Body.Close()
-		return res, fmt.Errorf("request returned error: %s", body)
+		return res, &HTTPError{
+			Method:     method,
+			URL:        req.URL.Redacted(),
+			StatusCode: res.StatusCode,
+			Body:       string(body),
+		}
 	}
 	return res, nil
 }
+
+// HTTPError is returned for responses with an error status code
+type HTTPError struct {
+	Method     string
+	URL        string
+	StatusCode int
+	Body       string
+}
+
+func (e *HTTPError) Error() string {
+	return fmt.Sprintf("request returned error: %s", e.Body)
+}
@@ -1,6 +1,7 @@
 package common
 
 import (
+	"errors"
 	"io/ioutil"
 	"net/http"
 	"net/http/httptest"
@@ -70,8 +71,13 @@ func TestFaultyServer(t *testing.T) {
 	defer server.Close()
 
 	client := server.Client()
-	_, err := HTTPRequest(client, "GET", server.URL, nil, nil)
+	_, err := HTTPRequest(client, "GET", server.URL+"/path", nil, nil)
 	test.MustFail(t, err, "Request supposed to error out for error response but call exited successfully")
+	var httpErr *HTTPError
+	test.AssertExpected(t, errors.As(err, &httpErr), true, "Error is not an HTTPError")
+	test.AssertExpected(t, httpErr.StatusCode, http.StatusUnauthorized, "Status code doesn't match")
+	test.AssertExpected(t, httpErr.URL, server.URL+"/path", "URL doesn't match")
+	test.AssertExpected(t, err.Error(), "request returned error: Unauthorized\n", "Error message doesn't match")
 
 	// Try requesting an unreachable server
 	_, err = HTTPRequest(client, "GET", "http://localhost:1/invalid", nil, nil)
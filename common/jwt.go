@@ -0,0 +1,78 @@
+package common
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"strings"
+
+	jsoniter "github.com/json-iterator/go"
+)
+
+var (
+	ErrInvalidPrivateKey = Categorize(ErrValidation, errors.New("invalid RSA private key"))
+)
+
+// ParseRSAPrivateKey parses a PEM-encoded RSA private key, in either PKCS#1 or PKCS#8 format.
+// Other PEM blocks (eg. certificates in a bundle) are skipped.
+func ParseRSAPrivateKey(data []byte) (*rsa.PrivateKey, error) {
+	var block *pem.Block
+	for {
+		block, data = pem.Decode(data)
+		if block == nil {
+			return nil, fmt.Errorf("%w: no PEM-encoded private key found", ErrInvalidPrivateKey)
+		}
+		if strings.HasSuffix(block.Type, "PRIVATE KEY") {
+			break
+		}
+	}
+
+	if key, err := x509.ParsePKCS1PrivateKey(block.Bytes); err == nil {
+		return key, nil
+	}
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrInvalidPrivateKey, err.Error())
+	}
+	rsaKey, ok := key.(*rsa.PrivateKey)
+	if !ok {
+		return nil, fmt.Errorf("%w: not an RSA key", ErrInvalidPrivateKey)
+	}
+	return rsaKey, nil
+}
+
+// SignJWT creates a JSON Web Token signed with RS256. Additional header fields (eg. "kid") can be
+// specified in header, "alg" and "typ" are always set.
+func SignJWT(header map[string]any, claims any, key *rsa.PrivateKey) (string, error) {
+	fullHeader := map[string]any{
+		"alg": "RS256",
+		"typ": "JWT",
+	}
+	for name, value := range header {
+		fullHeader[name] = value
+	}
+
+	encodedHeader, err := jsoniter.ConfigFastest.Marshal(fullHeader)
+	if err != nil {
+		return "", fmt.Errorf("error encoding JWT header: %w", err)
+	}
+	encodedClaims, err := jsoniter.ConfigFastest.Marshal(claims)
+	if err != nil {
+		return "", fmt.Errorf("error encoding JWT claims: %w", err)
+	}
+
+	signingInput := base64.RawURLEncoding.EncodeToString(encodedHeader) + "." + base64.RawURLEncoding.EncodeToString(encodedClaims)
+	hash := sha256.Sum256([]byte(signingInput))
+	signature, err := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, hash[:])
+	if err != nil {
+		return "", fmt.Errorf("error signing JWT: %w", err)
+	}
+
+	return signingInput + "." + base64.RawURLEncoding.EncodeToString(signature), nil
+}
@@ -0,0 +1,64 @@
+package common
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"strings"
+	"testing"
+
+	jsoniter "github.com/json-iterator/go"
+	"github.com/neosperience/shipper/test"
+)
+
+func TestSignJWT(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	test.MustSucceed(t, err, "Failed generating test key")
+
+	token, err := SignJWT(map[string]any{"kid": "test-key"}, map[string]any{"iss": "test"}, key)
+	test.MustSucceed(t, err, "Failed signing JWT")
+
+	parts := strings.Split(token, ".")
+	test.AssertExpected(t, len(parts), 3, "JWT doesn't have three parts")
+
+	var header map[string]string
+	byt, err := base64.RawURLEncoding.DecodeString(parts[0])
+	test.MustSucceed(t, err, "Failed decoding JWT header")
+	test.MustSucceed(t, jsoniter.ConfigFastest.Unmarshal(byt, &header), "Failed decoding JWT header")
+	test.AssertExpected(t, header["alg"], "RS256", "JWT algorithm doesn't match expected value")
+	test.AssertExpected(t, header["kid"], "test-key", "JWT key ID doesn't match expected value")
+
+	var claims map[string]string
+	byt, err = base64.RawURLEncoding.DecodeString(parts[1])
+	test.MustSucceed(t, err, "Failed decoding JWT claims")
+	test.MustSucceed(t, jsoniter.ConfigFastest.Unmarshal(byt, &claims), "Failed decoding JWT claims")
+	test.AssertExpected(t, claims["iss"], "test", "JWT issuer doesn't match expected value")
+
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	test.MustSucceed(t, err, "Failed decoding JWT signature")
+	hash := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+	test.MustSucceed(t, rsa.VerifyPKCS1v15(&key.PublicKey, crypto.SHA256, hash[:], signature), "JWT signature is not valid")
+}
+
+func TestParseRSAPrivateKey(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	test.MustSucceed(t, err, "Failed generating test key")
+
+	pkcs1 := pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)})
+	parsed, err := ParseRSAPrivateKey(pkcs1)
+	test.MustSucceed(t, err, "Failed parsing PKCS#1 key")
+	test.AssertExpected(t, parsed.Equal(key), true, "Parsed PKCS#1 key doesn't match original")
+
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	test.MustSucceed(t, err, "Failed encoding PKCS#8 key")
+	parsed, err = ParseRSAPrivateKey(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}))
+	test.MustSucceed(t, err, "Failed parsing PKCS#8 key")
+	test.AssertExpected(t, parsed.Equal(key), true, "Parsed PKCS#8 key doesn't match original")
+
+	_, err = ParseRSAPrivateKey([]byte("not a key"))
+	test.MustFail(t, err, "Parsing invalid key supposed to fail but succeeded")
+}
@@ -0,0 +1,177 @@
+package common
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// Middleware wraps a RoundTripper to add behavior to every request made through it
+type Middleware func(next http.RoundTripper) http.RoundTripper
+
+// RoundTripperFunc adapts a function to the http.RoundTripper interface
+type RoundTripperFunc func(req *http.Request) (*http.Response, error)
+
+func (f RoundTripperFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
+
+// Chain wraps transport with middlewares, the first middleware is the outermost one
+func Chain(transport http.RoundTripper, middlewares ...Middleware) http.RoundTripper {
+	if transport == nil {
+		transport = http.DefaultTransport
+	}
+	for i := len(middlewares) - 1; i >= 0; i-- {
+		transport = middlewares[i](transport)
+	}
+	return transport
+}
+
+// UserAgent sets the User-Agent header on requests that don't have one already
+func UserAgent(agent string) Middleware {
+	return func(next http.RoundTripper) http.RoundTripper {
+		return RoundTripperFunc(func(req *http.Request) (*http.Response, error) {
+			if req.Header.Get("User-Agent") == "" {
+				req = req.Clone(req.Context())
+				req.Header.Set("User-Agent", agent)
+			}
+			return next.RoundTrip(req)
+		})
+	}
+}
+
+// Maximum time to wait before retrying, even if the server asks for more
+const maxRetryDelay = 30 * time.Second
+
+// Retry retries requests that failed because of network errors or temporary server errors (502, 503, 504),
+// up to attempts times, waiting delay and doubling it after each attempt. Rate limited requests (429)
+// are retried for all methods, honoring Retry-After, while other failures are only retried for
+// GET, HEAD and OPTIONS: providers create commits on PUT as well, so any other request could have been
+// processed already.
+func Retry(attempts int, delay time.Duration) Middleware {
+	return RetryNotify(attempts, delay, nil)
+}
+
+// RetryNotify is Retry calling notify (if not nil) before each retry with the failed attempt's outcome,
+// attempt is the number of the retry about to be made, starting from 1
+func RetryNotify(attempts int, delay time.Duration, notify func(req *http.Request, res *http.Response, err error, attempt int)) Middleware {
+	return func(next http.RoundTripper) http.RoundTripper {
+		return RoundTripperFunc(func(req *http.Request) (*http.Response, error) {
+			for attempt := 0; ; attempt++ {
+				res, err := next.RoundTrip(req)
+				if attempt >= attempts || !shouldRetry(req, res, err) {
+					return res, err
+				}
+
+				// Requests with a body can only be retried if it can be read again
+				retry := req
+				if req.Body != nil && req.Body != http.NoBody {
+					if req.GetBody == nil {
+						return res, err
+					}
+					body, bodyErr := req.GetBody()
+					if bodyErr != nil {
+						return res, err
+					}
+					retry = req.Clone(req.Context())
+					retry.Body = body
+				}
+
+				if notify != nil {
+					notify(req, res, err, attempt+1)
+				}
+
+				wait := delay << attempt
+				if res != nil {
+					if seconds, parseErr := strconv.Atoi(res.Header.Get("Retry-After")); parseErr == nil {
+						wait = time.Duration(seconds) * time.Second
+					}
+					_ = res.Body.Close()
+				}
+				if wait > maxRetryDelay {
+					wait = maxRetryDelay
+				}
+
+				select {
+				case <-req.Context().Done():
+					return nil, req.Context().Err()
+				case <-time.After(wait):
+				}
+				req = retry
+			}
+		})
+	}
+}
+
+func shouldRetry(req *http.Request, res *http.Response, err error) bool {
+	if res != nil && res.StatusCode == http.StatusTooManyRequests {
+		return true
+	}
+	switch req.Method {
+	case "GET", "HEAD", "OPTIONS":
+	default:
+		return false
+	}
+	if err != nil {
+		// Don't retry requests that were cancelled
+		return req.Context().Err() == nil
+	}
+	switch res.StatusCode {
+	case http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	}
+	return false
+}
+
+// Observer is notified of every completed request, res is nil if the request failed.
+// Observers are the hook for collecting metrics and tracing requests.
+type Observer func(req *http.Request, res *http.Response, err error, duration time.Duration)
+
+// Observe calls observer after each request
+func Observe(observer Observer) Middleware {
+	return func(next http.RoundTripper) http.RoundTripper {
+		return RoundTripperFunc(func(req *http.Request) (*http.Response, error) {
+			start := time.Now()
+			res, err := next.RoundTrip(req)
+			observer(req, res, err, time.Since(start))
+			return res, err
+		})
+	}
+}
+
+// Logging logs every request with its outcome using logf (eg. log.Printf), URLs are redacted
+func Logging(logf func(format string, v ...any)) Middleware {
+	return Observe(func(req *http.Request, res *http.Response, err error, duration time.Duration) {
+		if err != nil {
+			logf("%s %s failed after %s: %s", req.Method, Redact(req.URL.Redacted()), duration.Round(time.Millisecond), Redact(err.Error()))
+			return
+		}
+		logf("%s %s returned %s in %s", req.Method, Redact(req.URL.Redacted()), res.Status, duration.Round(time.Millisecond))
+	})
+}
+
+// redactedError hides secrets from the message of an error while keeping it inspectable with errors.Is/As
+type redactedError struct {
+	err error
+}
+
+func (e *redactedError) Error() string {
+	return Redact(e.err.Error())
+}
+
+func (e *redactedError) Unwrap() error {
+	return e.err
+}
+
+// RedactErrors redacts registered secrets from transport errors (eg. proxy or TLS errors mentioning credentials)
+func RedactErrors() Middleware {
+	return func(next http.RoundTripper) http.RoundTripper {
+		return RoundTripperFunc(func(req *http.Request) (*http.Response, error) {
+			res, err := next.RoundTrip(req)
+			if err != nil {
+				err = &redactedError{err: err}
+			}
+			return res, err
+		})
+	}
+}
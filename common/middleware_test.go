@@ -0,0 +1,160 @@
+package common
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/neosperience/shipper/test"
+)
+
+func TestChain(t *testing.T) {
+	var order []string
+	tag := func(name string) Middleware {
+		return func(next http.RoundTripper) http.RoundTripper {
+			return RoundTripperFunc(func(req *http.Request) (*http.Response, error) {
+				order = append(order, name)
+				return next.RoundTrip(req)
+			})
+		}
+	}
+
+	var userAgent string
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		userAgent = req.Header.Get("User-Agent")
+	}))
+	defer server.Close()
+
+	client := &http.Client{Transport: Chain(server.Client().Transport, tag("first"), tag("second"), UserAgent("shipper/test"))}
+	_, err := HTTPRequest(client, "GET", server.URL, nil, nil)
+	test.MustSucceed(t, err, "Request through middlewares failed")
+	test.AssertExpected(t, strings.Join(order, ","), "first,second", "Middlewares were not called in order")
+	test.AssertExpected(t, userAgent, "shipper/test", "User agent doesn't match expected value")
+
+	// User agents set by targets are kept
+	_, err = HTTPRequest(client, "GET", server.URL, nil, http.Header{"User-Agent": {"git/2.0"}})
+	test.MustSucceed(t, err, "Request through middlewares failed")
+	test.AssertExpected(t, userAgent, "git/2.0", "User agent set by the request was replaced")
+}
+
+func TestRetry(t *testing.T) {
+	attempts := 0
+	var bodies []string
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		attempts++
+		body, _ := ioutil.ReadAll(req.Body)
+		bodies = append(bodies, string(body))
+		switch req.URL.Path {
+		case "/unavailable":
+			if attempts < 3 {
+				http.Error(rw, "unavailable", http.StatusServiceUnavailable)
+				return
+			}
+		case "/rate-limited":
+			if attempts < 2 {
+				rw.Header().Set("Retry-After", "0")
+				http.Error(rw, "slow down", http.StatusTooManyRequests)
+				return
+			}
+		case "/down":
+			http.Error(rw, "down", http.StatusBadGateway)
+			return
+		}
+		fmt.Fprint(rw, "ok")
+	}))
+	defer server.Close()
+	client := &http.Client{Transport: Chain(server.Client().Transport, Retry(3, time.Millisecond))}
+
+	// Idempotent requests are retried on temporary errors
+	_, err := HTTPRequest(client, "GET", server.URL+"/unavailable", nil, nil)
+	test.MustSucceed(t, err, "Retried request failed")
+	test.AssertExpected(t, attempts, 3, "Request was not retried the expected number of times")
+
+	// Other requests are not, unless rate limited, and bodies are sent again
+	for _, method := range []string{"POST", "PUT", "DELETE"} {
+		attempts = 0
+		_, err = HTTPRequest(client, method, server.URL+"/unavailable", strings.NewReader("payload"), nil)
+		test.MustFail(t, err, method+" request supposed to fail without retrying but succeeded")
+		test.AssertExpected(t, attempts, 1, method+" request was retried")
+	}
+
+	attempts = 0
+	bodies = nil
+	_, err = HTTPRequest(client, "POST", server.URL+"/rate-limited", strings.NewReader("payload"), nil)
+	test.MustSucceed(t, err, "Rate limited request failed")
+	test.AssertExpected(t, strings.Join(bodies, ","), "payload,payload", "Request body was not sent again")
+
+	// Give up after the maximum number of attempts
+	attempts = 0
+	_, err = HTTPRequest(client, "GET", server.URL+"/down", nil, nil)
+	test.MustFail(t, err, "Request to failing server supposed to fail but succeeded")
+	test.AssertExpected(t, attempts, 4, "Request was not attempted the expected number of times")
+}
+
+func TestRetryNotify(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		http.Error(rw, "down", http.StatusBadGateway)
+	}))
+	defer server.Close()
+
+	var notified []string
+	client := &http.Client{Transport: Chain(server.Client().Transport, RetryNotify(2, time.Millisecond,
+		func(req *http.Request, res *http.Response, err error, attempt int) {
+			notified = append(notified, fmt.Sprintf("%d:%d", attempt, res.StatusCode))
+		}))}
+	_, err := HTTPRequest(client, "GET", server.URL, nil, nil)
+	test.MustFail(t, err, "Request to failing server supposed to fail but succeeded")
+	test.AssertExpected(t, strings.Join(notified, ","), "1:502,2:502", "Retries were not notified")
+}
+
+func TestObserveAndLogging(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		http.Error(rw, "not found", http.StatusNotFound)
+	}))
+	defer server.Close()
+
+	var observed []int
+	var logged []string
+	client := &http.Client{Transport: Chain(server.Client().Transport,
+		Observe(func(req *http.Request, res *http.Response, err error, duration time.Duration) {
+			if res != nil {
+				observed = append(observed, res.StatusCode)
+			}
+		}),
+		Logging(func(format string, v ...any) {
+			logged = append(logged, fmt.Sprintf(format, v...))
+		}),
+	)}
+
+	RegisterSecret("observed-secret")
+	_, err := HTTPRequest(client, "GET", server.URL+"/file?token=observed-secret", nil, nil)
+	test.MustFail(t, err, "Request supposed to return not found but succeeded")
+	test.AssertExpected(t, len(observed), 1, "Observer was not called")
+	test.AssertExpected(t, observed[0], http.StatusNotFound, "Observed status code doesn't match expected value")
+	test.AssertExpected(t, len(logged), 1, "Request was not logged")
+	if !strings.HasPrefix(logged[0], "GET "+server.URL+"/file?token="+RedactedPlaceholder+" returned 404 Not Found") {
+		t.Fatalf("Unexpected log line: %s", logged[0])
+	}
+}
+
+func TestRedactErrors(t *testing.T) {
+	errFailed := errors.New("dial failed with redacted-transport-secret")
+	RegisterSecret("redacted-transport-secret")
+
+	transport := Chain(RoundTripperFunc(func(req *http.Request) (*http.Response, error) {
+		return nil, errFailed
+	}), RedactErrors())
+	req, err := http.NewRequest("GET", "http://example.com", nil)
+	test.MustSucceed(t, err, "Failed creating request")
+
+	_, err = transport.RoundTrip(req)
+	test.AssertExpected(t, err.Error(), "dial failed with "+RedactedPlaceholder, "Transport error was not redacted")
+	if !errors.Is(err, errFailed) {
+		t.Fatalf("Redacted error doesn't wrap the original error: %v", err)
+	}
+}
@@ -0,0 +1,66 @@
+package common
+
+import (
+	"io"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// RedactedPlaceholder replaces secrets in redacted text
+const RedactedPlaceholder = "[REDACTED]"
+
+var secrets = struct {
+	sync.RWMutex
+	values []string
+}{}
+
+// RegisterSecret adds a value that must never appear in logs or error messages
+func RegisterSecret(secret string) {
+	if secret == "" {
+		return
+	}
+
+	secrets.Lock()
+	defer secrets.Unlock()
+	for _, value := range secrets.values {
+		if value == secret {
+			return
+		}
+	}
+	secrets.values = append(secrets.values, secret)
+
+	// Replace longer secrets first, in case one contains another
+	sort.Slice(secrets.values, func(i, j int) bool {
+		return len(secrets.values[i]) > len(secrets.values[j])
+	})
+}
+
+// Redact replaces all registered secrets in text with RedactedPlaceholder
+func Redact(text string) string {
+	secrets.RLock()
+	defer secrets.RUnlock()
+	for _, secret := range secrets.values {
+		text = strings.ReplaceAll(text, secret, RedactedPlaceholder)
+	}
+	return text
+}
+
+// RedactingWriter redacts registered secrets from everything written to the underlying writer.
+// Every Write call must contain whole secrets, which is the case for log.Logger outputs.
+type RedactingWriter struct {
+	writer io.Writer
+}
+
+// NewRedactingWriter wraps a writer with a RedactingWriter, eg. log.SetOutput(NewRedactingWriter(os.Stderr))
+func NewRedactingWriter(writer io.Writer) *RedactingWriter {
+	return &RedactingWriter{writer: writer}
+}
+
+func (r *RedactingWriter) Write(p []byte) (int, error) {
+	_, err := io.WriteString(r.writer, Redact(string(p)))
+	if err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
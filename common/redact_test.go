@@ -0,0 +1,29 @@
+package common
+
+import (
+	"bytes"
+	"log"
+	"testing"
+
+	"github.com/neosperience/shipper/test"
+)
+
+func TestRedact(t *testing.T) {
+	RegisterSecret("hunter2")
+	RegisterSecret("user:hunter2-long")
+	RegisterSecret("")
+
+	test.AssertExpected(t, Redact("password is hunter2"), "password is "+RedactedPlaceholder, "Secret was not redacted")
+	test.AssertExpected(t, Redact("key user:hunter2-long used"), "key "+RedactedPlaceholder+" used", "Longer secret was not redacted first")
+	test.AssertExpected(t, Redact("nothing to see"), "nothing to see", "Text without secrets was modified")
+}
+
+func TestRedactingWriter(t *testing.T) {
+	RegisterSecret("s3cr3t-token")
+
+	buffer := new(bytes.Buffer)
+	logger := log.New(NewRedactingWriter(buffer), "", 0)
+	logger.Printf("request failed with token %s", "s3cr3t-token")
+
+	test.AssertExpected(t, buffer.String(), "request failed with token "+RedactedPlaceholder+"\n", "Logged secret was not redacted")
+}
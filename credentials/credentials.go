@@ -0,0 +1,112 @@
+package credentials
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"strings"
+
+	"github.com/neosperience/shipper/common"
+)
+
+// Format is how a target expects its credentials
+type Format int
+
+const (
+	// Token is a single secret, such as an API key or access token
+	Token Format = iota
+	// UserPassword is a "username:password" pair
+	UserPassword
+)
+
+var (
+	ErrNoCredentials = common.Categorize(common.ErrAuth, errors.New("no credentials found"))
+)
+
+// Credential is a username/password pair, the username can be empty for token-based authentication
+type Credential struct {
+	Username string
+	Password string
+}
+
+// Format returns the credential in the given format. Credentials without a username are returned
+// as they are, as the secret is expected to be in the right format already.
+func (c Credential) Format(format Format) string {
+	if format == UserPassword && c.Username != "" {
+		return c.Username + ":" + c.Password
+	}
+	return c.Password
+}
+
+// Resolve returns the credentials specified by value, in the given format. value is either a literal
+// secret or a reference to an external source:
+//
+//   - "file://<path>" reads the secret from a file
+//   - "helper://<helper>" asks a git credential helper for the endpoint's credentials
+//   - "netrc://" or "netrc://<path>" looks up the endpoint's host in ~/.netrc (or the given file)
+//   - "vault://<path>#<field>" reads a field of a HashiCorp Vault secret, see ConfigureVault
+//
+// Sources are only recognized with "://", so "username:password" pairs such as "file:secret" are kept literal.
+// All resolved secrets are registered for redaction.
+func Resolve(value string, endpoint string, format Format) (string, error) {
+	source, reference, found := strings.Cut(value, "://")
+	if !found {
+		source = ""
+	}
+
+	var secret string
+	switch source {
+	case "file":
+		byt, err := os.ReadFile(reference)
+		if err != nil {
+			return "", fmt.Errorf("error reading credentials file: %w", err)
+		}
+		secret = strings.TrimRight(string(byt), "\r\n")
+	case "helper":
+		credential, err := fromHelper(reference, endpoint)
+		if err != nil {
+			return "", err
+		}
+		secret = credential.Format(format)
+		common.RegisterSecret(credential.Password)
+	case "netrc":
+		credential, err := fromNetrc(reference, endpoint)
+		if err != nil {
+			return "", err
+		}
+		secret = credential.Format(format)
+		common.RegisterSecret(credential.Password)
+	case "vault":
+		credential, err := fromVault(reference)
+		if err != nil {
+			return "", err
+		}
+		secret = credential.Format(format)
+		common.RegisterSecret(credential.Password)
+	default:
+		secret = value
+	}
+
+	Register(secret, format)
+	return secret, nil
+}
+
+// Register registers a secret for redaction. For "username:password" pairs, the password is registered as well.
+func Register(secret string, format Format) {
+	common.RegisterSecret(secret)
+	if format == UserPassword {
+		if _, password, ok := strings.Cut(secret, ":"); ok {
+			common.RegisterSecret(password)
+		}
+	}
+}
+
+// endpointURL parses an endpoint, returning an empty URL if it's not valid so lookups simply don't match
+func endpointURL(endpoint string) *url.URL {
+	parsed, err := url.Parse(endpoint)
+	if err != nil {
+		return &url.URL{}
+	}
+	return parsed
+}
@@ -0,0 +1,99 @@
+package credentials
+
+import (
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+
+	"github.com/neosperience/shipper/common"
+	"github.com/neosperience/shipper/test"
+)
+
+func TestResolveLiteral(t *testing.T) {
+	secret, err := Resolve("user:literal-password", "https://git.example.com", UserPassword)
+	test.MustSucceed(t, err, "Failed resolving literal credentials")
+	test.AssertExpected(t, secret, "user:literal-password", "Literal credentials were modified")
+	test.AssertExpected(t, common.Redact("password literal-password"), "password "+common.RedactedPlaceholder, "Literal password was not registered for redaction")
+
+	// Usernames named like a source are not references
+	for _, value := range []string{"file:password", "netrc:password", "vault:password", "netrc"} {
+		secret, err = Resolve(value, "https://git.example.com", UserPassword)
+		test.MustSucceed(t, err, "Failed resolving literal credentials")
+		test.AssertExpected(t, secret, value, "Literal credentials were read as a reference")
+	}
+}
+
+func TestResolveFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "token")
+	test.MustSucceed(t, os.WriteFile(path, []byte("file-token\n"), 0600), "Failed writing token file")
+
+	secret, err := Resolve("file://"+path, "https://git.example.com", Token)
+	test.MustSucceed(t, err, "Failed resolving credentials from file")
+	test.AssertExpected(t, secret, "file-token", "Credentials from file don't match expected value")
+	test.AssertExpected(t, common.Redact("token file-token"), "token "+common.RedactedPlaceholder, "Token from file was not registered for redaction")
+
+	_, err = Resolve("file://"+path+".missing", "https://git.example.com", Token)
+	test.MustFail(t, err, "Resolving a missing file supposed to fail but succeeded")
+}
+
+func TestResolveHelper(t *testing.T) {
+	if _, err := exec.LookPath("sh"); err != nil {
+		t.Skip("sh is not available")
+	}
+
+	// Helper that echoes back the requested host in the username, to check the request
+	dir := t.TempDir()
+	helper := filepath.Join(dir, "helper.sh")
+	test.MustSucceed(t, os.WriteFile(helper, []byte(`#!/bin/sh
+test "$1" = "get" || exit 1
+while read line; do
+  case "$line" in
+    host=*) host="${line#host=}" ;;
+    protocol=*) protocol="${line#protocol=}" ;;
+    "") break ;;
+  esac
+done
+echo "username=user-$protocol-$host"
+echo "password=helper-password"
+`), 0700), "Failed writing helper script")
+
+	secret, err := Resolve("helper://"+helper, "https://git.example.com/org/repo.git", UserPassword)
+	test.MustSucceed(t, err, "Failed resolving credentials from helper")
+	test.AssertExpected(t, secret, "user-https-git.example.com:helper-password", "Credentials from helper don't match expected value")
+
+	secret, err = Resolve("helper://!"+helper, "https://git.example.com", Token)
+	test.MustSucceed(t, err, "Failed resolving credentials from shell helper")
+	test.AssertExpected(t, secret, "helper-password", "Token from helper doesn't match expected value")
+
+	_, err = Resolve("helper://!true", "https://git.example.com", Token)
+	if !errors.Is(err, ErrNoCredentials) {
+		t.Fatalf("Expected no credentials error, got %v", err)
+	}
+}
+
+func TestResolveNetrc(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "netrc")
+	test.MustSucceed(t, os.WriteFile(path, []byte(`machine other.example.com login other password other-password
+machine git.example.com
+  login netrc-user
+  password netrc-password
+default login default-user password default-password
+`), 0600), "Failed writing netrc file")
+	t.Setenv("NETRC", path)
+
+	secret, err := Resolve("netrc://", "https://git.example.com:8443/api/v4", UserPassword)
+	test.MustSucceed(t, err, "Failed resolving credentials from netrc")
+	test.AssertExpected(t, secret, "netrc-user:netrc-password", "Credentials from netrc don't match expected value")
+
+	secret, err = Resolve("netrc://"+path, "https://unknown.example.com", Token)
+	test.MustSucceed(t, err, "Failed resolving default credentials from netrc")
+	test.AssertExpected(t, secret, "default-password", "Default credentials from netrc don't match expected value")
+
+	test.MustSucceed(t, os.WriteFile(path, []byte("machine other.example.com login other password other-password\n"), 0600), "Failed writing netrc file")
+	_, err = Resolve("netrc://", "https://git.example.com", Token)
+	if !errors.Is(err, ErrNoCredentials) {
+		t.Fatalf("Expected no credentials error, got %v", err)
+	}
+}
@@ -0,0 +1,67 @@
+package credentials
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// fromHelper asks a git credential helper for the credentials of an endpoint, using the git-credential protocol.
+// helper follows the git "credential.helper" syntax: a name (eg. "store", running "git credential-store"),
+// an absolute path or a shell command prefixed by "!". The special "git" helper runs "git credential fill",
+// which uses the helpers configured in git.
+func fromHelper(helper string, endpoint string) (Credential, error) {
+	var cmd *exec.Cmd
+	switch {
+	case helper == "":
+		return Credential{}, fmt.Errorf("no credential helper specified")
+	case helper == "git":
+		cmd = exec.Command("git", "credential", "fill")
+	case strings.HasPrefix(helper, "!"):
+		cmd = exec.Command("sh", "-c", helper[1:]+" get")
+	case filepath.IsAbs(helper):
+		cmd = exec.Command("sh", "-c", helper+" get")
+	default:
+		cmd = exec.Command("sh", "-c", "git credential-"+helper+" get")
+	}
+
+	parsed := endpointURL(endpoint)
+	input := new(bytes.Buffer)
+	fmt.Fprintf(input, "protocol=%s\nhost=%s\n", parsed.Scheme, parsed.Host)
+	if path := strings.TrimPrefix(parsed.Path, "/"); path != "" {
+		fmt.Fprintf(input, "path=%s\n", path)
+	}
+	input.WriteString("\n")
+	cmd.Stdin = input
+
+	// Never let git prompt for credentials on the terminal
+	cmd.Env = append(os.Environ(), "GIT_TERMINAL_PROMPT=0")
+
+	output, err := cmd.Output()
+	if err != nil {
+		return Credential{}, fmt.Errorf("error running credential helper: %w", err)
+	}
+
+	var credential Credential
+	scanner := bufio.NewScanner(bytes.NewReader(output))
+	for scanner.Scan() {
+		key, value, ok := strings.Cut(scanner.Text(), "=")
+		if !ok {
+			continue
+		}
+		switch key {
+		case "username":
+			credential.Username = value
+		case "password":
+			credential.Password = value
+		}
+	}
+	if credential.Password == "" {
+		return Credential{}, fmt.Errorf("%w: credential helper returned no password for %s", ErrNoCredentials, parsed.Host)
+	}
+	return credential, nil
+}
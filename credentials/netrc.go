@@ -0,0 +1,77 @@
+package credentials
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// fromNetrc looks up the credentials for the endpoint's host in a netrc file. If path is empty, the file
+// in the NETRC environment variable or ~/.netrc is used. Entries without a matching "machine" fall back to "default".
+func fromNetrc(path string, endpoint string) (Credential, error) {
+	if path == "" {
+		path = os.Getenv("NETRC")
+	}
+	if path == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return Credential{}, fmt.Errorf("error finding home directory: %w", err)
+		}
+		path = filepath.Join(home, ".netrc")
+	}
+
+	byt, err := os.ReadFile(path)
+	if err != nil {
+		return Credential{}, fmt.Errorf("error reading netrc file: %w", err)
+	}
+
+	host := endpointURL(endpoint).Hostname()
+	var match, fallback *Credential
+	var current *Credential
+	tokens := strings.Fields(string(byt))
+	for index := 0; index < len(tokens); index += 1 {
+		// Every keyword except "default" is followed by a value
+		next := func() string {
+			if index+1 < len(tokens) {
+				index += 1
+				return tokens[index]
+			}
+			return ""
+		}
+
+		switch tokens[index] {
+		case "machine":
+			current = &Credential{}
+			if next() == host && match == nil {
+				match = current
+			}
+		case "default":
+			current = &Credential{}
+			if fallback == nil {
+				fallback = current
+			}
+		case "login":
+			if current != nil {
+				current.Username = next()
+			}
+		case "password":
+			if current != nil {
+				current.Password = next()
+			}
+		case "account":
+			next()
+		case "macdef":
+			// Macro definitions run until an empty line, they are irrelevant here and very rare
+			current = nil
+		}
+	}
+
+	if match == nil {
+		match = fallback
+	}
+	if match == nil || match.Password == "" {
+		return Credential{}, fmt.Errorf("%w: no netrc entry for %s", ErrNoCredentials, host)
+	}
+	return *match, nil
+}
@@ -0,0 +1,209 @@
+package credentials
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+
+	jsoniter "github.com/json-iterator/go"
+
+	"github.com/neosperience/shipper/common"
+)
+
+var (
+	ErrVaultNotConfigured = errors.New("vault address not configured")
+	ErrVaultNoAuth        = errors.New("no vault authentication method configured")
+	ErrVaultField         = errors.New("vault secret field not found")
+)
+
+// VaultConfig configures how to reach and authenticate with HashiCorp Vault.
+// The first available authentication method is used: Token, then AppRole (RoleID and SecretID),
+// then JWT/OIDC (JWT and Role).
+type VaultConfig struct {
+	Address   string
+	Namespace string
+
+	Token string
+
+	RoleID   string
+	SecretID string
+
+	JWT  string
+	Role string
+
+	// AuthMount is the path the AppRole or JWT auth method is mounted at, defaults to "approle" or "jwt"
+	AuthMount string
+
+	// Client is the HTTP client used to reach Vault, defaults to a plain client
+	Client *http.Client
+}
+
+type vaultClient struct {
+	config VaultConfig
+
+	mutex sync.Mutex
+	token string
+
+	client *http.Client
+}
+
+var vault *vaultClient
+
+// ConfigureVault sets up the Vault client used to resolve "vault://" references
+func ConfigureVault(config VaultConfig) {
+	config.Address = strings.TrimSuffix(config.Address, "/")
+	client := config.Client
+	if client == nil {
+		client = &http.Client{}
+	}
+	vault = &vaultClient{
+		config: config,
+		client: client,
+	}
+}
+
+func (v *vaultClient) doRequest(method string, path string, body io.Reader, token string) (*http.Response, error) {
+	headers := http.Header{}
+	if token != "" {
+		headers.Set("X-Vault-Token", token)
+	}
+	if v.config.Namespace != "" {
+		headers.Set("X-Vault-Namespace", v.config.Namespace)
+	}
+	if body != nil {
+		headers.Set("Content-Type", "application/json")
+	}
+	return common.HTTPRequest(v.client, method, v.config.Address+"/v1/"+path, body, headers)
+}
+
+// login returns a Vault token, logging in with the configured method the first time
+func (v *vaultClient) login() (string, error) {
+	v.mutex.Lock()
+	defer v.mutex.Unlock()
+
+	if v.token != "" {
+		return v.token, nil
+	}
+	if v.config.Token != "" {
+		v.token = v.config.Token
+		return v.token, nil
+	}
+
+	var method string
+	var input map[string]string
+	switch {
+	case v.config.RoleID != "":
+		method = "approle"
+		input = map[string]string{"role_id": v.config.RoleID, "secret_id": v.config.SecretID}
+	case v.config.JWT != "":
+		method = "jwt"
+		input = map[string]string{"role": v.config.Role, "jwt": v.config.JWT}
+	default:
+		return "", ErrVaultNoAuth
+	}
+	mount := v.config.AuthMount
+	if mount == "" {
+		mount = method
+	}
+
+	b := new(bytes.Buffer)
+	err := jsoniter.ConfigFastest.NewEncoder(b).Encode(input)
+	if err != nil {
+		return "", fmt.Errorf("error encoding vault login payload: %w", err)
+	}
+	res, err := v.doRequest("POST", fmt.Sprintf("auth/%s/login", strings.Trim(mount, "/")), b, "")
+	if err != nil {
+		return "", fmt.Errorf("error logging in to vault with %s: %w", method, err)
+	}
+	defer res.Body.Close()
+
+	var response struct {
+		Auth struct {
+			ClientToken string `json:"client_token"`
+		} `json:"auth"`
+	}
+	err = jsoniter.ConfigFastest.NewDecoder(res.Body).Decode(&response)
+	if err != nil {
+		return "", fmt.Errorf("error decoding vault login response: %w", err)
+	}
+
+	v.token = response.Auth.ClientToken
+	common.RegisterSecret(v.token)
+	return v.token, nil
+}
+
+// read reads a secret from Vault. KV v2 secrets (read from "<mount>/data/<path>") are unwrapped
+// so fields are accessible like for KV v1 and dynamic secret engines.
+func (v *vaultClient) read(path string) (map[string]any, error) {
+	token, err := v.login()
+	if err != nil {
+		return nil, err
+	}
+
+	res, err := v.doRequest("GET", strings.TrimPrefix(path, "/"), nil, token)
+	if err != nil {
+		return nil, fmt.Errorf("error reading vault secret %s: %w", path, err)
+	}
+	defer res.Body.Close()
+
+	var response struct {
+		Data map[string]any `json:"data"`
+	}
+	err = jsoniter.ConfigFastest.NewDecoder(res.Body).Decode(&response)
+	if err != nil {
+		return nil, fmt.Errorf("error decoding vault secret: %w", err)
+	}
+
+	if nested, ok := response.Data["data"].(map[string]any); ok {
+		if _, ok := response.Data["metadata"]; ok {
+			return nested, nil
+		}
+	}
+	return response.Data, nil
+}
+
+// fromVault resolves a "<path>#<field>" reference. If no field is specified, the secret must either have
+// a single field or "username" and "password" fields.
+func fromVault(reference string) (Credential, error) {
+	if vault == nil || vault.config.Address == "" {
+		return Credential{}, ErrVaultNotConfigured
+	}
+
+	path, field, _ := strings.Cut(reference, "#")
+	data, err := vault.read(path)
+	if err != nil {
+		return Credential{}, err
+	}
+
+	if field != "" {
+		value, ok := data[field].(string)
+		if !ok {
+			return Credential{}, fmt.Errorf("%w: %s in %s", ErrVaultField, field, path)
+		}
+		return Credential{Password: value}, nil
+	}
+
+	if password, ok := data["password"].(string); ok {
+		username, _ := data["username"].(string)
+		return Credential{Username: username, Password: password}, nil
+	}
+	if len(data) == 1 {
+		for _, value := range data {
+			if value, ok := value.(string); ok {
+				return Credential{Password: value}, nil
+			}
+		}
+	}
+
+	fields := make([]string, 0, len(data))
+	for name := range data {
+		fields = append(fields, name)
+	}
+	sort.Strings(fields)
+	return Credential{}, fmt.Errorf("%w: specify one of [%s] with \"%s#<field>\"", ErrVaultField, strings.Join(fields, ", "), path)
+}
@@ -0,0 +1,112 @@
+package credentials
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	jsoniter "github.com/json-iterator/go"
+	"github.com/neosperience/shipper/common"
+	"github.com/neosperience/shipper/test"
+)
+
+// testVaultServer mocks a Vault server with a KV v2 secret, a dynamic secret and AppRole/JWT auth methods
+func testVaultServer(t *testing.T) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		test.AssertExpected(t, req.Header.Get("X-Vault-Namespace"), "test-namespace", "Vault namespace doesn't match expected value")
+
+		var response any
+		switch req.URL.Path {
+		case "/v1/auth/approle/login", "/v1/auth/gitlab-jwt/login":
+			var input map[string]string
+			test.MustSucceed(t, jsoniter.ConfigFastest.NewDecoder(req.Body).Decode(&input), "Failed decoding login payload")
+			if (input["role_id"] != "test-role-id" || input["secret_id"] != "test-secret-id") && (input["jwt"] != "test-jwt" || input["role"] != "deployer") {
+				http.Error(rw, `{"errors":["permission denied"]}`, http.StatusBadRequest)
+				return
+			}
+			response = map[string]any{"auth": map[string]any{"client_token": "login-token"}}
+		case "/v1/secret/data/shipper":
+			if token := req.Header.Get("X-Vault-Token"); token != "static-token" && token != "login-token" {
+				http.Error(rw, `{"errors":["permission denied"]}`, http.StatusForbidden)
+				return
+			}
+			response = map[string]any{"data": map[string]any{
+				"data": map[string]any{
+					"gitlab": "kv-gitlab-token",
+					"github": "kv-github-token",
+				},
+				"metadata": map[string]any{"version": 3},
+			}}
+		case "/v1/gitlab/token/deployer":
+			response = map[string]any{"data": map[string]any{"token": "dynamic-token"}}
+		case "/v1/secret/data/userpass":
+			response = map[string]any{"data": map[string]any{
+				"data":     map[string]any{"username": "vault-user", "password": "vault-password"},
+				"metadata": map[string]any{"version": 1},
+			}}
+		default:
+			http.Error(rw, `{"errors":[]}`, http.StatusNotFound)
+			return
+		}
+		test.MustSucceed(t, jsoniter.ConfigFastest.NewEncoder(rw).Encode(response), "Failed writing response")
+	}))
+}
+
+func TestResolveVault(t *testing.T) {
+	server := testVaultServer(t)
+	defer server.Close()
+
+	for _, config := range []VaultConfig{
+		{Token: "static-token"},
+		{RoleID: "test-role-id", SecretID: "test-secret-id"},
+		{JWT: "test-jwt", Role: "deployer", AuthMount: "gitlab-jwt"},
+	} {
+		config.Address = server.URL
+		config.Namespace = "test-namespace"
+		ConfigureVault(config)
+		vault.client = server.Client()
+
+		secret, err := Resolve("vault://secret/data/shipper#gitlab", "https://gitlab.com/api/v4", Token)
+		test.MustSucceed(t, err, "Failed resolving KV v2 secret")
+		test.AssertExpected(t, secret, "kv-gitlab-token", "KV v2 secret doesn't match expected value")
+
+		secret, err = Resolve("vault://gitlab/token/deployer", "https://gitlab.com/api/v4", Token)
+		test.MustSucceed(t, err, "Failed resolving dynamic secret")
+		test.AssertExpected(t, secret, "dynamic-token", "Dynamic secret doesn't match expected value")
+
+		secret, err = Resolve("vault://secret/data/userpass", "https://gitea.example.com", UserPassword)
+		test.MustSucceed(t, err, "Failed resolving username/password secret")
+		test.AssertExpected(t, secret, "vault-user:vault-password", "Username/password secret doesn't match expected value")
+
+		_, err = Resolve("vault://secret/data/shipper#missing", "https://gitlab.com/api/v4", Token)
+		if !errors.Is(err, ErrVaultField) {
+			t.Fatalf("Expected missing field error, got %v", err)
+		}
+		_, err = Resolve("vault://secret/data/shipper", "https://gitlab.com/api/v4", Token)
+		if !errors.Is(err, ErrVaultField) {
+			t.Fatalf("Expected ambiguous field error, got %v", err)
+		}
+	}
+	test.AssertExpected(t, common.Redact("login-token"), common.RedactedPlaceholder, "Vault token was not registered for redaction")
+
+	// Wrong credentials
+	ConfigureVault(VaultConfig{Address: server.URL, Namespace: "test-namespace", RoleID: "test-role-id", SecretID: "wrong"})
+	vault.client = server.Client()
+	_, err := Resolve("vault://secret/data/shipper#gitlab", "https://gitlab.com/api/v4", Token)
+	test.MustFail(t, err, "Resolving with wrong AppRole credentials supposed to fail but succeeded")
+
+	// No authentication method
+	ConfigureVault(VaultConfig{Address: server.URL})
+	_, err = Resolve("vault://secret/data/shipper#gitlab", "https://gitlab.com/api/v4", Token)
+	if !errors.Is(err, ErrVaultNoAuth) {
+		t.Fatalf("Expected no authentication error, got %v", err)
+	}
+
+	// Vault not configured
+	vault = nil
+	_, err = Resolve("vault://secret/data/shipper#gitlab", "https://gitlab.com/api/v4", Token)
+	if !errors.Is(err, ErrVaultNotConfigured) {
+		t.Fatalf("Expected vault not configured error, got %v", err)
+	}
+}
@@ -0,0 +1,206 @@
+package diff
+
+import (
+	"fmt"
+	"strings"
+)
+
+// DefaultContext is the number of unchanged lines shown around changes, as in "diff -u"
+const DefaultContext = 3
+
+type operation int
+
+const (
+	opEqual operation = iota
+	opDelete
+	opInsert
+)
+
+// edit is a line of the edit script turning the old text into the new one
+type edit struct {
+	op   operation
+	line string
+
+	// Positions of the line in the old and new texts (for insertions and deletions, where it would be)
+	oldIndex int
+	newIndex int
+}
+
+// Unified returns the differences between two texts in the unified diff format, with context lines
+// around changes. An empty string is returned if the texts are equal.
+func Unified(oldName string, newName string, oldText []byte, newText []byte, context int) string {
+	edits := editScript(splitLines(string(oldText)), splitLines(string(newText)))
+
+	b := new(strings.Builder)
+	first := true
+	for start := 0; start < len(edits); {
+		// Find the next change
+		change := start
+		for change < len(edits) && edits[change].op == opEqual {
+			change++
+		}
+		if change == len(edits) {
+			break
+		}
+
+		// Extend the hunk until changes are more than two contexts apart
+		hunkStart := max(change-context, start)
+		end := change
+		for {
+			for end < len(edits) && edits[end].op != opEqual {
+				end++
+			}
+			next := end
+			for next < len(edits) && edits[next].op == opEqual {
+				next++
+			}
+			if next == len(edits) || next-end > 2*context {
+				break
+			}
+			end = next
+		}
+		hunkEnd := min(end+context, len(edits))
+
+		if first {
+			fmt.Fprintf(b, "--- %s\n+++ %s\n", oldName, newName)
+			first = false
+		}
+		writeHunk(b, edits[hunkStart:hunkEnd])
+		start = hunkEnd
+	}
+	return b.String()
+}
+
+func writeHunk(b *strings.Builder, edits []edit) {
+	oldCount, newCount := 0, 0
+	for _, e := range edits {
+		if e.op != opInsert {
+			oldCount++
+		}
+		if e.op != opDelete {
+			newCount++
+		}
+	}
+	fmt.Fprintf(b, "@@ -%s +%s @@\n", hunkRange(edits[0].oldIndex, oldCount), hunkRange(edits[0].newIndex, newCount))
+
+	for _, e := range edits {
+		switch e.op {
+		case opEqual:
+			b.WriteString(" ")
+		case opDelete:
+			b.WriteString("-")
+		case opInsert:
+			b.WriteString("+")
+		}
+		b.WriteString(e.line)
+		if !strings.HasSuffix(e.line, "\n") {
+			b.WriteString("\n\\ No newline at end of file\n")
+		}
+	}
+}
+
+// hunkRange formats the lines of a hunk as "diff -u" does: empty ranges start at the line before them
+func hunkRange(index int, count int) string {
+	switch count {
+	case 0:
+		return fmt.Sprintf("%d,0", index)
+	case 1:
+		return fmt.Sprintf("%d", index+1)
+	}
+	return fmt.Sprintf("%d,%d", index+1, count)
+}
+
+// splitLines splits text into lines, keeping line endings so a missing final newline shows up as a change
+func splitLines(text string) []string {
+	if text == "" {
+		return nil
+	}
+	lines := strings.SplitAfter(text, "\n")
+	if lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	return lines
+}
+
+// editScript computes the shortest edit script between two texts with the Myers algorithm
+func editScript(a []string, b []string) []edit {
+	n, m := len(a), len(b)
+	if n+m == 0 {
+		return nil
+	}
+
+	// v[offset+k] is the furthest x reached on diagonal k, a copy is kept for every edit distance to backtrack
+	offset := n + m
+	v := make([]int, 2*offset+2)
+	var trace [][]int
+search:
+	for d := 0; d <= n+m; d++ {
+		trace = append(trace, append([]int{}, v...))
+		for k := -d; k <= d; k += 2 {
+			var x int
+			if k == -d || (k != d && v[offset+k-1] < v[offset+k+1]) {
+				x = v[offset+k+1]
+			} else {
+				x = v[offset+k-1] + 1
+			}
+			y := x - k
+			for x < n && y < m && a[x] == b[y] {
+				x++
+				y++
+			}
+			v[offset+k] = x
+			if x >= n && y >= m {
+				break search
+			}
+		}
+	}
+
+	// Walk back from the end of both texts, collecting edits in reverse
+	var edits []edit
+	x, y := n, m
+	for d := len(trace) - 1; d >= 0; d-- {
+		v := trace[d]
+		k := x - y
+		var prevK int
+		if k == -d || (k != d && v[offset+k-1] < v[offset+k+1]) {
+			prevK = k + 1
+		} else {
+			prevK = k - 1
+		}
+		prevX := v[offset+prevK]
+		prevY := prevX - prevK
+
+		for x > prevX && y > prevY {
+			x--
+			y--
+			edits = append(edits, edit{op: opEqual, line: a[x], oldIndex: x, newIndex: y})
+		}
+		if d > 0 {
+			if x == prevX {
+				edits = append(edits, edit{op: opInsert, line: b[prevY], oldIndex: prevX, newIndex: prevY})
+			} else {
+				edits = append(edits, edit{op: opDelete, line: a[prevX], oldIndex: prevX, newIndex: prevY})
+			}
+		}
+		x, y = prevX, prevY
+	}
+
+	for i, j := 0, len(edits)-1; i < j; i, j = i+1, j-1 {
+		edits[i], edits[j] = edits[j], edits[i]
+	}
+	return edits
+}
+
+func min(a int, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+func max(a int, b int) int {
+	if a > b {
+		return a
+	}
+	return b
+}
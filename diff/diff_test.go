@@ -0,0 +1,117 @@
+package diff
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/neosperience/shipper/test"
+)
+
+func TestUnified(t *testing.T) {
+	tests := []struct {
+		name     string
+		old      string
+		new      string
+		expected string
+	}{
+		{
+			name:     "equal",
+			old:      "a\nb\n",
+			new:      "a\nb\n",
+			expected: "",
+		},
+		{
+			name: "changed line",
+			old:  "image:\n  repository: app\n  tag: v1\n",
+			new:  "image:\n  repository: app\n  tag: v2\n",
+			expected: `--- a/values.yaml
++++ b/values.yaml
+@@ -1,3 +1,3 @@
+ image:
+   repository: app
+-  tag: v1
++  tag: v2
+`,
+		},
+		{
+			name:     "new file",
+			old:      "",
+			new:      "a\n",
+			expected: "--- a/values.yaml\n+++ b/values.yaml\n@@ -0,0 +1 @@\n+a\n",
+		},
+		{
+			name:     "missing final newline",
+			old:      "a\nb",
+			new:      "a\nb\n",
+			expected: "--- a/values.yaml\n+++ b/values.yaml\n@@ -1,2 +1,2 @@\n a\n-b\n\\ No newline at end of file\n+b\n",
+		},
+		{
+			name: "separate hunks",
+			old:  "1\n2\n3\n4\n5\n6\n7\n8\n9\n10\n11\n12\n",
+			new:  "one\n2\n3\n4\n5\n6\n7\n8\n9\n10\n11\ntwelve\n",
+			expected: `--- a/values.yaml
++++ b/values.yaml
+@@ -1,4 +1,4 @@
+-1
++one
+ 2
+ 3
+ 4
+@@ -9,4 +9,4 @@
+ 9
+ 10
+ 11
+-12
++twelve
+`,
+		},
+		{
+			name: "merged hunks",
+			old:  "1\n2\n3\n4\n5\n6\n7\n8\n",
+			new:  "one\n2\n3\n4\n5\n6\n7\neight\n",
+			expected: `--- a/values.yaml
++++ b/values.yaml
+@@ -1,8 +1,8 @@
+-1
++one
+ 2
+ 3
+ 4
+ 5
+ 6
+ 7
+-8
++eight
+`,
+		},
+	}
+	for _, tt := range tests {
+		result := Unified("a/values.yaml", "b/values.yaml", []byte(tt.old), []byte(tt.new), DefaultContext)
+		test.AssertExpected(t, result, tt.expected, "Diff doesn't match for "+tt.name)
+	}
+}
+
+// TestPatchApplies checks that generated diffs can be applied with patch, when available
+func TestPatchApplies(t *testing.T) {
+	if _, err := exec.LookPath("patch"); err != nil {
+		t.Skip("patch is not installed")
+	}
+
+	old := strings.Repeat("line\n", 5) + "a\nb\nc\n" + strings.Repeat("other\n", 10) + "x\ny"
+	new := "first\n" + strings.Repeat("line\n", 5) + "a\nc\nd\n" + strings.Repeat("other\n", 9) + "x\ny\nz\n"
+
+	dir := t.TempDir()
+	file := filepath.Join(dir, "file.txt")
+	test.MustSucceed(t, os.WriteFile(file, []byte(old), 0644), "Failed writing file")
+	cmd := exec.Command("patch", "-s", file)
+	cmd.Stdin = strings.NewReader(Unified("a/file.txt", "b/file.txt", []byte(old), []byte(new), DefaultContext))
+	output, err := cmd.CombinedOutput()
+	test.MustSucceed(t, err, "Failed applying patch: "+string(output))
+
+	patched, err := os.ReadFile(file)
+	test.MustSucceed(t, err, "Failed reading patched file")
+	test.AssertExpected(t, string(patched), new, "Patched file doesn't match")
+}
@@ -0,0 +1,120 @@
+package environments
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+
+	"gopkg.in/yaml.v3"
+)
+
+var (
+	// ErrInvalidConfig happens if the environments configuration is incomplete or inconsistent
+	ErrInvalidConfig = errors.New("invalid environments configuration")
+)
+
+// Config lists the environments apps are deployed to
+type Config struct {
+	Environments []Environment `yaml:"environments"`
+}
+
+// Environment is where apps are deployed: a file on a branch, updated by a templater
+type Environment struct {
+	Name      string `yaml:"name"`
+	Templater string `yaml:"templater"`
+	// Repository is the value of the repository option of the target (such as the GitLab project) for
+	// environments in another repository, it defaults to the one specified on the command line
+	Repository string `yaml:"repository"`
+	// Branch defaults to the one specified on the command line
+	Branch string `yaml:"branch"`
+	// File is used by apps not specifying their own
+	File string `yaml:"file"`
+	// Apps deployed to the environment, by name
+	Apps map[string]App `yaml:"apps"`
+}
+
+// App tells where the image of an app is in an environment
+type App struct {
+	// File overrides the environment file
+	File string `yaml:"file"`
+	// Image is the image name for Kustomize and the key for JSON
+	Image string `yaml:"image"`
+	// ImagePath and TagPath are the Helm paths of image and tag, defaulting to "image.repository" and "image.tag"
+	ImagePath string `yaml:"image-path"`
+	TagPath   string `yaml:"tag-path"`
+}
+
+// LoadConfig reads the environments configuration from a YAML file
+func LoadConfig(path string) (*Config, error) {
+	byt, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("error reading environments configuration: %w", err)
+	}
+	return ParseConfig(byt)
+}
+
+// Environment returns the environment with the given name
+func (c *Config) Environment(name string) (Environment, error) {
+	for _, env := range c.Environments {
+		if env.Name == name {
+			return env, nil
+		}
+	}
+	return Environment{}, fmt.Errorf("%w: unknown environment %s", ErrInvalidConfig, name)
+}
+
+// ParseConfig parses and validates the environments configuration
+func ParseConfig(byt []byte) (*Config, error) {
+	config := new(Config)
+	decoder := yaml.NewDecoder(bytes.NewReader(byt))
+	decoder.KnownFields(true)
+	if err := decoder.Decode(config); err != nil {
+		return nil, fmt.Errorf("error parsing environments configuration: %w", err)
+	}
+
+	if len(config.Environments) == 0 {
+		return nil, fmt.Errorf("%w: no environments", ErrInvalidConfig)
+	}
+	names := make(map[string]bool)
+	for index := range config.Environments {
+		env := &config.Environments[index]
+		if env.Name == "" {
+			return nil, fmt.Errorf("%w: environment #%d has no name", ErrInvalidConfig, index+1)
+		}
+		if names[env.Name] {
+			return nil, fmt.Errorf("%w: environment %s is listed twice", ErrInvalidConfig, env.Name)
+		}
+		names[env.Name] = true
+
+		if len(env.Apps) == 0 {
+			return nil, fmt.Errorf("%w: environment %s has no apps", ErrInvalidConfig, env.Name)
+		}
+		for name, app := range env.Apps {
+			if app.File == "" {
+				app.File = env.File
+			}
+			if app.File == "" {
+				return nil, fmt.Errorf("%w: no file for app %s in environment %s", ErrInvalidConfig, name, env.Name)
+			}
+
+			switch env.Templater {
+			case "helm":
+				if app.ImagePath == "" {
+					app.ImagePath = "image.repository"
+				}
+				if app.TagPath == "" {
+					app.TagPath = "image.tag"
+				}
+			case "kustomize", "json":
+				if app.Image == "" {
+					return nil, fmt.Errorf("%w: no image for app %s in environment %s", ErrInvalidConfig, name, env.Name)
+				}
+			default:
+				return nil, fmt.Errorf("%w: templater option not supported in environment %s: %q", ErrInvalidConfig, env.Name, env.Templater)
+			}
+			env.Apps[name] = app
+		}
+	}
+	return config, nil
+}
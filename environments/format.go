@@ -0,0 +1,117 @@
+package environments
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"text/tabwriter"
+
+	jsoniter "github.com/json-iterator/go"
+)
+
+// WriteText writes the matrix as a table, marking versions that differ from the previous environment with "*"
+func (m *Matrix) WriteText(w io.Writer) error {
+	table := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprint(table, "APP")
+	for _, env := range m.Environments {
+		fmt.Fprintf(table, "\t%s", strings.ToUpper(env.Name))
+	}
+	fmt.Fprintln(table)
+
+	for _, app := range m.Apps {
+		fmt.Fprint(table, app.Name)
+		for _, env := range m.Environments {
+			fmt.Fprintf(table, "\t%s", m.cell(app, env, func(version string) string { return version + " *" }))
+		}
+		fmt.Fprintln(table)
+	}
+	if err := table.Flush(); err != nil {
+		return err
+	}
+
+	if m.differs() {
+		_, err := fmt.Fprintln(w, "\n* differs from the previous environment")
+		return err
+	}
+	return nil
+}
+
+// WriteMarkdown writes the matrix as a Markdown table, with versions that differ from the previous environment in bold
+func (m *Matrix) WriteMarkdown(w io.Writer) error {
+	b := new(strings.Builder)
+	b.WriteString("| App |")
+	for _, env := range m.Environments {
+		fmt.Fprintf(b, " %s |", escapeMarkdown(env.Name))
+	}
+	b.WriteString("\n|-----|")
+	for range m.Environments {
+		b.WriteString("-----|")
+	}
+	b.WriteString("\n")
+
+	for _, app := range m.Apps {
+		fmt.Fprintf(b, "| %s |", escapeMarkdown(app.Name))
+		for _, env := range m.Environments {
+			cell := m.cell(app, env, func(version string) string { return "**" + version + "**" })
+			fmt.Fprintf(b, " %s |", escapeMarkdown(cell))
+		}
+		b.WriteString("\n")
+	}
+
+	if m.differs() {
+		b.WriteString("\nVersions in **bold** differ from the previous environment.\n")
+	}
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// WriteJSON writes the matrix as JSON
+func (m *Matrix) WriteJSON(w io.Writer) error {
+	byt, err := jsoniter.ConfigFastest.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return fmt.Errorf("error encoding status: %w", err)
+	}
+	_, err = w.Write(append(byt, '\n'))
+	return err
+}
+
+// cell formats the version of an app in an environment, highlighting it with highlight if it differs
+// from the previous environment. Tags are shown alone unless the app has different images across environments.
+func (m *Matrix) cell(app AppStatus, env EnvironmentStatus, highlight func(string) string) string {
+	if env.Error != "" {
+		return "error"
+	}
+	version, ok := app.Versions[env.Name]
+	if !ok {
+		return "-"
+	}
+
+	text := version.Tag
+	images := make(map[string]bool)
+	for _, other := range app.Versions {
+		images[other.Image] = true
+	}
+	if len(images) > 1 {
+		text = version.Image + ":" + version.Tag
+	}
+
+	if version.Differs {
+		return highlight(text)
+	}
+	return text
+}
+
+func (m *Matrix) differs() bool {
+	for _, app := range m.Apps {
+		if app.Differs {
+			return true
+		}
+	}
+	return false
+}
+
+var markdownEscaper = strings.NewReplacer("|", `\|`)
+
+func escapeMarkdown(text string) string {
+	return markdownEscaper.Replace(text)
+}
@@ -0,0 +1,260 @@
+package environments
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/neosperience/shipper/logging"
+	"github.com/neosperience/shipper/targets"
+	"github.com/neosperience/shipper/templater"
+	helm_templater "github.com/neosperience/shipper/templater/helm"
+	json_templater "github.com/neosperience/shipper/templater/json"
+	kustomize_templater "github.com/neosperience/shipper/templater/kustomize"
+)
+
+// PromoteOptions tells which apps to promote between two environments
+type PromoteOptions struct {
+	From string
+	To   string
+	// Apps to promote, all the ones of both environments if empty
+	Apps []string
+	// DefaultBranch is used by environments without a branch
+	DefaultBranch string
+	// TagsOnly keeps the images of the destination environment, only copying tags
+	TagsOnly bool
+}
+
+// Change is the version of an app in the destination environment before and after the promotion
+type Change struct {
+	App  string
+	From Version
+	To   Version
+}
+
+func (c Change) String() string {
+	if c.From.Image == c.To.Image {
+		return fmt.Sprintf("%s: %s -> %s", c.App, valueOr(c.From.Tag, "none"), c.To.Tag)
+	}
+	from := "none"
+	if c.From.Image != "" || c.From.Tag != "" {
+		from = c.From.Image + ":" + c.From.Tag
+	}
+	return fmt.Sprintf("%s: %s -> %s:%s", c.App, from, c.To.Image, c.To.Tag)
+}
+
+// Promotion is the outcome of promoting apps from an environment to another, the files still need to be
+// committed to the destination repository
+type Promotion struct {
+	From         Environment
+	To           Environment
+	SourceBranch string
+	// SourceCommit is the commit versions were read from, empty if the source repository can't tell
+	SourceCommit string
+	// Branch and Repository of the destination environment
+	Branch     string
+	Repository targets.Repository
+	// Changes to the destination environment, sorted by app, and the files updated to make them
+	Changes []Change
+	Files   targets.FileList
+}
+
+// Message returns a commit message naming the promoted apps and the source commit. If subject is empty,
+// one naming the environments is used.
+func (p *Promotion) Message(subject string) string {
+	if subject == "" {
+		subject = fmt.Sprintf("Promote %s to %s", p.From.Name, p.To.Name)
+	}
+	lines := []string{subject, ""}
+	for _, change := range p.Changes {
+		lines = append(lines, change.String())
+	}
+	source := p.SourceBranch
+	if p.SourceCommit != "" {
+		source = p.SourceCommit + " (" + p.SourceBranch + ")"
+	}
+	lines = append(lines, "", fmt.Sprintf("Promoted from %s at %s", p.From.Name, source))
+	return strings.Join(lines, "\n")
+}
+
+// Promote reads the versions of apps in the source environment and updates the files of the destination
+// environment to the same versions. Apps already at the same version are left out.
+func Promote(connect Connector, config *Config, options PromoteOptions) (*Promotion, error) {
+	from, err := config.Environment(options.From)
+	if err != nil {
+		return nil, err
+	}
+	to, err := config.Environment(options.To)
+	if err != nil {
+		return nil, err
+	}
+	if from.Name == to.Name {
+		return nil, fmt.Errorf("%w: can't promote environment %s to itself", ErrInvalidConfig, from.Name)
+	}
+
+	apps, err := promotedApps(from, to, options.Apps)
+	if err != nil {
+		return nil, err
+	}
+
+	source, err := connect(from.Repository)
+	if err != nil {
+		return nil, fmt.Errorf("error connecting to the repository of %s: %w", from.Name, err)
+	}
+	destination, err := connect(to.Repository)
+	if err != nil {
+		return nil, fmt.Errorf("error connecting to the repository of %s: %w", to.Name, err)
+	}
+
+	promotion := &Promotion{
+		From:         from,
+		To:           to,
+		SourceBranch: branchOf(from, options.DefaultBranch),
+		Branch:       branchOf(to, options.DefaultBranch),
+		Repository:   destination,
+	}
+
+	// Resolve the source commit first, so versions are read as close as possible to it. It's only used in
+	// the commit message, failing to resolve it doesn't prevent the promotion.
+	promotion.SourceCommit, err = targets.ResolveCommit(source, promotion.SourceBranch)
+	if errors.Is(err, targets.ErrUnsupported) {
+		logging.Debug("source commit can't be resolved", "environment", from.Name, "error", err)
+	} else if err != nil {
+		logging.Warn("error resolving source commit", "environment", from.Name, "error", err)
+	}
+
+	sourceFiles := &cachedRepository{Repository: source, files: make(map[string]cachedFile)}
+	destinationFiles := &cachedRepository{Repository: destination, files: make(map[string]cachedFile)}
+	for _, name := range apps {
+		version, err := readApp(sourceFiles, from.Templater, promotion.SourceBranch, from.Apps[name])
+		if errors.Is(err, templater.ErrImageNotFound) && len(options.Apps) == 0 {
+			logging.Info("app not deployed to the source environment, skipping", "environment", from.Name, "app", name)
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("error reading %s in %s: %w", name, from.Name, err)
+		}
+
+		current, err := readApp(destinationFiles, to.Templater, promotion.Branch, to.Apps[name])
+		if err != nil && !errors.Is(err, templater.ErrImageNotFound) {
+			return nil, fmt.Errorf("error reading %s in %s: %w", name, to.Name, err)
+		}
+
+		change := Change{
+			App:  name,
+			From: Version{Image: current.Image, Tag: current.Tag},
+			To:   Version{Image: version.Image, Tag: version.Tag},
+		}
+		// Images are only copied if the destination can hold them
+		if options.TagsOnly || change.To.Image == "" || to.Templater == "json" {
+			change.To.Image = change.From.Image
+		}
+		if change.To == change.From {
+			logging.Info("app already at the promoted version", "app", name, "tag", change.To.Tag)
+			continue
+		}
+		if to.Templater == "helm" && change.To.Image == "" {
+			return nil, fmt.Errorf("no image to set for %s in %s, %s has none", name, to.Name, from.Name)
+		}
+		promotion.Changes = append(promotion.Changes, change)
+	}
+
+	if len(promotion.Changes) == 0 {
+		promotion.Files = targets.FileList{}
+		return promotion, nil
+	}
+	promotion.Files, err = updateApps(destinationFiles, to, promotion.Branch, promotion.Changes)
+	if err != nil {
+		return nil, fmt.Errorf("error updating %s: %w", to.Name, err)
+	}
+	return promotion, nil
+}
+
+// promotedApps returns the apps to promote, sorted by name
+func promotedApps(from Environment, to Environment, selected []string) ([]string, error) {
+	if len(selected) == 0 {
+		for name := range from.Apps {
+			if _, ok := to.Apps[name]; ok {
+				selected = append(selected, name)
+			}
+		}
+		if len(selected) == 0 {
+			return nil, fmt.Errorf("%w: environments %s and %s have no apps in common", ErrInvalidConfig, from.Name, to.Name)
+		}
+	}
+
+	apps := make([]string, 0, len(selected))
+	for _, name := range selected {
+		for _, env := range []Environment{from, to} {
+			if _, ok := env.Apps[name]; !ok {
+				return nil, fmt.Errorf("%w: no app %s in environment %s", ErrInvalidConfig, name, env.Name)
+			}
+		}
+		apps = append(apps, name)
+	}
+	sort.Strings(apps)
+	return apps, nil
+}
+
+// updateApps runs the templater of an environment, returning the files it changed
+func updateApps(repository targets.Repository, env Environment, branch string, changes []Change) (targets.FileList, error) {
+	switch env.Templater {
+	case "helm":
+		updates := make([]helm_templater.HelmUpdate, len(changes))
+		for index, change := range changes {
+			app := env.Apps[change.App]
+			updates[index] = helm_templater.HelmUpdate{
+				ValuesFile: app.File,
+				Image:      change.To.Image,
+				ImagePath:  app.ImagePath,
+				Tag:        change.To.Tag,
+				TagPath:    app.TagPath,
+			}
+		}
+		return helm_templater.UpdateHelmChart(repository, helm_templater.HelmProviderOptions{
+			Ref:     branch,
+			Updates: updates,
+		})
+	case "kustomize":
+		updates := make([]kustomize_templater.KustomizeUpdate, len(changes))
+		for index, change := range changes {
+			app := env.Apps[change.App]
+			updates[index] = kustomize_templater.KustomizeUpdate{
+				KustomizationFile: app.File,
+				Image:             app.Image,
+				NewTag:            change.To.Tag,
+			}
+			if change.To.Image != change.From.Image {
+				updates[index].NewImage = change.To.Image
+			}
+		}
+		return kustomize_templater.UpdateKustomization(repository, kustomize_templater.KustomizeProviderOptions{
+			Ref:     branch,
+			Updates: updates,
+		})
+	case "json":
+		updates := make([]json_templater.FileUpdate, len(changes))
+		for index, change := range changes {
+			app := env.Apps[change.App]
+			updates[index] = json_templater.FileUpdate{
+				File: app.File,
+				Path: app.Image,
+				Tag:  change.To.Tag,
+			}
+		}
+		return json_templater.UpdateJSONFile(repository, json_templater.JSONProviderOptions{
+			Ref:     branch,
+			Updates: updates,
+		})
+	default:
+		return nil, fmt.Errorf("templater option not supported: %s", env.Templater)
+	}
+}
+
+func valueOr(value string, fallback string) string {
+	if value != "" {
+		return value
+	}
+	return fallback
+}
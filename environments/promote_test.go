@@ -0,0 +1,102 @@
+package environments
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/neosperience/shipper/targets"
+	"github.com/neosperience/shipper/test"
+)
+
+const testDisasterRecovery = `
+  - name: dr
+    templater: json
+    repository: backup
+    file: versions.json
+    apps:
+      api:
+        image: api
+`
+
+func testConnector() Connector {
+	repositories := map[string]targets.Repository{
+		"":       testRepository(),
+		"backup": targets.NewInMemoryRepository(targets.FileList{"versions.json": []byte(`{"api": "1.0.0"}`)}),
+	}
+	return func(id string) (targets.Repository, error) {
+		repository, ok := repositories[id]
+		if !ok {
+			return nil, errors.New("unknown repository")
+		}
+		return repository, nil
+	}
+}
+
+func TestPromote(t *testing.T) {
+	config, err := ParseConfig([]byte(testConfig + testDisasterRecovery))
+	test.MustSucceed(t, err, "Failed parsing configuration")
+
+	// Same templater, only the api tag differs
+	promotion, err := Promote(testConnector(), config, PromoteOptions{From: "dev", To: "staging", DefaultBranch: "main"})
+	test.MustSucceed(t, err, "Failed promoting dev to staging")
+	test.AssertExpected(t, promotion.SourceCommit, "main-commit", "Source commit doesn't match")
+	test.AssertExpected(t, len(promotion.Changes), 1, "Only api should change")
+	test.AssertExpected(t, promotion.Changes[0].String(), "api: 1.2.0 -> 1.3.0", "Change doesn't match")
+	test.AssertExpected(t, string(promotion.Files["envs/staging/values.yaml"]), `image:
+    repository: registry/api
+    tag: 1.3.0
+worker:
+    image: registry/worker
+    tag: 2.0.0
+`, "Staging values don't match")
+	test.AssertExpected(t, promotion.Message(""), `Promote dev to staging
+
+api: 1.2.0 -> 1.3.0
+
+Promoted from dev at main-commit (main)`, "Commit message doesn't match")
+
+	// Across templaters and branches, images are copied unless only tags are promoted
+	promotion, err = Promote(testConnector(), config, PromoteOptions{From: "staging", To: "prod", DefaultBranch: "main"})
+	test.MustSucceed(t, err, "Failed promoting staging to prod")
+	test.AssertExpected(t, promotion.Branch, "production", "Destination branch doesn't match")
+	test.AssertExpected(t, len(promotion.Changes), 1, "Only the worker image should change")
+	test.AssertExpected(t, promotion.Changes[0].String(), "worker: mirror/worker:2.0.0 -> registry/worker:2.0.0", "Change doesn't match")
+	test.AssertExpected(t, string(promotion.Files["overlays/prod/kustomization.yaml"]), `images:
+    - name: registry/api
+      newTag: 1.2.0
+    - name: registry/worker
+      newImage: registry/worker
+      newTag: 2.0.0
+`, "Kustomization doesn't match")
+
+	promotion, err = Promote(testConnector(), config, PromoteOptions{From: "staging", To: "prod", DefaultBranch: "main", TagsOnly: true})
+	test.MustSucceed(t, err, "Failed promoting staging tags to prod")
+	test.AssertExpected(t, len(promotion.Changes), 0, "Tags are the same, nothing should change")
+	test.AssertExpected(t, len(promotion.Files), 0, "No files should change")
+
+	// To another repository
+	promotion, err = Promote(testConnector(), config, PromoteOptions{From: "dev", To: "dr", Apps: []string{"api"}, DefaultBranch: "main"})
+	test.MustSucceed(t, err, "Failed promoting dev to dr")
+	test.AssertExpected(t, string(promotion.Files["versions.json"]), "{\n  \"api\": \"1.3.0\"\n}", "JSON file doesn't match")
+}
+
+func TestPromoteErrors(t *testing.T) {
+	config, err := ParseConfig([]byte(testConfig + testDisasterRecovery))
+	test.MustSucceed(t, err, "Failed parsing configuration")
+
+	invalid := map[string]PromoteOptions{
+		"unknown source":      {From: "qa", To: "prod"},
+		"unknown destination": {From: "dev", To: "qa"},
+		"same environment":    {From: "dev", To: "dev"},
+		"app not in source":   {From: "dev", To: "prod", Apps: []string{"cron"}},
+		"app not in both":     {From: "dev", To: "dr", Apps: []string{"worker"}},
+	}
+	for name, options := range invalid {
+		options.DefaultBranch = "main"
+		_, err := Promote(testConnector(), config, options)
+		test.AssertExpected(t, errors.Is(err, ErrInvalidConfig), true, "Invalid promotion was not refused: "+name)
+	}
+
+	_, err = Promote(SingleRepository(testRepository()), config, PromoteOptions{From: "dev", To: "dr", DefaultBranch: "main"})
+	test.MustFail(t, err, "Promoting to an unknown repository succeeded")
+}
@@ -0,0 +1,42 @@
+package environments
+
+import (
+	"fmt"
+
+	"github.com/neosperience/shipper/targets"
+)
+
+// Connector returns the repository of environments with the given repository option, an empty id is the
+// repository specified on the command line
+type Connector func(id string) (targets.Repository, error)
+
+// SingleRepository returns a Connector for configurations where all environments are in repository
+func SingleRepository(repository targets.Repository) Connector {
+	return func(id string) (targets.Repository, error) {
+		if id != "" {
+			return nil, fmt.Errorf("%w: environments can't be in other repositories", ErrInvalidConfig)
+		}
+		return repository, nil
+	}
+}
+
+type cachedFile struct {
+	data []byte
+	err  error
+}
+
+// cachedRepository is a repository remembering the files it read, it must not be used concurrently
+type cachedRepository struct {
+	targets.Repository
+	files map[string]cachedFile
+}
+
+func (c *cachedRepository) Get(path string, ref string) ([]byte, error) {
+	key := ref + ":" + path
+	if file, ok := c.files[key]; ok {
+		return file.data, file.err
+	}
+	data, err := c.Repository.Get(path, ref)
+	c.files[key] = cachedFile{data: data, err: err}
+	return data, err
+}
@@ -0,0 +1,187 @@
+package environments
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+
+	"github.com/neosperience/shipper/logging"
+	"github.com/neosperience/shipper/targets"
+	"github.com/neosperience/shipper/templater"
+	helm_templater "github.com/neosperience/shipper/templater/helm"
+	json_templater "github.com/neosperience/shipper/templater/json"
+	kustomize_templater "github.com/neosperience/shipper/templater/kustomize"
+)
+
+// Version is the image and tag of an app in an environment
+type Version struct {
+	Image string `json:"image,omitempty"`
+	Tag   string `json:"tag"`
+	// Differs is true if the version is not the one of the previous environment deploying the app
+	Differs bool `json:"differs"`
+}
+
+// AppStatus is the version of an app in each environment
+type AppStatus struct {
+	Name string `json:"name"`
+	// Versions by environment name, environments not deploying the app are missing
+	Versions map[string]*Version `json:"versions"`
+	// Differs is true if the app is not at the same version in all environments deploying it
+	Differs bool `json:"differs"`
+}
+
+// EnvironmentStatus is an environment in the matrix, with the error that prevented reading it, if any
+type EnvironmentStatus struct {
+	Name   string `json:"name"`
+	Branch string `json:"branch,omitempty"`
+	Error  string `json:"error,omitempty"`
+}
+
+// Matrix is the version of apps across environments, apps are sorted by name and environments are in
+// configuration order
+type Matrix struct {
+	Environments []EnvironmentStatus `json:"environments"`
+	Apps         []AppStatus         `json:"apps"`
+}
+
+// Read reads the version of every app in every environment, reading environments concurrently unless concurrent
+// is false. Reads must be sequential when tracing, as spans are nested under whichever span started last.
+// Environments without a branch use defaultBranch. Environments that can't be read are reported in the matrix,
+// and the returned error is about the first of them.
+func Read(connect Connector, config *Config, defaultBranch string, concurrent bool) (*Matrix, error) {
+	versions := make([]map[string]*Version, len(config.Environments))
+	errs := make([]error, len(config.Environments))
+
+	// Connect before reading, so connectors don't need to be safe for concurrent use
+	repositories := make([]targets.Repository, len(config.Environments))
+	for index, env := range config.Environments {
+		repositories[index], errs[index] = connect(env.Repository)
+	}
+
+	read := func(index int) {
+		env := config.Environments[index]
+		versions[index], errs[index] = readEnvironment(repositories[index], env, branchOf(env, defaultBranch))
+	}
+	var wait sync.WaitGroup
+	for index := range config.Environments {
+		if errs[index] != nil {
+			continue
+		}
+		if !concurrent {
+			read(index)
+			continue
+		}
+		wait.Add(1)
+		go func(index int) {
+			defer wait.Done()
+			read(index)
+		}(index)
+	}
+	wait.Wait()
+
+	matrix := &Matrix{}
+	var firstErr error
+	apps := make(map[string]bool)
+	for index, env := range config.Environments {
+		envStatus := EnvironmentStatus{Name: env.Name, Branch: branchOf(env, defaultBranch)}
+		if err := errs[index]; err != nil {
+			envStatus.Error = err.Error()
+			if firstErr == nil {
+				firstErr = fmt.Errorf("error reading environment %s: %w", env.Name, err)
+			}
+		}
+		matrix.Environments = append(matrix.Environments, envStatus)
+		for name := range env.Apps {
+			apps[name] = true
+		}
+	}
+
+	names := make([]string, 0, len(apps))
+	for name := range apps {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		app := AppStatus{Name: name, Versions: make(map[string]*Version)}
+		var previous *Version
+		for index, env := range config.Environments {
+			version, ok := versions[index][name]
+			if !ok {
+				continue
+			}
+			if previous != nil && (version.Image != previous.Image || version.Tag != previous.Tag) {
+				version.Differs = true
+				app.Differs = true
+			}
+			app.Versions[env.Name] = version
+			previous = version
+		}
+		matrix.Apps = append(matrix.Apps, app)
+	}
+
+	return matrix, firstErr
+}
+
+func branchOf(env Environment, defaultBranch string) string {
+	if env.Branch != "" {
+		return env.Branch
+	}
+	return defaultBranch
+}
+
+// readEnvironment returns the version of the apps deployed to an environment
+func readEnvironment(repository targets.Repository, env Environment, branch string) (map[string]*Version, error) {
+	// Apps usually share files, only read them once
+	cached := &cachedRepository{Repository: repository, files: make(map[string]cachedFile)}
+
+	names := make([]string, 0, len(env.Apps))
+	for name := range env.Apps {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	versions := make(map[string]*Version)
+	for _, name := range names {
+		app := env.Apps[name]
+		image, err := readApp(cached, env.Templater, branch, app)
+		if errors.Is(err, templater.ErrImageNotFound) {
+			logging.Debug("app not deployed", "environment", env.Name, "app", name, "error", err)
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		versions[name] = &Version{Image: image.Image, Tag: image.Tag}
+	}
+	return versions, nil
+}
+
+func readApp(repository targets.Repository, name string, branch string, app App) (templater.Image, error) {
+	var images []templater.Image
+	var err error
+	switch name {
+	case "helm":
+		images, err = helm_templater.GetHelmImages(repository, helm_templater.HelmGetOptions{
+			Ref:     branch,
+			Lookups: []helm_templater.HelmLookup{{ValuesFile: app.File, ImagePath: app.ImagePath, TagPath: app.TagPath}},
+		})
+	case "kustomize":
+		images, err = kustomize_templater.GetKustomizeImages(repository, kustomize_templater.KustomizeGetOptions{
+			Ref:     branch,
+			Lookups: []kustomize_templater.KustomizeLookup{{KustomizationFile: app.File, Image: app.Image}},
+		})
+	case "json":
+		images, err = json_templater.GetJSONValues(repository, json_templater.JSONGetOptions{
+			Ref:     branch,
+			Lookups: []json_templater.JSONLookup{{File: app.File, Path: app.Image}},
+		})
+	default:
+		err = fmt.Errorf("templater option not supported: %s", name)
+	}
+	if err != nil {
+		return templater.Image{}, err
+	}
+	return images[0], nil
+}
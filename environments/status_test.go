@@ -0,0 +1,255 @@
+package environments
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/neosperience/shipper/targets"
+	"github.com/neosperience/shipper/test"
+	"github.com/neosperience/shipper/tracing"
+)
+
+const testConfig = `environments:
+  - name: dev
+    templater: helm
+    file: envs/dev/values.yaml
+    apps:
+      api: {}
+      worker:
+        image-path: worker.image
+        tag-path: worker.tag
+  - name: staging
+    templater: helm
+    file: envs/staging/values.yaml
+    apps:
+      api: {}
+      worker:
+        image-path: worker.image
+        tag-path: worker.tag
+  - name: prod
+    templater: kustomize
+    branch: production
+    file: overlays/prod/kustomization.yaml
+    apps:
+      api:
+        image: registry/api
+      worker:
+        image: registry/worker
+      cron:
+        file: overlays/prod/cron/kustomization.yaml
+        image: registry/cron
+`
+
+// branchRepository serves different files for each branch
+type branchRepository map[string]targets.FileList
+
+func (r branchRepository) Get(path string, ref string) ([]byte, error) {
+	file, ok := r[ref][path]
+	if !ok {
+		return nil, targets.ErrFileNotFound
+	}
+	return file, nil
+}
+
+func (r branchRepository) ResolveCommit(ref string) (string, error) {
+	if _, ok := r[ref]; !ok {
+		return "", targets.ErrFileNotFound
+	}
+	return ref + "-commit", nil
+}
+
+func (r branchRepository) Commit(payload *targets.CommitPayload) error {
+	return errors.New("read-only repository")
+}
+
+func testRepository() branchRepository {
+	return branchRepository{
+		"main": {
+			"envs/dev/values.yaml": []byte(`image:
+  repository: registry/api
+  tag: 1.3.0
+worker:
+  image: registry/worker
+  tag: 2.0.0
+`),
+			"envs/staging/values.yaml": []byte(`image:
+  repository: registry/api
+  tag: 1.2.0
+worker:
+  image: registry/worker
+  tag: 2.0.0
+`),
+		},
+		"production": {
+			"overlays/prod/kustomization.yaml": []byte(`images:
+- name: registry/api
+  newTag: 1.2.0
+- name: registry/worker
+  newImage: mirror/worker
+  newTag: 2.0.0
+`),
+			"overlays/prod/cron/kustomization.yaml": []byte(`images:
+- name: registry/other
+  newTag: 0.1.0
+`),
+		},
+	}
+}
+
+func TestParseConfig(t *testing.T) {
+	config, err := ParseConfig([]byte(testConfig))
+	test.MustSucceed(t, err, "Failed parsing configuration")
+	test.AssertExpected(t, len(config.Environments), 3, "Unexpected number of environments")
+	test.AssertExpected(t, config.Environments[0].Apps["api"], App{File: "envs/dev/values.yaml", ImagePath: "image.repository", TagPath: "image.tag"}, "Helm defaults were not applied")
+	test.AssertExpected(t, config.Environments[2].Apps["cron"].File, "overlays/prod/cron/kustomization.yaml", "App file should override the environment one")
+
+	invalid := map[string]string{
+		"no environments":    `environments: []`,
+		"unknown field":      "environments:\n  - name: dev\n    templater: helm\n    fil: values.yaml\n",
+		"missing name":       "environments:\n  - templater: helm\n    file: values.yaml\n    apps: {api: {}}\n",
+		"duplicate name":     "environments:\n  - {name: dev, templater: helm, file: a.yaml, apps: {api: {}}}\n  - {name: dev, templater: helm, file: b.yaml, apps: {api: {}}}\n",
+		"missing file":       "environments:\n  - {name: dev, templater: helm, apps: {api: {}}}\n",
+		"missing image":      "environments:\n  - {name: dev, templater: kustomize, file: k.yaml, apps: {api: {}}}\n",
+		"unknown templater":  "environments:\n  - {name: dev, templater: jsonnet, file: k.yaml, apps: {api: {}}}\n",
+		"environment no app": "environments:\n  - {name: dev, templater: helm, file: values.yaml}\n",
+	}
+	for name, config := range invalid {
+		_, err := ParseConfig([]byte(config))
+		test.MustFail(t, err, "Invalid configuration was accepted: "+name)
+	}
+}
+
+func TestRead(t *testing.T) {
+	config, err := ParseConfig([]byte(testConfig))
+	test.MustSucceed(t, err, "Failed parsing configuration")
+
+	matrix, err := Read(SingleRepository(testRepository()), config, "main", true)
+	test.MustSucceed(t, err, "Failed reading environments")
+
+	test.AssertExpected(t, len(matrix.Environments), 3, "Unexpected number of environments")
+	test.AssertExpected(t, matrix.Environments[2], EnvironmentStatus{Name: "prod", Branch: "production"}, "Environment branch doesn't match")
+	test.AssertExpected(t, len(matrix.Apps), 3, "Unexpected number of apps")
+
+	// Apps are sorted by name, cron is only configured in prod and not in its kustomization
+	test.AssertExpected(t, matrix.Apps[0].Name, "api", "Apps should be sorted")
+	test.AssertExpected(t, len(matrix.Apps[1].Versions), 0, "Missing app should have no versions")
+
+	api := matrix.Apps[0]
+	test.AssertExpected(t, api.Differs, true, "api should differ across environments")
+	test.AssertExpected(t, *api.Versions["dev"], Version{Image: "registry/api", Tag: "1.3.0"}, "api version in dev doesn't match")
+	test.AssertExpected(t, *api.Versions["staging"], Version{Image: "registry/api", Tag: "1.2.0", Differs: true}, "api in staging should differ from dev")
+	test.AssertExpected(t, api.Versions["prod"].Differs, false, "api in prod should match staging")
+
+	worker := matrix.Apps[2]
+	test.AssertExpected(t, worker.Differs, true, "worker image should differ in prod")
+	test.AssertExpected(t, worker.Versions["staging"].Differs, false, "worker in staging should match dev")
+}
+
+func TestReadErrors(t *testing.T) {
+	config, err := ParseConfig([]byte(testConfig))
+	test.MustSucceed(t, err, "Failed parsing configuration")
+
+	// Without the production branch, prod can't be read but other environments still are
+	repository := testRepository()
+	delete(repository, "production")
+	matrix, err := Read(SingleRepository(repository), config, "main", true)
+	test.AssertExpected(t, errors.Is(err, targets.ErrFileNotFound), true, "Missing file error was not returned")
+	test.AssertExpected(t, strings.Contains(err.Error(), "environment prod"), true, "Error should name the environment")
+	test.AssertExpected(t, matrix.Environments[2].Error != "", true, "Environment error was not reported")
+	test.AssertExpected(t, matrix.Apps[0].Versions["dev"].Tag, "1.3.0", "Other environments should be read")
+}
+
+// slowRepository makes reads of different environments overlap if they run concurrently
+type slowRepository struct {
+	branchRepository
+}
+
+func (r slowRepository) Get(path string, ref string) ([]byte, error) {
+	time.Sleep(10 * time.Millisecond)
+	return r.branchRepository.Get(path, ref)
+}
+
+type memoryExporter struct {
+	mutex sync.Mutex
+	spans []*tracing.Span
+}
+
+func (m *memoryExporter) Export(spans []*tracing.Span) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.spans = append(m.spans, spans...)
+	return nil
+}
+
+func TestReadTracing(t *testing.T) {
+	config, err := ParseConfig([]byte(`environments:
+  - name: dev
+    templater: helm
+    file: envs/dev/values.yaml
+    apps:
+      api: {}
+  - name: staging
+    templater: helm
+    file: envs/staging/values.yaml
+    apps:
+      api: {}
+`))
+	test.MustSucceed(t, err, "Failed parsing configuration")
+
+	exporter := &memoryExporter{}
+	tracer := tracing.NewTracer(exporter)
+	repository := tracing.WrapRepository(tracer, slowRepository{testRepository()})
+
+	status := tracer.Start("status")
+	_, err = Read(SingleRepository(repository), config, "main", false)
+	test.MustSucceed(t, err, "Failed reading environments")
+	status.Finish(nil)
+	test.MustSucceed(t, tracer.Flush(), "Failed flushing spans")
+
+	// Every read is a child of the command span, not of the read of another environment
+	reads := 0
+	for _, span := range exporter.spans {
+		if span.Name == "repository get" {
+			reads++
+			test.AssertExpected(t, span.ParentID, status.Context.SpanID, "Read span is not a child of the command span")
+		}
+	}
+	test.AssertExpected(t, reads, 2, "Unexpected number of read spans")
+}
+
+func TestFormats(t *testing.T) {
+	config, err := ParseConfig([]byte(testConfig))
+	test.MustSucceed(t, err, "Failed parsing configuration")
+	matrix, err := Read(SingleRepository(testRepository()), config, "main", true)
+	test.MustSucceed(t, err, "Failed reading environments")
+
+	text := new(bytes.Buffer)
+	test.MustSucceed(t, matrix.WriteText(text), "Failed writing table")
+	test.AssertExpected(t, text.String(), `APP     DEV                    STAGING                PROD
+api     1.3.0                  1.2.0 *                1.2.0
+cron    -                      -                      -
+worker  registry/worker:2.0.0  registry/worker:2.0.0  mirror/worker:2.0.0 *
+
+* differs from the previous environment
+`, "Table doesn't match")
+
+	markdown := new(bytes.Buffer)
+	test.MustSucceed(t, matrix.WriteMarkdown(markdown), "Failed writing Markdown")
+	test.AssertExpected(t, markdown.String(), `| App | dev | staging | prod |
+|-----|-----|-----|-----|
+| api | 1.3.0 | **1.2.0** | 1.2.0 |
+| cron | - | - | - |
+| worker | registry/worker:2.0.0 | registry/worker:2.0.0 | **mirror/worker:2.0.0** |
+
+Versions in **bold** differ from the previous environment.
+`, "Markdown doesn't match")
+
+	output := new(bytes.Buffer)
+	test.MustSucceed(t, matrix.WriteJSON(output), "Failed writing JSON")
+	test.AssertExpected(t, strings.Contains(output.String(), `"name": "staging"`), true, "JSON should list environments")
+	test.AssertExpected(t, strings.Contains(output.String(), `"differs": true`), true, "JSON should mark differences")
+}
@@ -0,0 +1,91 @@
+package history
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"text/tabwriter"
+
+	jsoniter "github.com/json-iterator/go"
+
+	"github.com/neosperience/shipper/templater"
+)
+
+// WriteText writes the entries as a timeline, newest first, marking rollbacks with "*"
+func WriteText(w io.Writer, entries []Entry) error {
+	columns := []templater.Image{}
+	if len(entries) > 0 {
+		columns = entries[0].Images
+	}
+
+	table := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprint(table, "DATE\tCOMMIT")
+	for _, image := range columns {
+		fmt.Fprintf(table, "\t%s", strings.ToUpper(image.Name))
+	}
+	fmt.Fprintln(table, "\tAUTHOR\tMESSAGE")
+
+	rollbacks := false
+	for _, entry := range entries {
+		commit := shortID(entry.Commit.ID)
+		if entry.Rollback {
+			commit += " *"
+			rollbacks = true
+		}
+		fmt.Fprintf(table, "%s\t%s", entry.Commit.Date.Format("2006-01-02 15:04"), commit)
+		for index := range columns {
+			fmt.Fprintf(table, "\t%s", cell(entries, index, entry))
+		}
+		fmt.Fprintf(table, "\t%s\t%s\n", entry.Commit.Author, subject(entry.Commit.Message))
+	}
+	if err := table.Flush(); err != nil {
+		return err
+	}
+
+	if rollbacks {
+		_, err := fmt.Fprintln(w, "\n* restores images deployed by an older commit")
+		return err
+	}
+	return nil
+}
+
+// WriteJSON writes the entries as JSON
+func WriteJSON(w io.Writer, entries []Entry) error {
+	byt, err := jsoniter.ConfigFastest.MarshalIndent(entries, "", "  ")
+	if err != nil {
+		return fmt.Errorf("error encoding history: %w", err)
+	}
+	_, err = w.Write(append(byt, '\n'))
+	return err
+}
+
+// cell formats the image at index in an entry. Tags are shown alone unless the image changed across entries.
+func cell(entries []Entry, index int, entry Entry) string {
+	if index >= len(entry.Images) {
+		return "-"
+	}
+
+	images := make(map[string]bool)
+	for _, other := range entries {
+		if index < len(other.Images) {
+			images[other.Images[index].Image] = true
+		}
+	}
+	image := entry.Images[index]
+	if len(images) > 1 && image.Image != "" {
+		return image.Image + ":" + image.Tag
+	}
+	return image.Tag
+}
+
+func shortID(id string) string {
+	if len(id) > 8 {
+		return id[:8]
+	}
+	return id
+}
+
+func subject(message string) string {
+	line, _, _ := strings.Cut(strings.TrimSpace(message), "\n")
+	return line
+}
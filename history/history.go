@@ -0,0 +1,129 @@
+package history
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+
+	"github.com/neosperience/shipper/logging"
+	"github.com/neosperience/shipper/targets"
+	"github.com/neosperience/shipper/templater"
+)
+
+var (
+	// ErrNoPrevious happens when the history has no value other than the current one
+	ErrNoPrevious = errors.New("no previous value in history")
+)
+
+// Lookup reads the deployed images from the files of a repository
+type Lookup func(repository targets.Repository) ([]templater.Image, error)
+
+// Entry is a commit that changed the deployed images, and the images it set
+type Entry struct {
+	Commit targets.Commit    `json:"commit"`
+	Images []templater.Image `json:"images"`
+	// Rollback is true if the images were already deployed by an older entry, as done by rollbacks
+	Rollback bool `json:"rollback"`
+}
+
+// Read returns the deployments in the history of files on ref, newest first. Up to limit commits are read
+// for each file, commits that didn't change the images (such as unrelated edits to the same files) are left
+// out so every entry is the commit that first set its images. Entries going back to images of older ones are
+// marked as rollbacks. Commits where the files are missing or don't set the images are skipped, other errors
+// are returned so a rollback can't pick images past a commit it couldn't read.
+func Read(repository targets.Repository, files []string, ref string, limit int, lookup Lookup) ([]Entry, error) {
+	commits := []targets.Commit{}
+	seen := make(map[string]bool)
+	for _, file := range files {
+		history, err := targets.History(repository, file, ref, limit)
+		if err != nil {
+			return nil, fmt.Errorf("error reading history of %s: %w", file, err)
+		}
+		for _, commit := range history {
+			if !seen[commit.ID] {
+				seen[commit.ID] = true
+				commits = append(commits, commit)
+			}
+		}
+	}
+	// Merge the history of all files, newest first
+	sort.SliceStable(commits, func(i, j int) bool {
+		return commits[i].Date.After(commits[j].Date)
+	})
+	if len(commits) > limit {
+		commits = commits[:limit]
+	}
+
+	entries := []Entry{}
+	for _, commit := range commits {
+		images, err := lookup(&revision{Repository: repository, commitID: commit.ID})
+		if errors.Is(err, targets.ErrFileNotFound) || errors.Is(err, templater.ErrImageNotFound) {
+			logging.Debug("images not deployed at commit, skipping", "commit", commit.ID, "error", err)
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("error reading images at commit %s: %w", commit.ID, err)
+		}
+
+		// Going back in time, a commit setting the same images as the newer one is the one that set them first
+		if last := len(entries) - 1; last >= 0 && Equal(entries[last].Images, images) {
+			entries[last] = Entry{Commit: commit, Images: images}
+			continue
+		}
+		entries = append(entries, Entry{Commit: commit, Images: images})
+	}
+
+	for index := range entries {
+		for _, older := range entries[index+1:] {
+			if Equal(entries[index].Images, older.Images) {
+				entries[index].Rollback = true
+				break
+			}
+		}
+	}
+	return entries, nil
+}
+
+// Previous returns the newest entry with images other than current. Entries undone by a rollback are skipped,
+// so rolling back twice goes further back instead of restoring the images the first rollback moved away from.
+func Previous(entries []Entry, current []templater.Image) (Entry, error) {
+	var restored []templater.Image
+	for _, entry := range entries {
+		if restored != nil {
+			if !Equal(entry.Images, restored) {
+				continue
+			}
+			restored = nil
+		}
+		if !Equal(entry.Images, current) {
+			return entry, nil
+		}
+		if entry.Rollback {
+			restored = entry.Images
+		}
+	}
+	return Entry{}, ErrNoPrevious
+}
+
+// Equal checks whether two lookups returned the same images
+func Equal(a []templater.Image, b []templater.Image) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for index := range a {
+		if a[index] != b[index] {
+			return false
+		}
+	}
+	return true
+}
+
+// revision is a repository reading files as they were at a commit, whatever the ref
+type revision struct {
+	targets.Repository
+	commitID string
+}
+
+func (r *revision) Get(path string, ref string) ([]byte, error) {
+	return targets.GetRevision(r.Repository, path, r.commitID)
+}
@@ -0,0 +1,177 @@
+package history
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/neosperience/shipper/targets"
+	"github.com/neosperience/shipper/templater"
+	json_templater "github.com/neosperience/shipper/templater/json"
+	"github.com/neosperience/shipper/test"
+)
+
+// historyRepository serves files as they were at each commit, commits are listed oldest first
+type historyRepository struct {
+	commits []targets.Commit
+	files   map[string]targets.FileList
+}
+
+func (r *historyRepository) Get(path string, ref string) ([]byte, error) {
+	return r.GetRevision(path, r.commits[len(r.commits)-1].ID)
+}
+
+func (r *historyRepository) Commit(payload *targets.CommitPayload) error {
+	return errors.New("read-only repository")
+}
+
+func (r *historyRepository) History(path string, ref string, limit int) ([]targets.Commit, error) {
+	commits := []targets.Commit{}
+	previous := []byte(nil)
+	for _, commit := range r.commits {
+		content := r.files[commit.ID][path]
+		if string(content) != string(previous) {
+			commits = append([]targets.Commit{commit}, commits...)
+		}
+		previous = content
+	}
+	if len(commits) > limit {
+		commits = commits[:limit]
+	}
+	return commits, nil
+}
+
+func (r *historyRepository) GetRevision(path string, commitID string) ([]byte, error) {
+	content, ok := r.files[commitID][path]
+	if !ok {
+		return nil, targets.ErrFileNotFound
+	}
+	return content, nil
+}
+
+func testRepository() *historyRepository {
+	repository := &historyRepository{files: make(map[string]targets.FileList)}
+	add := func(id string, files targets.FileList) {
+		repository.commits = append(repository.commits, targets.Commit{
+			ID:      id,
+			Author:  "Jane <jane@example.com>",
+			Date:    time.Date(2022, 2, len(repository.commits)+1, 9, 0, 0, 0, time.UTC),
+			Message: "Commit " + id,
+		})
+		repository.files[id] = files
+	}
+	add("c1", targets.FileList{"other.json": []byte(`{}`)})
+	add("c2", targets.FileList{"versions.json": []byte(`{"api": "1.1.0"}`)})
+	add("c3", targets.FileList{"versions.json": []byte(`{"api": "1.2.0"}`)})
+	// Reformatting the file doesn't change the version
+	add("c4", targets.FileList{"versions.json": []byte(`{"api": "1.2.0", "other": "value"}`)})
+	add("c5", targets.FileList{"versions.json": []byte(`{"api": "1.3.0", "other": "value"}`)})
+	return repository
+}
+
+func lookupAPI(repository targets.Repository) ([]templater.Image, error) {
+	return json_templater.GetJSONValues(repository, json_templater.JSONGetOptions{
+		Ref:     "main",
+		Lookups: []json_templater.JSONLookup{{File: "versions.json", Path: "api"}},
+	})
+}
+
+func TestRead(t *testing.T) {
+	entries, err := Read(testRepository(), []string{"versions.json"}, "main", 10, lookupAPI)
+	test.MustSucceed(t, err, "Failed reading history")
+	test.AssertExpected(t, len(entries), 3, "Commits not changing the version should be left out")
+	test.AssertExpected(t, entries[0].Commit.ID, "c5", "Entries should be newest first")
+	test.AssertExpected(t, entries[0].Images[0].Tag, "1.3.0", "Entry tag doesn't match")
+	test.AssertExpected(t, entries[1].Commit.ID, "c3", "Entries should be the commits first setting the version")
+	test.AssertExpected(t, entries[2].Images[0].Tag, "1.1.0", "Oldest entry tag doesn't match")
+
+	entries, err = Read(testRepository(), []string{"versions.json"}, "main", 2, lookupAPI)
+	test.MustSucceed(t, err, "Failed reading history")
+	test.AssertExpected(t, len(entries), 2, "History should be limited")
+	test.AssertExpected(t, entries[1].Commit.ID, "c4", "Only the commits read can be entries")
+
+	// Commits that can't be read fail the whole history
+	repository := testRepository()
+	repository.files["c3"] = targets.FileList{"versions.json": []byte(`{"api": `)}
+	_, err = Read(repository, []string{"versions.json"}, "main", 10, lookupAPI)
+	test.MustFail(t, err, "Reading a history with an invalid file supposed to fail but succeeded")
+
+	_, err = Read(targets.NewInMemoryRepository(targets.FileList{}), []string{"versions.json"}, "main", 10, lookupAPI)
+	test.AssertExpected(t, errors.Is(err, targets.ErrUnsupported), true, "Repositories without history should not be supported")
+}
+
+func TestPrevious(t *testing.T) {
+	repository := testRepository()
+	entries, err := Read(repository, []string{"versions.json"}, "main", 10, lookupAPI)
+	test.MustSucceed(t, err, "Failed reading history")
+	current, err := lookupAPI(repository)
+	test.MustSucceed(t, err, "Failed reading current images")
+
+	previous, err := Previous(entries, current)
+	test.MustSucceed(t, err, "Failed finding previous entry")
+	test.AssertExpected(t, previous.Commit.ID, "c3", "Previous entry doesn't match")
+	test.AssertExpected(t, previous.Images[0].Tag, "1.2.0", "Previous tag doesn't match")
+
+	_, err = Previous(entries[:1], current)
+	test.AssertExpected(t, errors.Is(err, ErrNoPrevious), true, "History without other values should have no previous entry")
+}
+
+func TestRollbackEntries(t *testing.T) {
+	repository := testRepository()
+	repository.commits = append(repository.commits, targets.Commit{
+		ID:      "c6",
+		Author:  "John <john@example.com>",
+		Date:    time.Date(2022, 2, 6, 9, 0, 0, 0, time.UTC),
+		Message: "Roll back api: 1.3.0 -> 1.2.0\n\nRestored from c3",
+	})
+	repository.files["c6"] = targets.FileList{"versions.json": []byte(`{"api": "1.2.0", "other": "value"}`)}
+
+	entries, err := Read(repository, []string{"versions.json"}, "main", 10, lookupAPI)
+	test.MustSucceed(t, err, "Failed reading history")
+	test.AssertExpected(t, len(entries), 4, "Rollbacks should be entries")
+	test.AssertExpected(t, entries[0].Rollback, true, "Going back to older images should be a rollback")
+	test.AssertExpected(t, entries[1].Rollback, false, "New images should not be a rollback")
+	test.AssertExpected(t, entries[2].Rollback, false, "Images rolled back to should not be a rollback")
+
+	// Rolling back again skips the images that were rolled back
+	current, err := lookupAPI(repository)
+	test.MustSucceed(t, err, "Failed reading current images")
+	previous, err := Previous(entries, current)
+	test.MustSucceed(t, err, "Failed finding previous entry")
+	test.AssertExpected(t, previous.Images[0].Tag, "1.1.0", "Rolled back images should be skipped")
+}
+
+func TestWrite(t *testing.T) {
+	entries := []Entry{
+		{
+			Commit:   targets.Commit{ID: "0123456789abcdef", Author: "John <john@example.com>", Date: time.Date(2022, 2, 6, 9, 0, 0, 0, time.UTC), Message: "Roll back\n\nRestored from c3"},
+			Images:   []templater.Image{{Name: "api", Image: "mirror/api", Tag: "1.2.0"}, {Name: "worker", Tag: "2.0.0"}},
+			Rollback: true,
+		},
+		{
+			Commit: targets.Commit{ID: "c5", Author: "Jane <jane@example.com>", Date: time.Date(2022, 2, 5, 9, 0, 0, 0, time.UTC), Message: "Deploy 1.3.0"},
+			Images: []templater.Image{{Name: "api", Image: "registry/api", Tag: "1.3.0"}, {Name: "worker", Tag: "2.0.0"}},
+		},
+		{
+			Commit: targets.Commit{ID: "c3", Author: "Jane <jane@example.com>", Date: time.Date(2022, 2, 3, 9, 0, 0, 0, time.UTC), Message: "Deploy 1.2.0"},
+			Images: []templater.Image{{Name: "api", Image: "mirror/api", Tag: "1.2.0"}},
+		},
+	}
+
+	text := new(bytes.Buffer)
+	test.MustSucceed(t, WriteText(text, entries), "Failed writing timeline")
+	test.AssertExpected(t, text.String(), `DATE              COMMIT      API                 WORKER  AUTHOR                   MESSAGE
+2022-02-06 09:00  01234567 *  mirror/api:1.2.0    2.0.0   John <john@example.com>  Roll back
+2022-02-05 09:00  c5          registry/api:1.3.0  2.0.0   Jane <jane@example.com>  Deploy 1.3.0
+2022-02-03 09:00  c3          mirror/api:1.2.0    -       Jane <jane@example.com>  Deploy 1.2.0
+
+* restores images deployed by an older commit
+`, "Timeline doesn't match")
+
+	output := new(bytes.Buffer)
+	test.MustSucceed(t, WriteJSON(output, entries), "Failed writing JSON")
+	test.AssertExpected(t, strings.Contains(output.String(), `"id": "0123456789abcdef"`), true, "JSON should list full commit IDs")
+	test.AssertExpected(t, strings.Contains(output.String(), `"rollback": true`), true, "JSON should mark rollbacks")
+}
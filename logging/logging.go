@@ -0,0 +1,286 @@
+package logging
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+
+	jsoniter "github.com/json-iterator/go"
+
+	"github.com/neosperience/shipper/common"
+)
+
+// Level is the severity of a log entry
+type Level int
+
+const (
+	LevelDebug Level = iota
+	LevelInfo
+	LevelWarn
+	LevelError
+)
+
+var levelNames = map[Level]string{
+	LevelDebug: "debug",
+	LevelInfo:  "info",
+	LevelWarn:  "warn",
+	LevelError: "error",
+}
+
+func (level Level) String() string {
+	return levelNames[level]
+}
+
+// Format is how log entries are written
+type Format int
+
+const (
+	// FormatText writes human readable lines, with fields as key=value pairs
+	FormatText Format = iota
+	// FormatJSON writes one JSON object per line
+	FormatJSON
+)
+
+var (
+	ErrInvalidLevel  = errors.New("invalid log level")
+	ErrInvalidFormat = errors.New("invalid log format")
+)
+
+// ParseLevel parses a level name ("debug", "info", "warn" or "error")
+func ParseLevel(name string) (Level, error) {
+	for level, levelName := range levelNames {
+		if strings.EqualFold(name, levelName) {
+			return level, nil
+		}
+	}
+	if strings.EqualFold(name, "warning") {
+		return LevelWarn, nil
+	}
+	return LevelInfo, fmt.Errorf("%w: %s", ErrInvalidLevel, name)
+}
+
+// ParseFormat parses a format name ("text" or "json")
+func ParseFormat(name string) (Format, error) {
+	switch strings.ToLower(name) {
+	case "text":
+		return FormatText, nil
+	case "json":
+		return FormatJSON, nil
+	}
+	return FormatText, fmt.Errorf("%w: %s", ErrInvalidFormat, name)
+}
+
+// Logger writes leveled log entries with structured fields. Secrets are redacted from everything it writes.
+type Logger struct {
+	output *output
+	level  Level
+	format Format
+
+	// Fields added to every entry, as alternating keys and values
+	fields []any
+}
+
+// output is shared between a logger and the loggers derived from it, so their lines don't interleave
+type output struct {
+	mutex  sync.Mutex
+	writer io.Writer
+	now    func() time.Time
+}
+
+// New creates a Logger writing entries of at least the given level to writer
+func New(writer io.Writer, level Level, format Format) *Logger {
+	return &Logger{
+		output: &output{writer: writer, now: time.Now},
+		level:  level,
+		format: format,
+	}
+}
+
+// With returns a logger adding fields, as alternating keys and values, to every entry
+func (l *Logger) With(keysAndValues ...any) *Logger {
+	fields := make([]any, 0, len(l.fields)+len(keysAndValues))
+	fields = append(fields, l.fields...)
+	fields = append(fields, keysAndValues...)
+	return &Logger{
+		output: l.output,
+		level:  l.level,
+		format: l.format,
+		fields: fields,
+	}
+}
+
+// Enabled checks if entries of a level are written
+func (l *Logger) Enabled(level Level) bool {
+	return level >= l.level
+}
+
+func (l *Logger) Debug(msg string, keysAndValues ...any) {
+	l.Log(LevelDebug, msg, keysAndValues...)
+}
+
+func (l *Logger) Info(msg string, keysAndValues ...any) {
+	l.Log(LevelInfo, msg, keysAndValues...)
+}
+
+func (l *Logger) Warn(msg string, keysAndValues ...any) {
+	l.Log(LevelWarn, msg, keysAndValues...)
+}
+
+func (l *Logger) Error(msg string, keysAndValues ...any) {
+	l.Log(LevelError, msg, keysAndValues...)
+}
+
+// Logf returns a printf-style function logging at level, for hooks such as common.DebugHTTP
+func (l *Logger) Logf(level Level) func(format string, v ...any) {
+	return func(format string, v ...any) {
+		l.Log(level, fmt.Sprintf(format, v...))
+	}
+}
+
+// Writer returns a writer logging each write as an entry at level, to redirect the standard log package
+func (l *Logger) Writer(level Level) io.Writer {
+	return writerFunc(func(p []byte) (int, error) {
+		l.Log(level, strings.TrimRight(string(p), "\n"))
+		return len(p), nil
+	})
+}
+
+type writerFunc func(p []byte) (int, error)
+
+func (f writerFunc) Write(p []byte) (int, error) {
+	return f(p)
+}
+
+// Log writes an entry with fields as alternating keys and values
+func (l *Logger) Log(level Level, msg string, keysAndValues ...any) {
+	if !l.Enabled(level) {
+		return
+	}
+
+	fields := make([]any, 0, len(l.fields)+len(keysAndValues))
+	fields = append(fields, l.fields...)
+	fields = append(fields, keysAndValues...)
+	if len(fields)%2 != 0 {
+		fields = append(fields, "(missing)")
+	}
+
+	l.output.mutex.Lock()
+	defer l.output.mutex.Unlock()
+
+	now := l.output.now()
+	var line string
+	if l.format == FormatJSON {
+		line = formatJSON(now, level, msg, fields)
+	} else {
+		line = formatText(now, level, msg, fields)
+	}
+	_, _ = io.WriteString(l.output.writer, common.Redact(line)+"\n")
+}
+
+func formatText(now time.Time, level Level, msg string, fields []any) string {
+	b := new(strings.Builder)
+	b.WriteString(now.Format("2006/01/02 15:04:05 "))
+	b.WriteString(strings.ToUpper(level.String()))
+	b.WriteString(" ")
+	b.WriteString(msg)
+	for index := 0; index < len(fields); index += 2 {
+		fmt.Fprintf(b, " %s=%s", fields[index], textValue(fields[index+1]))
+	}
+	return b.String()
+}
+
+// textValue formats a field value, quoting it if needed to keep the line parseable
+func textValue(value any) string {
+	var text string
+	switch value := value.(type) {
+	case error:
+		text = value.Error()
+	case fmt.Stringer:
+		text = value.String()
+	default:
+		text = fmt.Sprint(value)
+	}
+	if text == "" || strings.IndexFunc(text, func(r rune) bool {
+		return unicode.IsSpace(r) || r == '"' || r == '=' || !unicode.IsPrint(r)
+	}) >= 0 {
+		return strconv.Quote(text)
+	}
+	return text
+}
+
+func formatJSON(now time.Time, level Level, msg string, fields []any) string {
+	b := new(strings.Builder)
+	b.WriteString(`{"time":`)
+	writeJSON(b, now.Format(time.RFC3339Nano))
+	b.WriteString(`,"level":`)
+	writeJSON(b, level.String())
+	b.WriteString(`,"msg":`)
+	writeJSON(b, msg)
+	for index := 0; index < len(fields); index += 2 {
+		b.WriteString(",")
+		writeJSON(b, fmt.Sprint(fields[index]))
+		b.WriteString(":")
+		value := fields[index+1]
+		switch typed := value.(type) {
+		case error:
+			value = typed.Error()
+		case fmt.Stringer:
+			value = typed.String()
+		}
+		writeJSON(b, value)
+	}
+	b.WriteString("}")
+	return b.String()
+}
+
+// Log lines are not embedded in HTML, so characters such as < and > are kept as they are
+var jsonConfig = jsoniter.Config{SortMapKeys: true, ValidateJsonRawMessage: true}.Froze()
+
+func writeJSON(b *strings.Builder, value any) {
+	byt, err := jsonConfig.Marshal(value)
+	if err != nil {
+		byt, _ = jsonConfig.Marshal(fmt.Sprint(value))
+	}
+	b.Write(byt)
+}
+
+var defaultLogger = New(os.Stderr, LevelInfo, FormatText)
+
+// Default returns the logger used by the package-level functions
+func Default() *Logger {
+	return defaultLogger
+}
+
+// SetDefault replaces the logger used by the package-level functions
+func SetDefault(logger *Logger) {
+	defaultLogger = logger
+}
+
+func Debug(msg string, keysAndValues ...any) {
+	defaultLogger.Log(LevelDebug, msg, keysAndValues...)
+}
+
+func Info(msg string, keysAndValues ...any) {
+	defaultLogger.Log(LevelInfo, msg, keysAndValues...)
+}
+
+func Warn(msg string, keysAndValues ...any) {
+	defaultLogger.Log(LevelWarn, msg, keysAndValues...)
+}
+
+func Error(msg string, keysAndValues ...any) {
+	defaultLogger.Log(LevelError, msg, keysAndValues...)
+}
+
+// Logf returns a printf-style function logging at level with the default logger at the time of each call
+func Logf(level Level) func(format string, v ...any) {
+	return func(format string, v ...any) {
+		defaultLogger.Log(level, fmt.Sprintf(format, v...))
+	}
+}
@@ -0,0 +1,81 @@
+package logging
+
+import (
+	"bytes"
+	"errors"
+	"log"
+	"strings"
+	"testing"
+	"time"
+
+	jsoniter "github.com/json-iterator/go"
+	"github.com/neosperience/shipper/common"
+	"github.com/neosperience/shipper/test"
+)
+
+func testLogger(level Level, format Format) (*Logger, *bytes.Buffer) {
+	b := new(bytes.Buffer)
+	logger := New(b, level, format)
+	logger.output.now = func() time.Time {
+		return time.Date(2023, 5, 4, 10, 30, 0, 0, time.UTC)
+	}
+	return logger, b
+}
+
+func TestTextFormat(t *testing.T) {
+	logger, b := testLogger(LevelInfo, FormatText)
+	logger = logger.With("target", "gitlab", "branch", "main")
+
+	logger.Debug("hidden", "file", "values.yaml")
+	logger.Info("pushing changes", "file", "values.yaml", "message", "Update image", "empty", "")
+	logger.Warn("odd fields", "error", errors.New("failed"), "dangling")
+
+	test.AssertExpected(t, b.String(),
+		"2023/05/04 10:30:00 INFO pushing changes target=gitlab branch=main file=values.yaml message=\"Update image\" empty=\"\"\n"+
+			"2023/05/04 10:30:00 WARN odd fields target=gitlab branch=main error=failed dangling=(missing)\n",
+		"Text log doesn't match expected value")
+}
+
+func TestJSONFormat(t *testing.T) {
+	logger, b := testLogger(LevelDebug, FormatJSON)
+	logger.With("target", "github").Debug("commit created", "author", "Shipper <shipper@example.com>", "commit", "abc123", "files", 2, "error", errors.New("none"))
+
+	test.AssertExpected(t, b.String(),
+		`{"time":"2023-05-04T10:30:00Z","level":"debug","msg":"commit created","target":"github","author":"Shipper <shipper@example.com>","commit":"abc123","files":2,"error":"none"}`+"\n",
+		"JSON log doesn't match expected value")
+
+	var entry map[string]any
+	test.MustSucceed(t, jsoniter.ConfigFastest.Unmarshal(b.Bytes(), &entry), "JSON log is not valid JSON")
+}
+
+func TestRedaction(t *testing.T) {
+	common.RegisterSecret("logged-secret")
+	logger, b := testLogger(LevelInfo, FormatText)
+
+	logger.Info("token is logged-secret", "key", "logged-secret")
+	if strings.Contains(b.String(), "logged-secret") {
+		t.Fatalf("Secret was not redacted: %s", b.String())
+	}
+}
+
+func TestStandardLogRedirect(t *testing.T) {
+	logger, b := testLogger(LevelInfo, FormatJSON)
+	std := log.New(logger.Writer(LevelWarn), "", 0)
+	std.Printf("legacy %s", "message")
+
+	test.AssertExpected(t, b.String(), `{"time":"2023-05-04T10:30:00Z","level":"warn","msg":"legacy message"}`+"\n", "Redirected log doesn't match expected value")
+}
+
+func TestParse(t *testing.T) {
+	level, err := ParseLevel("WARNING")
+	test.MustSucceed(t, err, "Failed parsing level")
+	test.AssertExpected(t, level, LevelWarn, "Parsed level doesn't match expected value")
+	_, err = ParseLevel("verbose")
+	test.MustFail(t, err, "Parsing invalid level supposed to fail but succeeded")
+
+	format, err := ParseFormat("json")
+	test.MustSucceed(t, err, "Failed parsing format")
+	test.AssertExpected(t, format, FormatJSON, "Parsed format doesn't match expected value")
+	_, err = ParseFormat("xml")
+	test.MustFail(t, err, "Parsing invalid format supposed to fail but succeeded")
+}
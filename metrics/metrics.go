@@ -0,0 +1,294 @@
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// DefaultBuckets are histogram buckets, in seconds, suited to API calls and commits
+var DefaultBuckets = []float64{0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60}
+
+// Label is a metric label
+type Label struct {
+	Name  string
+	Value string
+}
+
+// ParseLabels parses labels in the key=value format
+func ParseLabels(pairs []string) ([]Label, error) {
+	labels := make([]Label, 0, len(pairs))
+	for _, pair := range pairs {
+		name, value, ok := strings.Cut(pair, "=")
+		name = strings.TrimSpace(name)
+		if !ok || name == "" {
+			return nil, fmt.Errorf("invalid label %q, expected key=value", pair)
+		}
+		labels = append(labels, Label{Name: name, Value: strings.TrimSpace(value)})
+	}
+	return labels, nil
+}
+
+type kind string
+
+const (
+	kindCounter   kind = "counter"
+	kindHistogram kind = "histogram"
+)
+
+// Registry holds metric families and writes them in the Prometheus text or OpenMetrics formats
+type Registry struct {
+	mutex    sync.Mutex
+	families []*family
+}
+
+type family struct {
+	name       string
+	help       string
+	kind       kind
+	labelNames []string
+	buckets    []float64
+
+	// Series by label values, in order of creation
+	series []*series
+}
+
+type series struct {
+	labelValues []string
+
+	// Counter value, or sum of observations for histograms
+	value float64
+
+	// Histogram observations, per bucket and in total
+	bucketCounts []uint64
+	count        uint64
+}
+
+// NewRegistry creates an empty Registry
+func NewRegistry() *Registry {
+	return &Registry{}
+}
+
+func (r *Registry) register(f *family) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.families = append(r.families, f)
+}
+
+// get returns the series with the given label values, creating it if needed. Must be called with the lock held.
+func (f *family) get(labelValues []string) *series {
+	if len(labelValues) != len(f.labelNames) {
+		panic(fmt.Sprintf("metric %s expects %d label values, got %d", f.name, len(f.labelNames), len(labelValues)))
+	}
+	for _, s := range f.series {
+		if equal(s.labelValues, labelValues) {
+			return s
+		}
+	}
+	s := &series{labelValues: append([]string{}, labelValues...)}
+	if f.kind == kindHistogram {
+		s.bucketCounts = make([]uint64, len(f.buckets))
+	}
+	f.series = append(f.series, s)
+	return s
+}
+
+func equal(a []string, b []string) bool {
+	for index := range a {
+		if a[index] != b[index] {
+			return false
+		}
+	}
+	return true
+}
+
+// Counter is a monotonically increasing metric, partitioned by labels
+type Counter struct {
+	registry *Registry
+	family   *family
+}
+
+// NewCounter registers a counter. name should not end in _total, the suffix is added when writing samples.
+func (r *Registry) NewCounter(name string, help string, labelNames ...string) *Counter {
+	f := &family{name: name, help: help, kind: kindCounter, labelNames: labelNames}
+	r.register(f)
+	return &Counter{registry: r, family: f}
+}
+
+// Add adds value to the counter with the given label values
+func (c *Counter) Add(value float64, labelValues ...string) {
+	c.registry.mutex.Lock()
+	defer c.registry.mutex.Unlock()
+	c.family.get(labelValues).value += value
+}
+
+// Inc increments the counter with the given label values
+func (c *Counter) Inc(labelValues ...string) {
+	c.Add(1, labelValues...)
+}
+
+// Histogram counts observations in buckets, partitioned by labels
+type Histogram struct {
+	registry *Registry
+	family   *family
+}
+
+// NewHistogram registers a histogram with the given bucket upper bounds, in increasing order
+func (r *Registry) NewHistogram(name string, help string, buckets []float64, labelNames ...string) *Histogram {
+	f := &family{name: name, help: help, kind: kindHistogram, labelNames: labelNames, buckets: buckets}
+	r.register(f)
+	return &Histogram{registry: r, family: f}
+}
+
+// Observe records a value in the histogram with the given label values
+func (h *Histogram) Observe(value float64, labelValues ...string) {
+	h.registry.mutex.Lock()
+	defer h.registry.mutex.Unlock()
+	s := h.family.get(labelValues)
+	for index, bound := range h.family.buckets {
+		if value <= bound {
+			s.bucketCounts[index]++
+		}
+	}
+	s.value += value
+	s.count++
+}
+
+// WriteText writes metrics in the Prometheus text format (version 0.0.4), adding labels to every sample
+func (r *Registry) WriteText(w io.Writer, labels ...Label) error {
+	return r.write(w, false, labels)
+}
+
+// WriteOpenMetrics writes metrics in the OpenMetrics text format, adding labels to every sample
+func (r *Registry) WriteOpenMetrics(w io.Writer, labels ...Label) error {
+	return r.write(w, true, labels)
+}
+
+// WriteFile writes metrics to a file in the OpenMetrics text format, adding labels to every sample
+func (r *Registry) WriteFile(path string, labels ...Label) error {
+	file, err := os.Create(path)
+	if err != nil {
+		return fmt.Errorf("error creating metrics file: %w", err)
+	}
+	if err := r.WriteOpenMetrics(file, labels...); err != nil {
+		file.Close()
+		return fmt.Errorf("error writing metrics file: %w", err)
+	}
+	return file.Close()
+}
+
+func (r *Registry) write(w io.Writer, openMetrics bool, labels []Label) error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	b := bufio.NewWriter(w)
+	for _, f := range r.families {
+		if len(f.series) == 0 {
+			continue
+		}
+
+		// Unlike the Prometheus format, OpenMetrics names counter families without the _total suffix
+		name := f.name
+		if f.kind == kindCounter && !openMetrics {
+			name += "_total"
+		}
+		fmt.Fprintf(b, "# HELP %s %s\n", name, escapeHelp(f.help))
+		fmt.Fprintf(b, "# TYPE %s %s\n", name, f.kind)
+
+		// Labels of the family take precedence over the added ones with the same name
+		added := make([]Label, 0, len(labels))
+		for _, label := range labels {
+			if !contains(f.labelNames, label.Name) {
+				added = append(added, label)
+			}
+		}
+
+		for _, s := range f.series {
+			sampleLabels := make([]Label, 0, len(added)+len(f.labelNames)+1)
+			sampleLabels = append(sampleLabels, added...)
+			for index, labelName := range f.labelNames {
+				sampleLabels = append(sampleLabels, Label{Name: labelName, Value: s.labelValues[index]})
+			}
+
+			switch f.kind {
+			case kindCounter:
+				writeSample(b, f.name+"_total", sampleLabels, s.value)
+			case kindHistogram:
+				for index, bound := range f.buckets {
+					writeSample(b, f.name+"_bucket", append(sampleLabels, Label{Name: "le", Value: formatFloat(bound)}), float64(s.bucketCounts[index]))
+				}
+				writeSample(b, f.name+"_bucket", append(sampleLabels, Label{Name: "le", Value: "+Inf"}), float64(s.count))
+				writeSample(b, f.name+"_sum", sampleLabels, s.value)
+				writeSample(b, f.name+"_count", sampleLabels, float64(s.count))
+			}
+		}
+	}
+	if openMetrics {
+		b.WriteString("# EOF\n")
+	}
+	return b.Flush()
+}
+
+func writeSample(b *bufio.Writer, name string, labels []Label, value float64) {
+	b.WriteString(name)
+	if len(labels) > 0 {
+		b.WriteString("{")
+		for index, label := range labels {
+			if index > 0 {
+				b.WriteString(",")
+			}
+			fmt.Fprintf(b, "%s=\"%s\"", label.Name, escapeLabel(label.Value))
+		}
+		b.WriteString("}")
+	}
+	b.WriteString(" ")
+	b.WriteString(formatFloat(value))
+	b.WriteString("\n")
+}
+
+func formatFloat(value float64) string {
+	switch {
+	case math.IsInf(value, 1):
+		return "+Inf"
+	case math.IsInf(value, -1):
+		return "-Inf"
+	}
+	return strconv.FormatFloat(value, 'g', -1, 64)
+}
+
+var (
+	helpEscaper  = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+	labelEscaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`)
+)
+
+func escapeHelp(help string) string {
+	return helpEscaper.Replace(help)
+}
+
+func escapeLabel(value string) string {
+	return labelEscaper.Replace(value)
+}
+
+func contains(names []string, name string) bool {
+	for _, other := range names {
+		if other == name {
+			return true
+		}
+	}
+	return false
+}
+
+// sortedLabels returns labels sorted by name
+func sortedLabels(labels []Label) []Label {
+	sorted := append([]Label{}, labels...)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return sorted[i].Name < sorted[j].Name
+	})
+	return sorted
+}
@@ -0,0 +1,149 @@
+package metrics
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/neosperience/shipper/common"
+	"github.com/neosperience/shipper/test"
+)
+
+func TestWriteFormats(t *testing.T) {
+	registry := NewRegistry()
+	counter := registry.NewCounter("jobs", "Jobs run", "outcome")
+	histogram := registry.NewHistogram("job_duration_seconds", "Job duration\nin seconds", []float64{1, 5}, "queue")
+	registry.NewCounter("unused", "Never incremented")
+
+	counter.Inc("success")
+	counter.Add(2, "success")
+	counter.Inc(`quoted "value"`)
+	histogram.Observe(0.5, "default")
+	histogram.Observe(3, "default")
+	histogram.Observe(10, "default")
+
+	b := new(strings.Builder)
+	test.MustSucceed(t, registry.WriteText(b, Label{Name: "job", Value: "shipper"}), "Failed writing text format")
+	test.AssertExpected(t, b.String(), `# HELP jobs_total Jobs run
+# TYPE jobs_total counter
+jobs_total{job="shipper",outcome="success"} 3
+jobs_total{job="shipper",outcome="quoted \"value\""} 1
+# HELP job_duration_seconds Job duration\nin seconds
+# TYPE job_duration_seconds histogram
+job_duration_seconds_bucket{job="shipper",queue="default",le="1"} 1
+job_duration_seconds_bucket{job="shipper",queue="default",le="5"} 2
+job_duration_seconds_bucket{job="shipper",queue="default",le="+Inf"} 3
+job_duration_seconds_sum{job="shipper",queue="default"} 13.5
+job_duration_seconds_count{job="shipper",queue="default"} 3
+`, "Text format doesn't match")
+
+	b.Reset()
+	test.MustSucceed(t, registry.WriteOpenMetrics(b), "Failed writing OpenMetrics format")
+	output := b.String()
+	test.AssertExpected(t, strings.HasPrefix(output, "# HELP jobs Jobs run\n# TYPE jobs counter\njobs_total{outcome=\"success\"} 3\n"), true, "OpenMetrics counter doesn't match")
+	test.AssertExpected(t, strings.HasSuffix(output, "# EOF\n"), true, "OpenMetrics output is not terminated")
+}
+
+func TestWriteFile(t *testing.T) {
+	metrics := NewMetrics()
+	metrics.RecordDeployment("gitlab", "production", "api", OutcomeSuccess)
+
+	// Added labels named like labels of the metric are not repeated
+	path := filepath.Join(t.TempDir(), "metrics.txt")
+	test.MustSucceed(t, metrics.WriteFile(path, Label{Name: "team", Value: "core"}, Label{Name: "app", Value: "api"}), "Failed writing metrics file")
+	byt, err := os.ReadFile(path)
+	test.MustSucceed(t, err, "Failed reading metrics file")
+	test.AssertExpected(t, strings.Contains(string(byt), `shipper_deployments_total{team="core",target="gitlab",environment="production",app="api",outcome="success"} 1`), true, "Deployment not found in metrics file")
+}
+
+func TestParseLabels(t *testing.T) {
+	labels, err := ParseLabels([]string{"app=api", " env = prod"})
+	test.MustSucceed(t, err, "Failed parsing labels")
+	test.AssertExpected(t, len(labels), 2, "Unexpected number of labels")
+	test.AssertExpected(t, labels[1], Label{Name: "env", Value: "prod"}, "Label doesn't match")
+
+	_, err = ParseLabels([]string{"invalid"})
+	test.MustFail(t, err, "Parsing invalid label succeeded")
+	_, err = ParseLabels([]string{"=value"})
+	test.MustFail(t, err, "Parsing label without name succeeded")
+}
+
+func TestPush(t *testing.T) {
+	var method, path, contentType, body string
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		method = req.Method
+		path = req.URL.EscapedPath()
+		contentType = req.Header.Get("Content-Type")
+		byt, _ := ioutil.ReadAll(req.Body)
+		body = string(byt)
+	}))
+	defer server.Close()
+
+	metrics := NewMetrics()
+	metrics.RecordDeployment("github", "", "", OutcomeFailure)
+	err := metrics.Push(server.Client(), server.URL+"/", "shipper", Label{Name: "env", Value: "prod"}, Label{Name: "app", Value: "org/api"}, Label{Name: "team", Value: ""})
+	test.MustSucceed(t, err, "Failed pushing metrics")
+	test.AssertExpected(t, method, "PUT", "Push method doesn't match")
+	test.AssertExpected(t, path, "/metrics/job/shipper/app@base64/b3JnL2FwaQ/env/prod/team@base64/=", "Grouping key doesn't match")
+	test.AssertExpected(t, contentType, "text/plain; version=0.0.4", "Content type doesn't match")
+	test.AssertExpected(t, strings.Contains(body, `shipper_deployments_total{target="github",environment="",app="",outcome="failure"} 1`), true, "Deployment not found in pushed metrics")
+	test.AssertExpected(t, strings.Contains(body, "# EOF"), false, "Pushed metrics are not in the text format")
+
+	test.MustFail(t, metrics.Push(server.Client(), server.URL, ""), "Pushing without job succeeded")
+}
+
+func TestPushFaultyServer(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		http.Error(rw, "invalid metrics", http.StatusBadRequest)
+	}))
+	defer server.Close()
+
+	test.MustFail(t, NewMetrics().Push(server.Client(), server.URL, "shipper"), "Pushing to faulty server succeeded")
+}
+
+func TestHTTPMetrics(t *testing.T) {
+	attempts := 0
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		attempts++
+		if attempts == 1 {
+			http.Error(rw, "unavailable", http.StatusServiceUnavailable)
+		}
+	}))
+	defer server.Close()
+
+	metrics := NewMetrics()
+	client := &http.Client{Transport: common.Chain(server.Client().Transport,
+		common.RetryNotify(3, time.Millisecond, metrics.RetryNotifier("gitlab")),
+		metrics.Middleware("gitlab"),
+	)}
+	_, err := common.HTTPRequest(client, "GET", server.URL, nil, nil)
+	test.MustSucceed(t, err, "Retried request failed")
+	metrics.ObserveCommit("gitlab", 2*time.Second)
+
+	b := new(strings.Builder)
+	test.MustSucceed(t, metrics.WriteText(b), "Failed writing metrics")
+	output := b.String()
+	for _, sample := range []string{
+		`shipper_http_requests_total{provider="gitlab",method="GET",code="503"} 1`,
+		`shipper_http_requests_total{provider="gitlab",method="GET",code="200"} 1`,
+		`shipper_http_request_duration_seconds_count{provider="gitlab"} 2`,
+		`shipper_http_retries_total{provider="gitlab"} 1`,
+		`shipper_commit_duration_seconds_bucket{target="gitlab",le="2.5"} 1`,
+	} {
+		test.AssertExpected(t, strings.Contains(output, sample), true, "Sample not found: "+sample)
+	}
+}
+
+func TestNilMetrics(t *testing.T) {
+	var metrics *Metrics
+	metrics.RecordDeployment("gitlab", "", "", OutcomeSuccess)
+	metrics.ObserveCommit("gitlab", time.Second)
+	test.AssertExpected(t, metrics.RetryNotifier("gitlab") == nil, true, "Nil metrics returned a retry notifier")
+	transport := metrics.Middleware("gitlab")(http.DefaultTransport)
+	test.AssertExpected(t, transport == http.DefaultTransport, true, "Nil metrics wrapped the transport")
+}
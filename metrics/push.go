@@ -0,0 +1,50 @@
+package metrics
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/neosperience/shipper/common"
+)
+
+// Push replaces the metrics of a group in a Prometheus Pushgateway. The group is identified by job and
+// grouping labels, which the Pushgateway adds to every pushed sample. If client is nil, a default HTTP client is used.
+func (r *Registry) Push(client *http.Client, gateway string, job string, grouping ...Label) error {
+	if job == "" {
+		return fmt.Errorf("job name is required to push metrics")
+	}
+
+	b := new(bytes.Buffer)
+	if err := r.WriteText(b); err != nil {
+		return fmt.Errorf("error encoding metrics: %w", err)
+	}
+
+	uri := strings.TrimSuffix(gateway, "/") + "/metrics/" + groupingPath("job", job)
+	for _, label := range sortedLabels(grouping) {
+		uri += "/" + groupingPath(label.Name, label.Value)
+	}
+
+	res, err := common.HTTPRequest(client, "PUT", uri, b, http.Header{
+		"Content-Type": []string{"text/plain; version=0.0.4"},
+	})
+	if err != nil {
+		return fmt.Errorf("error pushing metrics: %w", err)
+	}
+	return res.Body.Close()
+}
+
+// groupingPath encodes a label of the grouping key as a URL path, using base64 for values
+// that can't be in a path segment, as specified by the Pushgateway API
+func groupingPath(name string, value string) string {
+	if value == "" {
+		return name + "@base64/="
+	}
+	if strings.Contains(value, "/") {
+		return name + "@base64/" + base64.RawURLEncoding.EncodeToString([]byte(value))
+	}
+	return name + "/" + url.PathEscape(value)
+}
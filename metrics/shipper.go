@@ -0,0 +1,90 @@
+package metrics
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/neosperience/shipper/common"
+)
+
+// Deployment outcomes
+const (
+	OutcomeSuccess   = "success"
+	OutcomeNoChanges = "no_changes"
+	OutcomeFailure   = "failure"
+)
+
+// Metrics are the metrics shipper records about a run.
+// All methods can be called on a nil Metrics, which doesn't record anything.
+type Metrics struct {
+	*Registry
+
+	deployments         *Counter
+	commitDuration      *Histogram
+	httpRequests        *Counter
+	httpRequestDuration *Histogram
+	httpRetries         *Counter
+}
+
+// NewMetrics creates a registry with the metrics recorded by shipper
+func NewMetrics() *Metrics {
+	registry := NewRegistry()
+	return &Metrics{
+		Registry: registry,
+		deployments: registry.NewCounter("shipper_deployments",
+			"Deployments by target, environment, app and outcome", "target", "environment", "app", "outcome"),
+		commitDuration: registry.NewHistogram("shipper_commit_duration_seconds",
+			"Time taken to commit changes to the repository", DefaultBuckets, "target"),
+		httpRequests: registry.NewCounter("shipper_http_requests",
+			"HTTP requests by provider, method and status code (\"error\" if no response was received)", "provider", "method", "code"),
+		httpRequestDuration: registry.NewHistogram("shipper_http_request_duration_seconds",
+			"Duration of HTTP requests by provider", DefaultBuckets, "provider"),
+		httpRetries: registry.NewCounter("shipper_http_retries",
+			"HTTP requests retried after network errors, rate limiting or temporary server errors", "provider"),
+	}
+}
+
+// RecordDeployment counts a deployment of app to an environment of target with its outcome,
+// environment and app are empty if unknown
+func (m *Metrics) RecordDeployment(target string, environment string, app string, outcome string) {
+	if m == nil {
+		return
+	}
+	m.deployments.Inc(target, environment, app, outcome)
+}
+
+// ObserveCommit records how long a commit to target took
+func (m *Metrics) ObserveCommit(target string, duration time.Duration) {
+	if m == nil {
+		return
+	}
+	m.commitDuration.Observe(duration.Seconds(), target)
+}
+
+// Middleware counts HTTP requests made to provider, and how long they took
+func (m *Metrics) Middleware(provider string) common.Middleware {
+	if m == nil {
+		return func(next http.RoundTripper) http.RoundTripper {
+			return next
+		}
+	}
+	return common.Observe(func(req *http.Request, res *http.Response, err error, duration time.Duration) {
+		code := "error"
+		if err == nil {
+			code = strconv.Itoa(res.StatusCode)
+		}
+		m.httpRequests.Inc(provider, req.Method, code)
+		m.httpRequestDuration.Observe(duration.Seconds(), provider)
+	})
+}
+
+// RetryNotifier returns a common.RetryNotify callback counting retries of requests to provider
+func (m *Metrics) RetryNotifier(provider string) func(req *http.Request, res *http.Response, err error, attempt int) {
+	if m == nil {
+		return nil
+	}
+	return func(req *http.Request, res *http.Response, err error, attempt int) {
+		m.httpRetries.Inc(provider)
+	}
+}
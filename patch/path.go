@@ -7,6 +7,7 @@ import (
 
 var (
 	ErrInvalidYAMLStructure = errors.New("found a value while traversing a tree")
+	ErrPathNotFound         = errors.New("path not found")
 )
 
 func SetPath(root map[string]any, path string, value any) error {
@@ -30,3 +31,27 @@ func SetPath(root map[string]any, path string, value any) error {
 	data[tail] = value
 	return nil
 }
+
+// GetPath returns the value at a dot-separated path, the counterpart of SetPath
+func GetPath(root map[string]any, path string) (any, error) {
+	pieces := strings.Split(path, ".")
+	data := root
+	head, tail := pieces[:len(pieces)-1], pieces[len(pieces)-1]
+
+	for _, piece := range head {
+		switch v := data[piece].(type) {
+		case map[string]any:
+			data = v
+		case nil:
+			return nil, ErrPathNotFound
+		default:
+			return nil, ErrInvalidYAMLStructure
+		}
+	}
+
+	value, ok := data[tail]
+	if !ok {
+		return nil, ErrPathNotFound
+	}
+	return value, nil
+}
@@ -1,6 +1,7 @@
 package patch
 
 import (
+	"errors"
 	"testing"
 
 	"github.com/neosperience/shipper/test"
@@ -74,6 +75,35 @@ func TestSetPathInvalid(t *testing.T) {
 	}
 }
 
+func TestGetPath(t *testing.T) {
+	asMap := make(map[string]any)
+	err := yaml.Unmarshal([]byte("image:\n  repository: registry/app\n  tag: 1.2\nreplicas: 2\n"), asMap)
+	test.MustSucceed(t, err, "YAML decoding failed")
+
+	value, err := GetPath(asMap, "image.repository")
+	test.MustSucceed(t, err, "Failed to get nested value")
+	test.AssertExpected(t, value.(string), "registry/app", "image.repository value is different than expected")
+
+	value, err = GetPath(asMap, "replicas")
+	test.MustSucceed(t, err, "Failed to get top-level value")
+	test.AssertExpected(t, value.(int), 2, "replicas value is different than expected")
+
+	// Values set by SetPath can be read back
+	test.MustSucceed(t, SetPath(asMap, "sidecar.image.tag", "v3"), "Failed to set value")
+	value, err = GetPath(asMap, "sidecar.image.tag")
+	test.MustSucceed(t, err, "Failed to get value set by SetPath")
+	test.AssertExpected(t, value.(string), "v3", "sidecar.image.tag value is different than expected")
+
+	_, err = GetPath(asMap, "image.digest")
+	test.AssertExpected(t, errors.Is(err, ErrPathNotFound), true, "Missing value should not be found")
+
+	_, err = GetPath(asMap, "missing.tag")
+	test.AssertExpected(t, errors.Is(err, ErrPathNotFound), true, "Missing tree should not be found")
+
+	_, err = GetPath(asMap, "replicas.count")
+	test.AssertExpected(t, errors.Is(err, ErrInvalidYAMLStructure), true, "Traversing a value should fail")
+}
+
 func BenchmarkSetPath(b *testing.B) {
 	asMap := make(map[string]any)
 	err := SetPath(asMap, "nested.value", "new-value")
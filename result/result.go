@@ -0,0 +1,213 @@
+package result
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+
+	jsoniter "github.com/json-iterator/go"
+
+	"github.com/neosperience/shipper/common"
+)
+
+// Code is the exit code of shipper
+type Code int
+
+const (
+	// CodeSuccess means the changes were committed, or there were none to commit
+	CodeSuccess Code = 0
+	// CodeError is any failure not covered by other codes
+	CodeError Code = 1
+	// CodeValidation means options or input files are invalid
+	CodeValidation Code = 2
+	// CodeAuth means the credentials were missing, invalid or lacked the needed permissions
+	CodeAuth Code = 3
+	// CodeConflict means the repository changed concurrently, retrying the deployment may succeed
+	CodeConflict Code = 4
+	// CodeNetwork means a service could not be reached or was unavailable
+	CodeNetwork Code = 5
+	// CodePolicy means the change was refused, such as by branch protections or server hooks
+	CodePolicy Code = 6
+	// CodeNoChanges means there was nothing to commit, only used when asked for detailed exit codes
+	CodeNoChanges Code = 7
+)
+
+var categories = map[Code]string{
+	CodeSuccess:    "success",
+	CodeError:      "error",
+	CodeValidation: "validation",
+	CodeAuth:       "auth",
+	CodeConflict:   "conflict",
+	CodeNetwork:    "network",
+	CodePolicy:     "policy",
+	CodeNoChanges:  "no_changes",
+}
+
+// Category returns the name of the kind of outcome the code stands for
+func (code Code) Category() string {
+	return categories[code]
+}
+
+// Error is an error with the exit code it should cause
+type Error struct {
+	Code Code
+	Err  error
+}
+
+// WithCode wraps err so it causes the given exit code
+func WithCode(code Code, err error) error {
+	return &Error{Code: code, Err: err}
+}
+
+func (e *Error) Error() string {
+	return e.Err.Error()
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// Categories of errors marked with common.Categorize, with the exit code they cause. Conflicts come first,
+// as a concurrent update can also be reported as a refused change.
+var knownErrors = []struct {
+	err  error
+	code Code
+}{
+	{common.ErrConflict, CodeConflict},
+	{common.ErrPolicy, CodePolicy},
+	{common.ErrAuth, CodeAuth},
+	{common.ErrValidation, CodeValidation},
+}
+
+// CodeOf returns the exit code an error should cause
+func CodeOf(err error) Code {
+	if err == nil {
+		return CodeSuccess
+	}
+
+	var coded *Error
+	if errors.As(err, &coded) {
+		return coded.Code
+	}
+
+	for _, known := range knownErrors {
+		if errors.Is(err, known.err) {
+			return known.code
+		}
+	}
+
+	var httpErr *common.HTTPError
+	if errors.As(err, &httpErr) {
+		switch httpErr.StatusCode {
+		case http.StatusUnauthorized, http.StatusForbidden, http.StatusProxyAuthRequired:
+			return CodeAuth
+		case http.StatusConflict, http.StatusPreconditionFailed:
+			return CodeConflict
+		case http.StatusTooManyRequests, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+			return CodeNetwork
+		}
+		return CodeError
+	}
+
+	// Local files can't be read, their system errors would otherwise pass for network ones
+	var pathErr *fs.PathError
+	if errors.As(err, &pathErr) {
+		return CodeError
+	}
+
+	// Requests that got no response at all
+	var urlErr *url.Error
+	var netErr net.Error
+	if errors.As(err, &urlErr) || errors.As(err, &netErr) {
+		return CodeNetwork
+	}
+
+	return CodeError
+}
+
+// Status is the outcome of a run
+type Status string
+
+const (
+	StatusChanged   Status = "changed"
+	StatusNoChanges Status = "no_changes"
+	StatusFailed    Status = "failed"
+	// StatusOK is the outcome of commands that only read from the repository
+	StatusOK Status = "ok"
+)
+
+// Result describes the outcome of a run, for pipelines to act on
+type Result struct {
+	Status     Status   `json:"status"`
+	ExitCode   Code     `json:"exit_code"`
+	Command    string   `json:"command,omitempty"`
+	Templater  string   `json:"templater,omitempty"`
+	Target     string   `json:"target,omitempty"`
+	Repository string   `json:"repository,omitempty"`
+	Branch     string   `json:"branch,omitempty"`
+	Files      []string `json:"files"`
+	// Changes lists the images updated by commands reporting them
+	Changes []Change `json:"changes,omitempty"`
+
+	// Error is only set if the run failed
+	Error *ErrorDetails `json:"error,omitempty"`
+}
+
+// Change is an image updated by the run, from and to are "image:tag" or just the tag if the file has no image.
+// Changes checked for downgrades only have tags, and the decision taken.
+type Change struct {
+	File     string `json:"file"`
+	Name     string `json:"name"`
+	From     string `json:"from"`
+	To       string `json:"to"`
+	Decision string `json:"decision,omitempty"`
+}
+
+// ErrorDetails describes why a run failed
+type ErrorDetails struct {
+	Category string `json:"category"`
+	Message  string `json:"message"`
+}
+
+// Finish sets the outcome of the run from the error it ended with and returns the exit code.
+// Runs without changes exit with CodeNoChanges only if detailed is true.
+func (r *Result) Finish(err error, detailed bool) Code {
+	if r.Files == nil {
+		r.Files = []string{}
+	}
+
+	code := CodeOf(err)
+	switch {
+	case err != nil:
+		r.Status = StatusFailed
+		r.Error = &ErrorDetails{
+			Category: code.Category(),
+			Message:  common.Redact(err.Error()),
+		}
+	case r.Status == StatusNoChanges:
+		if detailed {
+			code = CodeNoChanges
+		}
+	case r.Status == "":
+		r.Status = StatusChanged
+	}
+	r.ExitCode = code
+	return code
+}
+
+// WriteFile writes the result as JSON to a file
+func (r *Result) WriteFile(path string) error {
+	byt, err := jsoniter.ConfigFastest.MarshalIndent(r, "", "  ")
+	if err != nil {
+		return fmt.Errorf("error encoding result: %w", err)
+	}
+	err = os.WriteFile(path, append(byt, '\n'), 0644)
+	if err != nil {
+		return fmt.Errorf("error writing result file: %w", err)
+	}
+	return nil
+}
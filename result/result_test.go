@@ -0,0 +1,117 @@
+package result
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	jsoniter "github.com/json-iterator/go"
+
+	"github.com/neosperience/shipper/common"
+	"github.com/neosperience/shipper/credentials"
+	"github.com/neosperience/shipper/targets"
+	filesystem_target "github.com/neosperience/shipper/targets/filesystem"
+	git_target "github.com/neosperience/shipper/targets/git"
+	"github.com/neosperience/shipper/templater"
+	"github.com/neosperience/shipper/test"
+)
+
+func TestCodeOf(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		switch req.URL.Path {
+		case "/unauthorized":
+			http.Error(rw, "unauthorized", http.StatusUnauthorized)
+		case "/forbidden":
+			http.Error(rw, "forbidden", http.StatusForbidden)
+		case "/conflict":
+			http.Error(rw, "conflict", http.StatusConflict)
+		case "/unavailable":
+			http.Error(rw, "unavailable", http.StatusServiceUnavailable)
+		default:
+			http.Error(rw, "bad request", http.StatusBadRequest)
+		}
+	}))
+	defer server.Close()
+
+	request := func(path string) error {
+		_, err := common.HTTPRequest(server.Client(), "GET", server.URL+path, nil, nil)
+		return fmt.Errorf("error performing GET: %w", err)
+	}
+	_, missingFile := os.ReadFile(filepath.Join(t.TempDir(), "missing.yaml"))
+	_, unreachable := common.HTTPRequest(&http.Client{Timeout: time.Millisecond}, "GET", "http://0.0.0.0", nil, nil)
+
+	tests := []struct {
+		name string
+		err  error
+		code Code
+	}{
+		{"nil", nil, CodeSuccess},
+		{"generic", errors.New("failed"), CodeError},
+		{"explicit", WithCode(CodeValidation, errors.New("invalid option")), CodeValidation},
+		{"wrapped explicit", fmt.Errorf("wrapped: %w", WithCode(CodePolicy, errors.New("refused"))), CodePolicy},
+		{"unauthorized", request("/unauthorized"), CodeAuth},
+		{"forbidden", request("/forbidden"), CodeAuth},
+		{"conflict", request("/conflict"), CodeConflict},
+		{"unavailable", request("/unavailable"), CodeNetwork},
+		{"bad request", request("/bad"), CodeError},
+		{"unreachable", unreachable, CodeNetwork},
+		{"missing local file", fmt.Errorf("error reading configuration: %w", missingFile), CodeError},
+		{"branch moved", fmt.Errorf("%w: refs/heads/main fetch first", git_target.ErrBranchMoved), CodeConflict},
+		{"push rejected", fmt.Errorf("%w: refs/heads/main pre-receive hook declined", git_target.ErrPushRejected), CodePolicy},
+		{"downgrade refused", fmt.Errorf("%w: api in versions.json from 1.3.0 to 1.2.0", templater.ErrDowngrade), CodePolicy},
+		{"outside root", fmt.Errorf("%w: ../values.yaml", filesystem_target.ErrPathOutsideRoot), CodeValidation},
+		{"no credentials", fmt.Errorf("error loading --gitlab-key: %w", credentials.ErrNoCredentials), CodeAuth},
+		{"categorized", common.Categorize(common.ErrConflict, errors.New("changed")), CodeConflict},
+		{"file not found", targets.ErrFileNotFound, CodeError},
+	}
+	for _, tt := range tests {
+		test.AssertExpected(t, CodeOf(tt.err), tt.code, "Exit code doesn't match for "+tt.name+" error")
+	}
+}
+
+func TestFinish(t *testing.T) {
+	result := &Result{Target: "gitlab", Files: []string{"values.yaml"}}
+	test.AssertExpected(t, result.Finish(nil, true), CodeSuccess, "Exit code of a successful run doesn't match")
+	test.AssertExpected(t, result.Status, StatusChanged, "Status of a successful run doesn't match")
+
+	result = &Result{Status: StatusNoChanges}
+	test.AssertExpected(t, result.Finish(nil, false), CodeSuccess, "Runs without changes should succeed by default")
+	result = &Result{Status: StatusNoChanges}
+	test.AssertExpected(t, result.Finish(nil, true), CodeNoChanges, "Exit code of a run without changes doesn't match")
+	test.AssertExpected(t, result.Status, StatusNoChanges, "Status of a run without changes doesn't match")
+	result = &Result{Status: StatusOK}
+	test.AssertExpected(t, result.Finish(nil, true), CodeSuccess, "Exit code of a read-only run doesn't match")
+	test.AssertExpected(t, result.Status, StatusOK, "Status of a read-only run doesn't match")
+
+	common.RegisterSecret("result-secret")
+	result = &Result{}
+	code := result.Finish(WithCode(CodeAuth, errors.New("invalid token result-secret")), false)
+	test.AssertExpected(t, code, CodeAuth, "Exit code of a failed run doesn't match")
+	test.AssertExpected(t, result.Status, StatusFailed, "Status of a failed run doesn't match")
+	test.AssertExpected(t, result.Error.Category, "auth", "Error category doesn't match")
+	test.AssertExpected(t, result.Error.Message, "invalid token [REDACTED]", "Secret was not redacted from the error")
+}
+
+func TestWriteFile(t *testing.T) {
+	result := &Result{Target: "github", Repository: "org/repo", Branch: "main"}
+	result.Finish(WithCode(CodeConflict, errors.New("branch moved")), false)
+
+	path := filepath.Join(t.TempDir(), "result.json")
+	test.MustSucceed(t, result.WriteFile(path), "Failed writing result file")
+	byt, err := os.ReadFile(path)
+	test.MustSucceed(t, err, "Failed reading result file")
+
+	var decoded map[string]any
+	test.MustSucceed(t, jsoniter.ConfigFastest.Unmarshal(byt, &decoded), "Result file is not valid JSON")
+	test.AssertExpected(t, fmt.Sprint(decoded["status"]), "failed", "Status doesn't match")
+	test.AssertExpected(t, fmt.Sprint(decoded["exit_code"]), "4", "Exit code doesn't match")
+	test.AssertExpected(t, fmt.Sprint(decoded["repository"]), "org/repo", "Repository doesn't match")
+	test.AssertExpected(t, fmt.Sprint(decoded["error"].(map[string]any)["category"]), "conflict", "Error category doesn't match")
+
+	test.MustFail(t, result.WriteFile(filepath.Join(t.TempDir(), "missing", "result.json")), "Writing to a missing directory succeeded")
+}
@@ -6,54 +6,111 @@ import (
 	"fmt"
 	"io"
 	"io/ioutil"
-	"log"
 	"net/http"
 	"net/url"
+	"regexp"
 	"strings"
+	"sync"
 	"time"
 	"unicode/utf8"
 
 	jsoniter "github.com/json-iterator/go"
 
 	"github.com/neosperience/shipper/common"
+	"github.com/neosperience/shipper/logging"
 	"github.com/neosperience/shipper/targets"
 )
 
-// AzureRepository commits to an Azure DevOps Services Git repository using the Azure Git REST APIs
+const (
+	// DefaultEndpoint is the base URL for Azure DevOps Services
+	DefaultEndpoint = "https://dev.azure.com"
+
+	// defaultAPIVersion is the API version used when not specified, if the server doesn't support it a lower one is negotiated
+	defaultAPIVersion = "6.0"
+)
+
+// Matches errors such as "The requested REST API version of 6.0 is out of range for this server. The latest REST API version this server supports is 4.1."
+var versionOutOfRangeRegex = regexp.MustCompile(`VssVersionOutOfRangeException|out of range for this server`)
+var latestVersionRegex = regexp.MustCompile(`latest REST API version this server supports is ([0-9]+\.[0-9]+)`)
+
+// AzureRepository commits to an Azure DevOps Services or Azure DevOps Server (TFS) Git repository using the Azure Git REST APIs
 type AzureRepository struct {
 	baseURI      string
 	projectID    string
 	repositoryID string
-	credentials  string
 
-	client *http.Client
+	// API version, negotiated by the first successful request if not set
+	versionMutex sync.Mutex
+	apiVersion   string
+
+	// If set, authenticate as an Entra ID service principal instead of using credentials
+	entra *entraAuth
+
+	client *common.Client
 }
 
-// NewAPIClient creates a AzureRepository instance
-func NewAPIClient(projectID string, repositoryID string, credentials string) *AzureRepository {
-	var client = &http.Client{}
+// NewAPIClient creates a AzureRepository instance. uri is the instance URL (DefaultEndpoint for Azure DevOps Services)
+// and projectID the collection (or organization) and project in "collection/project" format. If client is nil, a default
+// HTTP client is used.
+func NewAPIClient(uri string, projectID string, repositoryID string, credentials string, client *http.Client) *AzureRepository {
 	return &AzureRepository{
-		baseURI:      "https://dev.azure.com/",
+		baseURI:      strings.TrimSuffix(uri, "/"),
 		projectID:    projectID,
 		repositoryID: repositoryID,
-		credentials:  credentials,
-		client:       client,
+		client:       common.NewClient(client, common.BasicAuth(credentials)),
 	}
 }
 
-func (azure *AzureRepository) doRequest(method string, requestURI string, body io.Reader, headers http.Header) (*http.Response, error) {
-	// Add authentication headers
-	if headers == nil {
-		headers = make(http.Header)
+// SetAPIVersion forces the REST API version to use, instead of negotiating it with the server
+func (azure *AzureRepository) SetAPIVersion(version string) {
+	azure.versionMutex.Lock()
+	defer azure.versionMutex.Unlock()
+	azure.apiVersion = version
+}
+
+// negotiatedAPIVersion returns the API version to use, or "" if it was not settled yet
+func (azure *AzureRepository) negotiatedAPIVersion() string {
+	azure.versionMutex.Lock()
+	defer azure.versionMutex.Unlock()
+	return azure.apiVersion
+}
+
+// doAPIRequest performs a request adding the api-version parameter. If no version was set, the default one is tried
+// first and, if the server doesn't support it, the request is retried with the latest version the server reports.
+// Only body-less requests can be retried, so the version must be settled by a GET before any other request.
+func (azure *AzureRepository) doAPIRequest(method string, requestURI string, body io.Reader, headers http.Header) (*http.Response, error) {
+	negotiated := azure.negotiatedAPIVersion()
+	version := negotiated
+	if version == "" {
+		version = defaultAPIVersion
 	}
-	headers.Add("Authorization", "Basic "+base64.StdEncoding.EncodeToString([]byte(azure.credentials)))
 
-	return common.HTTPRequest(azure.client, method, requestURI, body, headers)
+	res, err := azure.client.Request(method, withAPIVersion(requestURI, version), body, headers)
+	if err != nil && negotiated == "" && body == nil && versionOutOfRangeRegex.MatchString(err.Error()) {
+		match := latestVersionRegex.FindStringSubmatch(err.Error())
+		if match == nil {
+			return res, fmt.Errorf("could not negotiate API version: %w", err)
+		}
+		version = match[1]
+		res, err = azure.client.Request(method, withAPIVersion(requestURI, version), nil, headers)
+	}
+	if err == nil && negotiated == "" {
+		azure.SetAPIVersion(version)
+	}
+	return res, err
+}
+
+func withAPIVersion(requestURI string, version string) string {
+	separator := "?"
+	if strings.Contains(requestURI, "?") {
+		separator = "&"
+	}
+	return requestURI + separator + "api-version=" + url.QueryEscape(version)
 }
 
 func (azure *AzureRepository) Get(path string, ref string) ([]byte, error) {
-	requestURI := fmt.Sprintf("%s/%s/_apis/git/repositories/%s/items?path=%s&version=%s&api-version=6.0", azure.baseURI, azure.projectID, azure.repositoryID, url.QueryEscape(path), url.QueryEscape(ref))
-	res, err := azure.doRequest("GET", requestURI, nil, nil)
+	requestURI := fmt.Sprintf("%s/%s/_apis/git/repositories/%s/items?path=%s&version=%s", azure.baseURI, azure.projectID, azure.repositoryID, url.QueryEscape(path), url.QueryEscape(ref))
+	res, err := azure.doAPIRequest("GET", requestURI, nil, nil)
 	if err != nil {
 		return nil, fmt.Errorf("error performing GET /items: %w", err)
 	}
@@ -63,8 +120,8 @@ func (azure *AzureRepository) Get(path string, ref string) ([]byte, error) {
 }
 
 func (azure *AzureRepository) headRef(ref string) (string, error) {
-	requestURI := fmt.Sprintf("%s/%s/_apis/git/repositories/%s/refs?filter=heads/%s&$top=1&api-version=6.0", azure.baseURI, azure.projectID, azure.repositoryID, url.QueryEscape(ref))
-	res, err := azure.doRequest("GET", requestURI, nil, nil)
+	requestURI := fmt.Sprintf("%s/%s/_apis/git/repositories/%s/refs?filter=heads/%s&$top=1", azure.baseURI, azure.projectID, azure.repositoryID, url.QueryEscape(ref))
+	res, err := azure.doAPIRequest("GET", requestURI, nil, nil)
 	if err != nil {
 		return "", fmt.Errorf("error performing GET /refs: %w", err)
 	}
@@ -83,6 +140,47 @@ func (azure *AzureRepository) headRef(ref string) (string, error) {
 	return refs.Value[0].ObjectID, nil
 }
 
+func (azure *AzureRepository) ResolveCommit(ref string) (string, error) {
+	return azure.headRef(ref)
+}
+
+func (azure *AzureRepository) History(path string, ref string, limit int) ([]targets.Commit, error) {
+	requestURI := fmt.Sprintf("%s/%s/_apis/git/repositories/%s/commits?searchCriteria.itemVersion.version=%s&searchCriteria.itemPath=%s&searchCriteria.$top=%d", azure.baseURI, azure.projectID, azure.repositoryID, url.QueryEscape(ref), url.QueryEscape(path), limit)
+	res, err := azure.doAPIRequest("GET", requestURI, nil, nil)
+	if err != nil {
+		return nil, fmt.Errorf("error performing GET /commits: %w", err)
+	}
+	defer res.Body.Close()
+
+	var list commitList
+	err = jsoniter.ConfigFastest.NewDecoder(res.Body).Decode(&list)
+	if err != nil {
+		return nil, fmt.Errorf("error decoding response: %w", err)
+	}
+
+	commits := make([]targets.Commit, 0, len(list.Value))
+	for _, commit := range list.Value {
+		commits = append(commits, targets.Commit{
+			ID:      commit.CommitID,
+			Author:  fmt.Sprintf("%s <%s>", commit.Author.Name, commit.Author.Email),
+			Date:    commit.Committer.Date,
+			Message: commit.Comment,
+		})
+	}
+	return commits, nil
+}
+
+func (azure *AzureRepository) GetRevision(path string, commitID string) ([]byte, error) {
+	requestURI := fmt.Sprintf("%s/%s/_apis/git/repositories/%s/items?path=%s&versionDescriptor.version=%s&versionDescriptor.versionType=commit", azure.baseURI, azure.projectID, azure.repositoryID, url.QueryEscape(path), url.QueryEscape(commitID))
+	res, err := azure.doAPIRequest("GET", requestURI, nil, nil)
+	if err != nil {
+		return nil, fmt.Errorf("error performing GET /items: %w", err)
+	}
+	defer res.Body.Close()
+
+	return ioutil.ReadAll(res.Body)
+}
+
 func (azure *AzureRepository) Commit(payload *targets.CommitPayload) error {
 	ref, err := azure.headRef(payload.Branch)
 	if err != nil {
@@ -116,8 +214,8 @@ func (azure *AzureRepository) Commit(payload *targets.CommitPayload) error {
 		return fmt.Errorf("error encoding request payload: %w", err)
 	}
 
-	requestURI := fmt.Sprintf("%s/%s/_apis/git/repositories/%s/pushes?api-version=6.0", azure.baseURI, azure.projectID, azure.repositoryID)
-	res, err := azure.doRequest("POST", requestURI, b, http.Header{
+	requestURI := fmt.Sprintf("%s/%s/_apis/git/repositories/%s/pushes", azure.baseURI, azure.projectID, azure.repositoryID)
+	res, err := azure.doAPIRequest("POST", requestURI, b, http.Header{
 		"Content-Type": []string{"application/json"},
 	})
 	if err != nil {
@@ -135,7 +233,8 @@ func (azure *AzureRepository) Commit(payload *targets.CommitPayload) error {
 		return fmt.Errorf("no commits returned")
 	}
 
-	log.Printf("Commit URL: %s/commit/%s", response.Repository.WebURL, response.Commits[0].CommitID)
+	commitID := response.Commits[0].CommitID
+	logging.Info("commit created", "commit", commitID, "url", fmt.Sprintf("%s/commit/%s", response.Repository.WebURL, commitID))
 	return nil
 }
 
@@ -75,9 +75,7 @@ func TestCommit(t *testing.T) {
 		})
 	}))
 	defer server.Close()
-	target := NewAPIClient("test-url", "test-project", fmt.Sprintf("%s:%s", testUser, testKey))
-	target.baseURI = server.URL
-	target.client = server.Client()
+	target := NewAPIClient(server.URL, "test-org/test-project", "test-repository", fmt.Sprintf("%s:%s", testUser, testKey), server.Client())
 
 	test.MustSucceed(t, target.Commit(push), "Failed to commit")
 }
@@ -102,9 +100,7 @@ func TestGet(t *testing.T) {
 		test.MustSucceed(t, err, "Failed to write test data")
 	}))
 	defer server.Close()
-	target := NewAPIClient("test-project", "test-repository", fmt.Sprintf("%s:%s", testUser, testKey))
-	target.baseURI = server.URL
-	target.client = server.Client()
+	target := NewAPIClient(server.URL, "test-org/test-project", "test-repository", fmt.Sprintf("%s:%s", testUser, testKey), server.Client())
 
 	byt, err := target.Get(testPath, "main")
 	test.MustSucceed(t, err, "Failed to get file")
@@ -113,6 +109,70 @@ func TestGet(t *testing.T) {
 	}
 }
 
+func TestResolveCommit(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		test.AssertExpected(t, req.URL.Path, "/test-org/test-project/_apis/git/repositories/test-repository/refs", "Request path doesn't match")
+		if req.URL.Query().Get("filter") != "heads/main" {
+			_ = jsoniter.ConfigFastest.NewEncoder(rw).Encode(refList{})
+			return
+		}
+		_ = jsoniter.ConfigFastest.NewEncoder(rw).Encode(refList{
+			Value: []azureRef{{Name: "refs/heads/main", ObjectID: "test-object-id"}},
+			Count: 1,
+		})
+	}))
+	defer server.Close()
+	target := NewAPIClient(server.URL, "test-org/test-project", "test-repository", "user:key", server.Client())
+
+	commitID, err := target.ResolveCommit("main")
+	test.MustSucceed(t, err, "Failed resolving branch")
+	test.AssertExpected(t, commitID, "test-object-id", "Resolved commit doesn't match")
+
+	_, err = target.ResolveCommit("missing")
+	test.MustFail(t, err, "Resolving a missing branch succeeded")
+}
+
+func TestHistory(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		query := req.URL.Query()
+		switch req.URL.Path {
+		case "/test-org/test-project/_apis/git/repositories/test-repository/commits":
+			test.AssertExpected(t, query.Get("searchCriteria.itemPath"), "values.yaml", "Requested path doesn't match")
+			test.AssertExpected(t, query.Get("searchCriteria.$top"), "10", "Requested limit doesn't match")
+			if query.Get("searchCriteria.itemVersion.version") != "main" {
+				http.Error(rw, "branch not found", http.StatusNotFound)
+				return
+			}
+			_ = jsoniter.ConfigFastest.NewEncoder(rw).Encode(commitList{
+				Value: []commit{
+					{CommitID: "second", Comment: "Update api to 1.3.0", Author: commitAuthor{Name: "Jane", Email: "jane@example.com"}, Committer: commitAuthor{Date: time.Date(2022, 2, 3, 9, 0, 0, 0, time.UTC)}},
+					{CommitID: "first", Comment: "Update api to 1.2.0", Author: commitAuthor{Name: "John", Email: "john@example.com"}, Committer: commitAuthor{Date: time.Date(2022, 2, 1, 9, 0, 0, 0, time.UTC)}},
+				},
+				Count: 2,
+			})
+		case "/test-org/test-project/_apis/git/repositories/test-repository/items":
+			test.AssertExpected(t, query.Get("versionDescriptor.versionType"), "commit", "Revision should be requested by commit")
+			_, _ = rw.Write([]byte("content at " + query.Get("versionDescriptor.version")))
+		default:
+			http.Error(rw, "not found", http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+	target := NewAPIClient(server.URL, "test-org/test-project", "test-repository", "user:key", server.Client())
+
+	commits, err := target.History("values.yaml", "main", 10)
+	test.MustSucceed(t, err, "Failed retrieving history")
+	test.AssertExpected(t, len(commits), 2, "Unexpected number of commits")
+	test.AssertExpected(t, commits[0], targets.Commit{ID: "second", Author: "Jane <jane@example.com>", Date: time.Date(2022, 2, 3, 9, 0, 0, 0, time.UTC), Message: "Update api to 1.3.0"}, "Commit doesn't match")
+
+	_, err = target.History("values.yaml", "missing", 10)
+	test.MustFail(t, err, "Retrieving history of a missing branch succeeded")
+
+	content, err := target.GetRevision("values.yaml", "first")
+	test.MustSucceed(t, err, "Failed retrieving revision")
+	test.AssertExpected(t, string(content), "content at first", "Revision content doesn't match")
+}
+
 func TestFaultyServer(t *testing.T) {
 	// Mock server that just errors out
 	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
@@ -122,8 +182,7 @@ func TestFaultyServer(t *testing.T) {
 	payload := targets.NewPayload("test-branch", "test-author <author@example.com>", "Hello")
 
 	// Test with faulty server
-	target := NewAPIClient(server.URL, "test-project", "unused")
-	target.client = server.Client()
+	target := NewAPIClient(server.URL, "test-org/test-project", "test-repository", "unused", server.Client())
 
 	_, err := target.Get("test", "main")
 	test.MustFail(t, err, "Request supposed to error out but Get call exited successfully")
@@ -132,9 +191,9 @@ func TestFaultyServer(t *testing.T) {
 	test.MustFail(t, err, "Request supposed to error out but Commit call exited successfully")
 
 	// Test with unreachable target
-	target = NewAPIClient("http://0.0.0.0", "test-project", "unused")
-	target.client = server.Client()
-	target.client.Timeout = time.Millisecond // Set a low timeout since we don't want this to work anyway
+	client := server.Client()
+	client.Timeout = time.Millisecond // Set a low timeout since we don't want this to work anyway
+	target = NewAPIClient("http://0.0.0.0", "test-org/test-project", "test-repository", "unused", client)
 
 	_, err = target.Get("test", "main")
 	test.MustFail(t, err, "Request supposed to error out but Get call exited successfully")
@@ -157,9 +216,7 @@ func TestFaultyCommit(t *testing.T) {
 	}))
 	defer server.Close()
 
-	target := NewAPIClient("test-url", "test-project", "test-user@org.tld:test-key")
-	target.baseURI = server.URL
-	target.client = server.Client()
+	target := NewAPIClient(server.URL, "test-org/test-project", "test-repository", "test-user@org.tld:test-key", server.Client())
 
 	push := targets.NewPayload("test-branch", "test-author <author@example.com>", "Hello")
 	test.MustSucceed(t, push.Files.Add(map[string][]byte{
@@ -167,3 +224,39 @@ func TestFaultyCommit(t *testing.T) {
 	}), "Failed adding test files")
 	test.MustFail(t, target.Commit(push), "Commit supposed to fail for missing ref but succeeded")
 }
+
+func TestAPIVersionNegotiation(t *testing.T) {
+	testData := []byte("hello test here")
+	requests := 0
+
+	// Mock an on-premises server (TFS 2018) that supports up to API version 4.1, in a collection
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		requests += 1
+		test.AssertExpected(t, req.URL.Path, "/tfs/DefaultCollection/test-project/_apis/git/repositories/test-repository/items", "Request path doesn't match expected value")
+		if req.URL.Query().Get("api-version") != "4.1" {
+			http.Error(rw, `{"message":"The requested REST API version of 6.0 is out of range for this server. The latest REST API version this server supports is 4.1.","typeKey":"VssVersionOutOfRangeException"}`, http.StatusBadRequest)
+			return
+		}
+		_, err := rw.Write(testData)
+		test.MustSucceed(t, err, "Failed to write test data")
+	}))
+	defer server.Close()
+	target := NewAPIClient(server.URL+"/tfs", "DefaultCollection/test-project", "test-repository", "test-user:test-key", server.Client())
+
+	byt, err := target.Get("path/to/file", "main")
+	test.MustSucceed(t, err, "Failed to get file")
+	if !bytes.Equal(byt, testData) {
+		t.Fatal("Expected file content is different from retrieved")
+	}
+	test.AssertExpected(t, target.apiVersion, "4.1", "Negotiated API version doesn't match expected value")
+
+	// Subsequent requests must use the negotiated version straight away
+	_, err = target.Get("path/to/file", "main")
+	test.MustSucceed(t, err, "Failed to get file")
+	test.AssertExpected(t, requests, 3, "Unexpected number of requests")
+
+	// Forced versions are never negotiated
+	target.SetAPIVersion("6.0")
+	_, err = target.Get("path/to/file", "main")
+	test.MustFail(t, err, "Get with unsupported forced API version supposed to fail but succeeded")
+}
@@ -38,6 +38,11 @@ type commit struct {
 	URL       string         `json:"url,omitempty"`
 }
 
+type commitList struct {
+	Value []commit `json:"value"`
+	Count int      `json:"count"`
+}
+
 type pushData struct {
 	RefUpdates []pushRef `json:"refUpdates"`
 	Commits    []commit  `json:"commits"`
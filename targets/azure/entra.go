@@ -0,0 +1,197 @@
+package azure_target
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha1"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	jsoniter "github.com/json-iterator/go"
+
+	"github.com/neosperience/shipper/common"
+)
+
+const (
+	// DefaultAuthorityHost is the Microsoft Entra ID endpoint for the global Azure cloud
+	DefaultAuthorityHost = "https://login.microsoftonline.com"
+
+	// Scope for Azure DevOps, using the well-known Azure DevOps application ID
+	azureDevOpsScope = "499b84ac-1321-427f-aa17-267ca6975798/.default"
+
+	// Access tokens are refreshed this long before they expire
+	tokenRefreshMargin = 5 * time.Minute
+	// Lifetime of the client assertions signed with the certificate
+	assertionLifetime = 10 * time.Minute
+)
+
+var (
+	ErrNoClientCredentials = common.Categorize(common.ErrValidation, errors.New("either a client secret or a client certificate must be specified"))
+	ErrInvalidCertificate  = common.Categorize(common.ErrValidation, errors.New("invalid client certificate"))
+)
+
+// ServicePrincipal holds the credentials of a Microsoft Entra ID application used to authenticate with Azure DevOps.
+// Either ClientSecret or Certificate (a PEM bundle with both the certificate and its private key) must be set.
+type ServicePrincipal struct {
+	TenantID     string
+	ClientID     string
+	ClientSecret string
+	Certificate  []byte
+
+	// TokenEndpoint overrides the OAuth2 token endpoint, by default it's the tenant's endpoint on DefaultAuthorityHost
+	TokenEndpoint string
+}
+
+// entraAuth obtains and caches Azure DevOps access tokens using the OAuth2 client credentials flow
+type entraAuth struct {
+	clientID      string
+	clientSecret  string
+	tokenEndpoint string
+
+	// Certificate credentials, used when clientSecret is empty
+	privateKey *rsa.PrivateKey
+	thumbprint string
+
+	mutex     sync.Mutex
+	token     string
+	expiresAt time.Time
+}
+
+// NewServicePrincipalClient creates a AzureRepository instance authenticating as an Entra ID service principal
+func NewServicePrincipalClient(uri string, projectID string, repositoryID string, principal ServicePrincipal, client *http.Client) (*AzureRepository, error) {
+	auth := &entraAuth{
+		clientID:      principal.ClientID,
+		clientSecret:  principal.ClientSecret,
+		tokenEndpoint: principal.TokenEndpoint,
+	}
+	if auth.tokenEndpoint == "" {
+		auth.tokenEndpoint = fmt.Sprintf("%s/%s/oauth2/v2.0/token", DefaultAuthorityHost, url.PathEscape(principal.TenantID))
+	}
+
+	if auth.clientSecret == "" {
+		if len(principal.Certificate) == 0 {
+			return nil, ErrNoClientCredentials
+		}
+		key, err := common.ParseRSAPrivateKey(principal.Certificate)
+		if err != nil {
+			return nil, fmt.Errorf("error parsing client certificate key: %w", err)
+		}
+		thumbprint, err := certificateThumbprint(principal.Certificate)
+		if err != nil {
+			return nil, err
+		}
+		auth.privateKey = key
+		auth.thumbprint = thumbprint
+	}
+
+	repository := NewAPIClient(uri, projectID, repositoryID, "", client)
+	repository.entra = auth
+	repository.client = common.NewClient(client, common.AuthenticatorFunc(repository.authenticate))
+	return repository, nil
+}
+
+// authenticate authenticates requests with the service principal's access token
+func (azure *AzureRepository) authenticate(req *http.Request) error {
+	token, err := azure.accessToken()
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	return nil
+}
+
+// certificateThumbprint returns the base64url-encoded SHA-1 hash of the first certificate in a PEM bundle
+func certificateThumbprint(data []byte) (string, error) {
+	for {
+		var block *pem.Block
+		block, data = pem.Decode(data)
+		if block == nil {
+			return "", fmt.Errorf("%w: no PEM-encoded certificate found", ErrInvalidCertificate)
+		}
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+		if _, err := x509.ParseCertificate(block.Bytes); err != nil {
+			return "", fmt.Errorf("%w: %s", ErrInvalidCertificate, err.Error())
+		}
+		hash := sha1.Sum(block.Bytes)
+		return base64.RawURLEncoding.EncodeToString(hash[:]), nil
+	}
+}
+
+// clientAssertion creates a JWT signed with the certificate's private key to authenticate the client
+func (auth *entraAuth) clientAssertion(now time.Time) (string, error) {
+	nonce := make([]byte, 16)
+	if _, err := rand.Read(nonce); err != nil {
+		return "", fmt.Errorf("error generating assertion ID: %w", err)
+	}
+	return common.SignJWT(map[string]any{
+		"x5t": auth.thumbprint,
+	}, map[string]any{
+		"aud": auth.tokenEndpoint,
+		"iss": auth.clientID,
+		"sub": auth.clientID,
+		"jti": hex.EncodeToString(nonce),
+		"nbf": now.Unix(),
+		"iat": now.Unix(),
+		"exp": now.Add(assertionLifetime).Unix(),
+	}, auth.privateKey)
+}
+
+// accessToken returns a valid access token, requesting a new one if the cached one is about to expire
+func (azure *AzureRepository) accessToken() (string, error) {
+	auth := azure.entra
+	auth.mutex.Lock()
+	defer auth.mutex.Unlock()
+
+	now := time.Now()
+	if auth.token != "" && now.Add(tokenRefreshMargin).Before(auth.expiresAt) {
+		return auth.token, nil
+	}
+
+	form := url.Values{
+		"grant_type": {"client_credentials"},
+		"client_id":  {auth.clientID},
+		"scope":      {azureDevOpsScope},
+	}
+	if auth.clientSecret != "" {
+		form.Set("client_secret", auth.clientSecret)
+	} else {
+		assertion, err := auth.clientAssertion(now)
+		if err != nil {
+			return "", err
+		}
+		form.Set("client_assertion_type", "urn:ietf:params:oauth:client-assertion-type:jwt-bearer")
+		form.Set("client_assertion", assertion)
+	}
+
+	res, err := common.HTTPRequest(azure.client.HTTPClient(), "POST", auth.tokenEndpoint, strings.NewReader(form.Encode()), http.Header{
+		"Content-Type": {"application/x-www-form-urlencoded"},
+	})
+	if err != nil {
+		return "", fmt.Errorf("error getting access token: %w", err)
+	}
+	defer res.Body.Close()
+
+	var token struct {
+		AccessToken string `json:"access_token"`
+		ExpiresIn   int64  `json:"expires_in"`
+	}
+	err = jsoniter.ConfigFastest.NewDecoder(res.Body).Decode(&token)
+	if err != nil {
+		return "", fmt.Errorf("error decoding access token: %w", err)
+	}
+
+	auth.token = token.AccessToken
+	auth.expiresAt = now.Add(time.Duration(token.ExpiresIn) * time.Second)
+	return auth.token, nil
+}
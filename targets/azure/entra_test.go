@@ -0,0 +1,136 @@
+package azure_target
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/pem"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	jsoniter "github.com/json-iterator/go"
+	"github.com/neosperience/shipper/test"
+)
+
+// testCertificate creates a self-signed certificate, returning a PEM bundle with the certificate and its key
+func testCertificate(t *testing.T) ([]byte, *x509.Certificate) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	test.MustSucceed(t, err, "Failed generating test key")
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "shipper-test"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	test.MustSucceed(t, err, "Failed creating test certificate")
+	certificate, err := x509.ParseCertificate(der)
+	test.MustSucceed(t, err, "Failed parsing test certificate")
+
+	bundle := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	bundle = append(bundle, pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)})...)
+	return bundle, certificate
+}
+
+// verifyAssertion checks that a client assertion is signed by the certificate and addressed to the token endpoint
+func verifyAssertion(t *testing.T, assertion string, certificate *x509.Certificate, clientID string, audience string) {
+	parts := strings.Split(assertion, ".")
+	test.AssertExpected(t, len(parts), 3, "Client assertion doesn't have three parts")
+
+	var header map[string]string
+	byt, err := base64.RawURLEncoding.DecodeString(parts[0])
+	test.MustSucceed(t, err, "Failed decoding assertion header")
+	test.MustSucceed(t, jsoniter.ConfigFastest.Unmarshal(byt, &header), "Failed decoding assertion header")
+	thumbprint := sha1.Sum(certificate.Raw)
+	test.AssertExpected(t, header["x5t"], base64.RawURLEncoding.EncodeToString(thumbprint[:]), "Assertion thumbprint doesn't match certificate")
+
+	var claims struct {
+		Audience string `json:"aud"`
+		Issuer   string `json:"iss"`
+		Subject  string `json:"sub"`
+	}
+	byt, err = base64.RawURLEncoding.DecodeString(parts[1])
+	test.MustSucceed(t, err, "Failed decoding assertion claims")
+	test.MustSucceed(t, jsoniter.ConfigFastest.Unmarshal(byt, &claims), "Failed decoding assertion claims")
+	test.AssertExpected(t, claims.Audience, audience, "Assertion audience doesn't match token endpoint")
+	test.AssertExpected(t, claims.Issuer, clientID, "Assertion issuer doesn't match client ID")
+	test.AssertExpected(t, claims.Subject, clientID, "Assertion subject doesn't match client ID")
+
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	test.MustSucceed(t, err, "Failed decoding assertion signature")
+	hash := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+	test.MustSucceed(t, rsa.VerifyPKCS1v15(certificate.PublicKey.(*rsa.PublicKey), crypto.SHA256, hash[:], signature), "Assertion signature is not valid")
+}
+
+func TestServicePrincipal(t *testing.T) {
+	clientID := "test-client-id"
+	bundle, certificate := testCertificate(t)
+
+	for _, testCase := range []struct {
+		name      string
+		principal ServicePrincipal
+		expiresIn int64
+		expected  int
+	}{
+		{"secret", ServicePrincipal{TenantID: "test-tenant", ClientID: clientID, ClientSecret: "test-secret"}, 3600, 1},
+		{"certificate", ServicePrincipal{TenantID: "test-tenant", ClientID: clientID, Certificate: bundle}, 3600, 1},
+		{"expiring", ServicePrincipal{TenantID: "test-tenant", ClientID: clientID, ClientSecret: "test-secret"}, 60, 2},
+	} {
+		tokenRequests := 0
+		var server *httptest.Server
+		server = httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+			if req.URL.Path == "/test-tenant/oauth2/v2.0/token" {
+				test.MustSucceed(t, req.ParseForm(), "Failed parsing token request")
+				test.AssertExpected(t, req.PostForm.Get("grant_type"), "client_credentials", "Grant type doesn't match expected value")
+				test.AssertExpected(t, req.PostForm.Get("client_id"), clientID, "Client ID doesn't match expected value")
+				test.AssertExpected(t, req.PostForm.Get("scope"), azureDevOpsScope, "Scope doesn't match Azure DevOps")
+				if testCase.principal.ClientSecret != "" {
+					test.AssertExpected(t, req.PostForm.Get("client_secret"), testCase.principal.ClientSecret, "Client secret doesn't match expected value")
+				} else {
+					test.AssertExpected(t, req.PostForm.Get("client_assertion_type"), "urn:ietf:params:oauth:client-assertion-type:jwt-bearer", "Client assertion type doesn't match expected value")
+					verifyAssertion(t, req.PostForm.Get("client_assertion"), certificate, clientID, server.URL+req.URL.Path)
+				}
+
+				tokenRequests += 1
+				test.MustSucceed(t, jsoniter.ConfigFastest.NewEncoder(rw).Encode(map[string]any{
+					"token_type":   "Bearer",
+					"access_token": "test-access-token",
+					"expires_in":   testCase.expiresIn,
+				}), "Failed sending token")
+				return
+			}
+
+			test.AssertExpected(t, req.Header.Get("Authorization"), "Bearer test-access-token", "Authorization header doesn't match access token")
+			_, err := rw.Write([]byte("test"))
+			test.MustSucceed(t, err, "Failed writing test data")
+		}))
+
+		principal := testCase.principal
+		principal.TokenEndpoint = server.URL + "/test-tenant/oauth2/v2.0/token"
+		target, err := NewServicePrincipalClient(server.URL, "test-org/test-project", "test-repo", principal, server.Client())
+		test.MustSucceed(t, err, "Failed creating service principal client for "+testCase.name)
+
+		for i := 0; i < 2; i += 1 {
+			_, err = target.Get("test", "main")
+			test.MustSucceed(t, err, "Failed getting file with "+testCase.name)
+		}
+		server.Close()
+
+		test.AssertExpected(t, tokenRequests, testCase.expected, "Number of token requests doesn't match expected value for "+testCase.name)
+	}
+
+	_, err := NewServicePrincipalClient("http://0.0.0.0", "test-org/test-project", "test-repo", ServicePrincipal{TenantID: "test-tenant", ClientID: clientID}, nil)
+	test.MustFail(t, err, "Creating a client without secret or certificate supposed to fail but succeeded")
+
+	_, err = NewServicePrincipalClient("http://0.0.0.0", "test-org/test-project", "test-repo", ServicePrincipal{TenantID: "test-tenant", ClientID: clientID, Certificate: []byte("invalid")}, nil)
+	test.MustFail(t, err, "Creating a client with an invalid certificate supposed to fail but succeeded")
+}
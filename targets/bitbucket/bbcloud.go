@@ -1,52 +1,43 @@
 package bitbucket_target
 
 import (
-	"encoding/base64"
 	"fmt"
-	"io"
 	"io/ioutil"
-	"log"
 	"net/http"
 	"net/url"
+	"path"
 	"strings"
+	"time"
 
+	jsoniter "github.com/json-iterator/go"
 	"github.com/neosperience/shipper/common"
+	"github.com/neosperience/shipper/logging"
 	"github.com/neosperience/shipper/targets"
 )
 
 // BitbucketCloudRepository commits to a Bitbucket.com repository using the Bitbucket Cloud REST APIs
 type BitbucketCloudRepository struct {
-	baseURI     string
-	projectID   string
-	credentials string
+	baseURI   string
+	projectID string
 
-	client *http.Client
+	client *common.Client
 }
 
+// DefaultCloudEndpoint is the API endpoint for Bitbucket Cloud
+const DefaultCloudEndpoint = "https://api.bitbucket.org/2.0"
+
 // NewCloudAPIClient creates a BitbucketCloudRepository instance
-func NewCloudAPIClient(projectID string, credentials string) *BitbucketCloudRepository {
-	var client = &http.Client{}
+func NewCloudAPIClient(uri string, projectID string, credentials string, client *http.Client) *BitbucketCloudRepository {
 	return &BitbucketCloudRepository{
-		baseURI:     "https://api.bitbucket.org/2.0",
-		projectID:   projectID,
-		credentials: credentials,
-		client:      client,
-	}
-}
-
-func (bb *BitbucketCloudRepository) doRequest(method string, requestURI string, body io.Reader, headers http.Header) (*http.Response, error) {
-	// Add authentication headers
-	if headers == nil {
-		headers = make(http.Header)
+		baseURI:   strings.TrimSuffix(uri, "/"),
+		projectID: projectID,
+		client:    common.NewClient(client, common.BasicAuth(credentials)),
 	}
-	headers.Add("Authorization", "Basic "+base64.StdEncoding.EncodeToString([]byte(bb.credentials)))
-
-	return common.HTTPRequest(bb.client, method, requestURI, body, headers)
 }
 
 func (bb *BitbucketCloudRepository) Get(path string, ref string) ([]byte, error) {
 	requestURI := fmt.Sprintf("%s/repositories/%s/src/%s/%s", bb.baseURI, bb.projectID, ref, path)
-	res, err := bb.doRequest("GET", requestURI, nil, nil)
+	res, err := bb.client.Request("GET", requestURI, nil, nil)
 	if err != nil {
 		return nil, fmt.Errorf("error performing GET file: %w", err)
 	}
@@ -55,6 +46,73 @@ func (bb *BitbucketCloudRepository) Get(path string, ref string) ([]byte, error)
 	return ioutil.ReadAll(res.Body)
 }
 
+func (bb *BitbucketCloudRepository) ResolveCommit(ref string) (string, error) {
+	requestURI := fmt.Sprintf("%s/repositories/%s/commit/%s", bb.baseURI, bb.projectID, url.PathEscape(ref))
+	res, err := bb.client.Request("GET", requestURI, nil, nil)
+	if err != nil {
+		return "", fmt.Errorf("error performing GET commit: %w", err)
+	}
+	defer res.Body.Close()
+
+	var commit struct {
+		Hash string `json:"hash"`
+	}
+	err = jsoniter.ConfigFastest.NewDecoder(res.Body).Decode(&commit)
+	if err != nil {
+		return "", fmt.Errorf("error decoding response: %w", err)
+	}
+	return commit.Hash, nil
+}
+
+func (bb *BitbucketCloudRepository) History(path string, ref string, limit int) ([]targets.Commit, error) {
+	commits := []targets.Commit{}
+	requestURI := fmt.Sprintf("%s/repositories/%s/commits/%s?path=%s&pagelen=%d", bb.baseURI, bb.projectID, url.PathEscape(ref), url.QueryEscape(path), maxPageSize)
+	for requestURI != "" && len(commits) < limit {
+		res, err := bb.client.Request("GET", requestURI, nil, nil)
+		if err != nil {
+			return nil, fmt.Errorf("error performing GET commits: %w", err)
+		}
+
+		var page struct {
+			Values []struct {
+				Hash   string `json:"hash"`
+				Author struct {
+					Raw string `json:"raw"`
+				} `json:"author"`
+				Date    time.Time `json:"date"`
+				Message string    `json:"message"`
+			} `json:"values"`
+			Next string `json:"next"`
+		}
+		err = jsoniter.ConfigFastest.NewDecoder(res.Body).Decode(&page)
+		res.Body.Close()
+		if err != nil {
+			return nil, fmt.Errorf("error decoding response: %w", err)
+		}
+
+		for _, commit := range page.Values {
+			commits = append(commits, targets.Commit{
+				ID:      commit.Hash,
+				Author:  commit.Author.Raw,
+				Date:    commit.Date,
+				Message: commit.Message,
+			})
+		}
+		requestURI = page.Next
+	}
+	if len(commits) > limit {
+		commits = commits[:limit]
+	}
+	return commits, nil
+}
+
+func (bb *BitbucketCloudRepository) GetRevision(path string, commitID string) ([]byte, error) {
+	return bb.Get(path, commitID)
+}
+
+// maxPageSize is the maximum number of items Bitbucket Cloud returns per page
+const maxPageSize = 100
+
 func (bb *BitbucketCloudRepository) Commit(payload *targets.CommitPayload) error {
 	data := url.Values{}
 	for name, content := range payload.Files {
@@ -66,7 +124,7 @@ func (bb *BitbucketCloudRepository) Commit(payload *targets.CommitPayload) error
 	data.Set("message", payload.Message)
 
 	requestURI := fmt.Sprintf("%s/repositories/%s/src", bb.baseURI, bb.projectID)
-	res, err := bb.doRequest("POST", requestURI, strings.NewReader(data.Encode()), http.Header{
+	res, err := bb.client.Request("POST", requestURI, strings.NewReader(data.Encode()), http.Header{
 		"Content-Type": []string{"application/x-www-form-urlencoded"},
 	})
 	if err != nil {
@@ -74,7 +132,8 @@ func (bb *BitbucketCloudRepository) Commit(payload *targets.CommitPayload) error
 	}
 	defer res.Body.Close()
 
-	log.Printf("Commit URL (API): %s", res.Header.Get("Location"))
+	location := res.Header.Get("Location")
+	logging.Info("commit created", "commit", path.Base(location), "url", location)
 
 	return nil
 }
@@ -39,9 +39,7 @@ func TestCommit(t *testing.T) {
 		rw.Header().Set("Location", "https://bitbucket.org/test-user/test-repo/commits/test-commit")
 	}))
 	defer server.Close()
-	target := NewCloudAPIClient("test-project", fmt.Sprintf("%s:%s", testUser, testKey))
-	target.client = server.Client()
-	target.baseURI = server.URL
+	target := NewCloudAPIClient(server.URL, "test-project", fmt.Sprintf("%s:%s", testUser, testKey), server.Client())
 
 	test.MustSucceed(t, target.Commit(commit), "Failed to commit")
 }
@@ -65,9 +63,7 @@ func TestGet(t *testing.T) {
 		test.MustSucceed(t, err, "Failed to write test data")
 	}))
 	defer server.Close()
-	target := NewCloudAPIClient("test-project", fmt.Sprintf("%s:%s", testUser, testKey))
-	target.client = server.Client()
-	target.baseURI = server.URL
+	target := NewCloudAPIClient(server.URL, "test-project", fmt.Sprintf("%s:%s", testUser, testKey), server.Client())
 
 	byt, err := target.Get(testKey, "main")
 	test.MustSucceed(t, err, "Failed to get test data")
@@ -76,6 +72,56 @@ func TestGet(t *testing.T) {
 	}
 }
 
+func TestResolveCommit(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		if req.URL.Path != "/repositories/test-org/test-repo/commit/main" {
+			http.Error(rw, "not found", http.StatusNotFound)
+			return
+		}
+		_, _ = rw.Write([]byte(`{"hash": "main-commit-id", "type": "commit"}`))
+	}))
+	defer server.Close()
+	target := NewCloudAPIClient(server.URL, "test-org/test-repo", "test-user:test-key", server.Client())
+
+	commitID, err := target.ResolveCommit("main")
+	test.MustSucceed(t, err, "Failed resolving branch")
+	test.AssertExpected(t, commitID, "main-commit-id", "Resolved commit doesn't match")
+
+	_, err = target.ResolveCommit("missing")
+	test.MustFail(t, err, "Resolving a missing branch succeeded")
+}
+
+func TestHistory(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		if req.URL.Path != "/repositories/test-org/test-repo/commits/main" || req.URL.Query().Get("path") != "values.yaml" {
+			http.Error(rw, "not found", http.StatusNotFound)
+			return
+		}
+		// Commits are split in two pages
+		if req.URL.Query().Get("page") == "2" {
+			_, _ = rw.Write([]byte(`{"values": [{"hash": "first", "author": {"raw": "John <john@example.com>"}, "date": "2022-02-01T09:00:00+00:00", "message": "Update api to 1.2.0"}]}`))
+			return
+		}
+		_, _ = fmt.Fprintf(rw, `{"values": [{"hash": "second", "author": {"raw": "Jane <jane@example.com>"}, "date": "2022-02-03T09:00:00+00:00", "message": "Update api to 1.3.0"}], "next": "http://%s%s?page=2&path=values.yaml"}`, req.Host, req.URL.Path)
+	}))
+	defer server.Close()
+	target := NewCloudAPIClient(server.URL, "test-org/test-repo", "test-user:test-key", server.Client())
+
+	commits, err := target.History("values.yaml", "main", 10)
+	test.MustSucceed(t, err, "Failed retrieving history")
+	test.AssertExpected(t, len(commits), 2, "All pages should be read")
+	test.AssertExpected(t, commits[0].ID, "second", "Commits should be newest first")
+	test.AssertExpected(t, commits[1].Author, "John <john@example.com>", "Commit author doesn't match")
+	test.AssertExpected(t, commits[1].Date.Equal(time.Date(2022, 2, 1, 9, 0, 0, 0, time.UTC)), true, "Commit date doesn't match")
+
+	commits, err = target.History("values.yaml", "main", 1)
+	test.MustSucceed(t, err, "Failed retrieving history")
+	test.AssertExpected(t, len(commits), 1, "History should be limited")
+
+	_, err = target.History("values.yaml", "missing", 10)
+	test.MustFail(t, err, "Retrieving history of a missing branch succeeded")
+}
+
 func TestFaultyServer(t *testing.T) {
 	// Mock server that just errors out
 	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
@@ -85,9 +131,7 @@ func TestFaultyServer(t *testing.T) {
 	payload := targets.NewPayload("test-branch", "test-author <author@example.com>", "Hello")
 
 	// Test with faulty server
-	target := NewCloudAPIClient("test-project", "unused")
-	target.client = server.Client()
-	target.baseURI = server.URL
+	target := NewCloudAPIClient(server.URL, "test-project", "unused", server.Client())
 
 	_, err := target.Get("test", "main")
 	test.MustFail(t, err, "Request supposed to error out but Get call exited successfully")
@@ -97,7 +141,7 @@ func TestFaultyServer(t *testing.T) {
 
 	// Test with unreachable target
 	target.baseURI = "http://0.0.0.0"
-	target.client.Timeout = time.Millisecond // Set a low timeout since we don't want this to work anyway
+	target.client.HTTPClient().Timeout = time.Millisecond // Set a low timeout since we don't want this to work anyway
 
 	_, err = target.Get("test", "main")
 	test.MustFail(t, err, "Request supposed to error out but Get call exited successfully")
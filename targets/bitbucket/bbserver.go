@@ -0,0 +1,334 @@
+package bitbucket_target
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	jsoniter "github.com/json-iterator/go"
+
+	"github.com/neosperience/shipper/common"
+	"github.com/neosperience/shipper/logging"
+	"github.com/neosperience/shipper/targets"
+)
+
+// BitbucketServerRepository commits to a Bitbucket Server/Data Center repository using the Bitbucket Server REST APIs
+type BitbucketServerRepository struct {
+	baseURI        string
+	projectKey     string
+	repositorySlug string
+
+	// If set, changes are committed to this branch and a pull request is opened towards the payload branch
+	pullRequestBranch string
+
+	client *common.Client
+}
+
+// NewServerAPIClient creates a BitbucketServerRepository instance, projectID must be in "PROJECT/repository" format
+func NewServerAPIClient(uri string, projectID string, token string, client *http.Client) *BitbucketServerRepository {
+	projectKey, repositorySlug, _ := strings.Cut(projectID, "/")
+	return &BitbucketServerRepository{
+		baseURI:        strings.TrimSuffix(uri, "/"),
+		projectKey:     projectKey,
+		repositorySlug: repositorySlug,
+		client:         common.NewClient(client, common.BearerAuth(token)),
+	}
+}
+
+// SetPullRequestBranch makes Commit push changes to a separate branch (created if missing) and
+// open a pull request from it towards the payload branch
+func (bb *BitbucketServerRepository) SetPullRequestBranch(branch string) {
+	bb.pullRequestBranch = branch
+}
+
+func (bb *BitbucketServerRepository) repositoryURI() string {
+	return fmt.Sprintf("%s/rest/api/1.0/projects/%s/repos/%s", bb.baseURI, url.PathEscape(bb.projectKey), url.PathEscape(bb.repositorySlug))
+}
+
+func (bb *BitbucketServerRepository) Get(path string, ref string) ([]byte, error) {
+	requestURI := fmt.Sprintf("%s/raw/%s?at=%s", bb.repositoryURI(), escapePath(path), url.QueryEscape(ref))
+	res, err := bb.client.Request("GET", requestURI, nil, nil)
+	if err != nil {
+		return nil, fmt.Errorf("error performing GET /raw: %w", err)
+	}
+	defer res.Body.Close()
+
+	return ioutil.ReadAll(res.Body)
+}
+
+// lastCommit returns the ID of the last commit on branch that touched path (empty if the file does not exist).
+// exists is false if the branch itself does not exist.
+func (bb *BitbucketServerRepository) lastCommit(path string, branch string) (commitID string, exists bool, err error) {
+	requestURI := fmt.Sprintf("%s/commits?until=%s&path=%s&limit=1", bb.repositoryURI(), url.QueryEscape("refs/heads/"+branch), url.QueryEscape(path))
+	res, err := bb.client.Request("GET", requestURI, nil, nil)
+	if err != nil {
+		if isNotFound(res) {
+			return "", false, nil
+		}
+		return "", false, fmt.Errorf("error performing GET /commits: %w", err)
+	}
+	defer res.Body.Close()
+
+	var commits serverCommitList
+	err = jsoniter.ConfigFastest.NewDecoder(res.Body).Decode(&commits)
+	if err != nil {
+		return "", false, fmt.Errorf("error decoding response: %w", err)
+	}
+
+	if len(commits.Values) == 0 {
+		return "", true, nil
+	}
+	return commits.Values[0].ID, true, nil
+}
+
+func (bb *BitbucketServerRepository) ResolveCommit(ref string) (string, error) {
+	requestURI := fmt.Sprintf("%s/commits?until=%s&limit=1", bb.repositoryURI(), url.QueryEscape(ref))
+	res, err := bb.client.Request("GET", requestURI, nil, nil)
+	if err != nil {
+		return "", fmt.Errorf("error performing GET /commits: %w", err)
+	}
+	defer res.Body.Close()
+
+	var commits serverCommitList
+	err = jsoniter.ConfigFastest.NewDecoder(res.Body).Decode(&commits)
+	if err != nil {
+		return "", fmt.Errorf("error decoding response: %w", err)
+	}
+
+	if len(commits.Values) == 0 {
+		return "", fmt.Errorf("ref %s has no commits", ref)
+	}
+	return commits.Values[0].ID, nil
+}
+
+func (bb *BitbucketServerRepository) History(path string, ref string, limit int) ([]targets.Commit, error) {
+	commits := []targets.Commit{}
+	start := 0
+	for len(commits) < limit {
+		requestURI := fmt.Sprintf("%s/commits?until=%s&path=%s&limit=%d&start=%d", bb.repositoryURI(), url.QueryEscape(ref), url.QueryEscape(path), limit-len(commits), start)
+		res, err := bb.client.Request("GET", requestURI, nil, nil)
+		if err != nil {
+			return nil, fmt.Errorf("error performing GET /commits: %w", err)
+		}
+
+		var page serverCommitList
+		err = jsoniter.ConfigFastest.NewDecoder(res.Body).Decode(&page)
+		res.Body.Close()
+		if err != nil {
+			return nil, fmt.Errorf("error decoding response: %w", err)
+		}
+
+		for _, commit := range page.Values {
+			commits = append(commits, targets.Commit{
+				ID:      commit.ID,
+				Author:  fmt.Sprintf("%s <%s>", commit.Author.Name, commit.Author.EmailAddress),
+				Date:    time.UnixMilli(commit.CommitterTimestamp),
+				Message: commit.Message,
+			})
+		}
+		if page.IsLastPage || len(page.Values) == 0 {
+			break
+		}
+		start = page.NextPageStart
+	}
+	if len(commits) > limit {
+		commits = commits[:limit]
+	}
+	return commits, nil
+}
+
+func (bb *BitbucketServerRepository) GetRevision(path string, commitID string) ([]byte, error) {
+	return bb.Get(path, commitID)
+}
+
+// editFile commits a single file using the multipart file edit API
+func (bb *BitbucketServerRepository) editFile(filePath string, content []byte, fields map[string]string) (serverCommit, error) {
+	b := new(bytes.Buffer)
+	form := multipart.NewWriter(b)
+	for name, value := range fields {
+		if value == "" {
+			continue
+		}
+		if err := form.WriteField(name, value); err != nil {
+			return serverCommit{}, fmt.Errorf("error encoding form field %s: %w", name, err)
+		}
+	}
+	part, err := form.CreateFormFile("content", path.Base(filePath))
+	if err != nil {
+		return serverCommit{}, fmt.Errorf("error encoding file content: %w", err)
+	}
+	if _, err := part.Write(content); err != nil {
+		return serverCommit{}, fmt.Errorf("error encoding file content: %w", err)
+	}
+	if err := form.Close(); err != nil {
+		return serverCommit{}, fmt.Errorf("error encoding form: %w", err)
+	}
+
+	requestURI := fmt.Sprintf("%s/browse/%s", bb.repositoryURI(), escapePath(filePath))
+	res, err := bb.client.Request("PUT", requestURI, b, http.Header{
+		"Content-Type": {form.FormDataContentType()},
+	})
+	if err != nil {
+		return serverCommit{}, fmt.Errorf("error performing PUT /browse: %w", err)
+	}
+	defer res.Body.Close()
+
+	var commit serverCommit
+	err = jsoniter.ConfigFastest.NewDecoder(res.Body).Decode(&commit)
+	if err != nil {
+		return serverCommit{}, fmt.Errorf("error decoding response: %w", err)
+	}
+	return commit, nil
+}
+
+func (bb *BitbucketServerRepository) Commit(payload *targets.CommitPayload) error {
+	branch := payload.Branch
+	if bb.pullRequestBranch != "" {
+		branch = bb.pullRequestBranch
+	}
+
+	// Sort files for a predictable commit order
+	paths := make([]string, 0, len(payload.Files))
+	for name := range payload.Files {
+		paths = append(paths, name)
+	}
+	sort.Strings(paths)
+
+	multipleFiles := len(paths) > 1
+	for _, name := range paths {
+		message := payload.Message
+		if multipleFiles {
+			message = fmt.Sprintf("%s: %s", payload.Message, name)
+		}
+
+		// Get the file's last commit on the branch, so the server can detect conflicting changes
+		sourceCommit, exists, err := bb.lastCommit(name, branch)
+		if err != nil {
+			return fmt.Errorf("failed to retrieve last commit for %s: %w", name, err)
+		}
+
+		sourceBranch := ""
+		if !exists {
+			// Only allowed when committing to a pull request branch, which gets created from the target branch
+			if branch == payload.Branch {
+				return fmt.Errorf("branch %s not found", branch)
+			}
+			sourceBranch = payload.Branch
+			sourceCommit, _, err = bb.lastCommit(name, payload.Branch)
+			if err != nil {
+				return fmt.Errorf("failed to retrieve last commit for %s: %w", name, err)
+			}
+		}
+
+		commit, err := bb.editFile(name, payload.Files[name], map[string]string{
+			"branch":         branch,
+			"message":        message,
+			"sourceCommitId": sourceCommit,
+			"sourceBranch":   sourceBranch,
+		})
+		if err != nil {
+			return fmt.Errorf("failed to commit file %s: %w", name, err)
+		}
+
+		logging.Info("commit created", "commit", commit.ID, "file", name, "url", fmt.Sprintf("%s/projects/%s/repos/%s/commits/%s", bb.baseURI, bb.projectKey, bb.repositorySlug, commit.ID))
+	}
+
+	if bb.pullRequestBranch != "" {
+		return bb.openPullRequest(payload.Message, bb.pullRequestBranch, payload.Branch)
+	}
+	return nil
+}
+
+// openPullRequest creates a pull request from one branch to another, unless one is already open
+func (bb *BitbucketServerRepository) openPullRequest(title string, from string, to string) error {
+	b := new(bytes.Buffer)
+	err := jsoniter.ConfigFastest.NewEncoder(b).Encode(serverPullRequest{
+		Title:   title,
+		FromRef: serverRef{ID: "refs/heads/" + from},
+		ToRef:   serverRef{ID: "refs/heads/" + to},
+	})
+	if err != nil {
+		return fmt.Errorf("error encoding request payload: %w", err)
+	}
+
+	res, err := bb.client.Request("POST", bb.repositoryURI()+"/pull-requests", b, http.Header{
+		"Content-Type": {"application/json"},
+	})
+	if err != nil {
+		// A pull request between the two branches is already open, the new commits will show up there
+		if res != nil && res.StatusCode == http.StatusConflict {
+			logging.Info("pull request already open", "source", from, "destination", to)
+			return nil
+		}
+		return fmt.Errorf("error performing POST /pull-requests: %w", err)
+	}
+	defer res.Body.Close()
+
+	var response serverPullRequest
+	err = jsoniter.ConfigFastest.NewDecoder(res.Body).Decode(&response)
+	if err != nil {
+		return fmt.Errorf("error decoding response: %w", err)
+	}
+
+	if len(response.Links.Self) > 0 {
+		logging.Info("pull request opened", "url", response.Links.Self[0].Href)
+	}
+	return nil
+}
+
+// escapePath escapes every segment of a file path for use in URLs
+func escapePath(filePath string) string {
+	segments := strings.Split(strings.Trim(filePath, "/"), "/")
+	for index := range segments {
+		segments[index] = url.PathEscape(segments[index])
+	}
+	return strings.Join(segments, "/")
+}
+
+func isNotFound(res *http.Response) bool {
+	return res != nil && res.StatusCode == 404
+}
+
+type serverCommit struct {
+	ID        string `json:"id"`
+	DisplayID string `json:"displayId"`
+	Message   string `json:"message"`
+	Author    struct {
+		Name         string `json:"name"`
+		EmailAddress string `json:"emailAddress"`
+	} `json:"author"`
+	// Milliseconds since the Unix epoch
+	CommitterTimestamp int64 `json:"committerTimestamp"`
+}
+
+type serverCommitList struct {
+	Values        []serverCommit `json:"values"`
+	Size          int            `json:"size"`
+	IsLastPage    bool           `json:"isLastPage"`
+	NextPageStart int            `json:"nextPageStart"`
+}
+
+type serverRef struct {
+	ID string `json:"id"`
+}
+
+type serverLink struct {
+	Href string `json:"href"`
+}
+
+type serverPullRequest struct {
+	ID      int       `json:"id,omitempty"`
+	Title   string    `json:"title"`
+	FromRef serverRef `json:"fromRef"`
+	ToRef   serverRef `json:"toRef"`
+	Links   struct {
+		Self []serverLink `json:"self,omitempty"`
+	} `json:"links,omitempty"`
+}
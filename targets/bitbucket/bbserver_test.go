@@ -0,0 +1,232 @@
+package bitbucket_target
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	jsoniter "github.com/json-iterator/go"
+	"github.com/neosperience/shipper/targets"
+	"github.com/neosperience/shipper/test"
+)
+
+const serverRepositoryPath = "/rest/api/1.0/projects/PRJ/repos/test-repo"
+
+func TestServerCommit(t *testing.T) {
+	testKey := "test-key"
+	commit := targets.NewPayload("test-branch", "test-author <author@example.com>", "Hello")
+	test.MustSucceed(t, commit.Files.Add(map[string][]byte{
+		"path/to/textfile.txt": []byte("test file"),
+		"binaryfile.jpg":       {0xff, 0xd8, 0xff, 0xe0},
+	}), "Failed adding test files")
+
+	lastCommits := map[string]string{
+		"path/to/textfile.txt": "test-commit-id",
+	}
+	committed := make(map[string]bool)
+
+	// Setup test HTTP server/client
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		// Check authorization key
+		test.AssertExpected(t, req.Header.Get("Authorization"), "Bearer "+testKey, "Authorization header doesn't match expected value")
+
+		switch {
+		case req.Method == "GET" && req.URL.Path == serverRepositoryPath+"/commits":
+			test.AssertExpected(t, req.URL.Query().Get("until"), "refs/heads/"+commit.Branch, "Commit list branch doesn't match expected value")
+			list := serverCommitList{Values: []serverCommit{}}
+			if id, ok := lastCommits[req.URL.Query().Get("path")]; ok {
+				list.Values = append(list.Values, serverCommit{ID: id})
+			}
+			test.MustSucceed(t, jsoniter.ConfigFastest.NewEncoder(rw).Encode(list), "Failed sending commit list")
+		case req.Method == "PUT" && strings.HasPrefix(req.URL.Path, serverRepositoryPath+"/browse/"):
+			file := strings.TrimPrefix(req.URL.Path, serverRepositoryPath+"/browse/")
+			test.MustSucceed(t, req.ParseMultipartForm(1<<20), "Failed to parse multipart form")
+			test.AssertExpected(t, req.FormValue("branch"), commit.Branch, "Form branch doesn't match expected commit branch")
+			test.AssertExpected(t, req.FormValue("message"), commit.Message+": "+file, "Form message doesn't match expected commit message")
+			test.AssertExpected(t, req.FormValue("sourceCommitId"), lastCommits[file], "Form sourceCommitId doesn't match the file's last commit")
+
+			content, _, err := req.FormFile("content")
+			test.MustSucceed(t, err, "Failed reading file content")
+			byt, err := ioutil.ReadAll(content)
+			test.MustSucceed(t, err, "Failed reading file content")
+			if !bytes.Equal(byt, commit.Files[file]) {
+				t.Fatalf("Content for %s doesn't match expected value", file)
+			}
+			committed[file] = true
+
+			test.MustSucceed(t, jsoniter.ConfigFastest.NewEncoder(rw).Encode(serverCommit{ID: "new-commit-id"}), "Failed sending commit info")
+		default:
+			t.Fatalf("Unexpected request: %s %s", req.Method, req.URL.Path)
+		}
+	}))
+	defer server.Close()
+	target := NewServerAPIClient(server.URL, "PRJ/test-repo", testKey, server.Client())
+
+	test.MustSucceed(t, target.Commit(commit), "Failed to commit")
+	test.AssertExpected(t, len(committed), len(commit.Files), "Not all files were committed")
+}
+
+func TestServerPullRequest(t *testing.T) {
+	commit := targets.NewPayload("main", "test-author <author@example.com>", "Hello")
+	test.MustSucceed(t, commit.Files.Add(map[string][]byte{
+		"values.yaml": []byte("test file"),
+	}), "Failed adding test files")
+
+	pullRequestOpened := false
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		switch {
+		case req.Method == "GET" && req.URL.Path == serverRepositoryPath+"/commits":
+			// Pull request branch does not exist yet
+			if req.URL.Query().Get("until") != "refs/heads/main" {
+				http.Error(rw, "not found", http.StatusNotFound)
+				return
+			}
+			test.MustSucceed(t, jsoniter.ConfigFastest.NewEncoder(rw).Encode(serverCommitList{
+				Values: []serverCommit{{ID: "main-commit-id"}},
+			}), "Failed sending commit list")
+		case req.Method == "PUT":
+			test.MustSucceed(t, req.ParseMultipartForm(1<<20), "Failed to parse multipart form")
+			test.AssertExpected(t, req.FormValue("branch"), "deploy/test", "Form branch must be the pull request branch")
+			test.AssertExpected(t, req.FormValue("sourceBranch"), "main", "Form sourceBranch must be the target branch")
+			test.AssertExpected(t, req.FormValue("sourceCommitId"), "main-commit-id", "Form sourceCommitId must come from the target branch")
+			test.MustSucceed(t, jsoniter.ConfigFastest.NewEncoder(rw).Encode(serverCommit{ID: "new-commit-id"}), "Failed sending commit info")
+		case req.Method == "POST" && req.URL.Path == serverRepositoryPath+"/pull-requests":
+			var pullRequest serverPullRequest
+			test.MustSucceed(t, jsoniter.ConfigFastest.NewDecoder(req.Body).Decode(&pullRequest), "Failed decoding pull request")
+			test.AssertExpected(t, pullRequest.FromRef.ID, "refs/heads/deploy/test", "Pull request source doesn't match expected value")
+			test.AssertExpected(t, pullRequest.ToRef.ID, "refs/heads/main", "Pull request target doesn't match expected value")
+			pullRequestOpened = true
+
+			pullRequest.Links.Self = []serverLink{{Href: "https://bitbucket.example.com/projects/PRJ/repos/test-repo/pull-requests/1"}}
+			test.MustSucceed(t, jsoniter.ConfigFastest.NewEncoder(rw).Encode(pullRequest), "Failed sending pull request info")
+		default:
+			t.Fatalf("Unexpected request: %s %s", req.Method, req.URL.Path)
+		}
+	}))
+	defer server.Close()
+	target := NewServerAPIClient(server.URL, "PRJ/test-repo", "test-key", server.Client())
+	target.SetPullRequestBranch("deploy/test")
+
+	test.MustSucceed(t, target.Commit(commit), "Failed to commit")
+	if !pullRequestOpened {
+		t.Fatal("Pull request was not opened")
+	}
+
+	// Committing directly to a missing branch must fail
+	target.SetPullRequestBranch("")
+	commit.Branch = "missing"
+	test.MustFail(t, target.Commit(commit), "Commit supposed to fail for missing branch but succeeded")
+}
+
+func TestServerResolveCommit(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		test.AssertExpected(t, req.URL.Path, serverRepositoryPath+"/commits", "Request path doesn't match")
+		values := []serverCommit{}
+		if req.URL.Query().Get("until") == "main" {
+			values = append(values, serverCommit{ID: "main-commit-id"})
+		}
+		test.MustSucceed(t, jsoniter.ConfigFastest.NewEncoder(rw).Encode(serverCommitList{Values: values}), "Failed sending commit list")
+	}))
+	defer server.Close()
+	target := NewServerAPIClient(server.URL, "PRJ/test-repo", "test-key", server.Client())
+
+	commitID, err := target.ResolveCommit("main")
+	test.MustSucceed(t, err, "Failed resolving branch")
+	test.AssertExpected(t, commitID, "main-commit-id", "Resolved commit doesn't match")
+
+	_, err = target.ResolveCommit("missing")
+	test.MustFail(t, err, "Resolving a branch without commits succeeded")
+}
+
+func TestServerHistory(t *testing.T) {
+	all := []serverCommit{{ID: "third"}, {ID: "second"}, {ID: "first"}}
+	all[0].Author.Name = "Jane"
+	all[0].Author.EmailAddress = "jane@example.com"
+	all[0].CommitterTimestamp = time.Date(2022, 2, 3, 9, 0, 0, 0, time.UTC).UnixMilli()
+
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		query := req.URL.Query()
+		test.AssertExpected(t, req.URL.Path, serverRepositoryPath+"/commits", "Request path doesn't match")
+		test.AssertExpected(t, query.Get("path"), "values.yaml", "Requested path doesn't match")
+		if query.Get("until") != "main" {
+			http.Error(rw, "not found", http.StatusNotFound)
+			return
+		}
+		// Return two commits per page
+		page := serverCommitList{Values: all[:2], NextPageStart: 2}
+		if query.Get("start") == "2" {
+			page = serverCommitList{Values: all[2:], IsLastPage: true}
+		}
+		test.MustSucceed(t, jsoniter.ConfigFastest.NewEncoder(rw).Encode(page), "Failed sending commit list")
+	}))
+	defer server.Close()
+	target := NewServerAPIClient(server.URL, "PRJ/test-repo", "test-key", server.Client())
+
+	commits, err := target.History("values.yaml", "main", 10)
+	test.MustSucceed(t, err, "Failed retrieving history")
+	test.AssertExpected(t, len(commits), 3, "All pages should be read")
+	test.AssertExpected(t, commits[2].ID, "first", "Commits should be newest first")
+	test.AssertExpected(t, commits[0].Author, "Jane <jane@example.com>", "Commit author doesn't match")
+	test.AssertExpected(t, commits[0].Date.Equal(time.Date(2022, 2, 3, 9, 0, 0, 0, time.UTC)), true, "Commit date doesn't match")
+
+	_, err = target.History("values.yaml", "missing", 10)
+	test.MustFail(t, err, "Retrieving history of a missing branch succeeded")
+}
+
+func TestServerGet(t *testing.T) {
+	testKey := "test-key"
+	testData := []byte("hello test here")
+
+	// Setup test HTTP server/client
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		test.AssertExpected(t, req.Header.Get("Authorization"), "Bearer "+testKey, "Authorization header doesn't match expected value")
+		test.AssertExpected(t, req.URL.Path, serverRepositoryPath+"/raw/path/to/file.yaml", "Request path doesn't match expected value")
+		test.AssertExpected(t, req.URL.Query().Get("at"), "main", "Requested ref doesn't match expected value")
+
+		_, err := rw.Write(testData)
+		test.MustSucceed(t, err, "Failed to write test data")
+	}))
+	defer server.Close()
+	target := NewServerAPIClient(server.URL, "PRJ/test-repo", testKey, server.Client())
+
+	byt, err := target.Get("path/to/file.yaml", "main")
+	test.MustSucceed(t, err, "Failed to get test data")
+	if !bytes.Equal(byt, testData) {
+		t.Fatal("Expected file content is different from retrieved")
+	}
+}
+
+func TestServerFaultyServer(t *testing.T) {
+	// Mock server that just errors out
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		http.Error(rw, "Unauthorized", http.StatusUnauthorized)
+	}))
+	defer server.Close()
+	payload := targets.NewPayload("test-branch", "test-author <author@example.com>", "Hello")
+	test.MustSucceed(t, payload.Files.Add(map[string][]byte{
+		"textfile.txt": []byte("test file"),
+	}), "Failed adding test file")
+
+	// Test with faulty server
+	target := NewServerAPIClient(server.URL, "PRJ/test-repo", "unused", server.Client())
+
+	_, err := target.Get("test", "main")
+	test.MustFail(t, err, "Request supposed to error out but Get call exited successfully")
+
+	err = target.Commit(payload)
+	test.MustFail(t, err, "Request supposed to error out but Commit call exited successfully")
+
+	// Test with unreachable target
+	target.baseURI = "http://0.0.0.0"
+	target.client.HTTPClient().Timeout = time.Millisecond // Set a low timeout since we don't want this to work anyway
+
+	_, err = target.Get("test", "main")
+	test.MustFail(t, err, "Request supposed to error out but Get call exited successfully")
+
+	err = target.Commit(payload)
+	test.MustFail(t, err, "Request supposed to error out but Commit call exited successfully")
+}
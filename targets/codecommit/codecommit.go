@@ -0,0 +1,386 @@
+package codecommit_target
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	jsoniter "github.com/json-iterator/go"
+
+	"github.com/neosperience/shipper/common"
+	"github.com/neosperience/shipper/logging"
+	"github.com/neosperience/shipper/targets"
+)
+
+const (
+	service       = "codecommit"
+	targetPrefix  = "CodeCommit_20150413."
+	jsonMediaType = "application/x-amz-json-1.1"
+)
+
+var (
+	// ErrBranchMoved happens when the branch was updated after its tip was read, retrying may succeed
+	ErrBranchMoved = common.Categorize(common.ErrConflict, errors.New("branch was updated concurrently"))
+)
+
+// CodeCommitRepository commits to an AWS CodeCommit repository using the CodeCommit JSON APIs
+type CodeCommitRepository struct {
+	endpoint       string
+	region         string
+	repositoryName string
+	credentials    Credentials
+
+	client *common.Client
+}
+
+// NewAPIClient creates a CodeCommitRepository instance. If endpoint is empty, the public regional endpoint is used.
+// If client is nil, a default HTTP client is used.
+func NewAPIClient(endpoint string, region string, repositoryName string, credentials Credentials, client *http.Client) *CodeCommitRepository {
+	if endpoint == "" {
+		endpoint = fmt.Sprintf("https://codecommit.%s.amazonaws.com", region)
+	}
+	cc := &CodeCommitRepository{
+		endpoint:       strings.TrimSuffix(endpoint, "/"),
+		region:         region,
+		repositoryName: repositoryName,
+		credentials:    credentials,
+	}
+	cc.client = common.NewClient(client, common.AuthenticatorFunc(cc.authenticate))
+	return cc
+}
+
+// authenticate signs requests with AWS Signature Version 4
+func (cc *CodeCommitRepository) authenticate(req *http.Request) error {
+	var body []byte
+	if req.GetBody != nil {
+		reader, err := req.GetBody()
+		if err != nil {
+			return fmt.Errorf("error reading request body: %w", err)
+		}
+		defer reader.Close()
+		body, err = ioutil.ReadAll(reader)
+		if err != nil {
+			return fmt.Errorf("error reading request body: %w", err)
+		}
+	}
+	if err := signRequest(req.Method, req.URL.String(), req.Header, body, cc.credentials, cc.region, service, time.Now()); err != nil {
+		return fmt.Errorf("error signing request: %w", err)
+	}
+	return nil
+}
+
+// doAction calls a CodeCommit API action, encoding input and decoding the response into output
+func (cc *CodeCommitRepository) doAction(action string, input any, output any) error {
+	body, err := jsoniter.ConfigFastest.Marshal(input)
+	if err != nil {
+		return fmt.Errorf("error encoding request payload: %w", err)
+	}
+
+	requestURI := cc.endpoint + "/"
+	headers := http.Header{
+		"Content-Type": {jsonMediaType},
+		"X-Amz-Target": {targetPrefix + action},
+	}
+	res, err := cc.client.Request("POST", requestURI, bytes.NewReader(body), headers)
+	if err != nil {
+		return fmt.Errorf("error performing %s: %w", action, err)
+	}
+	defer res.Body.Close()
+
+	err = jsoniter.ConfigFastest.NewDecoder(res.Body).Decode(output)
+	if err != nil {
+		return fmt.Errorf("error decoding response: %w", err)
+	}
+	return nil
+}
+
+// errorType returns the type of the AWS error returned by an action, such as "FileDoesNotExistException",
+// "unknown" if err is not an AWS error or an empty string if err is nil
+func errorType(err error) string {
+	if err == nil {
+		return ""
+	}
+	var httpErr *common.HTTPError
+	if !errors.As(err, &httpErr) {
+		return "unknown"
+	}
+	var body struct {
+		Type string `json:"__type"`
+	}
+	if jsoniter.ConfigFastest.UnmarshalFromString(httpErr.Body, &body) != nil || body.Type == "" {
+		return "unknown"
+	}
+	// Some protocols prefix the type with its namespace, eg. "com.amazonaws.codecommit#..."
+	if index := strings.LastIndex(body.Type, "#"); index >= 0 {
+		return body.Type[index+1:]
+	}
+	return body.Type
+}
+
+func (cc *CodeCommitRepository) Get(path string, ref string) ([]byte, error) {
+	var response getFileOutput
+	err := cc.doAction("GetFile", getFileInput{
+		RepositoryName:  cc.repositoryName,
+		CommitSpecifier: ref,
+		FilePath:        path,
+	}, &response)
+	if err != nil {
+		if errorType(err) == "FileDoesNotExistException" {
+			return nil, targets.ErrFileNotFound
+		}
+		return nil, fmt.Errorf("error getting file: %w", err)
+	}
+
+	return response.FileContent, nil
+}
+
+func (cc *CodeCommitRepository) ResolveCommit(ref string) (string, error) {
+	var branch getBranchOutput
+	err := cc.doAction("GetBranch", getBranchInput{
+		RepositoryName: cc.repositoryName,
+		BranchName:     ref,
+	}, &branch)
+	if err != nil {
+		return "", fmt.Errorf("error getting branch: %w", err)
+	}
+	return branch.Branch.CommitID, nil
+}
+
+func (cc *CodeCommitRepository) Commit(payload *targets.CommitPayload) error {
+	// The current branch tip is used as parent, CodeCommit rejects the commit if the branch moved in the meantime
+	var branch getBranchOutput
+	err := cc.doAction("GetBranch", getBranchInput{
+		RepositoryName: cc.repositoryName,
+		BranchName:     payload.Branch,
+	}, &branch)
+	if err != nil {
+		return fmt.Errorf("error getting branch: %w", err)
+	}
+
+	files := make([]putFileEntry, 0, len(payload.Files))
+	for path, content := range payload.Files {
+		files = append(files, putFileEntry{
+			FilePath:    path,
+			FileContent: content,
+		})
+	}
+	// Sort files for a predictable request payload
+	sort.Slice(files, func(i, j int) bool {
+		return files[i].FilePath < files[j].FilePath
+	})
+
+	author, email := payload.SplitAuthor()
+	var response createCommitOutput
+	err = cc.doAction("CreateCommit", createCommitInput{
+		RepositoryName: cc.repositoryName,
+		BranchName:     payload.Branch,
+		ParentCommitID: branch.Branch.CommitID,
+		AuthorName:     author,
+		Email:          email,
+		CommitMessage:  payload.Message,
+		PutFiles:       files,
+	}, &response)
+	switch errorType(err) {
+	case "":
+	case "ParentCommitIdOutdatedException", "ParentCommitDoesNotExistException":
+		return fmt.Errorf("error creating commit: %w: %s", ErrBranchMoved, err.Error())
+	default:
+		return fmt.Errorf("error creating commit: %w", err)
+	}
+
+	logging.Info("commit created", "commit", response.CommitID, "url", fmt.Sprintf("https://%s.console.aws.amazon.com/codesuite/codecommit/repositories/%s/commit/%s?region=%s", cc.region, url.PathEscape(cc.repositoryName), response.CommitID, cc.region))
+	return nil
+}
+
+// maxHistoryWalk is the maximum number of commits History goes through, CodeCommit can't filter commits by path
+// so every commit of the branch is checked for changes to the file. Each commit takes a GetCommit and a
+// GetDifferences call, so the walk is kept short to stay within the API rate limits.
+const maxHistoryWalk = 200
+
+// History walks the first-parent history of ref, returning the commits that changed path. The walk stops as soon
+// as limit commits are found, or after maxHistoryWalk commits.
+func (cc *CodeCommitRepository) History(path string, ref string, limit int) ([]targets.Commit, error) {
+	commitID, err := cc.ResolveCommit(ref)
+	if err != nil {
+		return nil, err
+	}
+
+	commits := []targets.Commit{}
+	for walked := 0; commitID != "" && len(commits) < limit && walked < maxHistoryWalk; walked++ {
+		var response getCommitOutput
+		err := cc.doAction("GetCommit", getCommitInput{
+			RepositoryName: cc.repositoryName,
+			CommitID:       commitID,
+		}, &response)
+		if err != nil {
+			return nil, fmt.Errorf("error getting commit: %w", err)
+		}
+
+		parent := ""
+		if len(response.Commit.Parents) > 0 {
+			parent = response.Commit.Parents[0]
+		}
+		changed, err := cc.changed(path, parent, commitID)
+		if err != nil {
+			return nil, err
+		}
+		if changed {
+			author := response.Commit.Author
+			commits = append(commits, targets.Commit{
+				ID:      commitID,
+				Author:  fmt.Sprintf("%s <%s>", author.Name, author.Email),
+				Date:    parseDate(response.Commit.Committer.Date),
+				Message: strings.TrimSpace(response.Commit.Message),
+			})
+		}
+		commitID = parent
+	}
+	return commits, nil
+}
+
+func (cc *CodeCommitRepository) GetRevision(path string, commitID string) ([]byte, error) {
+	return cc.Get(path, commitID)
+}
+
+// changed checks whether a commit changed a file compared to its parent (the empty tree if parent is empty)
+func (cc *CodeCommitRepository) changed(path string, parent string, commitID string) (bool, error) {
+	var response getDifferencesOutput
+	err := cc.doAction("GetDifferences", getDifferencesInput{
+		RepositoryName:        cc.repositoryName,
+		BeforeCommitSpecifier: parent,
+		AfterCommitSpecifier:  commitID,
+		BeforePath:            path,
+		AfterPath:             path,
+		MaxResults:            1,
+	}, &response)
+	if err != nil {
+		// The file is missing from at least one of the commits, it changed if it was created or deleted
+		if errorType(err) == "PathDoesNotExistException" {
+			before, err := cc.exists(path, parent)
+			if err != nil {
+				return false, err
+			}
+			after, err := cc.exists(path, commitID)
+			if err != nil {
+				return false, err
+			}
+			return before != after, nil
+		}
+		return false, fmt.Errorf("error getting differences: %w", err)
+	}
+	return len(response.Differences) > 0, nil
+}
+
+// exists checks whether a file is in a commit, no file is in the empty commit ID of the parent of a root commit
+func (cc *CodeCommitRepository) exists(path string, commitID string) (bool, error) {
+	if commitID == "" {
+		return false, nil
+	}
+	_, err := cc.Get(path, commitID)
+	if errors.Is(err, targets.ErrFileNotFound) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// parseDate parses CodeCommit dates, in "<seconds since epoch> <timezone>" format
+func parseDate(date string) time.Time {
+	seconds, _, _ := strings.Cut(date, " ")
+	timestamp, err := strconv.ParseInt(seconds, 10, 64)
+	if err != nil {
+		return time.Time{}
+	}
+	return time.Unix(timestamp, 0).UTC()
+}
+
+type getFileInput struct {
+	RepositoryName  string `json:"repositoryName"`
+	CommitSpecifier string `json:"commitSpecifier,omitempty"`
+	FilePath        string `json:"filePath"`
+}
+
+type getFileOutput struct {
+	CommitID    string `json:"commitId"`
+	BlobID      string `json:"blobId"`
+	FilePath    string `json:"filePath"`
+	FileMode    string `json:"fileMode"`
+	FileSize    int64  `json:"fileSize"`
+	FileContent []byte `json:"fileContent"`
+}
+
+type getBranchInput struct {
+	RepositoryName string `json:"repositoryName"`
+	BranchName     string `json:"branchName"`
+}
+
+type getBranchOutput struct {
+	Branch struct {
+		BranchName string `json:"branchName"`
+		CommitID   string `json:"commitId"`
+	} `json:"branch"`
+}
+
+type putFileEntry struct {
+	FilePath    string `json:"filePath"`
+	FileContent []byte `json:"fileContent"`
+}
+
+type createCommitInput struct {
+	RepositoryName string         `json:"repositoryName"`
+	BranchName     string         `json:"branchName"`
+	ParentCommitID string         `json:"parentCommitId"`
+	AuthorName     string         `json:"authorName,omitempty"`
+	Email          string         `json:"email,omitempty"`
+	CommitMessage  string         `json:"commitMessage"`
+	PutFiles       []putFileEntry `json:"putFiles"`
+}
+
+type createCommitOutput struct {
+	CommitID string `json:"commitId"`
+	TreeID   string `json:"treeId"`
+}
+
+type getCommitInput struct {
+	RepositoryName string `json:"repositoryName"`
+	CommitID       string `json:"commitId"`
+}
+
+type userInfo struct {
+	Name  string `json:"name"`
+	Email string `json:"email"`
+	Date  string `json:"date"`
+}
+
+type getCommitOutput struct {
+	Commit struct {
+		CommitID  string   `json:"commitId"`
+		Parents   []string `json:"parents"`
+		Message   string   `json:"message"`
+		Author    userInfo `json:"author"`
+		Committer userInfo `json:"committer"`
+	} `json:"commit"`
+}
+
+type getDifferencesInput struct {
+	RepositoryName        string `json:"repositoryName"`
+	BeforeCommitSpecifier string `json:"beforeCommitSpecifier,omitempty"`
+	AfterCommitSpecifier  string `json:"afterCommitSpecifier"`
+	BeforePath            string `json:"beforePath,omitempty"`
+	AfterPath             string `json:"afterPath,omitempty"`
+	MaxResults            int    `json:"MaxResults,omitempty"`
+}
+
+type difference struct {
+	ChangeType string `json:"changeType"`
+}
+
+type getDifferencesOutput struct {
+	Differences []difference `json:"differences"`
+}
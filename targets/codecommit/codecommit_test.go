@@ -0,0 +1,305 @@
+package codecommit_target
+
+import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	jsoniter "github.com/json-iterator/go"
+	"github.com/neosperience/shipper/result"
+	"github.com/neosperience/shipper/targets"
+	"github.com/neosperience/shipper/test"
+)
+
+func checkSignature(t *testing.T, req *http.Request, action string) {
+	test.AssertExpected(t, req.Header.Get("X-Amz-Target"), targetPrefix+action, "X-Amz-Target doesn't match expected action")
+	test.AssertExpected(t, req.Header.Get("Content-Type"), jsonMediaType, "Content-Type doesn't match expected value")
+	authorization := req.Header.Get("Authorization")
+	if !strings.HasPrefix(authorization, signingAlgorithm+" Credential="+testCredentials.AccessKeyID+"/") || !strings.Contains(authorization, "/eu-west-1/codecommit/aws4_request") {
+		t.Fatalf("Authorization header is not a valid SigV4 signature for the expected scope: %s", authorization)
+	}
+}
+
+func TestCommit(t *testing.T) {
+	commit := targets.NewPayload("test-branch", "test-author <author@example.com>", "Hello")
+	test.MustSucceed(t, commit.Files.Add(map[string][]byte{
+		"textfile.txt":   []byte("test file"),
+		"binaryfile.jpg": {0xff, 0xd8, 0xff, 0xe0},
+	}), "Failed adding test files")
+
+	// Setup test HTTP server/client
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		switch req.Header.Get("X-Amz-Target") {
+		case targetPrefix + "GetBranch":
+			checkSignature(t, req, "GetBranch")
+			var input getBranchInput
+			test.MustSucceed(t, jsoniter.ConfigFastest.NewDecoder(req.Body).Decode(&input), "Failed decoding payload")
+			test.AssertExpected(t, input.BranchName, commit.Branch, "Branch name doesn't match expected value")
+
+			var output getBranchOutput
+			output.Branch.BranchName = input.BranchName
+			output.Branch.CommitID = "test-parent-id"
+			test.MustSucceed(t, jsoniter.ConfigFastest.NewEncoder(rw).Encode(output), "Failed sending branch info")
+		case targetPrefix + "CreateCommit":
+			checkSignature(t, req, "CreateCommit")
+			var input createCommitInput
+			test.MustSucceed(t, jsoniter.ConfigFastest.NewDecoder(req.Body).Decode(&input), "Failed decoding payload")
+			test.AssertExpected(t, input.RepositoryName, "test-repo", "Repository name doesn't match expected value")
+			test.AssertExpected(t, input.ParentCommitID, "test-parent-id", "Parent commit ID doesn't match the branch tip")
+			test.AssertExpected(t, input.AuthorName, "test-author", "Author name doesn't match expected value")
+			test.AssertExpected(t, input.Email, "author@example.com", "Author email doesn't match expected value")
+			test.AssertExpected(t, len(input.PutFiles), len(commit.Files), "Wrong number of files in commit")
+			for _, file := range input.PutFiles {
+				if !bytes.Equal(file.FileContent, commit.Files[file.FilePath]) {
+					t.Fatalf("Content for %s doesn't match expected value", file.FilePath)
+				}
+			}
+			test.MustSucceed(t, jsoniter.ConfigFastest.NewEncoder(rw).Encode(createCommitOutput{CommitID: "new-commit-id"}), "Failed sending commit info")
+		default:
+			t.Fatalf("Unexpected action: %s", req.Header.Get("X-Amz-Target"))
+		}
+	}))
+	defer server.Close()
+	target := NewAPIClient(server.URL, "eu-west-1", "test-repo", testCredentials, server.Client())
+
+	test.MustSucceed(t, target.Commit(commit), "Failed committing files")
+}
+
+func TestCommitBranchMoved(t *testing.T) {
+	commit := targets.NewPayload("test-branch", "test-author <author@example.com>", "Hello")
+	test.MustSucceed(t, commit.Files.Add(map[string][]byte{"textfile.txt": []byte("test file")}), "Failed adding test files")
+
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		switch req.Header.Get("X-Amz-Target") {
+		case targetPrefix + "GetBranch":
+			var output getBranchOutput
+			output.Branch.CommitID = "test-parent-id"
+			test.MustSucceed(t, jsoniter.ConfigFastest.NewEncoder(rw).Encode(output), "Failed sending branch info")
+		case targetPrefix + "CreateCommit":
+			http.Error(rw, `{"__type":"ParentCommitIdOutdatedException","message":"The parent commit ID is not the branch tip"}`, http.StatusBadRequest)
+		default:
+			t.Fatalf("Unexpected action: %s", req.Header.Get("X-Amz-Target"))
+		}
+	}))
+	defer server.Close()
+	target := NewAPIClient(server.URL, "eu-west-1", "test-repo", testCredentials, server.Client())
+
+	err := target.Commit(commit)
+	test.MustFail(t, err, "Commit on a branch that moved supposed to fail but succeeded")
+	test.AssertExpected(t, errors.Is(err, ErrBranchMoved), true, "Outdated parent is not reported as the branch moving")
+	test.AssertExpected(t, result.CodeOf(err), result.CodeConflict, "Exit code of a branch that moved doesn't match")
+}
+
+func TestGet(t *testing.T) {
+	testData := []byte("hello test here")
+
+	// Setup test HTTP server/client
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		checkSignature(t, req, "GetFile")
+
+		var input getFileInput
+		test.MustSucceed(t, jsoniter.ConfigFastest.NewDecoder(req.Body).Decode(&input), "Failed decoding payload")
+		test.AssertExpected(t, input.CommitSpecifier, "main", "Commit specifier doesn't match expected value")
+		if input.FilePath != "path/to/file.yaml" {
+			rw.Header().Set("Content-Type", jsonMediaType)
+			rw.WriteHeader(http.StatusBadRequest)
+			_, _ = rw.Write([]byte(`{"__type":"FileDoesNotExistException","message":"File does not exist"}`))
+			return
+		}
+
+		// Blobs are base64-encoded in the JSON protocol
+		_, err := rw.Write([]byte(`{"fileContent":"` + base64.StdEncoding.EncodeToString(testData) + `"}`))
+		test.MustSucceed(t, err, "Failed writing test data")
+	}))
+	defer server.Close()
+	target := NewAPIClient(server.URL, "eu-west-1", "test-repo", testCredentials, server.Client())
+
+	byt, err := target.Get("path/to/file.yaml", "main")
+	test.MustSucceed(t, err, "Failed getting file")
+	if !bytes.Equal(byt, testData) {
+		t.Fatal("Expected file content is different from retrieved")
+	}
+
+	_, err = target.Get("missing.yaml", "main")
+	if err != targets.ErrFileNotFound {
+		t.Fatalf("Expected file not found error, got %v", err)
+	}
+}
+
+func TestResolveCommit(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		checkSignature(t, req, "GetBranch")
+
+		var input getBranchInput
+		test.MustSucceed(t, jsoniter.ConfigFastest.NewDecoder(req.Body).Decode(&input), "Failed decoding payload")
+		if input.BranchName != "main" {
+			rw.Header().Set("Content-Type", jsonMediaType)
+			rw.WriteHeader(http.StatusBadRequest)
+			_, _ = rw.Write([]byte(`{"__type":"BranchDoesNotExistException","message":"Branch does not exist"}`))
+			return
+		}
+
+		var output getBranchOutput
+		output.Branch.BranchName = input.BranchName
+		output.Branch.CommitID = "main-commit-id"
+		test.MustSucceed(t, jsoniter.ConfigFastest.NewEncoder(rw).Encode(output), "Failed sending branch info")
+	}))
+	defer server.Close()
+	target := NewAPIClient(server.URL, "eu-west-1", "test-repo", testCredentials, server.Client())
+
+	commitID, err := target.ResolveCommit("main")
+	test.MustSucceed(t, err, "Failed resolving branch")
+	test.AssertExpected(t, commitID, "main-commit-id", "Resolved commit doesn't match")
+
+	_, err = target.ResolveCommit("missing")
+	test.MustFail(t, err, "Resolving a missing branch succeeded")
+}
+
+func TestHistory(t *testing.T) {
+	// c4 only changes another file, c3 changes values.yaml and c2 creates it
+	parents := map[string]string{"c4": "c3", "c3": "c2", "c2": "c1", "c1": ""}
+	changes := map[string]bool{"c3": true}
+	exists := map[string]bool{"c4": true, "c3": true, "c2": true}
+
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		switch strings.TrimPrefix(req.Header.Get("X-Amz-Target"), targetPrefix) {
+		case "GetBranch":
+			var output getBranchOutput
+			output.Branch.CommitID = "c4"
+			test.MustSucceed(t, jsoniter.ConfigFastest.NewEncoder(rw).Encode(output), "Failed sending branch info")
+		case "GetCommit":
+			var input getCommitInput
+			test.MustSucceed(t, jsoniter.ConfigFastest.NewDecoder(req.Body).Decode(&input), "Failed decoding payload")
+			var output getCommitOutput
+			output.Commit.CommitID = input.CommitID
+			if parents[input.CommitID] != "" {
+				output.Commit.Parents = []string{parents[input.CommitID]}
+			}
+			output.Commit.Message = "Commit " + input.CommitID + "\n"
+			output.Commit.Author = userInfo{Name: "Jane", Email: "jane@example.com", Date: "1643878800 +0100"}
+			output.Commit.Committer = output.Commit.Author
+			test.MustSucceed(t, jsoniter.ConfigFastest.NewEncoder(rw).Encode(output), "Failed sending commit")
+		case "GetDifferences":
+			var input getDifferencesInput
+			test.MustSucceed(t, jsoniter.ConfigFastest.NewDecoder(req.Body).Decode(&input), "Failed decoding payload")
+			test.AssertExpected(t, input.AfterPath, "values.yaml", "Path doesn't match")
+			test.AssertExpected(t, input.BeforeCommitSpecifier, parents[input.AfterCommitSpecifier], "Commit should be compared to its parent")
+			if !exists[input.BeforeCommitSpecifier] || !exists[input.AfterCommitSpecifier] {
+				http.Error(rw, `{"__type":"PathDoesNotExistException"}`, http.StatusBadRequest)
+				return
+			}
+			var output getDifferencesOutput
+			if changes[input.AfterCommitSpecifier] {
+				output.Differences = append(output.Differences, difference{ChangeType: "M"})
+			}
+			test.MustSucceed(t, jsoniter.ConfigFastest.NewEncoder(rw).Encode(output), "Failed sending differences")
+		case "GetFile":
+			var input getFileInput
+			test.MustSucceed(t, jsoniter.ConfigFastest.NewDecoder(req.Body).Decode(&input), "Failed decoding payload")
+			if !exists[input.CommitSpecifier] {
+				http.Error(rw, `{"__type":"FileDoesNotExistException"}`, http.StatusBadRequest)
+				return
+			}
+			test.MustSucceed(t, jsoniter.ConfigFastest.NewEncoder(rw).Encode(getFileOutput{FileContent: []byte("tag: 1.0.0")}), "Failed sending file")
+		default:
+			t.Fatalf("Unexpected action: %s", req.Header.Get("X-Amz-Target"))
+		}
+	}))
+	defer server.Close()
+	target := NewAPIClient(server.URL, "eu-west-1", "test-repo", testCredentials, server.Client())
+
+	commits, err := target.History("values.yaml", "main", 10)
+	test.MustSucceed(t, err, "Failed retrieving history")
+	test.AssertExpected(t, len(commits), 2, "Only commits changing the file should be listed")
+	test.AssertExpected(t, commits[0], targets.Commit{ID: "c3", Author: "Jane <jane@example.com>", Date: time.Unix(1643878800, 0).UTC(), Message: "Commit c3"}, "Commit doesn't match")
+	test.AssertExpected(t, commits[1].ID, "c2", "The commit creating the file should be listed")
+
+	commits, err = target.History("values.yaml", "main", 1)
+	test.MustSucceed(t, err, "Failed retrieving history")
+	test.AssertExpected(t, len(commits), 1, "History should be limited")
+}
+
+func TestFaultyServer(t *testing.T) {
+	// Mock server that just errors out
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		http.Error(rw, `{"__type":"UnrecognizedClientException"}`, http.StatusBadRequest)
+	}))
+	defer server.Close()
+	payload := targets.NewPayload("test-branch", "test-author <author@example.com>", "Hello")
+
+	// Test with erroring server
+	target := NewAPIClient(server.URL, "eu-west-1", "test-repo", testCredentials, server.Client())
+
+	_, err := target.Get("test", "main")
+	test.MustFail(t, err, "Request supposed to error out but Get call exited successfully")
+
+	err = target.Commit(payload)
+	test.MustFail(t, err, "Request supposed to error out but Commit call exited successfully")
+
+	// Test with unreachable target
+	client := server.Client()
+	client.Timeout = time.Millisecond // Set a low timeout since we don't want this to work anyway
+	target = NewAPIClient("http://0.0.0.0", "eu-west-1", "test-repo", testCredentials, client)
+
+	_, err = target.Get("test", "main")
+	test.MustFail(t, err, "Request supposed to error out but Get call exited successfully")
+
+	err = target.Commit(payload)
+	test.MustFail(t, err, "Request supposed to error out but Commit call exited successfully")
+}
+
+func TestLoadCredentials(t *testing.T) {
+	dir := t.TempDir()
+	credentialsFile := filepath.Join(dir, "credentials")
+	configFile := filepath.Join(dir, "config")
+	test.MustSucceed(t, os.WriteFile(credentialsFile, []byte("[default]\naws_access_key_id = default-key\naws_secret_access_key = default-secret\n\n[deploy]\naws_access_key_id=deploy-key\naws_secret_access_key=deploy-secret\naws_session_token=deploy-token\n"), 0600), "Failed writing credentials file")
+	test.MustSucceed(t, os.WriteFile(configFile, []byte("[default]\nregion = us-east-1\n\n[profile deploy]\nregion = eu-south-1\n\n[profile config-only]\naws_access_key_id = config-key\naws_secret_access_key = config-secret\n"), 0600), "Failed writing config file")
+
+	t.Setenv("AWS_ACCESS_KEY_ID", "")
+	t.Setenv("AWS_REGION", "")
+	t.Setenv("AWS_DEFAULT_REGION", "")
+	t.Setenv("AWS_PROFILE", "")
+	t.Setenv("AWS_SHARED_CREDENTIALS_FILE", credentialsFile)
+	t.Setenv("AWS_CONFIG_FILE", configFile)
+
+	credentials, err := LoadCredentials("")
+	test.MustSucceed(t, err, "Failed loading default credentials")
+	test.AssertExpected(t, credentials.AccessKeyID, "default-key", "Default profile access key doesn't match expected value")
+	region, err := LoadRegion("")
+	test.MustSucceed(t, err, "Failed loading default region")
+	test.AssertExpected(t, region, "us-east-1", "Default profile region doesn't match expected value")
+
+	t.Setenv("AWS_PROFILE", "deploy")
+	credentials, err = LoadCredentials("")
+	test.MustSucceed(t, err, "Failed loading profile credentials")
+	test.AssertExpected(t, credentials.SecretAccessKey, "deploy-secret", "Profile secret key doesn't match expected value")
+	test.AssertExpected(t, credentials.SessionToken, "deploy-token", "Profile session token doesn't match expected value")
+	region, err = LoadRegion("")
+	test.MustSucceed(t, err, "Failed loading profile region")
+	test.AssertExpected(t, region, "eu-south-1", "Profile region doesn't match expected value")
+
+	credentials, err = LoadCredentials("config-only")
+	test.MustSucceed(t, err, "Failed loading credentials from config file")
+	test.AssertExpected(t, credentials.AccessKeyID, "config-key", "Config file access key doesn't match expected value")
+
+	_, err = LoadCredentials("missing")
+	test.MustFail(t, err, "Loading credentials for a missing profile supposed to fail but succeeded")
+
+	// Environment variables take precedence
+	t.Setenv("AWS_ACCESS_KEY_ID", "env-key")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "env-secret")
+	t.Setenv("AWS_REGION", "ap-south-1")
+	credentials, err = LoadCredentials("deploy")
+	test.MustSucceed(t, err, "Failed loading credentials from environment")
+	test.AssertExpected(t, credentials.AccessKeyID, "env-key", "Environment access key doesn't match expected value")
+	region, err = LoadRegion("deploy")
+	test.MustSucceed(t, err, "Failed loading region from environment")
+	test.AssertExpected(t, region, "ap-south-1", "Environment region doesn't match expected value")
+}
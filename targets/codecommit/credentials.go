@@ -0,0 +1,147 @@
+package codecommit_target
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/neosperience/shipper/common"
+)
+
+var (
+	ErrNoCredentials = common.Categorize(common.ErrAuth, errors.New("no AWS credentials found"))
+)
+
+// Credentials are AWS access keys, with an optional session token for temporary credentials
+type Credentials struct {
+	AccessKeyID     string
+	SecretAccessKey string
+	SessionToken    string
+}
+
+// LoadCredentials retrieves AWS credentials the same way the AWS CLI does: first from the
+// AWS_ACCESS_KEY_ID/AWS_SECRET_ACCESS_KEY/AWS_SESSION_TOKEN environment variables, then from the
+// shared credentials and config files for the given profile (AWS_PROFILE or "default" if empty)
+func LoadCredentials(profile string) (Credentials, error) {
+	if accessKey := os.Getenv("AWS_ACCESS_KEY_ID"); accessKey != "" {
+		return Credentials{
+			AccessKeyID:     accessKey,
+			SecretAccessKey: os.Getenv("AWS_SECRET_ACCESS_KEY"),
+			SessionToken:    os.Getenv("AWS_SESSION_TOKEN"),
+		}, nil
+	}
+
+	profile = profileName(profile)
+	for _, file := range []struct {
+		path    string
+		section string
+	}{
+		{sharedFilePath("AWS_SHARED_CREDENTIALS_FILE", "credentials"), profile},
+		{sharedFilePath("AWS_CONFIG_FILE", "config"), configSection(profile)},
+	} {
+		values, err := readProfile(file.path, file.section)
+		if err != nil {
+			return Credentials{}, err
+		}
+		if values["aws_access_key_id"] != "" {
+			return Credentials{
+				AccessKeyID:     values["aws_access_key_id"],
+				SecretAccessKey: values["aws_secret_access_key"],
+				SessionToken:    values["aws_session_token"],
+			}, nil
+		}
+	}
+
+	return Credentials{}, fmt.Errorf("%w (profile %s)", ErrNoCredentials, profile)
+}
+
+// LoadRegion retrieves the AWS region from the AWS_REGION/AWS_DEFAULT_REGION environment variables
+// or the shared config file for the given profile
+func LoadRegion(profile string) (string, error) {
+	for _, env := range []string{"AWS_REGION", "AWS_DEFAULT_REGION"} {
+		if region := os.Getenv(env); region != "" {
+			return region, nil
+		}
+	}
+
+	values, err := readProfile(sharedFilePath("AWS_CONFIG_FILE", "config"), configSection(profileName(profile)))
+	if err != nil {
+		return "", err
+	}
+	return values["region"], nil
+}
+
+func profileName(profile string) string {
+	if profile != "" {
+		return profile
+	}
+	if env := os.Getenv("AWS_PROFILE"); env != "" {
+		return env
+	}
+	return "default"
+}
+
+// configSection returns the section name for a profile in the config file, where non-default profiles are prefixed
+func configSection(profile string) string {
+	if profile == "default" {
+		return profile
+	}
+	return "profile " + profile
+}
+
+func sharedFilePath(env string, name string) string {
+	if path := os.Getenv(env); path != "" {
+		return path
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(home, ".aws", name)
+}
+
+// readProfile reads the key/value pairs of a section of an INI-like AWS shared file.
+// Missing files are not an error, as all AWS shared files are optional.
+func readProfile(path string, section string) (map[string]string, error) {
+	values := make(map[string]string)
+	if path == "" {
+		return values, nil
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return values, nil
+		}
+		return nil, fmt.Errorf("error opening %s: %w", path, err)
+	}
+	defer file.Close()
+
+	current := ""
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") || strings.HasPrefix(line, ";") {
+			continue
+		}
+		if strings.HasPrefix(line, "[") && strings.HasSuffix(line, "]") {
+			current = strings.TrimSpace(line[1 : len(line)-1])
+			continue
+		}
+		if current != section {
+			continue
+		}
+		key, value, ok := strings.Cut(line, "=")
+		if !ok {
+			continue
+		}
+		values[strings.TrimSpace(key)] = strings.TrimSpace(value)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("error reading %s: %w", path, err)
+	}
+	return values, nil
+}
@@ -0,0 +1,131 @@
+package codecommit_target
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	signingAlgorithm = "AWS4-HMAC-SHA256"
+	amzDateFormat    = "20060102T150405Z"
+)
+
+// signRequest adds AWS Signature Version 4 headers (Authorization, X-Amz-Date and, for temporary
+// credentials, X-Amz-Security-Token) to the headers of a request
+func signRequest(method string, requestURI string, headers http.Header, body []byte, credentials Credentials, region string, service string, now time.Time) error {
+	parsed, err := url.Parse(requestURI)
+	if err != nil {
+		return fmt.Errorf("error parsing request URI: %w", err)
+	}
+
+	amzDate := now.UTC().Format(amzDateFormat)
+	date := amzDate[:8]
+	headers.Set("X-Amz-Date", amzDate)
+	if credentials.SessionToken != "" {
+		headers.Set("X-Amz-Security-Token", credentials.SessionToken)
+	}
+
+	// Canonical headers, host is always signed
+	canonical := map[string]string{
+		"host": parsed.Host,
+	}
+	for name, values := range headers {
+		trimmed := make([]string, len(values))
+		for index, value := range values {
+			trimmed[index] = strings.Join(strings.Fields(value), " ")
+		}
+		canonical[strings.ToLower(name)] = strings.Join(trimmed, ",")
+	}
+	names := make([]string, 0, len(canonical))
+	for name := range canonical {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	canonicalHeaders := ""
+	for _, name := range names {
+		canonicalHeaders += name + ":" + canonical[name] + "\n"
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	payloadHash := sha256.Sum256(body)
+	canonicalRequest := strings.Join([]string{
+		method,
+		canonicalPath(parsed.EscapedPath()),
+		canonicalQuery(parsed.Query()),
+		canonicalHeaders,
+		signedHeaders,
+		hex.EncodeToString(payloadHash[:]),
+	}, "\n")
+
+	scope := strings.Join([]string{date, region, service, "aws4_request"}, "/")
+	requestHash := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := strings.Join([]string{
+		signingAlgorithm,
+		amzDate,
+		scope,
+		hex.EncodeToString(requestHash[:]),
+	}, "\n")
+
+	key := hmacSHA256([]byte("AWS4"+credentials.SecretAccessKey), date)
+	key = hmacSHA256(key, region)
+	key = hmacSHA256(key, service)
+	key = hmacSHA256(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	headers.Set("Authorization", fmt.Sprintf("%s Credential=%s/%s, SignedHeaders=%s, Signature=%s", signingAlgorithm, credentials.AccessKeyID, scope, signedHeaders, signature))
+	return nil
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+
+// canonicalPath normalizes an escaped URL path as required by SigV4 for non-S3 services
+func canonicalPath(path string) string {
+	if path == "" {
+		return "/"
+	}
+	segments := strings.Split(path, "/")
+	for index, segment := range segments {
+		unescaped, err := url.PathUnescape(segment)
+		if err != nil {
+			unescaped = segment
+		}
+		segments[index] = uriEncode(uriEncode(unescaped))
+	}
+	return strings.Join(segments, "/")
+}
+
+// canonicalQuery encodes query parameters sorted by name and value
+func canonicalQuery(query url.Values) string {
+	pairs := []string{}
+	for name, values := range query {
+		for _, value := range values {
+			pairs = append(pairs, uriEncode(name)+"="+uriEncode(value))
+		}
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, "&")
+}
+
+// uriEncode percent-encodes everything except RFC 3986 unreserved characters
+func uriEncode(value string) string {
+	builder := strings.Builder{}
+	for _, b := range []byte(value) {
+		if (b >= 'A' && b <= 'Z') || (b >= 'a' && b <= 'z') || (b >= '0' && b <= '9') || b == '-' || b == '_' || b == '.' || b == '~' {
+			builder.WriteByte(b)
+		} else {
+			fmt.Fprintf(&builder, "%%%02X", b)
+		}
+	}
+	return builder.String()
+}
@@ -0,0 +1,38 @@
+package codecommit_target
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/neosperience/shipper/test"
+)
+
+// Test vectors from the AWS Signature Version 4 test suite
+var testCredentials = Credentials{
+	AccessKeyID:     "AKIDEXAMPLE",
+	SecretAccessKey: "wJalrXUtnFEMI/K7MDENG+bPxRfiCYEXAMPLEKEY",
+}
+
+func TestSignRequestVanilla(t *testing.T) {
+	now := time.Date(2015, 8, 30, 12, 36, 0, 0, time.UTC)
+
+	headers := make(http.Header)
+	test.MustSucceed(t, signRequest("GET", "https://example.amazonaws.com/", headers, nil, testCredentials, "us-east-1", "service", now), "Failed signing request")
+	test.AssertExpected(t, headers.Get("X-Amz-Date"), "20150830T123600Z", "X-Amz-Date doesn't match expected value")
+	test.AssertExpected(t, headers.Get("Authorization"), "AWS4-HMAC-SHA256 Credential=AKIDEXAMPLE/20150830/us-east-1/service/aws4_request, SignedHeaders=host;x-amz-date, Signature=5fa00fa31553b73ebf1942676e86291e8372ff2a2260956d9b8aae1d763fbf31", "Authorization header doesn't match expected value")
+
+	headers = make(http.Header)
+	test.MustSucceed(t, signRequest("GET", "https://example.amazonaws.com/?Param2=value2&Param1=value1", headers, nil, testCredentials, "us-east-1", "service", now), "Failed signing request")
+	test.AssertExpected(t, headers.Get("Authorization"), "AWS4-HMAC-SHA256 Credential=AKIDEXAMPLE/20150830/us-east-1/service/aws4_request, SignedHeaders=host;x-amz-date, Signature=b97d918cfa904a5beff61c982a1b6f458b799221646efd99d3219ec94cdf2500", "Authorization header doesn't match expected value")
+}
+
+func TestSignRequestSessionToken(t *testing.T) {
+	now := time.Date(2015, 8, 30, 12, 36, 0, 0, time.UTC)
+	credentials := testCredentials
+	credentials.SessionToken = "test-session-token"
+
+	headers := make(http.Header)
+	test.MustSucceed(t, signRequest("POST", "https://example.amazonaws.com/", headers, []byte("{}"), credentials, "us-east-1", "service", now), "Failed signing request")
+	test.AssertExpected(t, headers.Get("X-Amz-Security-Token"), "test-session-token", "X-Amz-Security-Token doesn't match expected value")
+}
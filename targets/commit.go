@@ -3,6 +3,7 @@ package targets
 import (
 	"errors"
 	"fmt"
+	"sort"
 	"strings"
 )
 
@@ -49,6 +50,16 @@ func (list FileList) Add(files FileList) error {
 	return nil
 }
 
+// Names returns the sorted paths of the files in the list
+func (list FileList) Names() []string {
+	names := make([]string, 0, len(list))
+	for name := range list {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
 // SplitAuthor splits the Author field to return a tuple of (name, email) fields
 // Since the field is quite dynamic, either field could be empty
 func (payload *CommitPayload) SplitAuthor() (string, string) {
@@ -73,3 +73,11 @@ func TestPayloadString(t *testing.T) {
 		t.Fatal("file name not found in commit string")
 	}
 }
+
+func TestFileListNames(t *testing.T) {
+	list := targets.FileList{
+		"values.yaml":             []byte("a"),
+		"base/kustomization.yaml": []byte("b"),
+	}
+	test.AssertExpected(t, strings.Join(list.Names(), ","), "base/kustomization.yaml,values.yaml", "File names don't match expected value")
+}
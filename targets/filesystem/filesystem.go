@@ -0,0 +1,246 @@
+package filesystem_target
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/neosperience/shipper/common"
+	"github.com/neosperience/shipper/logging"
+	"github.com/neosperience/shipper/targets"
+)
+
+var (
+	ErrPathOutsideRoot = common.Categorize(common.ErrValidation, errors.New("path is outside of the root directory"))
+)
+
+// FilesystemRepository edits files in a local directory, such as the working tree of a cloned repository
+type FilesystemRepository struct {
+	root string
+
+	// If true, written files are staged with "git add" so they can be committed externally
+	stage bool
+}
+
+// NewFilesystemRepository creates a FilesystemRepository instance rooted at the given directory
+func NewFilesystemRepository(root string) *FilesystemRepository {
+	return &FilesystemRepository{
+		root: root,
+	}
+}
+
+// SetStaging makes Commit stage written files in the Git index of the working tree root is in
+func (f *FilesystemRepository) SetStaging(stage bool) {
+	f.stage = stage
+}
+
+// resolve returns the location of a repository path on disk, making sure it doesn't escape the root directory,
+// including through symlinks
+func (f *FilesystemRepository) resolve(path string) (string, error) {
+	if filepath.IsAbs(path) {
+		return "", fmt.Errorf("%w: %s", ErrPathOutsideRoot, path)
+	}
+	clean := filepath.Clean(filepath.FromSlash(path))
+	if !isRelativeInside(clean) {
+		return "", fmt.Errorf("%w: %s", ErrPathOutsideRoot, path)
+	}
+	location := filepath.Join(f.root, clean)
+
+	root, err := filepath.EvalSymlinks(f.root)
+	if errors.Is(err, fs.ErrNotExist) {
+		// Nothing in a missing root can be a symlink
+		return location, nil
+	}
+	if err != nil {
+		return "", fmt.Errorf("error resolving root directory: %w", err)
+	}
+	// Files may not exist yet, the closest existing parent is checked instead as they'll be created in it
+	for existing := location; ; existing = filepath.Dir(existing) {
+		resolved, err := filepath.EvalSymlinks(existing)
+		if errors.Is(err, fs.ErrNotExist) {
+			// Broken symlinks would be followed when writing the file
+			if _, lstatErr := os.Lstat(existing); lstatErr == nil {
+				return "", fmt.Errorf("%w: %s is a broken symlink", ErrPathOutsideRoot, path)
+			}
+			continue
+		}
+		if err != nil {
+			return "", fmt.Errorf("error resolving %s: %w", path, err)
+		}
+		relative, err := filepath.Rel(root, resolved)
+		if err != nil || !isRelativeInside(relative) {
+			return "", fmt.Errorf("%w: %s", ErrPathOutsideRoot, path)
+		}
+		return location, nil
+	}
+}
+
+// isRelativeInside checks whether a clean relative path stays in the directory it's relative to
+func isRelativeInside(path string) bool {
+	return path != ".." && !strings.HasPrefix(path, ".."+string(filepath.Separator))
+}
+
+func (f *FilesystemRepository) Get(path string, ref string) ([]byte, error) {
+	// ref is ignored, files are always read from the working tree
+	location, err := f.resolve(path)
+	if err != nil {
+		return nil, err
+	}
+
+	byt, err := os.ReadFile(location)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil, targets.ErrFileNotFound
+		}
+		return nil, fmt.Errorf("error reading file: %w", err)
+	}
+	return byt, nil
+}
+
+func (f *FilesystemRepository) Commit(payload *targets.CommitPayload) error {
+	// Sort files for a predictable order of operations
+	paths := make([]string, 0, len(payload.Files))
+	for path := range payload.Files {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+
+	for _, path := range paths {
+		location, err := f.resolve(path)
+		if err != nil {
+			return err
+		}
+		err = writeAtomic(location, payload.Files[path])
+		if err != nil {
+			return fmt.Errorf("error writing file %s: %w", path, err)
+		}
+		logging.Info("file written", "file", location)
+	}
+
+	if f.stage && len(paths) > 0 {
+		cmd := exec.Command("git", append([]string{"add", "--"}, paths...)...)
+		cmd.Dir = f.root
+		output, err := cmd.CombinedOutput()
+		if err != nil {
+			return fmt.Errorf("error staging files: %w: %s", err, strings.TrimSpace(string(output)))
+		}
+	}
+
+	return nil
+}
+
+// ResolveCommit returns the commit checked out in the working tree root is in, ref is ignored as files
+// are always read from the working tree. targets.ErrUnsupported is returned if root is not in a Git working
+// tree with commits.
+func (f *FilesystemRepository) ResolveCommit(ref string) (string, error) {
+	cmd := exec.Command("git", "rev-parse", "--verify", "HEAD")
+	cmd.Dir = f.root
+	output, err := cmd.CombinedOutput()
+	if err != nil {
+		return "", fmt.Errorf("%w: %s", targets.ErrUnsupported, strings.TrimSpace(string(output)))
+	}
+	return strings.TrimSpace(string(output)), nil
+}
+
+// History returns the commits changing path in the history of the commit checked out in the working tree
+// root is in, ref is ignored like in ResolveCommit. targets.ErrUnsupported is returned if root is not in a
+// Git working tree.
+func (f *FilesystemRepository) History(path string, ref string, limit int) ([]targets.Commit, error) {
+	if _, err := f.resolve(path); err != nil {
+		return nil, err
+	}
+
+	// Fields are separated by the ASCII unit separator and commits by the record separator
+	cmd := exec.Command("git", "log", fmt.Sprintf("--max-count=%d", limit), "--format=%H%x1f%an <%ae>%x1f%cI%x1f%B%x1e", "--", path)
+	cmd.Dir = f.root
+	output, err := cmd.CombinedOutput()
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", targets.ErrUnsupported, strings.TrimSpace(string(output)))
+	}
+
+	commits := []targets.Commit{}
+	for _, record := range strings.Split(string(output), "\x1e") {
+		fields := strings.Split(strings.TrimSpace(record), "\x1f")
+		if len(fields) != 4 {
+			continue
+		}
+		date, err := time.Parse(time.RFC3339, fields[2])
+		if err != nil {
+			return nil, fmt.Errorf("error parsing date of commit %s: %w", fields[0], err)
+		}
+		commits = append(commits, targets.Commit{
+			ID:      fields[0],
+			Author:  fields[1],
+			Date:    date,
+			Message: strings.TrimSpace(fields[3]),
+		})
+	}
+	return commits, nil
+}
+
+// GetRevision reads a file as it was at a commit of the Git repository root is in
+func (f *FilesystemRepository) GetRevision(path string, commitID string) ([]byte, error) {
+	if _, err := f.resolve(path); err != nil {
+		return nil, err
+	}
+
+	// The "./" prefix makes the path relative to root instead of the top of the working tree
+	cmd := exec.Command("git", "show", commitID+":./"+filepath.ToSlash(filepath.Clean(path)))
+	cmd.Dir = f.root
+	stderr := new(bytes.Buffer)
+	cmd.Stderr = stderr
+	output, err := cmd.Output()
+	if err != nil {
+		message := strings.TrimSpace(stderr.String())
+		if strings.Contains(message, "does not exist") || strings.Contains(message, "exists on disk, but not in") {
+			return nil, targets.ErrFileNotFound
+		}
+		return nil, fmt.Errorf("error reading file at commit %s: %w: %s", commitID, err, message)
+	}
+	return output, nil
+}
+
+// writeAtomic writes a file by renaming a temporary file in the same directory over it, so readers
+// never see a partially written file. The permissions of the existing file, if any, are preserved.
+func writeAtomic(location string, content []byte) error {
+	mode := fs.FileMode(0644)
+	if info, err := os.Stat(location); err == nil {
+		mode = info.Mode().Perm()
+	}
+
+	dir := filepath.Dir(location)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return fmt.Errorf("error creating directory: %w", err)
+	}
+
+	temp, err := os.CreateTemp(dir, "."+filepath.Base(location)+".shipper-*")
+	if err != nil {
+		return fmt.Errorf("error creating temporary file: %w", err)
+	}
+	// Clean up the temporary file if anything goes wrong, this is a no-op after the rename
+	defer os.Remove(temp.Name())
+
+	if _, err := temp.Write(content); err != nil {
+		temp.Close()
+		return fmt.Errorf("error writing temporary file: %w", err)
+	}
+	if err := temp.Sync(); err != nil {
+		temp.Close()
+		return fmt.Errorf("error syncing temporary file: %w", err)
+	}
+	if err := temp.Close(); err != nil {
+		return fmt.Errorf("error closing temporary file: %w", err)
+	}
+	if err := os.Chmod(temp.Name(), mode); err != nil {
+		return fmt.Errorf("error setting file permissions: %w", err)
+	}
+
+	return os.Rename(temp.Name(), location)
+}
@@ -0,0 +1,217 @@
+package filesystem_target
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/neosperience/shipper/targets"
+	"github.com/neosperience/shipper/test"
+)
+
+func TestCommit(t *testing.T) {
+	root := t.TempDir()
+	test.MustSucceed(t, os.WriteFile(filepath.Join(root, "script.sh"), []byte("old"), 0755), "Failed writing existing file")
+
+	commit := targets.NewPayload("test-branch", "test-author <author@example.com>", "Hello")
+	test.MustSucceed(t, commit.Files.Add(map[string][]byte{
+		"path/to/textfile.txt": []byte("test file"),
+		"binaryfile.jpg":       {0xff, 0xd8, 0xff, 0xe0},
+		"script.sh":            []byte("new"),
+	}), "Failed adding test files")
+
+	target := NewFilesystemRepository(root)
+	test.MustSucceed(t, target.Commit(commit), "Failed committing files")
+
+	for path, content := range commit.Files {
+		byt, err := os.ReadFile(filepath.Join(root, filepath.FromSlash(path)))
+		test.MustSucceed(t, err, "Failed reading written file")
+		if !bytes.Equal(byt, content) {
+			t.Fatalf("Content for %s doesn't match expected value", path)
+		}
+	}
+
+	// Existing permissions must be preserved
+	info, err := os.Stat(filepath.Join(root, "script.sh"))
+	test.MustSucceed(t, err, "Failed getting file info")
+	test.AssertExpected(t, info.Mode().Perm(), os.FileMode(0755), "File permissions were not preserved")
+
+	// No temporary files must be left behind
+	entries, err := os.ReadDir(root)
+	test.MustSucceed(t, err, "Failed listing root directory")
+	for _, entry := range entries {
+		if strings.Contains(entry.Name(), ".shipper-") {
+			t.Fatalf("Temporary file %s was left behind", entry.Name())
+		}
+	}
+}
+
+func TestCommitStaging(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git is not available")
+	}
+	root := t.TempDir()
+	output, err := exec.Command("git", "init", "-q", root).CombinedOutput()
+	test.MustSucceed(t, err, "Failed initializing repository: "+string(output))
+
+	commit := targets.NewPayload("test-branch", "test-author <author@example.com>", "Hello")
+	test.MustSucceed(t, commit.Files.Add(map[string][]byte{
+		"path/to/textfile.txt": []byte("test file"),
+	}), "Failed adding test files")
+
+	target := NewFilesystemRepository(root)
+	target.SetStaging(true)
+	test.MustSucceed(t, target.Commit(commit), "Failed committing files")
+
+	cmd := exec.Command("git", "diff", "--cached", "--name-only")
+	cmd.Dir = root
+	output, err = cmd.Output()
+	test.MustSucceed(t, err, "Failed listing staged files")
+	test.AssertExpected(t, strings.TrimSpace(string(output)), "path/to/textfile.txt", "Staged files don't match expected value")
+}
+
+func TestResolveCommit(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git is not available")
+	}
+	root := t.TempDir()
+	target := NewFilesystemRepository(root)
+	_, err := target.ResolveCommit("main")
+	test.AssertExpected(t, errors.Is(err, targets.ErrUnsupported), true, "Resolving a commit outside of a Git repository should be unsupported")
+
+	git := func(args ...string) string {
+		cmd := exec.Command("git", append([]string{"-c", "user.name=test", "-c", "user.email=test@example.com"}, args...)...)
+		cmd.Dir = root
+		output, err := cmd.CombinedOutput()
+		test.MustSucceed(t, err, "Failed running git: "+string(output))
+		return strings.TrimSpace(string(output))
+	}
+	git("init", "-q")
+	git("commit", "-q", "--allow-empty", "-m", "initial")
+
+	commitID, err := target.ResolveCommit("main")
+	test.MustSucceed(t, err, "Failed resolving commit")
+	test.AssertExpected(t, commitID, git("rev-parse", "HEAD"), "Resolved commit doesn't match the checked out one")
+}
+
+func TestHistory(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git is not available")
+	}
+	root := t.TempDir()
+	target := NewFilesystemRepository(filepath.Join(root, "deploy"))
+	_, err := target.History("values.yaml", "main", 10)
+	test.AssertExpected(t, errors.Is(err, targets.ErrUnsupported), true, "History outside of a Git repository should be unsupported")
+
+	git := func(args ...string) string {
+		cmd := exec.Command("git", append([]string{"-c", "user.name=test", "-c", "user.email=test@example.com"}, args...)...)
+		cmd.Dir = root
+		output, err := cmd.CombinedOutput()
+		test.MustSucceed(t, err, "Failed running git: "+string(output))
+		return strings.TrimSpace(string(output))
+	}
+	commit := func(content string, message string) {
+		test.MustSucceed(t, os.WriteFile(filepath.Join(root, "deploy", "values.yaml"), []byte(content), 0644), "Failed writing file")
+		test.MustSucceed(t, os.WriteFile(filepath.Join(root, "other.yaml"), []byte(message), 0644), "Failed writing file")
+		git("add", ".")
+		git("commit", "-q", "-m", message)
+	}
+	git("init", "-q")
+	git("commit", "-q", "--allow-empty", "-m", "initial")
+	test.MustSucceed(t, os.Mkdir(filepath.Join(root, "deploy"), 0755), "Failed creating directory")
+	commit("tag: 1.2.0", "Update api to 1.2.0")
+	first := git("rev-parse", "HEAD")
+	commit("tag: 1.3.0", "Update api to 1.3.0\n\nWith a body")
+
+	// The target is rooted in a subdirectory of the working tree
+	commits, err := target.History("values.yaml", "main", 10)
+	test.MustSucceed(t, err, "Failed retrieving history")
+	test.AssertExpected(t, len(commits), 2, "Only commits changing the file should be listed")
+	test.AssertExpected(t, commits[0].Message, "Update api to 1.3.0\n\nWith a body", "Commits should be newest first")
+	test.AssertExpected(t, commits[1].ID, first, "Commit ID doesn't match")
+	test.AssertExpected(t, commits[1].Author, "test <test@example.com>", "Commit author doesn't match")
+
+	commits, err = target.History("values.yaml", "main", 1)
+	test.MustSucceed(t, err, "Failed retrieving history")
+	test.AssertExpected(t, len(commits), 1, "History should be limited")
+
+	content, err := target.GetRevision("values.yaml", first)
+	test.MustSucceed(t, err, "Failed retrieving revision")
+	test.AssertExpected(t, string(content), "tag: 1.2.0", "Revision content doesn't match")
+
+	_, err = target.GetRevision("values.yaml", first+"~1")
+	test.AssertExpected(t, errors.Is(err, targets.ErrFileNotFound), true, "A file missing at a commit should not be found")
+}
+
+func TestGet(t *testing.T) {
+	root := t.TempDir()
+	testData := []byte("hello test here")
+	test.MustSucceed(t, os.MkdirAll(filepath.Join(root, "path", "to"), 0755), "Failed creating directory")
+	test.MustSucceed(t, os.WriteFile(filepath.Join(root, "path", "to", "file.yaml"), testData, 0644), "Failed writing test file")
+
+	target := NewFilesystemRepository(root)
+
+	byt, err := target.Get("path/to/file.yaml", "main")
+	test.MustSucceed(t, err, "Failed getting file")
+	if !bytes.Equal(byt, testData) {
+		t.Fatal("Expected file content is different from retrieved")
+	}
+
+	_, err = target.Get("missing.yaml", "main")
+	if err != targets.ErrFileNotFound {
+		t.Fatalf("Expected file not found error, got %v", err)
+	}
+}
+
+func TestPathOutsideRoot(t *testing.T) {
+	target := NewFilesystemRepository(t.TempDir())
+
+	for _, path := range []string{"../outside.yaml", "path/../../outside.yaml", "/etc/passwd"} {
+		_, err := target.Get(path, "main")
+		if !errors.Is(err, ErrPathOutsideRoot) {
+			t.Fatalf("Expected path outside root error for %s, got %v", path, err)
+		}
+
+		commit := targets.NewPayload("test-branch", "test-author <author@example.com>", "Hello")
+		test.MustSucceed(t, commit.Files.Add(map[string][]byte{path: []byte("test")}), "Failed adding test files")
+		err = target.Commit(commit)
+		if !errors.Is(err, ErrPathOutsideRoot) {
+			t.Fatalf("Expected path outside root error for %s, got %v", path, err)
+		}
+	}
+}
+
+func TestSymlinkOutsideRoot(t *testing.T) {
+	root, outside := t.TempDir(), t.TempDir()
+	test.MustSucceed(t, os.WriteFile(filepath.Join(outside, "values.yaml"), []byte("tag: 1.0.0"), 0644), "Failed writing file outside root")
+	test.MustSucceed(t, os.Symlink(outside, filepath.Join(root, "escape")), "Failed creating directory symlink")
+	test.MustSucceed(t, os.Symlink(filepath.Join(outside, "values.yaml"), filepath.Join(root, "values.yaml")), "Failed creating file symlink")
+	test.MustSucceed(t, os.Symlink(filepath.Join(outside, "missing.yaml"), filepath.Join(root, "broken.yaml")), "Failed creating broken symlink")
+	test.MustSucceed(t, os.Mkdir(filepath.Join(root, "inside"), 0755), "Failed creating directory")
+	test.MustSucceed(t, os.Symlink("inside", filepath.Join(root, "link")), "Failed creating symlink inside root")
+	target := NewFilesystemRepository(root)
+
+	for _, path := range []string{"escape/values.yaml", "escape/new/values.yaml", "values.yaml", "broken.yaml"} {
+		_, err := target.Get(path, "main")
+		test.AssertExpected(t, errors.Is(err, ErrPathOutsideRoot), true, "Symlinks should not escape the root for "+path)
+
+		commit := targets.NewPayload("test-branch", "test-author <author@example.com>", "Hello")
+		test.MustSucceed(t, commit.Files.Add(map[string][]byte{path: []byte("test")}), "Failed adding test files")
+		test.AssertExpected(t, errors.Is(target.Commit(commit), ErrPathOutsideRoot), true, "Symlinks should not escape the root for "+path)
+	}
+
+	commit := targets.NewPayload("test-branch", "test-author <author@example.com>", "Hello")
+	test.MustSucceed(t, commit.Files.Add(map[string][]byte{"link/values.yaml": []byte("test")}), "Failed adding test files")
+	test.MustSucceed(t, target.Commit(commit), "Symlinks inside the root should be followed")
+	byt, err := os.ReadFile(filepath.Join(root, "inside", "values.yaml"))
+	test.MustSucceed(t, err, "Failed reading file written through symlink")
+	test.AssertExpected(t, string(byt), "test", "File written through symlink doesn't match")
+
+	byt, err = os.ReadFile(filepath.Join(outside, "values.yaml"))
+	test.MustSucceed(t, err, "Failed reading file outside root")
+	test.AssertExpected(t, string(byt), "tag: 1.0.0", "Files outside the root should not be written")
+}
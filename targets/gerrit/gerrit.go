@@ -0,0 +1,339 @@
+package gerrit_target
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	jsoniter "github.com/json-iterator/go"
+
+	"github.com/neosperience/shipper/common"
+	"github.com/neosperience/shipper/logging"
+	"github.com/neosperience/shipper/targets"
+)
+
+// Prefix added by Gerrit to all JSON responses to prevent XSSI
+const magicPrefix = ")]}'"
+
+// GerritRepository uploads changes for review to a Gerrit project using the Gerrit REST change edit APIs
+type GerritRepository struct {
+	baseURI string
+	project string
+
+	// Votes to apply to the uploaded change, eg. "Code-Review": 2
+	labels map[string]int
+	// If true, the change is submitted after voting, if Gerrit allows it
+	submit bool
+
+	client *common.Client
+}
+
+// NewAPIClient creates a GerritRepository instance, credentials must be in "username:http-password" format
+func NewAPIClient(uri string, project string, credentials string, client *http.Client) *GerritRepository {
+	return &GerritRepository{
+		baseURI: strings.TrimSuffix(uri, "/"),
+		project: project,
+		client:  common.NewClient(client, common.BasicAuth(credentials)),
+	}
+}
+
+// SetReview makes Commit vote on the uploaded change with the given labels and, if submit is true,
+// submit it when all submit requirements are met
+func (ge *GerritRepository) SetReview(labels map[string]int, submit bool) {
+	ge.labels = labels
+	ge.submit = submit
+}
+
+// ParseLabels parses votes in "Label=value" format (eg. "Code-Review=+2")
+func ParseLabels(votes []string) (map[string]int, error) {
+	labels := make(map[string]int)
+	for _, vote := range votes {
+		label, value, ok := strings.Cut(vote, "=")
+		if !ok || label == "" {
+			return nil, fmt.Errorf("invalid vote %q, must be in \"Label=value\" format", vote)
+		}
+		score, err := strconv.Atoi(strings.TrimPrefix(value, "+"))
+		if err != nil {
+			return nil, fmt.Errorf("invalid score for label %s: %w", label, err)
+		}
+		labels[label] = score
+	}
+	return labels, nil
+}
+
+func (ge *GerritRepository) doRequest(method string, path string, body io.Reader, headers http.Header) (*http.Response, error) {
+	// Authenticated endpoints are under /a/
+	return ge.client.Request(method, ge.baseURI+"/a"+path, body, headers)
+}
+
+// doJSONRequest performs a request with a JSON payload and decodes the JSON response into output, if not nil
+func (ge *GerritRepository) doJSONRequest(method string, path string, input any, output any) error {
+	b := new(bytes.Buffer)
+	if input != nil {
+		err := jsoniter.ConfigFastest.NewEncoder(b).Encode(input)
+		if err != nil {
+			return fmt.Errorf("error encoding request payload: %w", err)
+		}
+	}
+
+	res, err := ge.doRequest(method, path, b, http.Header{
+		"Content-Type": []string{"application/json"},
+	})
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if output == nil {
+		return nil
+	}
+	return decodeResponse(res.Body, output)
+}
+
+// decodeResponse decodes a Gerrit JSON response, stripping the XSSI protection prefix
+func decodeResponse(body io.Reader, output any) error {
+	reader := bufio.NewReader(body)
+	prefix, err := reader.Peek(len(magicPrefix))
+	if err == nil && string(prefix) == magicPrefix {
+		_, _ = reader.ReadString('\n')
+	}
+
+	err = jsoniter.ConfigFastest.NewDecoder(reader).Decode(output)
+	if err != nil {
+		return fmt.Errorf("error decoding response: %w", err)
+	}
+	return nil
+}
+
+func (ge *GerritRepository) Get(path string, ref string) ([]byte, error) {
+	requestPath := fmt.Sprintf("/projects/%s/branches/%s/files/%s/content", url.PathEscape(ge.project), url.PathEscape(ref), url.PathEscape(path))
+	res, err := ge.doRequest("GET", requestPath, nil, nil)
+	if err != nil {
+		if isNotFound(res) {
+			return nil, targets.ErrFileNotFound
+		}
+		return nil, fmt.Errorf("error getting file: %w", err)
+	}
+	defer res.Body.Close()
+
+	// File contents are returned base64-encoded
+	return ioutil.ReadAll(base64.NewDecoder(base64.StdEncoding, res.Body))
+}
+
+func (ge *GerritRepository) ResolveCommit(ref string) (string, error) {
+	var branch struct {
+		Revision string `json:"revision"`
+	}
+	err := ge.doJSONRequest("GET", fmt.Sprintf("/projects/%s/branches/%s", url.PathEscape(ge.project), url.PathEscape(ref)), nil, &branch)
+	if err != nil {
+		return "", fmt.Errorf("error getting branch: %w", err)
+	}
+	return branch.Revision, nil
+}
+
+// maxHistoryWalk is the maximum number of commits History goes through, Gerrit can't list the commits changing
+// a file so its content is compared at every commit of the branch
+const maxHistoryWalk = 1000
+
+// History walks the first-parent history of ref, returning the commits that changed path
+func (ge *GerritRepository) History(path string, ref string, limit int) ([]targets.Commit, error) {
+	commitID, err := ge.ResolveCommit(ref)
+	if err != nil {
+		return nil, err
+	}
+
+	commits := []targets.Commit{}
+	content, err := ge.GetRevision(path, commitID)
+	if err != nil && err != targets.ErrFileNotFound {
+		return nil, err
+	}
+	for walked := 0; commitID != "" && len(commits) < limit && walked < maxHistoryWalk; walked++ {
+		var commit commitInfo
+		err := ge.doJSONRequest("GET", fmt.Sprintf("/projects/%s/commits/%s", url.PathEscape(ge.project), url.PathEscape(commitID)), nil, &commit)
+		if err != nil {
+			return nil, fmt.Errorf("error getting commit: %w", err)
+		}
+
+		parent := ""
+		var parentContent []byte
+		if len(commit.Parents) > 0 {
+			parent = commit.Parents[0].Commit
+			parentContent, err = ge.GetRevision(path, parent)
+			if err != nil && err != targets.ErrFileNotFound {
+				return nil, err
+			}
+		}
+		if !bytes.Equal(content, parentContent) {
+			date, _ := time.Parse(gerritDateFormat, commit.Committer.Date)
+			commits = append(commits, targets.Commit{
+				ID:      commitID,
+				Author:  fmt.Sprintf("%s <%s>", commit.Author.Name, commit.Author.Email),
+				Date:    date,
+				Message: strings.TrimSpace(commit.Message),
+			})
+		}
+		commitID, content = parent, parentContent
+	}
+	return commits, nil
+}
+
+func (ge *GerritRepository) GetRevision(path string, commitID string) ([]byte, error) {
+	requestPath := fmt.Sprintf("/projects/%s/commits/%s/files/%s/content", url.PathEscape(ge.project), url.PathEscape(commitID), url.PathEscape(path))
+	res, err := ge.doRequest("GET", requestPath, nil, nil)
+	if err != nil {
+		if isNotFound(res) {
+			return nil, targets.ErrFileNotFound
+		}
+		return nil, fmt.Errorf("error getting file: %w", err)
+	}
+	defer res.Body.Close()
+
+	// File contents are returned base64-encoded
+	return ioutil.ReadAll(base64.NewDecoder(base64.StdEncoding, res.Body))
+}
+
+func (ge *GerritRepository) Commit(payload *targets.CommitPayload) error {
+	// Create an empty change on the target branch, files are added to it through a change edit.
+	// Publishing the edit keeps the author of the change's first commit.
+	input := changeInput{
+		Project: ge.project,
+		Branch:  payload.Branch,
+		Subject: payload.Message,
+	}
+	// Gerrit needs both the name and email of an author
+	if name, email := payload.SplitAuthor(); name != "" && email != "" {
+		input.Author = &accountInput{Name: name, Email: email}
+	}
+	var change changeInfo
+	err := ge.doJSONRequest("POST", "/changes/", input, &change)
+	if err != nil {
+		return fmt.Errorf("error creating change: %w", err)
+	}
+	changeID := fmt.Sprintf("%s~%d", url.PathEscape(ge.project), change.Number)
+
+	// Don't leave an empty change open if its files can't be added
+	if err := ge.edit(changeID, payload.Files); err != nil {
+		if abandonErr := ge.doJSONRequest("POST", fmt.Sprintf("/changes/%s/abandon", changeID), abandonInput{Message: "Abandoned by Shipper: " + err.Error()}, nil); abandonErr != nil {
+			logging.Warn("error abandoning change", "change", change.Number, "error", abandonErr)
+		}
+		return err
+	}
+
+	logging.Info("change created", "change", change.Number, "url", fmt.Sprintf("%s/c/%s/+/%d", ge.baseURI, ge.project, change.Number))
+
+	if len(ge.labels) > 0 {
+		err = ge.doJSONRequest("POST", fmt.Sprintf("/changes/%s/revisions/current/review", changeID), reviewInput{Labels: ge.labels}, nil)
+		if err != nil {
+			return fmt.Errorf("error voting on change: %w", err)
+		}
+	}
+
+	if ge.submit {
+		var status changeInfo
+		err = ge.doJSONRequest("GET", fmt.Sprintf("/changes/%s?o=SUBMITTABLE", changeID), nil, &status)
+		if err != nil {
+			return fmt.Errorf("error getting change status: %w", err)
+		}
+		if !status.Submittable {
+			logging.Warn("change does not meet its submit requirements yet, leaving it open for review", "change", change.Number)
+			return nil
+		}
+
+		err = ge.doJSONRequest("POST", fmt.Sprintf("/changes/%s/submit", changeID), nil, nil)
+		if err != nil {
+			return fmt.Errorf("error submitting change: %w", err)
+		}
+		logging.Info("change submitted", "change", change.Number)
+	}
+
+	return nil
+}
+
+// edit adds files to a change through a change edit, then publishes it as a new patch set
+func (ge *GerritRepository) edit(changeID string, files targets.FileList) error {
+	// Sort files for a predictable order of operations
+	paths := make([]string, 0, len(files))
+	for path := range files {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+
+	for _, path := range paths {
+		res, err := ge.doRequest("PUT", fmt.Sprintf("/changes/%s/edit/%s", changeID, url.PathEscape(path)), bytes.NewReader(files[path]), http.Header{
+			"Content-Type": []string{"application/octet-stream"},
+		})
+		if err != nil {
+			return fmt.Errorf("error editing file %s: %w", path, err)
+		}
+		res.Body.Close()
+	}
+
+	err := ge.doJSONRequest("POST", fmt.Sprintf("/changes/%s/edit:publish", changeID), nil, nil)
+	if err != nil {
+		return fmt.Errorf("error publishing change edit: %w", err)
+	}
+	return nil
+}
+
+type changeInput struct {
+	Project string        `json:"project"`
+	Branch  string        `json:"branch"`
+	Subject string        `json:"subject"`
+	Author  *accountInput `json:"author,omitempty"`
+}
+
+type accountInput struct {
+	Name  string `json:"name"`
+	Email string `json:"email"`
+}
+
+type changeInfo struct {
+	ID          string `json:"id"`
+	ChangeID    string `json:"change_id"`
+	Number      int    `json:"_number"`
+	Status      string `json:"status"`
+	Submittable bool   `json:"submittable"`
+}
+
+// Timestamps are in UTC, in this format
+const gerritDateFormat = "2006-01-02 15:04:05.000000000"
+
+type gitPerson struct {
+	Name  string `json:"name"`
+	Email string `json:"email"`
+	Date  string `json:"date"`
+}
+
+type parentInfo struct {
+	Commit string `json:"commit"`
+}
+
+type commitInfo struct {
+	Commit    string       `json:"commit"`
+	Parents   []parentInfo `json:"parents"`
+	Author    gitPerson    `json:"author"`
+	Committer gitPerson    `json:"committer"`
+	Subject   string       `json:"subject"`
+	Message   string       `json:"message"`
+}
+
+type abandonInput struct {
+	Message string `json:"message"`
+}
+
+type reviewInput struct {
+	Labels map[string]int `json:"labels"`
+}
+
+func isNotFound(res *http.Response) bool {
+	return res != nil && res.StatusCode == 404
+}
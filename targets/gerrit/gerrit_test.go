@@ -0,0 +1,263 @@
+package gerrit_target
+
+import (
+	"bytes"
+	"encoding/base64"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	jsoniter "github.com/json-iterator/go"
+	"github.com/neosperience/shipper/targets"
+	"github.com/neosperience/shipper/test"
+)
+
+const changePath = "/a/changes/org%2Fproject~42"
+
+func writeJSON(t *testing.T, rw http.ResponseWriter, data any) {
+	_, err := rw.Write([]byte(magicPrefix + "\n"))
+	test.MustSucceed(t, err, "Failed writing response prefix")
+	test.MustSucceed(t, jsoniter.ConfigFastest.NewEncoder(rw).Encode(data), "Failed writing response")
+}
+
+func TestCommit(t *testing.T) {
+	testKey := "user:http-password"
+	commit := targets.NewPayload("test-branch", "test-author <author@example.com>", "Hello")
+	test.MustSucceed(t, commit.Files.Add(map[string][]byte{
+		"path/to/textfile.txt": []byte("test file"),
+		"binaryfile.jpg":       {0xff, 0xd8, 0xff, 0xe0},
+	}), "Failed adding test files")
+
+	for _, submittable := range []bool{true, false} {
+		edited := make(map[string]bool)
+		published, voted, submitted := false, false, false
+
+		// Setup test HTTP server/client
+		server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+			// Check authorization key
+			test.AssertExpected(t, req.Header.Get("Authorization"), "Basic "+base64.StdEncoding.EncodeToString([]byte(testKey)), "Authorization header doesn't match expected value")
+
+			switch {
+			case req.Method == "POST" && req.URL.Path == "/a/changes/":
+				var input changeInput
+				test.MustSucceed(t, jsoniter.ConfigFastest.NewDecoder(req.Body).Decode(&input), "Failed decoding payload")
+				test.AssertExpected(t, input.Project, "org/project", "Change project doesn't match expected value")
+				test.AssertExpected(t, input.Branch, commit.Branch, "Change branch doesn't match expected value")
+				test.AssertExpected(t, input.Subject, commit.Message, "Change subject doesn't match commit message")
+				test.AssertExpected(t, input.Author.Name, "test-author", "Change author name doesn't match expected value")
+				test.AssertExpected(t, input.Author.Email, "author@example.com", "Change author email doesn't match expected value")
+				rw.WriteHeader(http.StatusCreated)
+				writeJSON(t, rw, changeInfo{Number: 42})
+			case req.Method == "PUT" && strings.HasPrefix(req.URL.EscapedPath(), changePath+"/edit/"):
+				file := strings.TrimPrefix(req.URL.Path, "/a/changes/org/project~42/edit/")
+				byt, err := ioutil.ReadAll(req.Body)
+				test.MustSucceed(t, err, "Failed reading file content")
+				if !bytes.Equal(byt, commit.Files[file]) {
+					t.Fatalf("Content for %s doesn't match expected value", file)
+				}
+				edited[file] = true
+				rw.WriteHeader(http.StatusNoContent)
+			case req.Method == "POST" && req.URL.EscapedPath() == changePath+"/edit:publish":
+				test.AssertExpected(t, len(edited), len(commit.Files), "Change edit published before all files were edited")
+				published = true
+				rw.WriteHeader(http.StatusNoContent)
+			case req.Method == "POST" && req.URL.EscapedPath() == changePath+"/revisions/current/review":
+				test.AssertExpected(t, published, true, "Vote cast before publishing the change edit")
+				var input reviewInput
+				test.MustSucceed(t, jsoniter.ConfigFastest.NewDecoder(req.Body).Decode(&input), "Failed decoding payload")
+				test.AssertExpected(t, input.Labels["Code-Review"], 2, "Code-Review vote doesn't match expected value")
+				test.AssertExpected(t, input.Labels["Verified"], 1, "Verified vote doesn't match expected value")
+				voted = true
+				writeJSON(t, rw, input)
+			case req.Method == "GET" && req.URL.EscapedPath() == changePath:
+				test.AssertExpected(t, req.URL.Query().Get("o"), "SUBMITTABLE", "Change query options don't match expected value")
+				writeJSON(t, rw, changeInfo{Number: 42, Submittable: submittable})
+			case req.Method == "POST" && req.URL.EscapedPath() == changePath+"/submit":
+				test.AssertExpected(t, voted, true, "Change submitted before voting")
+				submitted = true
+				writeJSON(t, rw, changeInfo{Number: 42, Status: "MERGED"})
+			default:
+				t.Fatalf("Unexpected request: %s %s", req.Method, req.URL.EscapedPath())
+			}
+		}))
+		target := NewAPIClient(server.URL, "org/project", testKey, server.Client())
+		labels, err := ParseLabels([]string{"Code-Review=+2", "Verified=1"})
+		test.MustSucceed(t, err, "Failed parsing labels")
+		target.SetReview(labels, true)
+
+		test.MustSucceed(t, target.Commit(commit), "Failed committing files")
+		server.Close()
+
+		test.AssertExpected(t, len(edited), len(commit.Files), "Not all files were edited")
+		test.AssertExpected(t, voted, true, "Votes were not cast")
+		test.AssertExpected(t, submitted, submittable, "Change submission doesn't match its submittable status")
+	}
+}
+
+func TestCommitAbandon(t *testing.T) {
+	commit := targets.NewPayload("test-branch", "test-author <author@example.com>", "Hello")
+	test.MustSucceed(t, commit.Files.Add(map[string][]byte{"values.yaml": []byte("tag: 1.0.0")}), "Failed adding test files")
+
+	abandoned := false
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		switch {
+		case req.Method == "POST" && req.URL.Path == "/a/changes/":
+			rw.WriteHeader(http.StatusCreated)
+			writeJSON(t, rw, changeInfo{Number: 42})
+		case req.Method == "PUT" && strings.HasPrefix(req.URL.EscapedPath(), changePath+"/edit/"):
+			http.Error(rw, "file too large", http.StatusBadRequest)
+		case req.Method == "POST" && req.URL.EscapedPath() == changePath+"/abandon":
+			var input abandonInput
+			test.MustSucceed(t, jsoniter.ConfigFastest.NewDecoder(req.Body).Decode(&input), "Failed decoding payload")
+			test.AssertExpected(t, strings.Contains(input.Message, "values.yaml"), true, "Abandon message should name the failure")
+			abandoned = true
+			writeJSON(t, rw, changeInfo{Number: 42, Status: "ABANDONED"})
+		default:
+			t.Fatalf("Unexpected request: %s %s", req.Method, req.URL.EscapedPath())
+		}
+	}))
+	defer server.Close()
+	target := NewAPIClient(server.URL, "org/project", "user:http-password", server.Client())
+
+	test.MustFail(t, target.Commit(commit), "Commit supposed to fail when files can't be edited")
+	test.AssertExpected(t, abandoned, true, "Change should be abandoned when files can't be edited")
+}
+
+func TestGet(t *testing.T) {
+	testData := []byte("hello test here")
+
+	// Setup test HTTP server/client
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		if req.URL.EscapedPath() != "/a/projects/org%2Fproject/branches/main/files/path%2Fto%2Ffile.yaml/content" {
+			http.Error(rw, "Not found", http.StatusNotFound)
+			return
+		}
+		_, err := rw.Write([]byte(base64.StdEncoding.EncodeToString(testData)))
+		test.MustSucceed(t, err, "Failed writing test data")
+	}))
+	defer server.Close()
+	target := NewAPIClient(server.URL, "org/project", "user:pass", server.Client())
+
+	byt, err := target.Get("path/to/file.yaml", "main")
+	test.MustSucceed(t, err, "Failed getting file")
+	if !bytes.Equal(byt, testData) {
+		t.Fatal("Expected file content is different from retrieved")
+	}
+
+	_, err = target.Get("missing.yaml", "main")
+	if err != targets.ErrFileNotFound {
+		t.Fatalf("Expected file not found error, got %v", err)
+	}
+}
+
+func TestResolveCommit(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		if req.URL.EscapedPath() != "/a/projects/org%2Fproject/branches/main" {
+			http.Error(rw, "Not found", http.StatusNotFound)
+			return
+		}
+		writeJSON(t, rw, map[string]string{"ref": "refs/heads/main", "revision": "main-commit-id"})
+	}))
+	defer server.Close()
+	target := NewAPIClient(server.URL, "org/project", "user:pass", server.Client())
+
+	commitID, err := target.ResolveCommit("main")
+	test.MustSucceed(t, err, "Failed resolving branch")
+	test.AssertExpected(t, commitID, "main-commit-id", "Resolved commit doesn't match")
+
+	_, err = target.ResolveCommit("missing")
+	test.MustFail(t, err, "Resolving a missing branch succeeded")
+}
+
+func TestHistory(t *testing.T) {
+	// c3 only changes another file, c2 updates values.yaml and c1 creates it
+	parents := map[string]string{"c3": "c2", "c2": "c1", "c1": "c0"}
+	contents := map[string]string{"c3": "tag: 1.3.0", "c2": "tag: 1.3.0", "c1": "tag: 1.2.0"}
+
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		path := strings.TrimPrefix(req.URL.EscapedPath(), "/a/projects/org%2Fproject/")
+		switch {
+		case path == "branches/main":
+			writeJSON(t, rw, map[string]string{"ref": "refs/heads/main", "revision": "c3"})
+		case strings.HasSuffix(path, "/files/values.yaml/content"):
+			content, ok := contents[strings.TrimSuffix(strings.TrimPrefix(path, "commits/"), "/files/values.yaml/content")]
+			if !ok {
+				http.Error(rw, "Not found", http.StatusNotFound)
+				return
+			}
+			_, _ = rw.Write([]byte(base64.StdEncoding.EncodeToString([]byte(content))))
+		case strings.HasPrefix(path, "commits/"):
+			commitID := strings.TrimPrefix(path, "commits/")
+			commit := commitInfo{
+				Commit:    commitID,
+				Author:    gitPerson{Name: "Jane", Email: "jane@example.com", Date: "2022-02-03 09:00:00.000000000"},
+				Committer: gitPerson{Name: "Jane", Email: "jane@example.com", Date: "2022-02-03 09:00:00.000000000"},
+				Message:   "Commit " + commitID + "\n",
+			}
+			if parent, ok := parents[commitID]; ok {
+				commit.Parents = append(commit.Parents, parentInfo{Commit: parent})
+			}
+			writeJSON(t, rw, commit)
+		default:
+			http.Error(rw, "Not found", http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+	target := NewAPIClient(server.URL, "org/project", "user:pass", server.Client())
+
+	commits, err := target.History("values.yaml", "main", 10)
+	test.MustSucceed(t, err, "Failed retrieving history")
+	test.AssertExpected(t, len(commits), 2, "Only commits changing the file should be listed")
+	test.AssertExpected(t, commits[0], targets.Commit{ID: "c2", Author: "Jane <jane@example.com>", Date: time.Date(2022, 2, 3, 9, 0, 0, 0, time.UTC), Message: "Commit c2"}, "Commit doesn't match")
+	test.AssertExpected(t, commits[1].ID, "c1", "Commits should be newest first")
+
+	content, err := target.GetRevision("values.yaml", "c1")
+	test.MustSucceed(t, err, "Failed retrieving revision")
+	test.AssertExpected(t, string(content), "tag: 1.2.0", "Revision content doesn't match")
+}
+
+func TestParseLabels(t *testing.T) {
+	labels, err := ParseLabels([]string{"Code-Review=+2", "Verified=-1", "Custom=0"})
+	test.MustSucceed(t, err, "Failed parsing labels")
+	test.AssertExpected(t, labels["Code-Review"], 2, "Code-Review vote doesn't match expected value")
+	test.AssertExpected(t, labels["Verified"], -1, "Verified vote doesn't match expected value")
+	test.AssertExpected(t, labels["Custom"], 0, "Custom vote doesn't match expected value")
+
+	_, err = ParseLabels([]string{"Code-Review"})
+	test.MustFail(t, err, "Parsing a vote without a score supposed to fail but succeeded")
+
+	_, err = ParseLabels([]string{"Code-Review=two"})
+	test.MustFail(t, err, "Parsing a non-numeric score supposed to fail but succeeded")
+}
+
+func TestFaultyServer(t *testing.T) {
+	// Mock server that just errors out
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		http.Error(rw, "Server error", http.StatusInternalServerError)
+	}))
+	defer server.Close()
+	payload := targets.NewPayload("test-branch", "test-author <author@example.com>", "Hello")
+
+	// Test with erroring server
+	target := NewAPIClient(server.URL, "org/project", "user:pass", server.Client())
+
+	_, err := target.Get("test", "main")
+	test.MustFail(t, err, "Request supposed to error out but Get call exited successfully")
+
+	err = target.Commit(payload)
+	test.MustFail(t, err, "Request supposed to error out but Commit call exited successfully")
+
+	// Test with unreachable target
+	client := server.Client()
+	client.Timeout = time.Millisecond // Set a low timeout since we don't want this to work anyway
+	target = NewAPIClient("http://0.0.0.0", "org/project", "user:pass", client)
+
+	_, err = target.Get("test", "main")
+	test.MustFail(t, err, "Request supposed to error out but Get call exited successfully")
+
+	err = target.Commit(payload)
+	test.MustFail(t, err, "Request supposed to error out but Commit call exited successfully")
+}
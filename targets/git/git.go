@@ -0,0 +1,542 @@
+package git_target
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/neosperience/shipper/common"
+	"github.com/neosperience/shipper/logging"
+	"github.com/neosperience/shipper/targets"
+)
+
+const (
+	agent = "shipper"
+)
+
+var (
+	ErrProtocolV2Unsupported = errors.New("server does not support git protocol v2")
+	ErrRefNotFound           = errors.New("ref not found")
+	ErrPushRejected          = common.Categorize(common.ErrPolicy, errors.New("push rejected"))
+	// ErrBranchMoved is a push rejection caused by the branch being updated after it was fetched
+	ErrBranchMoved = common.Categorize(common.ErrConflict, fmt.Errorf("%w: branch was updated concurrently", ErrPushRejected))
+
+	objectIDRegex = regexp.MustCompile("^[0-9a-f]{40}$")
+)
+
+// GitRepository commits to any Git server exposing the smart HTTP protocol
+type GitRepository struct {
+	baseURI string
+
+	// Objects fetched so far, indexed by commit ID. Objects are resolved lazily, so reads are serialized by mutex.
+	mutex   sync.Mutex
+	fetched map[string]objectStore
+
+	client *common.Client
+}
+
+// NewSmartHTTPClient creates a GitRepository instance using Basic auth, credentials must be in "username:password" format.
+// If client is nil, a default HTTP client is used.
+func NewSmartHTTPClient(uri string, credentials string, client *http.Client) *GitRepository {
+	var auth common.Authenticator
+	if credentials != "" {
+		auth = common.BasicAuth(credentials)
+	}
+	return newClient(uri, common.NewClient(client, auth))
+}
+
+// NewSmartHTTPTokenClient creates a GitRepository instance using a bearer token. If client is nil, a default HTTP client is used.
+func NewSmartHTTPTokenClient(uri string, token string, client *http.Client) *GitRepository {
+	return newClient(uri, common.NewClient(client, common.BearerAuth(token)))
+}
+
+func newClient(uri string, client *common.Client) *GitRepository {
+	return &GitRepository{
+		baseURI: strings.TrimSuffix(uri, "/"),
+		fetched: make(map[string]objectStore),
+		client:  client,
+	}
+}
+
+func (g *GitRepository) doRequest(method string, requestURI string, body io.Reader, headers http.Header) (*http.Response, error) {
+	// Some servers only speak the smart protocol to git clients
+	if headers == nil {
+		headers = make(http.Header)
+	}
+	headers.Set("User-Agent", "git/2.0 ("+agent+")")
+
+	return g.client.Request(method, requestURI, body, headers)
+}
+
+// uploadPackCommand sends a protocol v2 command to the git-upload-pack service
+func (g *GitRepository) uploadPackCommand(command string, args []string) (*pktLineReader, io.Closer, error) {
+	req := new(pktLineWriter)
+	req.WriteLine("command=%s", command)
+	req.WriteLine("agent=%s", agent)
+	req.WriteLine("object-format=sha1")
+	req.Delim()
+	for _, arg := range args {
+		req.WriteLine("%s", arg)
+	}
+	req.Flush()
+
+	res, err := g.doRequest("POST", g.baseURI+"/git-upload-pack", bytes.NewReader(req.Bytes()), http.Header{
+		"Content-Type": {"application/x-git-upload-pack-request"},
+		"Accept":       {"application/x-git-upload-pack-result"},
+		"Git-Protocol": {"version=2"},
+	})
+	if err != nil {
+		return nil, nil, fmt.Errorf("error performing %s: %w", command, err)
+	}
+	return newPktLineReader(res.Body), res.Body, nil
+}
+
+// capabilities retrieves the protocol v2 capability advertisement of git-upload-pack
+func (g *GitRepository) capabilities() (map[string]string, error) {
+	res, err := g.doRequest("GET", g.baseURI+"/info/refs?service=git-upload-pack", nil, http.Header{
+		"Git-Protocol": {"version=2"},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error retrieving capabilities: %w", err)
+	}
+	defer res.Body.Close()
+
+	reader := newPktLineReader(res.Body)
+	line, kind, err := reader.NextLine()
+	if err != nil {
+		return nil, fmt.Errorf("error reading capabilities: %w", err)
+	}
+	// Some servers prefix the smart HTTP service announcement
+	if strings.HasPrefix(line, "# service=") {
+		if _, _, err := reader.Next(); err != nil {
+			return nil, fmt.Errorf("error reading capabilities: %w", err)
+		}
+		line, kind, err = reader.NextLine()
+		if err != nil {
+			return nil, fmt.Errorf("error reading capabilities: %w", err)
+		}
+	}
+	if kind != pktData || line != "version 2" {
+		return nil, ErrProtocolV2Unsupported
+	}
+
+	capabilities := make(map[string]string)
+	for {
+		line, kind, err := reader.NextLine()
+		if err != nil {
+			return nil, fmt.Errorf("error reading capabilities: %w", err)
+		}
+		if kind != pktData {
+			break
+		}
+		key, value, _ := strings.Cut(line, "=")
+		capabilities[key] = value
+	}
+	return capabilities, nil
+}
+
+// resolveRef finds the commit ID a branch, tag or commit ID points to
+func (g *GitRepository) resolveRef(ref string) (string, error) {
+	if objectIDRegex.MatchString(ref) {
+		return ref, nil
+	}
+
+	candidates := []string{ref}
+	if !strings.HasPrefix(ref, "refs/") {
+		candidates = []string{"refs/heads/" + ref, "refs/tags/" + ref}
+	}
+	args := []string{"peel"}
+	for _, candidate := range candidates {
+		args = append(args, "ref-prefix "+candidate)
+	}
+
+	reader, closer, err := g.uploadPackCommand("ls-refs", args)
+	if err != nil {
+		return "", err
+	}
+	defer closer.Close()
+
+	refs := make(map[string]string)
+	for {
+		line, kind, err := reader.NextLine()
+		if err != nil {
+			return "", fmt.Errorf("error reading ref list: %w", err)
+		}
+		if kind != pktData {
+			break
+		}
+		// Format is "<oid> <name> [attributes...]", peeled tags point to the commit
+		fields := strings.Fields(line)
+		if len(fields) < 2 {
+			continue
+		}
+		id := fields[0]
+		for _, attribute := range fields[2:] {
+			if peeled := strings.TrimPrefix(attribute, "peeled:"); peeled != attribute {
+				id = peeled
+			}
+		}
+		refs[fields[1]] = id
+	}
+
+	for _, candidate := range candidates {
+		if id, ok := refs[candidate]; ok {
+			return id, nil
+		}
+	}
+	return "", fmt.Errorf("%w: %s", ErrRefNotFound, ref)
+}
+
+// fetch downloads the objects needed to read the tree of a commit
+func (g *GitRepository) fetch(commitID string) (objectStore, error) {
+	if store, ok := g.fetched[commitID]; ok {
+		return store, nil
+	}
+
+	store, err := g.fetchHistory(commitID, 1)
+	if err != nil {
+		return nil, err
+	}
+	g.fetched[commitID] = store
+	return store, nil
+}
+
+// fetchHistory downloads the objects needed to read the trees of a commit and of its ancestors, up to depth
+// commits deep. Servers not supporting shallow fetches send the whole history.
+func (g *GitRepository) fetchHistory(commitID string, depth int) (objectStore, error) {
+	capabilities, err := g.capabilities()
+	if err != nil {
+		return nil, err
+	}
+
+	args := []string{"no-progress", "ofs-delta"}
+	// Only ask for the needed part of the history, if the server supports it
+	if strings.Contains(" "+capabilities["fetch"]+" ", " shallow ") {
+		args = append(args, fmt.Sprintf("deepen %d", depth))
+	}
+	args = append(args, "want "+commitID, "done")
+
+	reader, closer, err := g.uploadPackCommand("fetch", args)
+	if err != nil {
+		return nil, err
+	}
+	defer closer.Close()
+
+	// Skip to the packfile section
+	for {
+		line, kind, err := reader.NextLine()
+		if err != nil {
+			return nil, fmt.Errorf("error reading fetch response: %w", err)
+		}
+		if kind == pktData && line == "packfile" {
+			break
+		}
+	}
+
+	// Packfile data is multiplexed over side-band channels
+	pack := new(bytes.Buffer)
+	for {
+		kind, payload, err := reader.Next()
+		if err != nil {
+			return nil, fmt.Errorf("error reading packfile: %w", err)
+		}
+		if kind != pktData {
+			break
+		}
+		if len(payload) == 0 {
+			continue
+		}
+		switch payload[0] {
+		case 1:
+			pack.Write(payload[1:])
+		case 2:
+			// Progress messages, ignore
+		case 3:
+			return nil, fmt.Errorf("server error: %s", strings.TrimSpace(string(payload[1:])))
+		}
+	}
+
+	store, err := readPack(pack.Bytes())
+	if err != nil {
+		return nil, fmt.Errorf("error parsing packfile: %w", err)
+	}
+	if _, err := store.Get(commitID, objectCommit); err != nil {
+		return nil, fmt.Errorf("server did not send the requested commit: %w", err)
+	}
+	return store, nil
+}
+
+func (g *GitRepository) Get(path string, ref string) ([]byte, error) {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+
+	commitID, err := g.resolveRef(ref)
+	if err != nil {
+		return nil, fmt.Errorf("error resolving ref: %w", err)
+	}
+
+	store, err := g.fetch(commitID)
+	if err != nil {
+		return nil, fmt.Errorf("error fetching commit %s: %w", commitID, err)
+	}
+
+	commit, err := store.Get(commitID, objectCommit)
+	if err != nil {
+		return nil, err
+	}
+	tree, err := commitTree(commit.Data)
+	if err != nil {
+		return nil, err
+	}
+
+	return store.readPath(tree, path)
+}
+
+func (g *GitRepository) ResolveCommit(ref string) (string, error) {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+
+	return g.resolveRef(ref)
+}
+
+// maxHistoryDepth is the number of commits History fetches, the commits changing a file are searched among them
+const maxHistoryDepth = 1000
+
+// History walks the first-parent history of ref, returning the commits that changed path
+func (g *GitRepository) History(path string, ref string, limit int) ([]targets.Commit, error) {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+
+	commitID, err := g.resolveRef(ref)
+	if err != nil {
+		return nil, fmt.Errorf("error resolving ref: %w", err)
+	}
+
+	store, err := g.fetchHistory(commitID, maxHistoryDepth)
+	if err != nil {
+		return nil, fmt.Errorf("error fetching history of %s: %w", commitID, err)
+	}
+
+	commits := []targets.Commit{}
+	info, content, err := store.readCommitPath(commitID, path)
+	if err != nil {
+		return nil, err
+	}
+	for len(commits) < limit {
+		// Commits read so far don't need to be fetched again by GetRevision
+		g.fetched[commitID] = store
+
+		parent := ""
+		var parentInfo commitInfo
+		var parentContent []byte
+		if len(info.Parents) > 0 {
+			parent = info.Parents[0]
+			if _, err := store.Get(parent, objectCommit); err != nil {
+				// The history is deeper than what was fetched
+				break
+			}
+			parentInfo, parentContent, err = store.readCommitPath(parent, path)
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		if !bytes.Equal(content, parentContent) {
+			author, _ := parseSignature(info.Author)
+			_, date := parseSignature(info.Committer)
+			commits = append(commits, targets.Commit{
+				ID:      commitID,
+				Author:  author,
+				Date:    date,
+				Message: strings.TrimSpace(info.Message),
+			})
+		}
+		if parent == "" {
+			break
+		}
+		commitID, info, content = parent, parentInfo, parentContent
+	}
+	return commits, nil
+}
+
+func (g *GitRepository) GetRevision(path string, commitID string) ([]byte, error) {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+
+	store, err := g.fetch(commitID)
+	if err != nil {
+		return nil, fmt.Errorf("error fetching commit %s: %w", commitID, err)
+	}
+
+	_, content, err := store.readCommitPath(commitID, path)
+	if err != nil {
+		return nil, err
+	}
+	if content == nil {
+		return nil, targets.ErrFileNotFound
+	}
+	return content, nil
+}
+
+// receivePackCapabilities retrieves the ref advertisement of git-receive-pack and returns the server capabilities
+func (g *GitRepository) receivePackCapabilities() (map[string]bool, error) {
+	res, err := g.doRequest("GET", g.baseURI+"/info/refs?service=git-receive-pack", nil, nil)
+	if err != nil {
+		return nil, fmt.Errorf("error retrieving receive-pack refs: %w", err)
+	}
+	defer res.Body.Close()
+
+	reader := newPktLineReader(res.Body)
+	capabilities := make(map[string]bool)
+	seenRefs := false
+	for {
+		kind, payload, err := reader.Next()
+		if err != nil {
+			return nil, fmt.Errorf("error reading receive-pack refs: %w", err)
+		}
+		if kind != pktData {
+			// The "# service=" announcement is followed by its own flush
+			if seenRefs {
+				break
+			}
+			continue
+		}
+		if bytes.HasPrefix(payload, []byte("# service=")) {
+			continue
+		}
+		seenRefs = true
+		// Capabilities follow a NUL byte after the first ref
+		if index := bytes.IndexByte(payload, 0); index >= 0 {
+			for _, capability := range strings.Fields(string(payload[index+1:])) {
+				capabilities[capability] = true
+			}
+		}
+	}
+	return capabilities, nil
+}
+
+func (g *GitRepository) Commit(payload *targets.CommitPayload) error {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+
+	oldCommit, err := g.resolveRef("refs/heads/" + payload.Branch)
+	if err != nil {
+		return fmt.Errorf("error resolving branch: %w", err)
+	}
+
+	store, err := g.fetch(oldCommit)
+	if err != nil {
+		return fmt.Errorf("error fetching commit %s: %w", oldCommit, err)
+	}
+
+	commit, err := store.Get(oldCommit, objectCommit)
+	if err != nil {
+		return err
+	}
+	tree, err := commitTree(commit.Data)
+	if err != nil {
+		return err
+	}
+
+	// Build new objects on top of a copy of the fetched ones, so we can tell which ones are new
+	newStore := make(objectStore)
+	for id, obj := range store {
+		newStore[id] = obj
+	}
+	for path, content := range payload.Files {
+		blob := newStore.Add(object{Type: objectBlob, Data: content})
+		tree, err = newStore.writePath(tree, strings.Split(strings.Trim(path, "/"), "/"), blob)
+		if err != nil {
+			return fmt.Errorf("error writing %s to tree: %w", path, err)
+		}
+	}
+
+	name, email := payload.SplitAuthor()
+	now := time.Now()
+	signature := fmt.Sprintf("%s <%s> %d %s", name, email, now.Unix(), now.Format("-0700"))
+	message := payload.Message
+	if !strings.HasSuffix(message, "\n") {
+		message += "\n"
+	}
+	newCommit := newStore.Add(object{
+		Type: objectCommit,
+		Data: []byte(fmt.Sprintf("tree %s\nparent %s\nauthor %s\ncommitter %s\n\n%s", tree, oldCommit, signature, signature, message)),
+	})
+
+	objects, err := newStore.missingFrom(store, newCommit)
+	if err != nil {
+		return fmt.Errorf("error collecting new objects: %w", err)
+	}
+	pack, err := writePack(objects)
+	if err != nil {
+		return fmt.Errorf("error creating packfile: %w", err)
+	}
+
+	capabilities, err := g.receivePackCapabilities()
+	if err != nil {
+		return err
+	}
+	if !capabilities["report-status"] {
+		return fmt.Errorf("server does not support report-status")
+	}
+
+	req := new(pktLineWriter)
+	req.WritePacket([]byte(fmt.Sprintf("%s %s refs/heads/%s\x00report-status agent=%s\n", oldCommit, newCommit, payload.Branch, agent)))
+	req.Flush()
+	req.Write(pack)
+
+	res, err := g.doRequest("POST", g.baseURI+"/git-receive-pack", bytes.NewReader(req.Bytes()), http.Header{
+		"Content-Type": {"application/x-git-receive-pack-request"},
+		"Accept":       {"application/x-git-receive-pack-result"},
+	})
+	if err != nil {
+		return fmt.Errorf("error pushing to git-receive-pack: %w", err)
+	}
+	defer res.Body.Close()
+
+	// Check report-status for errors
+	reader := newPktLineReader(res.Body)
+	for {
+		line, kind, err := reader.NextLine()
+		if err != nil {
+			if err == io.EOF {
+				break
+			}
+			return fmt.Errorf("error reading push report: %w", err)
+		}
+		if kind != pktData {
+			break
+		}
+		switch {
+		case strings.HasPrefix(line, "unpack ") && line != "unpack ok":
+			return fmt.Errorf("%w: %s", ErrPushRejected, line)
+		case strings.HasPrefix(line, "ng "):
+			reason := strings.TrimPrefix(line, "ng ")
+			if isBranchMoved(reason) {
+				return fmt.Errorf("%w: %s", ErrBranchMoved, reason)
+			}
+			return fmt.Errorf("%w: %s", ErrPushRejected, reason)
+		}
+	}
+	_, _ = io.Copy(ioutil.Discard, res.Body)
+
+	logging.Info("commit pushed", "commit", newCommit)
+	return nil
+}
+
+// isBranchMoved checks if a push was rejected because the ref changed, rather than by a hook or a branch protection
+func isBranchMoved(reason string) bool {
+	for _, cause := range []string{"non-fast-forward", "fetch first", "stale info", "failed to lock"} {
+		if strings.HasSuffix(reason, cause) {
+			return true
+		}
+	}
+	return false
+}
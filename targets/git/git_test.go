@@ -0,0 +1,515 @@
+package git_target
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/neosperience/shipper/targets"
+	"github.com/neosperience/shipper/test"
+)
+
+// testServer is a minimal in-process smart HTTP git server (protocol v2 for fetching, v0 for pushing)
+type testServer struct {
+	t       *testing.T
+	objects objectStore
+	refs    map[string]string
+	auth    string
+	mutex   sync.Mutex
+
+	// Called before processing a push, to simulate concurrent changes
+	beforeReceive func()
+}
+
+func newTestServer(t *testing.T, files targets.FileList) *testServer {
+	server := &testServer{
+		t:       t,
+		objects: make(objectStore),
+		refs:    make(map[string]string),
+	}
+
+	// Create initial commit with the provided files
+	tree := ""
+	var err error
+	for path, content := range files {
+		blob := server.objects.Add(object{Type: objectBlob, Data: content})
+		tree, err = server.objects.writePath(tree, strings.Split(path, "/"), blob)
+		test.MustSucceed(t, err, "Failed creating initial tree")
+	}
+	commit := server.objects.Add(object{
+		Type: objectCommit,
+		Data: []byte("tree " + tree + "\nauthor Test <test@example.com> 0 +0000\ncommitter Test <test@example.com> 0 +0000\n\nInitial\n"),
+	})
+	server.refs["refs/heads/main"] = commit
+	return server
+}
+
+// history lists all objects needed to check out a commit and its first-parent ancestors, up to depth commits
+func (s *testServer) history(commitID string, depth int) []object {
+	objects := []object{}
+	for ; commitID != "" && depth > 0; depth-- {
+		objects = append(objects, s.reachable(commitID)...)
+		info, err := parseCommit(s.objects[commitID].Data)
+		test.MustSucceed(s.t, err, "Failed parsing commit")
+		commitID = ""
+		if len(info.Parents) > 0 {
+			commitID = info.Parents[0]
+		}
+	}
+	return objects
+}
+
+// reachable lists all objects needed to check out a commit
+func (s *testServer) reachable(commitID string) []object {
+	objects := []object{s.objects[commitID]}
+	tree, err := commitTree(s.objects[commitID].Data)
+	test.MustSucceed(s.t, err, "Failed reading commit tree")
+
+	var walk func(id string)
+	walk = func(id string) {
+		objects = append(objects, s.objects[id])
+		entries, err := parseTree(s.objects[id].Data)
+		test.MustSucceed(s.t, err, "Failed parsing tree")
+		for _, entry := range entries {
+			if entry.IsTree() {
+				walk(entry.ID)
+			} else if !entry.IsGitlink() {
+				objects = append(objects, s.objects[entry.ID])
+			}
+		}
+	}
+	walk(tree)
+	return objects
+}
+
+func (s *testServer) ServeHTTP(rw http.ResponseWriter, req *http.Request) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.auth != "" && req.Header.Get("Authorization") != s.auth {
+		http.Error(rw, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	out := new(pktLineWriter)
+	switch {
+	case req.Method == "GET" && req.URL.Path == "/repo.git/info/refs" && req.URL.Query().Get("service") == "git-upload-pack":
+		if req.Header.Get("Git-Protocol") != "version=2" {
+			http.Error(rw, "only protocol v2 is supported", http.StatusBadRequest)
+			return
+		}
+		out.WriteLine("# service=git-upload-pack")
+		out.Flush()
+		out.WriteLine("version 2")
+		out.WriteLine("ls-refs")
+		out.WriteLine("fetch=shallow")
+		out.WriteLine("object-format=sha1")
+		out.Flush()
+	case req.Method == "POST" && req.URL.Path == "/repo.git/git-upload-pack":
+		reader := newPktLineReader(req.Body)
+		command := ""
+		args := []string{}
+		for {
+			line, kind, err := reader.NextLine()
+			test.MustSucceed(s.t, err, "Failed reading upload-pack request")
+			if kind == pktFlush {
+				break
+			}
+			if strings.HasPrefix(line, "command=") {
+				command = strings.TrimPrefix(line, "command=")
+			}
+			args = append(args, line)
+		}
+
+		switch command {
+		case "ls-refs":
+			for name, id := range s.refs {
+				for _, arg := range args {
+					if prefix := strings.TrimPrefix(arg, "ref-prefix "); prefix != arg && strings.HasPrefix(name, prefix) {
+						out.WriteLine("%s %s", id, name)
+						break
+					}
+				}
+			}
+			out.Flush()
+		case "fetch":
+			// Without deepen, the whole history would be sent
+			depth := 1
+			for _, arg := range args {
+				if deepen := strings.TrimPrefix(arg, "deepen "); deepen != arg {
+					depth, _ = strconv.Atoi(deepen)
+				}
+			}
+			objects := []object{}
+			for _, arg := range args {
+				if want := strings.TrimPrefix(arg, "want "); want != arg {
+					objects = append(objects, s.history(want, depth)...)
+				}
+			}
+			pack, err := writePack(objects)
+			test.MustSucceed(s.t, err, "Failed writing packfile")
+
+			out.WriteLine("packfile")
+			// Send in small chunks to exercise demultiplexing
+			for len(pack) > 0 {
+				size := 100
+				if size > len(pack) {
+					size = len(pack)
+				}
+				out.WritePacket(append([]byte{1}, pack[:size]...))
+				pack = pack[size:]
+			}
+			out.Flush()
+		default:
+			http.Error(rw, "unknown command", http.StatusBadRequest)
+			return
+		}
+	case req.Method == "GET" && req.URL.Path == "/repo.git/info/refs" && req.URL.Query().Get("service") == "git-receive-pack":
+		out.WriteLine("# service=git-receive-pack")
+		out.Flush()
+		first := true
+		for name, id := range s.refs {
+			if first {
+				out.WritePacket([]byte(id + " " + name + "\x00report-status delete-refs ofs-delta\n"))
+				first = false
+			} else {
+				out.WriteLine("%s %s", id, name)
+			}
+		}
+		out.Flush()
+	case req.Method == "POST" && req.URL.Path == "/repo.git/git-receive-pack":
+		if s.beforeReceive != nil {
+			s.beforeReceive()
+		}
+		body, err := ioutil.ReadAll(req.Body)
+		test.MustSucceed(s.t, err, "Failed reading receive-pack request")
+
+		reader := bytes.NewReader(body)
+		pkt := newPktLineReader(reader)
+		commands := [][]string{}
+		for {
+			line, kind, err := pkt.NextLine()
+			test.MustSucceed(s.t, err, "Failed reading receive-pack commands")
+			if kind == pktFlush {
+				break
+			}
+			line, _, _ = strings.Cut(line, "\x00")
+			commands = append(commands, strings.Fields(line))
+		}
+
+		pack := body[len(body)-reader.Len():]
+		objects, err := readPack(pack)
+		if err != nil {
+			out.WriteLine("unpack %s", err.Error())
+			out.Flush()
+			break
+		}
+		for id, obj := range objects {
+			s.objects[id] = obj
+		}
+		out.WriteLine("unpack ok")
+		for _, command := range commands {
+			oldID, newID, ref := command[0], command[1], command[2]
+			if s.refs[ref] != oldID {
+				out.WriteLine("ng %s fetch first", ref)
+				continue
+			}
+			if _, ok := s.objects[newID]; !ok {
+				out.WriteLine("ng %s missing necessary objects", ref)
+				continue
+			}
+			s.refs[ref] = newID
+			out.WriteLine("ok %s", ref)
+		}
+		out.Flush()
+	default:
+		http.NotFound(rw, req)
+		return
+	}
+
+	_, err := rw.Write(out.Bytes())
+	test.MustSucceed(s.t, err, "Failed writing response")
+}
+
+func TestGet(t *testing.T) {
+	testData := []byte("image:\n  tag: old\n")
+	gitServer := newTestServer(t, targets.FileList{
+		"README.md":                 []byte("hello"),
+		"deploy/prod/values.yaml":   testData,
+		"deploy/staging/values.yml": []byte("other"),
+	})
+	gitServer.auth = "Bearer test-token"
+	server := httptest.NewServer(gitServer)
+	defer server.Close()
+
+	target := NewSmartHTTPTokenClient(server.URL+"/repo.git", "test-token", server.Client())
+
+	byt, err := target.Get("deploy/prod/values.yaml", "main")
+	test.MustSucceed(t, err, "Failed getting file")
+	if !bytes.Equal(byt, testData) {
+		t.Fatal("Expected file content is different from retrieved")
+	}
+
+	_, err = target.Get("deploy/dev/values.yaml", "main")
+	if err != targets.ErrFileNotFound {
+		t.Fatalf("Expected file not found error, got %v", err)
+	}
+
+	_, err = target.Get("README.md", "non-existing-branch")
+	test.MustFail(t, err, "Get supposed to fail for non-existing branch but succeeded")
+}
+
+func TestResolveCommit(t *testing.T) {
+	gitServer := newTestServer(t, targets.FileList{"README.md": []byte("hello")})
+	server := httptest.NewServer(gitServer)
+	defer server.Close()
+
+	target := NewSmartHTTPClient(server.URL+"/repo.git", "", server.Client())
+
+	commitID, err := target.ResolveCommit("main")
+	test.MustSucceed(t, err, "Failed resolving branch")
+	test.AssertExpected(t, commitID, gitServer.refs["refs/heads/main"], "Resolved commit doesn't match the branch head")
+
+	_, err = target.ResolveCommit("non-existing-branch")
+	test.AssertExpected(t, errors.Is(err, ErrRefNotFound), true, "Missing branch was not reported")
+}
+
+func TestCommit(t *testing.T) {
+	gitServer := newTestServer(t, targets.FileList{
+		"README.md":               []byte("hello"),
+		"deploy/prod/values.yaml": []byte("image:\n  tag: old\n"),
+	})
+	gitServer.auth = "Basic dGVzdC11c2VyOnRlc3Qta2V5" // test-user:test-key
+	server := httptest.NewServer(gitServer)
+	defer server.Close()
+	oldCommit := gitServer.refs["refs/heads/main"]
+
+	commit := targets.NewPayload("main", "test-author <author@example.com>", "Hello")
+	test.MustSucceed(t, commit.Files.Add(map[string][]byte{
+		"deploy/prod/values.yaml": []byte("image:\n  tag: new\n"),
+		"deploy/dev/values.yaml":  []byte("image:\n  tag: dev\n"),
+		"binaryfile.jpg":          {0xff, 0xd8, 0xff, 0xe0},
+	}), "Failed adding test files")
+
+	target := NewSmartHTTPClient(server.URL+"/repo.git", "test-user:test-key", server.Client())
+
+	test.MustSucceed(t, target.Commit(commit), "Failed committing files")
+
+	// Check the new commit on the server side
+	newCommit := gitServer.refs["refs/heads/main"]
+	if newCommit == oldCommit {
+		t.Fatal("Branch was not updated")
+	}
+	commitData := string(gitServer.objects[newCommit].Data)
+	if !strings.Contains(commitData, "parent "+oldCommit+"\n") {
+		t.Fatal("New commit does not have the previous commit as parent")
+	}
+	if !strings.Contains(commitData, "author test-author <author@example.com>") {
+		t.Fatal("New commit author is different than expected")
+	}
+	tree, err := commitTree(gitServer.objects[newCommit].Data)
+	test.MustSucceed(t, err, "Failed reading new commit tree")
+	for path, content := range commit.Files {
+		byt, err := gitServer.objects.readPath(tree, path)
+		test.MustSucceed(t, err, "Failed reading committed file "+path)
+		if !bytes.Equal(byt, content) {
+			t.Fatalf("Content of %s is different from committed", path)
+		}
+	}
+	byt, err := gitServer.objects.readPath(tree, "README.md")
+	test.MustSucceed(t, err, "Failed reading untouched file")
+	test.AssertExpected(t, string(byt), "hello", "Untouched file was modified")
+
+	// Branch moving between fetch and push must make the push fail
+	gitServer.beforeReceive = func() {
+		gitServer.refs["refs/heads/main"] = oldCommit
+	}
+	err = target.Commit(commit)
+	test.MustFail(t, err, "Commit supposed to fail on a branch that moved but succeeded")
+	test.AssertExpected(t, errors.Is(err, ErrBranchMoved), true, "Push rejection is not reported as the branch moving")
+	test.AssertExpected(t, errors.Is(err, ErrPushRejected), true, "Branch moving is not reported as a push rejection")
+}
+
+func TestCommitSubmodule(t *testing.T) {
+	gitServer := newTestServer(t, targets.FileList{"values.yaml": []byte("image:\n  tag: old\n")})
+	server := httptest.NewServer(gitServer)
+	defer server.Close()
+
+	// Add a submodule at the root, its commit is not in the repository
+	submodule := strings.Repeat("ab", 20)
+	initial := gitServer.objects[gitServer.refs["refs/heads/main"]]
+	tree, err := commitTree(initial.Data)
+	test.MustSucceed(t, err, "Failed reading initial tree")
+	entries, err := parseTree(gitServer.objects[tree].Data)
+	test.MustSucceed(t, err, "Failed parsing initial tree")
+	data, err := encodeTree(append(entries, treeEntry{Mode: modeGitlink, Name: "vendor", ID: submodule}))
+	test.MustSucceed(t, err, "Failed encoding tree with submodule")
+	tree = gitServer.objects.Add(object{Type: objectTree, Data: data})
+	gitServer.refs["refs/heads/main"] = gitServer.objects.Add(object{
+		Type: objectCommit,
+		Data: []byte("tree " + tree + "\nauthor Test <test@example.com> 0 +0000\ncommitter Test <test@example.com> 0 +0000\n\nAdd submodule\n"),
+	})
+
+	target := NewSmartHTTPClient(server.URL+"/repo.git", "", server.Client())
+	_, err = target.Get("vendor", "main")
+	test.AssertExpected(t, errors.Is(err, targets.ErrFileNotFound), true, "Submodules should not be read as files")
+
+	commit := targets.NewPayload("main", "test-author <author@example.com>", "Update")
+	test.MustSucceed(t, commit.Files.Add(map[string][]byte{"values.yaml": []byte("image:\n  tag: new\n")}), "Failed adding test files")
+	test.MustSucceed(t, target.Commit(commit), "Failed committing to a tree with a submodule")
+
+	tree, err = commitTree(gitServer.objects[gitServer.refs["refs/heads/main"]].Data)
+	test.MustSucceed(t, err, "Failed reading new commit tree")
+	entries, err = parseTree(gitServer.objects[tree].Data)
+	test.MustSucceed(t, err, "Failed parsing new tree")
+	found := false
+	for _, entry := range entries {
+		found = found || (entry.IsGitlink() && entry.ID == submodule)
+	}
+	test.AssertExpected(t, found, true, "Submodule was not kept in the new tree")
+
+	commit = targets.NewPayload("main", "test-author <author@example.com>", "Overwrite")
+	test.MustSucceed(t, commit.Files.Add(map[string][]byte{"vendor": []byte("file")}), "Failed adding test files")
+	test.MustFail(t, target.Commit(commit), "Replacing a submodule with a file supposed to fail but succeeded")
+}
+
+func TestHistory(t *testing.T) {
+	gitServer := newTestServer(t, targets.FileList{
+		"README.md":               []byte("hello"),
+		"deploy/prod/values.yaml": []byte("image:\n  tag: 1.1.0\n"),
+	})
+	server := httptest.NewServer(gitServer)
+	defer server.Close()
+
+	target := NewSmartHTTPClient(server.URL+"/repo.git", "", server.Client())
+	commit := func(path string, content string, message string) {
+		test.MustSucceed(t, target.Commit(&targets.CommitPayload{
+			Files:   targets.FileList{path: []byte(content)},
+			Branch:  "main",
+			Message: message,
+			Author:  "Jane <jane@example.com>",
+		}), "Failed pushing commit")
+	}
+	commit("deploy/prod/values.yaml", "image:\n  tag: 1.2.0\n", "Update to 1.2.0")
+	commit("README.md", "hello again", "Update readme")
+	commit("deploy/prod/values.yaml", "image:\n  tag: 1.3.0\n", "Update to 1.3.0\n\nWith a body")
+
+	commits, err := target.History("deploy/prod/values.yaml", "main", 10)
+	test.MustSucceed(t, err, "Failed retrieving history")
+	test.AssertExpected(t, len(commits), 3, "Only commits changing the file should be listed")
+	test.AssertExpected(t, commits[0].ID, gitServer.refs["refs/heads/main"], "Commits should be newest first")
+	test.AssertExpected(t, commits[0].Message, "Update to 1.3.0\n\nWith a body", "Commit message doesn't match")
+	test.AssertExpected(t, commits[0].Author, "Jane <jane@example.com>", "Commit author doesn't match")
+	test.AssertExpected(t, commits[2].Message, "Initial", "The commit creating the file should be listed")
+	test.AssertExpected(t, commits[2].Date.Equal(time.Unix(0, 0)), true, "Commit date doesn't match")
+
+	content, err := target.GetRevision("deploy/prod/values.yaml", commits[1].ID)
+	test.MustSucceed(t, err, "Failed retrieving revision")
+	test.AssertExpected(t, string(content), "image:\n  tag: 1.2.0\n", "Revision content doesn't match")
+
+	_, err = target.GetRevision("deploy/dev/values.yaml", commits[1].ID)
+	test.AssertExpected(t, errors.Is(err, targets.ErrFileNotFound), true, "Missing file was not reported")
+
+	commits, err = target.History("deploy/prod/values.yaml", "main", 1)
+	test.MustSucceed(t, err, "Failed retrieving history")
+	test.AssertExpected(t, len(commits), 1, "History should be limited")
+}
+
+func TestParseSignature(t *testing.T) {
+	identity, date := parseSignature("Jane Doe <jane@example.com> 1643878800 +0100")
+	test.AssertExpected(t, identity, "Jane Doe <jane@example.com>", "Identity doesn't match")
+	test.AssertExpected(t, date.Equal(time.Date(2022, 2, 3, 9, 0, 0, 0, time.UTC)), true, "Date doesn't match")
+	test.AssertExpected(t, date.Format("-0700"), "+0100", "Time zone should be kept")
+}
+
+func TestApplyDelta(t *testing.T) {
+	base := []byte("hello world, this is the base")
+	delta := []byte{
+		byte(len(base)),  // source size
+		17,               // target size
+		0x91, 0x00, 0x05, // copy 5 bytes from offset 0 ("hello")
+		0x0c, ' ', 'n', 'e', 'w', ' ', 'c', 'o', 'n', 't', 'e', 'n', 't', // insert 12 bytes
+	}
+	out, err := applyDelta(base, delta)
+	test.MustSucceed(t, err, "Failed applying delta")
+	test.AssertExpected(t, string(out), "hello new content", "Delta result is different than expected")
+
+	// Pack with an OFS_DELTA entry referencing the previous object
+	pack, err := writePack([]object{{Type: objectBlob, Data: base}})
+	test.MustSucceed(t, err, "Failed writing packfile")
+	pack = appendOfsDelta(t, pack, delta)
+
+	store, err := readPack(pack)
+	test.MustSucceed(t, err, "Failed reading packfile with delta")
+	found := false
+	for _, obj := range store {
+		if string(obj.Data) == "hello new content" && obj.Type == objectBlob {
+			found = true
+		}
+	}
+	if !found {
+		t.Fatal("Deltified object was not resolved")
+	}
+
+	_, err = applyDelta([]byte("short"), delta)
+	test.MustFail(t, err, "Delta with wrong base size supposed to fail but succeeded")
+}
+
+// appendOfsDelta rewrites a single-object pack to include an OFS_DELTA entry against the first object
+func appendOfsDelta(t *testing.T, pack []byte, delta []byte) []byte {
+	body := pack[:len(pack)-20]
+	deltaPack, err := writePack([]object{{Type: packOfsDelta, Data: delta}})
+	test.MustSucceed(t, err, "Failed writing delta entry")
+	entry := deltaPack[12 : len(deltaPack)-20]
+
+	// Insert relative offset (always < 128 here) right after the entry header
+	headerLength := 1
+	for entry[headerLength-1]&0x80 != 0 {
+		headerLength += 1
+	}
+	relative := len(body) - 12
+	out := append([]byte{}, body...)
+	out[11] = 2 // object count
+	out = append(out, entry[:headerLength]...)
+	out = append(out, byte(relative))
+	out = append(out, entry[headerLength:]...)
+	checksum := sha1.Sum(out)
+	return append(out, checksum[:]...)
+}
+
+func TestFaultyServer(t *testing.T) {
+	// Mock server that just errors out
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		http.Error(rw, "Unauthorized", http.StatusUnauthorized)
+	}))
+	defer server.Close()
+	payload := targets.NewPayload("test-branch", "test-author <author@example.com>", "Hello")
+
+	// Test with erroring server
+	target := NewSmartHTTPClient(server.URL, "unused", server.Client())
+
+	_, err := target.Get("test", "main")
+	test.MustFail(t, err, "Request supposed to error out but Get call exited successfully")
+
+	err = target.Commit(payload)
+	test.MustFail(t, err, "Request supposed to error out but Commit call exited successfully")
+
+	// Test with unreachable target
+	client := server.Client()
+	client.Timeout = time.Millisecond // Set a low timeout since we don't want this to work anyway
+	target = NewSmartHTTPClient("http://0.0.0.0", "unused", client)
+
+	_, err = target.Get("test", "main")
+	test.MustFail(t, err, "Request supposed to error out but Get call exited successfully")
+
+	err = target.Commit(payload)
+	test.MustFail(t, err, "Request supposed to error out but Commit call exited successfully")
+}
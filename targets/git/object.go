@@ -0,0 +1,405 @@
+package git_target
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/neosperience/shipper/targets"
+)
+
+// objectType is the type of a git object, using the same numbering as packfiles
+type objectType int
+
+const (
+	objectCommit objectType = 1
+	objectTree   objectType = 2
+	objectBlob   objectType = 3
+	objectTag    objectType = 4
+)
+
+const (
+	modeTree = "40000"
+	modeFile = "100644"
+	// modeGitlink is a submodule, pointing to a commit of another repository
+	modeGitlink = "160000"
+)
+
+var (
+	ErrObjectNotFound = errors.New("object not found")
+	ErrInvalidObject  = errors.New("invalid object")
+	ErrNotADirectory  = errors.New("path component is not a directory")
+)
+
+func (t objectType) String() string {
+	switch t {
+	case objectCommit:
+		return "commit"
+	case objectTree:
+		return "tree"
+	case objectBlob:
+		return "blob"
+	case objectTag:
+		return "tag"
+	default:
+		return fmt.Sprintf("unknown(%d)", int(t))
+	}
+}
+
+// object is a loose git object
+type object struct {
+	Type objectType
+	Data []byte
+}
+
+// ID computes the SHA-1 object ID of the object
+func (o object) ID() string {
+	hash := sha1.New()
+	fmt.Fprintf(hash, "%s %d\x00", o.Type, len(o.Data))
+	hash.Write(o.Data)
+	return hex.EncodeToString(hash.Sum(nil))
+}
+
+// objectStore is an in-memory set of git objects indexed by ID
+type objectStore map[string]object
+
+// Add inserts an object in the store and returns its ID
+func (s objectStore) Add(o object) string {
+	id := o.ID()
+	s[id] = o
+	return id
+}
+
+// Get retrieves an object of a specific type from the store
+func (s objectStore) Get(id string, kind objectType) (object, error) {
+	o, ok := s[id]
+	if !ok {
+		return object{}, fmt.Errorf("%w: %s", ErrObjectNotFound, id)
+	}
+	if o.Type != kind {
+		return object{}, fmt.Errorf("%w: %s is a %s, expected %s", ErrInvalidObject, id, o.Type, kind)
+	}
+	return o, nil
+}
+
+// treeEntry is a single item in a tree object
+type treeEntry struct {
+	Mode string
+	Name string
+	ID   string
+}
+
+func (e treeEntry) IsTree() bool {
+	return e.Mode == modeTree
+}
+
+// IsGitlink checks whether the entry is a submodule, whose commit is not part of the repository
+func (e treeEntry) IsGitlink() bool {
+	return e.Mode == modeGitlink
+}
+
+// parseTree decodes the binary representation of a tree object
+func parseTree(data []byte) ([]treeEntry, error) {
+	entries := []treeEntry{}
+	for len(data) > 0 {
+		space := bytes.IndexByte(data, ' ')
+		if space < 0 {
+			return nil, fmt.Errorf("%w: truncated tree entry mode", ErrInvalidObject)
+		}
+		nul := bytes.IndexByte(data[space:], 0)
+		if nul < 0 || space+nul+21 > len(data) {
+			return nil, fmt.Errorf("%w: truncated tree entry", ErrInvalidObject)
+		}
+		nul += space
+		entries = append(entries, treeEntry{
+			Mode: string(data[:space]),
+			Name: string(data[space+1 : nul]),
+			ID:   hex.EncodeToString(data[nul+1 : nul+21]),
+		})
+		data = data[nul+21:]
+	}
+	return entries, nil
+}
+
+// encodeTree serializes tree entries, sorting them the way git expects
+func encodeTree(entries []treeEntry) ([]byte, error) {
+	// Git sorts entries by name, but directories are compared as if they had a trailing slash
+	sortKey := func(e treeEntry) string {
+		if e.IsTree() {
+			return e.Name + "/"
+		}
+		return e.Name
+	}
+	sorted := make([]treeEntry, len(entries))
+	copy(sorted, entries)
+	sort.Slice(sorted, func(i, j int) bool {
+		return sortKey(sorted[i]) < sortKey(sorted[j])
+	})
+
+	buf := new(bytes.Buffer)
+	for _, entry := range sorted {
+		id, err := hex.DecodeString(entry.ID)
+		if err != nil || len(id) != sha1.Size {
+			return nil, fmt.Errorf("%w: bad object ID %q in tree entry %s", ErrInvalidObject, entry.ID, entry.Name)
+		}
+		fmt.Fprintf(buf, "%s %s\x00", entry.Mode, entry.Name)
+		buf.Write(id)
+	}
+	return buf.Bytes(), nil
+}
+
+// commitTree extracts the root tree ID from a commit object
+func commitTree(data []byte) (string, error) {
+	for _, line := range strings.Split(string(data), "\n") {
+		if line == "" {
+			// End of headers
+			break
+		}
+		if tree := strings.TrimPrefix(line, "tree "); tree != line {
+			return tree, nil
+		}
+	}
+	return "", fmt.Errorf("%w: commit has no tree", ErrInvalidObject)
+}
+
+// commitInfo holds the headers and message of a commit object
+type commitInfo struct {
+	Tree      string
+	Parents   []string
+	Author    string
+	Committer string
+	Message   string
+}
+
+// parseCommit decodes a commit object
+func parseCommit(data []byte) (commitInfo, error) {
+	info := commitInfo{}
+	headers, message, _ := strings.Cut(string(data), "\n\n")
+	for _, line := range strings.Split(headers, "\n") {
+		key, value, _ := strings.Cut(line, " ")
+		switch key {
+		case "tree":
+			info.Tree = value
+		case "parent":
+			info.Parents = append(info.Parents, value)
+		case "author":
+			info.Author = value
+		case "committer":
+			info.Committer = value
+		}
+	}
+	if info.Tree == "" {
+		return info, fmt.Errorf("%w: commit has no tree", ErrInvalidObject)
+	}
+	info.Message = message
+	return info, nil
+}
+
+// parseSignature splits an author or committer header ("Name <email> <unix time> <offset>") in identity and time
+func parseSignature(signature string) (string, time.Time) {
+	end := strings.LastIndexByte(signature, '>')
+	if end < 0 {
+		return signature, time.Time{}
+	}
+	fields := strings.Fields(signature[end+1:])
+	if len(fields) != 2 {
+		return signature[:end+1], time.Time{}
+	}
+	seconds, err := strconv.ParseInt(fields[0], 10, 64)
+	if err != nil {
+		return signature[:end+1], time.Time{}
+	}
+	date := time.Unix(seconds, 0).UTC()
+	if zone, err := time.Parse("-0700", fields[1]); err == nil {
+		date = date.In(zone.Location())
+	}
+	return signature[:end+1], date
+}
+
+// readPath walks the tree starting at rootID to retrieve the blob at the given slash-separated path
+func (s objectStore) readPath(rootID string, path string) ([]byte, error) {
+	pieces := strings.Split(strings.Trim(path, "/"), "/")
+	current := rootID
+	for index, piece := range pieces {
+		tree, err := s.Get(current, objectTree)
+		if err != nil {
+			return nil, err
+		}
+		entries, err := parseTree(tree.Data)
+		if err != nil {
+			return nil, err
+		}
+
+		found := false
+		for _, entry := range entries {
+			if entry.Name != piece {
+				continue
+			}
+			last := index == len(pieces)-1
+			if !last && !entry.IsTree() {
+				return nil, fmt.Errorf("%w: %s", ErrNotADirectory, strings.Join(pieces[:index+1], "/"))
+			}
+			if entry.IsGitlink() {
+				return nil, fmt.Errorf("%w: %s is a submodule", targets.ErrFileNotFound, path)
+			}
+			current = entry.ID
+			found = true
+			break
+		}
+		if !found {
+			return nil, targets.ErrFileNotFound
+		}
+	}
+
+	blob, err := s.Get(current, objectBlob)
+	if err != nil {
+		return nil, err
+	}
+	return blob.Data, nil
+}
+
+// readCommitPath parses a commit and reads the blob at path in its tree, the content is nil if the file
+// does not exist in the commit
+func (s objectStore) readCommitPath(commitID string, path string) (commitInfo, []byte, error) {
+	commit, err := s.Get(commitID, objectCommit)
+	if err != nil {
+		return commitInfo{}, nil, err
+	}
+	info, err := parseCommit(commit.Data)
+	if err != nil {
+		return info, nil, err
+	}
+	content, err := s.readPath(info.Tree, path)
+	if err != nil && err != targets.ErrFileNotFound {
+		return info, nil, err
+	}
+	return info, content, nil
+}
+
+// writePath creates new tree objects for the tree rooted at rootID (empty for a new tree)
+// with the file at path set to the blob with ID blobID. It returns the ID of the new root tree.
+func (s objectStore) writePath(rootID string, path []string, blobID string) (string, error) {
+	entries := []treeEntry{}
+	if rootID != "" {
+		tree, err := s.Get(rootID, objectTree)
+		if err != nil {
+			return "", err
+		}
+		entries, err = parseTree(tree.Data)
+		if err != nil {
+			return "", err
+		}
+	}
+
+	name := path[0]
+	index := -1
+	for i, entry := range entries {
+		if entry.Name == name {
+			index = i
+			break
+		}
+	}
+
+	var entry treeEntry
+	if len(path) == 1 {
+		// Leaf, keep existing mode (eg. executable bit) if the file already exists
+		entry = treeEntry{Mode: modeFile, Name: name, ID: blobID}
+		if index >= 0 {
+			if entries[index].IsTree() {
+				return "", fmt.Errorf("%w: %s is a directory", ErrInvalidObject, name)
+			}
+			if entries[index].IsGitlink() {
+				return "", fmt.Errorf("%w: %s is a submodule", ErrInvalidObject, name)
+			}
+			entry.Mode = entries[index].Mode
+		}
+	} else {
+		subtree := ""
+		if index >= 0 {
+			if !entries[index].IsTree() {
+				return "", fmt.Errorf("%w: %s", ErrNotADirectory, name)
+			}
+			subtree = entries[index].ID
+		}
+		newID, err := s.writePath(subtree, path[1:], blobID)
+		if err != nil {
+			return "", err
+		}
+		entry = treeEntry{Mode: modeTree, Name: name, ID: newID}
+	}
+
+	if index >= 0 {
+		entries[index] = entry
+	} else {
+		entries = append(entries, entry)
+	}
+
+	data, err := encodeTree(entries)
+	if err != nil {
+		return "", err
+	}
+	return s.Add(object{Type: objectTree, Data: data}), nil
+}
+
+// missingFrom lists the objects reachable from commitID that are not in the other store.
+// Only the commit itself and its tree are walked, as parents must already exist in other.
+// Submodule commits belong to other repositories, so they are never sent.
+func (s objectStore) missingFrom(other objectStore, commitID string) ([]object, error) {
+	commit, err := s.Get(commitID, objectCommit)
+	if err != nil {
+		return nil, err
+	}
+	tree, err := commitTree(commit.Data)
+	if err != nil {
+		return nil, err
+	}
+
+	objects := []object{commit}
+	seen := make(map[string]bool)
+	var walk func(id string) error
+	walk = func(id string) error {
+		if _, ok := other[id]; ok || seen[id] {
+			return nil
+		}
+		seen[id] = true
+		tree, err := s.Get(id, objectTree)
+		if err != nil {
+			return err
+		}
+		objects = append(objects, tree)
+
+		entries, err := parseTree(tree.Data)
+		if err != nil {
+			return err
+		}
+		for _, entry := range entries {
+			if entry.IsTree() {
+				if err := walk(entry.ID); err != nil {
+					return err
+				}
+				continue
+			}
+			if entry.IsGitlink() {
+				continue
+			}
+			if _, ok := other[entry.ID]; ok || seen[entry.ID] {
+				continue
+			}
+			seen[entry.ID] = true
+			blob, err := s.Get(entry.ID, objectBlob)
+			if err != nil {
+				return err
+			}
+			objects = append(objects, blob)
+		}
+		return nil
+	}
+
+	return objects, walk(tree)
+}
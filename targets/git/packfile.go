@@ -0,0 +1,274 @@
+package git_target
+
+import (
+	"bytes"
+	"compress/zlib"
+	"crypto/sha1"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+)
+
+const (
+	packOfsDelta objectType = 6
+	packRefDelta objectType = 7
+)
+
+var (
+	ErrInvalidPack = errors.New("invalid packfile")
+)
+
+// packEntry is an object read from a packfile, possibly still deltified
+type packEntry struct {
+	Type     objectType
+	Data     []byte
+	BaseOfs  int
+	BaseID   string
+	resolved *object
+}
+
+// readPack parses a version 2 packfile and returns all the objects it contains, with deltas resolved
+func readPack(data []byte) (objectStore, error) {
+	if len(data) < 32 || string(data[:4]) != "PACK" {
+		return nil, fmt.Errorf("%w: missing header", ErrInvalidPack)
+	}
+	if version := binary.BigEndian.Uint32(data[4:8]); version != 2 && version != 3 {
+		return nil, fmt.Errorf("%w: unsupported version %d", ErrInvalidPack, version)
+	}
+	count := binary.BigEndian.Uint32(data[8:12])
+
+	// Verify trailing checksum
+	body, trailer := data[:len(data)-sha1.Size], data[len(data)-sha1.Size:]
+	checksum := sha1.Sum(body)
+	if !bytes.Equal(checksum[:], trailer) {
+		return nil, fmt.Errorf("%w: checksum mismatch", ErrInvalidPack)
+	}
+
+	entries := make(map[int]*packEntry, count)
+	order := make([]int, 0, count)
+	reader := bytes.NewReader(body[12:])
+	for i := uint32(0); i < count; i++ {
+		offset := 12 + len(body[12:]) - reader.Len()
+		entry, err := readPackEntry(reader, offset)
+		if err != nil {
+			return nil, fmt.Errorf("error reading object %d: %w", i, err)
+		}
+		entries[offset] = entry
+		order = append(order, offset)
+	}
+
+	store := make(objectStore)
+
+	// Resolve deltas in rounds, as bases can appear anywhere in the pack and be deltas themselves
+	pending := order
+	for len(pending) > 0 {
+		unresolved := []int{}
+		for _, offset := range pending {
+			entry := entries[offset]
+
+			var base *object
+			switch entry.Type {
+			case packOfsDelta:
+				baseEntry, ok := entries[entry.BaseOfs]
+				if !ok {
+					return nil, fmt.Errorf("%w: delta base at offset %d not found", ErrInvalidPack, entry.BaseOfs)
+				}
+				base = baseEntry.resolved
+			case packRefDelta:
+				if obj, ok := store[entry.BaseID]; ok {
+					base = &obj
+				}
+			default:
+				base = &object{Type: entry.Type}
+			}
+			if base == nil {
+				unresolved = append(unresolved, offset)
+				continue
+			}
+
+			obj := object{Type: entry.Type, Data: entry.Data}
+			if entry.Type == packOfsDelta || entry.Type == packRefDelta {
+				data, err := applyDelta(base.Data, entry.Data)
+				if err != nil {
+					return nil, err
+				}
+				obj = object{Type: base.Type, Data: data}
+			}
+			entry.resolved = &obj
+			store.Add(obj)
+		}
+
+		if len(unresolved) == len(pending) {
+			return nil, fmt.Errorf("%w: %d deltas with missing bases (thin packs are not supported)", ErrInvalidPack, len(unresolved))
+		}
+		pending = unresolved
+	}
+
+	return store, nil
+}
+
+// readPackEntry reads a single object header and its compressed data
+func readPackEntry(reader *bytes.Reader, offset int) (*packEntry, error) {
+	c, err := reader.ReadByte()
+	if err != nil {
+		return nil, fmt.Errorf("%w: truncated object header", ErrInvalidPack)
+	}
+	entry := &packEntry{Type: objectType((c >> 4) & 0x07)}
+	size := uint64(c & 0x0f)
+	shift := 4
+	for c&0x80 != 0 {
+		if c, err = reader.ReadByte(); err != nil {
+			return nil, fmt.Errorf("%w: truncated object header", ErrInvalidPack)
+		}
+		size |= uint64(c&0x7f) << shift
+		shift += 7
+	}
+
+	switch entry.Type {
+	case objectCommit, objectTree, objectBlob, objectTag:
+		// Nothing else in the header
+	case packOfsDelta:
+		if c, err = reader.ReadByte(); err != nil {
+			return nil, fmt.Errorf("%w: truncated delta offset", ErrInvalidPack)
+		}
+		relative := int(c & 0x7f)
+		for c&0x80 != 0 {
+			if c, err = reader.ReadByte(); err != nil {
+				return nil, fmt.Errorf("%w: truncated delta offset", ErrInvalidPack)
+			}
+			relative = ((relative + 1) << 7) | int(c&0x7f)
+		}
+		entry.BaseOfs = offset - relative
+	case packRefDelta:
+		id := make([]byte, sha1.Size)
+		if _, err := io.ReadFull(reader, id); err != nil {
+			return nil, fmt.Errorf("%w: truncated delta base", ErrInvalidPack)
+		}
+		entry.BaseID = hex.EncodeToString(id)
+	default:
+		return nil, fmt.Errorf("%w: unknown object type %d", ErrInvalidPack, entry.Type)
+	}
+
+	zr, err := zlib.NewReader(reader)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrInvalidPack, err.Error())
+	}
+	entry.Data, err = ioutil.ReadAll(zr)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrInvalidPack, err.Error())
+	}
+	if uint64(len(entry.Data)) != size {
+		return nil, fmt.Errorf("%w: object size mismatch", ErrInvalidPack)
+	}
+
+	return entry, nil
+}
+
+// applyDelta reconstructs an object from its base and a git delta
+func applyDelta(base []byte, delta []byte) ([]byte, error) {
+	reader := bytes.NewReader(delta)
+	readSize := func() (uint64, error) {
+		var size uint64
+		shift := 0
+		for {
+			c, err := reader.ReadByte()
+			if err != nil {
+				return 0, fmt.Errorf("%w: truncated delta header", ErrInvalidPack)
+			}
+			size |= uint64(c&0x7f) << shift
+			shift += 7
+			if c&0x80 == 0 {
+				return size, nil
+			}
+		}
+	}
+
+	srcSize, err := readSize()
+	if err != nil {
+		return nil, err
+	}
+	if srcSize != uint64(len(base)) {
+		return nil, fmt.Errorf("%w: delta base size mismatch", ErrInvalidPack)
+	}
+	dstSize, err := readSize()
+	if err != nil {
+		return nil, err
+	}
+
+	out := make([]byte, 0, dstSize)
+	for reader.Len() > 0 {
+		op, _ := reader.ReadByte()
+		switch {
+		case op&0x80 != 0:
+			// Copy from base, offset and size are sparse little endian values
+			var values [7]byte
+			for i := range values {
+				if op&(1<<i) == 0 {
+					continue
+				}
+				if values[i], err = reader.ReadByte(); err != nil {
+					return nil, fmt.Errorf("%w: truncated copy instruction", ErrInvalidPack)
+				}
+			}
+			offset := uint64(values[0]) | uint64(values[1])<<8 | uint64(values[2])<<16 | uint64(values[3])<<24
+			size := uint64(values[4]) | uint64(values[5])<<8 | uint64(values[6])<<16
+			if size == 0 {
+				size = 0x10000
+			}
+			if offset+size > uint64(len(base)) {
+				return nil, fmt.Errorf("%w: copy instruction out of bounds", ErrInvalidPack)
+			}
+			out = append(out, base[offset:offset+size]...)
+		case op != 0:
+			// Insert literal data
+			literal := make([]byte, op)
+			if _, err := io.ReadFull(reader, literal); err != nil {
+				return nil, fmt.Errorf("%w: truncated insert instruction", ErrInvalidPack)
+			}
+			out = append(out, literal...)
+		default:
+			return nil, fmt.Errorf("%w: reserved delta instruction", ErrInvalidPack)
+		}
+	}
+
+	if uint64(len(out)) != dstSize {
+		return nil, fmt.Errorf("%w: delta result size mismatch", ErrInvalidPack)
+	}
+	return out, nil
+}
+
+// writePack serializes objects into a version 2 packfile, without using deltas
+func writePack(objects []object) ([]byte, error) {
+	buf := new(bytes.Buffer)
+	buf.WriteString("PACK")
+	_ = binary.Write(buf, binary.BigEndian, uint32(2))
+	_ = binary.Write(buf, binary.BigEndian, uint32(len(objects)))
+
+	for _, obj := range objects {
+		// Object header: type and variable-length size
+		size := len(obj.Data)
+		c := byte(obj.Type)<<4 | byte(size&0x0f)
+		size >>= 4
+		for size > 0 {
+			buf.WriteByte(c | 0x80)
+			c = byte(size & 0x7f)
+			size >>= 7
+		}
+		buf.WriteByte(c)
+
+		zw := zlib.NewWriter(buf)
+		if _, err := zw.Write(obj.Data); err != nil {
+			return nil, fmt.Errorf("error compressing object: %w", err)
+		}
+		if err := zw.Close(); err != nil {
+			return nil, fmt.Errorf("error compressing object: %w", err)
+		}
+	}
+
+	checksum := sha1.Sum(buf.Bytes())
+	buf.Write(checksum[:])
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,102 @@
+package git_target
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+)
+
+const (
+	// maxPktPayload is the largest payload that fits in a single pkt-line
+	maxPktPayload = 65516
+)
+
+// pktKind tells apart data lines from the special zero-length packets
+type pktKind int
+
+const (
+	pktData pktKind = iota
+	pktFlush
+	pktDelim
+	pktResponseEnd
+)
+
+var (
+	ErrInvalidPktLine = errors.New("invalid pkt-line")
+)
+
+// pktLineReader reads pkt-line framed data as defined in gitprotocol-common(5)
+type pktLineReader struct {
+	r io.Reader
+}
+
+func newPktLineReader(r io.Reader) *pktLineReader {
+	return &pktLineReader{r: r}
+}
+
+// Next reads a single pkt-line, returning its kind and its payload (for data lines)
+func (p *pktLineReader) Next() (pktKind, []byte, error) {
+	var header [4]byte
+	if _, err := io.ReadFull(p.r, header[:]); err != nil {
+		return pktData, nil, err
+	}
+
+	length, err := strconv.ParseUint(string(header[:]), 16, 16)
+	if err != nil {
+		return pktData, nil, fmt.Errorf("%w: bad length %q", ErrInvalidPktLine, header)
+	}
+
+	switch length {
+	case 0:
+		return pktFlush, nil, nil
+	case 1:
+		return pktDelim, nil, nil
+	case 2:
+		return pktResponseEnd, nil, nil
+	case 3:
+		return pktData, nil, fmt.Errorf("%w: bad length %q", ErrInvalidPktLine, header)
+	}
+
+	payload := make([]byte, length-4)
+	if _, err := io.ReadFull(p.r, payload); err != nil {
+		return pktData, nil, fmt.Errorf("error reading pkt-line payload: %w", err)
+	}
+	return pktData, payload, nil
+}
+
+// NextLine reads a single data line with the trailing LF removed (the payload is empty for special packets)
+func (p *pktLineReader) NextLine() (string, pktKind, error) {
+	kind, payload, err := p.Next()
+	if err != nil || kind != pktData {
+		return "", kind, err
+	}
+	return string(bytes.TrimSuffix(payload, []byte("\n"))), kind, nil
+}
+
+// pktLineWriter buffers pkt-line framed data
+type pktLineWriter struct {
+	bytes.Buffer
+}
+
+// WriteLine writes a text line, adding the trailing LF
+func (p *pktLineWriter) WriteLine(format string, args ...any) {
+	p.WritePacket([]byte(fmt.Sprintf(format, args...) + "\n"))
+}
+
+// WritePacket writes an arbitrary payload as a single pkt-line
+func (p *pktLineWriter) WritePacket(payload []byte) {
+	fmt.Fprintf(&p.Buffer, "%04x", len(payload)+4)
+	p.Write(payload)
+}
+
+// Flush writes a flush-pkt (0000)
+func (p *pktLineWriter) Flush() {
+	p.WriteString("0000")
+}
+
+// Delim writes a delim-pkt (0001)
+func (p *pktLineWriter) Delim() {
+	p.WriteString("0001")
+}
@@ -4,51 +4,38 @@ import (
 	"bytes"
 	"encoding/base64"
 	"fmt"
-	"io"
 	"io/ioutil"
-	"log"
 	"net/http"
 	"net/url"
+	"time"
 
 	jsoniter "github.com/json-iterator/go"
 	"github.com/neosperience/shipper/common"
+	"github.com/neosperience/shipper/logging"
 
 	"github.com/neosperience/shipper/targets"
 )
 
 // GiteaRepository commits to a Gitea repository using the Gitea REST Commits APIs
 type GiteaRepository struct {
-	baseURI     string
-	projectID   string
-	credentials string
+	baseURI   string
+	projectID string
 
-	client *http.Client
+	client *common.Client
 }
 
 // NewAPIClient creates a GiteaRepository instance
-func NewAPIClient(uri string, projectID string, credentials string) *GiteaRepository {
-	var client = &http.Client{}
+func NewAPIClient(uri string, projectID string, credentials string, client *http.Client) *GiteaRepository {
 	return &GiteaRepository{
-		baseURI:     uri,
-		projectID:   projectID,
-		credentials: credentials,
-		client:      client,
+		baseURI:   uri,
+		projectID: projectID,
+		client:    common.NewClient(client, common.BasicAuth(credentials)),
 	}
 }
 
-func (ge *GiteaRepository) doRequest(method string, requestURI string, body io.Reader, headers http.Header) (*http.Response, error) {
-	// Add authentication headers
-	if headers == nil {
-		headers = make(http.Header)
-	}
-	headers.Add("Authorization", "Basic "+base64.StdEncoding.EncodeToString([]byte(ge.credentials)))
-
-	return common.HTTPRequest(ge.client, method, requestURI, body, headers)
-}
-
 func (ge *GiteaRepository) Get(path string, ref string) ([]byte, error) {
 	requestURI := fmt.Sprintf("%s/repos/%s/raw/%s?ref=%s", ge.baseURI, ge.projectID, path, url.QueryEscape(ref))
-	res, err := ge.doRequest("GET", requestURI, nil, nil)
+	res, err := ge.client.Request("GET", requestURI, nil, nil)
 	if err != nil {
 		return nil, fmt.Errorf("error getting file: %w", err)
 	}
@@ -59,7 +46,7 @@ func (ge *GiteaRepository) Get(path string, ref string) ([]byte, error) {
 
 func (ge *GiteaRepository) getFileSHA(path, branch string) (string, bool, error) {
 	requestURI := fmt.Sprintf("%s/repos/%s/contents/%s?ref=%s", ge.baseURI, ge.projectID, path, url.QueryEscape(branch))
-	res, err := ge.doRequest("GET", requestURI, nil, nil)
+	res, err := ge.client.Request("GET", requestURI, nil, nil)
 	if err != nil && !isNotFound(res) {
 		return "", false, fmt.Errorf("error getting file SHA from server: %w", err)
 	}
@@ -110,7 +97,7 @@ func (ge *GiteaRepository) commitSingle(path string, commitData CommitData) erro
 	}
 
 	putURI := fmt.Sprintf("%s/repos/%s/contents/%s", ge.baseURI, ge.projectID, path)
-	res, err := ge.doRequest("PUT", putURI, b, http.Header{
+	res, err := ge.client.Request("PUT", putURI, b, http.Header{
 		"Content-Type": []string{"application/json"},
 	})
 	if err != nil {
@@ -120,6 +107,7 @@ func (ge *GiteaRepository) commitSingle(path string, commitData CommitData) erro
 
 	var response struct {
 		Commit struct {
+			SHA     string `json:"sha"`
 			HTMLURL string `json:"html_url"`
 		} `json:"commit"`
 	}
@@ -128,7 +116,7 @@ func (ge *GiteaRepository) commitSingle(path string, commitData CommitData) erro
 		return fmt.Errorf("error decoding response body: %w", err)
 	}
 
-	log.Printf("Commit URL: %s", response.Commit.HTMLURL)
+	logging.Info("commit created", "commit", response.Commit.SHA, "url", response.Commit.HTMLURL)
 	return nil
 }
 
@@ -159,6 +147,80 @@ func (ge *GiteaRepository) Commit(payload *targets.CommitPayload) error {
 	return nil
 }
 
+func (ge *GiteaRepository) ResolveCommit(ref string) (string, error) {
+	requestURI := fmt.Sprintf("%s/repos/%s/branches/%s", ge.baseURI, ge.projectID, url.PathEscape(ref))
+	res, err := ge.client.Request("GET", requestURI, nil, nil)
+	if err != nil {
+		return "", fmt.Errorf("error getting branch: %w", err)
+	}
+	defer res.Body.Close()
+
+	var branch struct {
+		Commit struct {
+			ID string `json:"id"`
+		} `json:"commit"`
+	}
+	err = jsoniter.ConfigFastest.NewDecoder(res.Body).Decode(&branch)
+	if err != nil {
+		return "", fmt.Errorf("error decoding response: %w", err)
+	}
+	return branch.Commit.ID, nil
+}
+
+// maxPageSize is the default maximum number of items Gitea returns per page
+const maxPageSize = 50
+
+func (ge *GiteaRepository) History(path string, ref string, limit int) ([]targets.Commit, error) {
+	commits := []targets.Commit{}
+	for page := 1; len(commits) < limit; page++ {
+		requestURI := fmt.Sprintf("%s/repos/%s/commits?sha=%s&path=%s&limit=%d&page=%d", ge.baseURI, ge.projectID, url.QueryEscape(ref), url.QueryEscape(path), maxPageSize, page)
+		res, err := ge.client.Request("GET", requestURI, nil, nil)
+		if err != nil {
+			return nil, fmt.Errorf("error getting commits: %w", err)
+		}
+
+		var response []struct {
+			SHA    string `json:"sha"`
+			Commit struct {
+				Author    commitSignature `json:"author"`
+				Committer commitSignature `json:"committer"`
+				Message   string          `json:"message"`
+			} `json:"commit"`
+		}
+		err = jsoniter.ConfigFastest.NewDecoder(res.Body).Decode(&response)
+		res.Body.Close()
+		if err != nil {
+			return nil, fmt.Errorf("error decoding response: %w", err)
+		}
+
+		for _, commit := range response {
+			commits = append(commits, targets.Commit{
+				ID:      commit.SHA,
+				Author:  fmt.Sprintf("%s <%s>", commit.Commit.Author.Name, commit.Commit.Author.Email),
+				Date:    commit.Commit.Committer.Date,
+				Message: commit.Commit.Message,
+			})
+		}
+		if len(response) < maxPageSize {
+			break
+		}
+	}
+	if len(commits) > limit {
+		commits = commits[:limit]
+	}
+	return commits, nil
+}
+
+func (ge *GiteaRepository) GetRevision(path string, commitID string) ([]byte, error) {
+	return ge.Get(path, commitID)
+}
+
+type commitSignature struct {
+	Name  string    `json:"name"`
+	Email string    `json:"email"`
+	Date  time.Time `json:"date"`
+}
+
 func isNotFound(res *http.Response) bool {
 	return res != nil && res.StatusCode == 404
 }
@@ -78,8 +78,7 @@ func TestCommit(t *testing.T) {
 		}), "Failed sending commit info")
 	}))
 	defer server.Close()
-	target := NewAPIClient(server.URL, "test-project", fmt.Sprintf("%s:%s", testUser, testKey))
-	target.client = server.Client()
+	target := NewAPIClient(server.URL, "test-project", fmt.Sprintf("%s:%s", testUser, testKey), server.Client())
 
 	test.MustSucceed(t, target.Commit(commit), "Failed committing files")
 }
@@ -102,8 +101,7 @@ func TestGet(t *testing.T) {
 		rw.Write(testData)
 	}))
 	defer server.Close()
-	target := NewAPIClient(server.URL, "test-project", fmt.Sprintf("%s:%s", testUser, testKey))
-	target.client = server.Client()
+	target := NewAPIClient(server.URL, "test-project", fmt.Sprintf("%s:%s", testUser, testKey), server.Client())
 
 	byt, err := target.Get(testKey, "main")
 	test.MustSucceed(t, err, "Failed getting file")
@@ -112,6 +110,55 @@ func TestGet(t *testing.T) {
 	}
 }
 
+func TestResolveCommit(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		if req.URL.Path != "/repos/test-org/test-repo/branches/main" {
+			http.Error(rw, "not found", http.StatusNotFound)
+			return
+		}
+		_, _ = rw.Write([]byte(`{"name": "main", "commit": {"id": "main-commit-id"}}`))
+	}))
+	defer server.Close()
+	target := NewAPIClient(server.URL, "test-org/test-repo", "test-user:test-key", server.Client())
+
+	commitID, err := target.ResolveCommit("main")
+	test.MustSucceed(t, err, "Failed resolving branch")
+	test.AssertExpected(t, commitID, "main-commit-id", "Resolved commit doesn't match")
+
+	_, err = target.ResolveCommit("missing")
+	test.MustFail(t, err, "Resolving a missing branch succeeded")
+}
+
+func TestHistory(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		query := req.URL.Query()
+		if req.URL.Path != "/repos/test-org/test-repo/commits" || query.Get("sha") != "main" || query.Get("path") != "values.yaml" {
+			http.Error(rw, "not found", http.StatusNotFound)
+			return
+		}
+		_, _ = rw.Write([]byte(`[
+			{"sha": "second", "commit": {"author": {"name": "Jane", "email": "jane@example.com", "date": "2022-02-03T09:00:00Z"}, "committer": {"name": "Jane", "email": "jane@example.com", "date": "2022-02-03T09:00:00Z"}, "message": "Update api to 1.3.0"}},
+			{"sha": "first", "commit": {"author": {"name": "John", "email": "john@example.com", "date": "2022-02-01T09:00:00Z"}, "committer": {"name": "John", "email": "john@example.com", "date": "2022-02-01T09:00:00Z"}, "message": "Update api to 1.2.0"}}
+		]`))
+	}))
+	defer server.Close()
+	target := NewAPIClient(server.URL, "test-org/test-repo", "test-user:test-key", server.Client())
+
+	commits, err := target.History("values.yaml", "main", 10)
+	test.MustSucceed(t, err, "Failed retrieving history")
+	test.AssertExpected(t, len(commits), 2, "Unexpected number of commits")
+	test.AssertExpected(t, commits[0].ID, "second", "Commits should be newest first")
+	test.AssertExpected(t, commits[0].Author, "Jane <jane@example.com>", "Commit author doesn't match")
+	test.AssertExpected(t, commits[1].Date.Equal(time.Date(2022, 2, 1, 9, 0, 0, 0, time.UTC)), true, "Commit date doesn't match")
+
+	commits, err = target.History("values.yaml", "main", 1)
+	test.MustSucceed(t, err, "Failed retrieving history")
+	test.AssertExpected(t, len(commits), 1, "History should be limited")
+
+	_, err = target.History("values.yaml", "missing", 10)
+	test.MustFail(t, err, "Retrieving history of a missing branch succeeded")
+}
+
 func TestFaultyServer(t *testing.T) {
 	// Mock server that just errors out
 	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
@@ -124,8 +171,7 @@ func TestFaultyServer(t *testing.T) {
 	}), "Failed adding test file")
 
 	// Test with erroring server
-	target := NewAPIClient(server.URL, "test-project", "unused")
-	target.client = server.Client()
+	target := NewAPIClient(server.URL, "test-project", "unused", server.Client())
 
 	_, err := target.Get("test", "main")
 	test.MustFail(t, err, "Request supposed to error out but Get call exited successfully")
@@ -134,9 +180,9 @@ func TestFaultyServer(t *testing.T) {
 	test.MustFail(t, err, "Request supposed to error out but Commit call exited successfully")
 
 	// Test with unreacheable target
-	target = NewAPIClient("http://0.0.0.0", "test-project", "unused")
-	target.client = server.Client()
-	target.client.Timeout = time.Millisecond // Set a low timeout since we don't want this to work anyway
+	client := server.Client()
+	client.Timeout = time.Millisecond // Set a low timeout since we don't want this to work anyway
+	target = NewAPIClient("http://0.0.0.0", "test-project", "unused", client)
 
 	_, err = target.Get("test", "main")
 	test.MustFail(t, err, "Request supposed to error out but Get call exited successfully")
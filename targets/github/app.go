@@ -0,0 +1,146 @@
+package github_target
+
+import (
+	"bytes"
+	"crypto/rsa"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	jsoniter "github.com/json-iterator/go"
+
+	"github.com/neosperience/shipper/common"
+)
+
+const (
+	// GitHub rejects app JWTs valid for more than 10 minutes
+	appJWTLifetime = 9 * time.Minute
+	// Installation tokens are refreshed this long before they expire
+	tokenRefreshMargin = 5 * time.Minute
+)
+
+// githubApp holds the GitHub App credentials and the cached installation access token
+type githubApp struct {
+	appID          string
+	privateKey     *rsa.PrivateKey
+	installationID int64
+
+	mutex     sync.Mutex
+	token     string
+	expiresAt time.Time
+}
+
+// NewAppClient creates a GithubRepository instance authenticating as a GitHub App installation.
+// privateKey is the PEM-encoded private key of the App. If installationID is 0, the installation
+// is looked up from the repository.
+func NewAppClient(uri string, projectID string, appID string, privateKey []byte, installationID int64, client *http.Client) (*GithubRepository, error) {
+	key, err := common.ParseRSAPrivateKey(privateKey)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing GitHub App private key: %w", err)
+	}
+
+	repository := NewAPIClient(uri, projectID, "", client)
+	repository.app = &githubApp{
+		appID:          appID,
+		privateKey:     key,
+		installationID: installationID,
+	}
+	repository.client = common.NewClient(client, common.AuthenticatorFunc(repository.authenticateApp))
+	return repository, nil
+}
+
+// authenticateApp authenticates requests with the installation access token
+func (gh *GithubRepository) authenticateApp(req *http.Request) error {
+	token, err := gh.installationToken()
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "token "+token)
+	return nil
+}
+
+// appJWT creates a JWT to authenticate as the GitHub App itself
+func (app *githubApp) appJWT(now time.Time) (string, error) {
+	return common.SignJWT(nil, map[string]any{
+		// Backdate the token to allow for clock drift
+		"iat": now.Add(-time.Minute).Unix(),
+		"exp": now.Add(appJWTLifetime).Unix(),
+		"iss": app.appID,
+	}, app.privateKey)
+}
+
+// installationToken returns a valid installation access token, creating a new one if the cached one is about to expire
+func (gh *GithubRepository) installationToken() (string, error) {
+	app := gh.app
+	app.mutex.Lock()
+	defer app.mutex.Unlock()
+
+	now := time.Now()
+	if app.token != "" && now.Add(tokenRefreshMargin).Before(app.expiresAt) {
+		return app.token, nil
+	}
+
+	jwt, err := app.appJWT(now)
+	if err != nil {
+		return "", err
+	}
+	headers := func() http.Header {
+		return http.Header{
+			"Authorization": {"Bearer " + jwt},
+			"Accept":        {"application/vnd.github.v3+json"},
+		}
+	}
+
+	if app.installationID == 0 {
+		res, err := common.HTTPRequest(gh.client.HTTPClient(), "GET", fmt.Sprintf("%s/repos/%s/installation", gh.baseURI, gh.projectID), nil, headers())
+		if err != nil {
+			return "", fmt.Errorf("error getting GitHub App installation: %w", err)
+		}
+		defer res.Body.Close()
+
+		var installation struct {
+			ID int64 `json:"id"`
+		}
+		err = jsoniter.ConfigFastest.NewDecoder(res.Body).Decode(&installation)
+		if err != nil {
+			return "", fmt.Errorf("error decoding installation: %w", err)
+		}
+		app.installationID = installation.ID
+	}
+
+	// Restrict the token to the target repository
+	_, repositoryName, _ := strings.Cut(gh.projectID, "/")
+	b := new(bytes.Buffer)
+	err = jsoniter.ConfigFastest.NewEncoder(b).Encode(map[string][]string{
+		"repositories": {repositoryName},
+	})
+	if err != nil {
+		return "", fmt.Errorf("error encoding token request: %w", err)
+	}
+
+	requestHeaders := headers()
+	requestHeaders.Set("Content-Type", "application/json")
+	res, err := common.HTTPRequest(gh.client.HTTPClient(), "POST", fmt.Sprintf("%s/app/installations/%s/access_tokens", gh.baseURI, strconv.FormatInt(app.installationID, 10)), b, requestHeaders)
+	if err != nil {
+		return "", fmt.Errorf("error creating installation access token: %w", err)
+	}
+	defer res.Body.Close()
+
+	var token struct {
+		Token     string    `json:"token"`
+		ExpiresAt time.Time `json:"expires_at"`
+	}
+	err = jsoniter.ConfigFastest.NewDecoder(res.Body).Decode(&token)
+	if err != nil {
+		return "", fmt.Errorf("error decoding installation access token: %w", err)
+	}
+
+	// The token is as powerful as the App key, it must never be logged
+	common.RegisterSecret(token.Token)
+	app.token = token.Token
+	app.expiresAt = token.ExpiresAt
+	return app.token, nil
+}
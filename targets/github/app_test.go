@@ -0,0 +1,124 @@
+package github_target
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	jsoniter "github.com/json-iterator/go"
+	"github.com/neosperience/shipper/common"
+	"github.com/neosperience/shipper/targets"
+	"github.com/neosperience/shipper/test"
+)
+
+// verifyAppJWT checks that a bearer token is a valid JWT signed by key for the given App ID
+func verifyAppJWT(t *testing.T, authorization string, key *rsa.PublicKey, appID string) {
+	token := strings.TrimPrefix(authorization, "Bearer ")
+	parts := strings.Split(token, ".")
+	test.AssertExpected(t, len(parts), 3, "App JWT doesn't have three parts")
+
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	test.MustSucceed(t, err, "Failed decoding JWT signature")
+	hash := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+	test.MustSucceed(t, rsa.VerifyPKCS1v15(key, crypto.SHA256, hash[:], signature), "App JWT signature is not valid")
+
+	var claims struct {
+		Issuer    string `json:"iss"`
+		IssuedAt  int64  `json:"iat"`
+		ExpiresAt int64  `json:"exp"`
+	}
+	byt, err := base64.RawURLEncoding.DecodeString(parts[1])
+	test.MustSucceed(t, err, "Failed decoding JWT claims")
+	test.MustSucceed(t, jsoniter.ConfigFastest.Unmarshal(byt, &claims), "Failed decoding JWT claims")
+	test.AssertExpected(t, claims.Issuer, appID, "App JWT issuer doesn't match App ID")
+	if claims.ExpiresAt-claims.IssuedAt > 600 {
+		t.Fatal("App JWT is valid for more than 10 minutes")
+	}
+}
+
+func TestAppAuth(t *testing.T) {
+	appID := "12345"
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	test.MustSucceed(t, err, "Failed generating test key")
+	privateKey := pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)})
+
+	commit := targets.NewPayload("test-branch", "test-author <author@example.com>", "Hello")
+	test.MustSucceed(t, commit.Files.Add(map[string][]byte{
+		"textfile.txt": []byte("test file"),
+	}), "Failed adding test files")
+
+	for _, tokenLifetime := range []time.Duration{time.Hour, time.Minute} {
+		installationLookups, tokenRequests := 0, 0
+
+		// Setup test HTTP server/client
+		server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+			switch {
+			case req.Method == "GET" && req.URL.Path == "/repos/test-org/test-repo/installation":
+				verifyAppJWT(t, req.Header.Get("Authorization"), &key.PublicKey, appID)
+				installationLookups += 1
+				_, err := rw.Write([]byte(`{"id":42}`))
+				test.MustSucceed(t, err, "Failed sending installation")
+			case req.Method == "POST" && req.URL.Path == "/app/installations/42/access_tokens":
+				verifyAppJWT(t, req.Header.Get("Authorization"), &key.PublicKey, appID)
+				var request struct {
+					Repositories []string `json:"repositories"`
+				}
+				test.MustSucceed(t, jsoniter.ConfigFastest.NewDecoder(req.Body).Decode(&request), "Failed decoding token request")
+				test.AssertExpected(t, len(request.Repositories), 1, "Token is not restricted to a single repository")
+				test.AssertExpected(t, request.Repositories[0], "test-repo", "Token repository doesn't match expected value")
+
+				tokenRequests += 1
+				rw.WriteHeader(http.StatusCreated)
+				test.MustSucceed(t, jsoniter.ConfigFastest.NewEncoder(rw).Encode(map[string]any{
+					"token":      "ghs_test",
+					"expires_at": time.Now().Add(tokenLifetime).UTC().Format(time.RFC3339),
+				}), "Failed sending token")
+			case strings.HasPrefix(req.URL.Path, "/repos/test-org/test-repo/contents/"):
+				test.AssertExpected(t, req.Header.Get("Authorization"), "token ghs_test", "Authorization header doesn't match installation token")
+				switch req.Method {
+				case "GET":
+					http.Error(rw, "not found", http.StatusNotFound)
+				case "PUT":
+					var payload CommitData
+					test.MustSucceed(t, jsoniter.ConfigFastest.NewDecoder(req.Body).Decode(&payload), "Failed decoding payload")
+					if payload.Committer != nil {
+						t.Fatal("Committer must not be set when using App authentication")
+					}
+					if payload.Author == nil || payload.Author.Name != "test-author" {
+						t.Fatal("Author doesn't match expected value")
+					}
+					_, err := rw.Write([]byte(`{"commit":{"html_url":"https://github.com/test-org/test-repo/commit/testsha"}}`))
+					test.MustSucceed(t, err, "Failed sending commit info")
+				}
+			default:
+				t.Fatalf("Unexpected request: %s %s", req.Method, req.URL.Path)
+			}
+		}))
+		target, err := NewAppClient(server.URL, "test-org/test-repo", appID, privateKey, 0, server.Client())
+		test.MustSucceed(t, err, "Failed creating App client")
+
+		test.MustSucceed(t, target.Commit(commit), "Failed committing files")
+		test.MustSucceed(t, target.Commit(commit), "Failed committing files")
+		server.Close()
+
+		test.AssertExpected(t, common.Redact("token ghs_test"), "token "+common.RedactedPlaceholder, "Installation token should be redacted")
+		test.AssertExpected(t, installationLookups, 1, "Installation must be looked up only once")
+		if tokenLifetime > tokenRefreshMargin {
+			test.AssertExpected(t, tokenRequests, 1, "Valid installation token was not reused")
+		} else {
+			test.AssertExpected(t, tokenRequests, 4, "Expiring installation token was not refreshed")
+		}
+	}
+
+	_, err = NewAppClient("http://0.0.0.0", "test-org/test-repo", appID, []byte("not a key"), 0, nil)
+	test.MustFail(t, err, "Creating App client with an invalid key supposed to fail but succeeded")
+}
@@ -4,51 +4,41 @@ import (
 	"bytes"
 	"encoding/base64"
 	"fmt"
-	"io"
 	"io/ioutil"
-	"log"
 	"net/http"
 	"net/url"
+	"time"
 
 	jsoniter "github.com/json-iterator/go"
 	"github.com/neosperience/shipper/common"
+	"github.com/neosperience/shipper/logging"
 
 	"github.com/neosperience/shipper/targets"
 )
 
 // GithubRepository commits to a GitHub repository using the GitHub REST Commits APIs
 type GithubRepository struct {
-	baseURI     string
-	projectID   string
-	credentials string
+	baseURI   string
+	projectID string
 
-	client *http.Client
-}
+	// If set, authenticate as a GitHub App installation instead of using credentials
+	app *githubApp
 
-// NewAPIClient creates a GithubRepository instance
-func NewAPIClient(uri string, projectID string, credentials string) *GithubRepository {
-	var client = &http.Client{}
-	return &GithubRepository{
-		baseURI:     uri,
-		projectID:   projectID,
-		credentials: credentials,
-		client:      client,
-	}
+	client *common.Client
 }
 
-func (gh *GithubRepository) doRequest(method string, requestURI string, body io.Reader, headers http.Header) (*http.Response, error) {
-	// Add authentication headers
-	if headers == nil {
-		headers = make(http.Header)
+// NewAPIClient creates a GithubRepository instance. If client is nil, a default HTTP client is used.
+func NewAPIClient(uri string, projectID string, credentials string, client *http.Client) *GithubRepository {
+	return &GithubRepository{
+		baseURI:   uri,
+		projectID: projectID,
+		client:    common.NewClient(client, common.BasicAuth(credentials)),
 	}
-	headers.Add("Authorization", "Basic "+base64.StdEncoding.EncodeToString([]byte(gh.credentials)))
-
-	return common.HTTPRequest(gh.client, method, requestURI, body, headers)
 }
 
 func (gh *GithubRepository) Get(path string, ref string) ([]byte, error) {
 	requestURI := fmt.Sprintf("%s/repos/%s/contents/%s?ref=%s", gh.baseURI, gh.projectID, path, url.QueryEscape(ref))
-	res, err := gh.doRequest("GET", requestURI, nil, http.Header{
+	res, err := gh.client.Request("GET", requestURI, nil, http.Header{
 		"Accept": {"application/vnd.github.v3.raw"},
 	})
 	if err != nil {
@@ -61,7 +51,7 @@ func (gh *GithubRepository) Get(path string, ref string) ([]byte, error) {
 
 func (gh *GithubRepository) getFileSHA(path, branch string) (string, bool, error) {
 	requestURI := fmt.Sprintf("%s/repos/%s/contents/%s?ref=%s", gh.baseURI, gh.projectID, path, url.QueryEscape(branch))
-	res, err := gh.doRequest("GET", requestURI, nil, http.Header{
+	res, err := gh.client.Request("GET", requestURI, nil, http.Header{
 		"Accept": {"application/vnd.github.v3+json"},
 	})
 	if err != nil && !isNotFound(res) {
@@ -92,11 +82,12 @@ type CommitDataAuthor struct {
 }
 
 type CommitData struct {
-	Message   string           `json:"message"`
-	Content   string           `json:"content"`
-	Branch    string           `json:"branch"`
-	SHA       string           `json:"sha,omitempty"`
-	Committer CommitDataAuthor `json:"committer"`
+	Message   string            `json:"message"`
+	Content   string            `json:"content"`
+	Branch    string            `json:"branch"`
+	SHA       string            `json:"sha,omitempty"`
+	Author    *CommitDataAuthor `json:"author,omitempty"`
+	Committer *CommitDataAuthor `json:"committer,omitempty"`
 }
 
 func (gh *GithubRepository) commitSingle(path string, commitData CommitData) error {
@@ -114,7 +105,7 @@ func (gh *GithubRepository) commitSingle(path string, commitData CommitData) err
 	}
 
 	requestURI := fmt.Sprintf("%s/repos/%s/contents/%s", gh.baseURI, gh.projectID, path)
-	res, err := gh.doRequest("PUT", requestURI, b, http.Header{
+	res, err := gh.client.Request("PUT", requestURI, b, http.Header{
 		"Content-Type": {"application/json"},
 		"Accept":       {"application/vnd.github.v3+json"},
 	})
@@ -125,6 +116,7 @@ func (gh *GithubRepository) commitSingle(path string, commitData CommitData) err
 
 	var response struct {
 		Commit struct {
+			SHA     string `json:"sha"`
 			HTMLURL string `json:"html_url"`
 		} `json:"commit"`
 	}
@@ -133,7 +125,7 @@ func (gh *GithubRepository) commitSingle(path string, commitData CommitData) err
 		return fmt.Errorf("error decoding response body: %w", err)
 	}
 
-	log.Printf("Commit URL: %s", response.Commit.HTMLURL)
+	logging.Info("commit created", "commit", response.Commit.SHA, "url", response.Commit.HTMLURL)
 	return nil
 }
 
@@ -151,12 +143,20 @@ func (gh *GithubRepository) Commit(payload *targets.CommitPayload) error {
 			message = fmt.Sprintf("%s: %s", payload.Message, path)
 		}
 
-		err := gh.commitSingle(path, CommitData{
-			Branch:    payload.Branch,
-			Message:   message,
-			Committer: commitAuthor,
-			Content:   base64.StdEncoding.EncodeToString(file),
-		})
+		commitData := CommitData{
+			Branch:  payload.Branch,
+			Message: message,
+			Content: base64.StdEncoding.EncodeToString(file),
+		}
+		// GitHub only signs commits when the committer is left to its default (the App),
+		// so the author is used instead to keep track of who deployed
+		if gh.app != nil {
+			commitData.Author = &commitAuthor
+		} else {
+			commitData.Committer = &commitAuthor
+		}
+
+		err := gh.commitSingle(path, commitData)
 		if err != nil {
 			return fmt.Errorf("failed to commit file %s: %w", path, err)
 		}
@@ -165,6 +165,82 @@ func (gh *GithubRepository) Commit(payload *targets.CommitPayload) error {
 	return nil
 }
 
+func (gh *GithubRepository) ResolveCommit(ref string) (string, error) {
+	requestURI := fmt.Sprintf("%s/repos/%s/commits/%s", gh.baseURI, gh.projectID, url.PathEscape(ref))
+	res, err := gh.client.Request("GET", requestURI, nil, http.Header{
+		"Accept": {"application/vnd.github.v3+json"},
+	})
+	if err != nil {
+		return "", fmt.Errorf("error getting commit: %w", err)
+	}
+	defer res.Body.Close()
+
+	var commit struct {
+		SHA string `json:"sha"`
+	}
+	err = jsoniter.ConfigFastest.NewDecoder(res.Body).Decode(&commit)
+	if err != nil {
+		return "", fmt.Errorf("error decoding response: %w", err)
+	}
+	return commit.SHA, nil
+}
+
+// maxPageSize is the maximum number of items GitHub returns per page
+const maxPageSize = 100
+
+func (gh *GithubRepository) History(path string, ref string, limit int) ([]targets.Commit, error) {
+	commits := []targets.Commit{}
+	for page := 1; len(commits) < limit; page++ {
+		requestURI := fmt.Sprintf("%s/repos/%s/commits?sha=%s&path=%s&per_page=%d&page=%d", gh.baseURI, gh.projectID, url.QueryEscape(ref), url.QueryEscape(path), maxPageSize, page)
+		res, err := gh.client.Request("GET", requestURI, nil, http.Header{
+			"Accept": {"application/vnd.github.v3+json"},
+		})
+		if err != nil {
+			return nil, fmt.Errorf("error getting commits: %w", err)
+		}
+
+		var response []struct {
+			SHA    string `json:"sha"`
+			Commit struct {
+				Author    commitSignature `json:"author"`
+				Committer commitSignature `json:"committer"`
+				Message   string          `json:"message"`
+			} `json:"commit"`
+		}
+		err = jsoniter.ConfigFastest.NewDecoder(res.Body).Decode(&response)
+		res.Body.Close()
+		if err != nil {
+			return nil, fmt.Errorf("error decoding response: %w", err)
+		}
+
+		for _, commit := range response {
+			commits = append(commits, targets.Commit{
+				ID:      commit.SHA,
+				Author:  fmt.Sprintf("%s <%s>", commit.Commit.Author.Name, commit.Commit.Author.Email),
+				Date:    commit.Commit.Committer.Date,
+				Message: commit.Commit.Message,
+			})
+		}
+		if len(response) < maxPageSize {
+			break
+		}
+	}
+	if len(commits) > limit {
+		commits = commits[:limit]
+	}
+	return commits, nil
+}
+
+func (gh *GithubRepository) GetRevision(path string, commitID string) ([]byte, error) {
+	return gh.Get(path, commitID)
+}
+
+type commitSignature struct {
+	Name  string    `json:"name"`
+	Email string    `json:"email"`
+	Date  time.Time `json:"date"`
+}
+
 func isNotFound(res *http.Response) bool {
 	return res != nil && res.StatusCode == 404
 }
@@ -0,0 +1,84 @@
+package gitlab_target
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"regexp"
+	"strings"
+
+	"github.com/neosperience/shipper/common"
+)
+
+// TokenKind is the kind of token used to authenticate with GitLab, which determines the header it's sent in
+type TokenKind string
+
+const (
+	// TokenAuto detects the token kind from the token itself
+	TokenAuto TokenKind = "auto"
+	// TokenPrivate is a personal, project or group access token, sent as PRIVATE-TOKEN
+	TokenPrivate TokenKind = "private"
+	// TokenJob is a CI/CD job token (CI_JOB_TOKEN), sent as JOB-TOKEN
+	TokenJob TokenKind = "job"
+	// TokenOAuth is an OAuth2 access token, sent as a bearer token
+	TokenOAuth TokenKind = "oauth"
+	// TokenDeploy is a deploy token, which can't be used with the GitLab REST API
+	TokenDeploy TokenKind = "deploy"
+)
+
+var (
+	ErrInvalidTokenKind = common.Categorize(common.ErrValidation, errors.New("invalid token kind"))
+	ErrDeployToken      = common.Categorize(common.ErrAuth, errors.New("deploy tokens can't be used with the GitLab API, use an access token instead"))
+)
+
+// OAuth2 access tokens issued by GitLab are 64 hex characters
+var oauthTokenRegexp = regexp.MustCompile(`^[0-9a-f]{64}$`)
+
+// ParseTokenKind parses a token kind name (one of "auto", "private", "job" or "oauth")
+func ParseTokenKind(kind string) (TokenKind, error) {
+	switch TokenKind(kind) {
+	case "":
+		return TokenAuto, nil
+	case TokenAuto, TokenPrivate, TokenJob, TokenOAuth:
+		return TokenKind(kind), nil
+	default:
+		return "", fmt.Errorf("%w: %s", ErrInvalidTokenKind, kind)
+	}
+}
+
+// DetectTokenKind guesses the kind of a token from its prefix, or by comparing it with the
+// CI_JOB_TOKEN environment variable when running in GitLab CI. Unknown tokens are assumed to be access tokens.
+func DetectTokenKind(token string) TokenKind {
+	switch {
+	case strings.HasPrefix(token, "glcbt-"):
+		return TokenJob
+	case strings.HasPrefix(token, "gldt-"):
+		return TokenDeploy
+	case strings.HasPrefix(token, "glpat-"):
+		return TokenPrivate
+	case token != "" && token == os.Getenv("CI_JOB_TOKEN"):
+		return TokenJob
+	case oauthTokenRegexp.MatchString(token):
+		return TokenOAuth
+	default:
+		return TokenPrivate
+	}
+}
+
+// setAuthHeader adds the token to headers, in the header expected for its kind
+func setAuthHeader(headers http.Header, kind TokenKind, token string) error {
+	switch kind {
+	case TokenPrivate:
+		headers.Set("PRIVATE-TOKEN", token)
+	case TokenJob:
+		headers.Set("JOB-TOKEN", token)
+	case TokenOAuth:
+		headers.Set("Authorization", "Bearer "+token)
+	case TokenDeploy:
+		return ErrDeployToken
+	default:
+		return fmt.Errorf("%w: %s", ErrInvalidTokenKind, kind)
+	}
+	return nil
+}
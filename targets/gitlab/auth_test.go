@@ -0,0 +1,80 @@
+package gitlab_target
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/neosperience/shipper/test"
+)
+
+func TestDetectTokenKind(t *testing.T) {
+	t.Setenv("CI_JOB_TOKEN", "legacy-job-token")
+
+	for token, expected := range map[string]TokenKind{
+		"glpat-abcdef":             TokenPrivate,
+		"glcbt-64_abcdef":          TokenJob,
+		"gldt-abcdef":              TokenDeploy,
+		"legacy-job-token":         TokenJob,
+		strings.Repeat("a1", 32):   TokenOAuth,
+		"some-legacy-access-token": TokenPrivate,
+	} {
+		test.AssertExpected(t, DetectTokenKind(token), expected, "Detected token kind doesn't match expected value for "+token)
+	}
+}
+
+func TestParseTokenKind(t *testing.T) {
+	for name, expected := range map[string]TokenKind{
+		"":        TokenAuto,
+		"auto":    TokenAuto,
+		"private": TokenPrivate,
+		"job":     TokenJob,
+		"oauth":   TokenOAuth,
+	} {
+		kind, err := ParseTokenKind(name)
+		test.MustSucceed(t, err, "Failed parsing token kind")
+		test.AssertExpected(t, kind, expected, "Parsed token kind doesn't match expected value")
+	}
+
+	_, err := ParseTokenKind("deploy")
+	if !errors.Is(err, ErrInvalidTokenKind) {
+		t.Fatalf("Expected invalid token kind error, got %v", err)
+	}
+}
+
+func TestTokenHeaders(t *testing.T) {
+	testKey := "test-key"
+	expectedHeaders := map[TokenKind][2]string{
+		TokenPrivate: {"PRIVATE-TOKEN", testKey},
+		TokenJob:     {"JOB-TOKEN", testKey},
+		TokenOAuth:   {"Authorization", "Bearer " + testKey},
+	}
+
+	for kind, expected := range expectedHeaders {
+		server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+			test.AssertExpected(t, req.Header.Get(expected[0]), expected[1], "Authentication header doesn't match expected value")
+			for otherKind, other := range expectedHeaders {
+				if otherKind != kind && req.Header.Get(other[0]) != "" {
+					t.Fatalf("Unexpected %s header sent with %s token", other[0], kind)
+				}
+			}
+			_, err := rw.Write([]byte(`{"encoding":"text","content":"test"}`))
+			test.MustSucceed(t, err, "Failed writing test data")
+		}))
+		target := NewAPIClient(server.URL, "test-project", testKey, server.Client())
+		target.SetTokenKind(kind)
+
+		_, err := target.Get("test", "main")
+		test.MustSucceed(t, err, "Failed getting file")
+		server.Close()
+	}
+
+	// Deploy tokens must be rejected before sending any request
+	target := NewAPIClient("http://0.0.0.0", "test-project", "gldt-test", nil)
+	_, err := target.Get("test", "main")
+	if !errors.Is(err, ErrDeployToken) {
+		t.Fatalf("Expected deploy token error, got %v", err)
+	}
+}
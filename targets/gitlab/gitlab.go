@@ -4,14 +4,15 @@ import (
 	"bytes"
 	"encoding/base64"
 	"fmt"
-	"io"
-	"log"
 	"net/http"
 	"net/url"
+	"strings"
+	"time"
 	"unicode/utf8"
 
 	jsoniter "github.com/json-iterator/go"
 	"github.com/neosperience/shipper/common"
+	"github.com/neosperience/shipper/logging"
 
 	"github.com/neosperience/shipper/targets"
 )
@@ -21,19 +22,44 @@ type GitlabRepository struct {
 	baseURI    string
 	projectID  string
 	privateKey string
+	tokenKind  TokenKind
 
-	client *http.Client
+	// If set, changes are committed to this branch and a merge request is opened from it
+	mergeRequestBranch string
+
+	client *common.Client
 }
 
-// NewAPIClient creates a GitlabRepository instance
-func NewAPIClient(uri string, projectID string, key string) *GitlabRepository {
-	var client = &http.Client{}
-	return &GitlabRepository{
+// NewAPIClient creates a GitlabRepository instance, the kind of key is detected automatically.
+// If client is nil, a default HTTP client is used.
+func NewAPIClient(uri string, projectID string, key string, client *http.Client) *GitlabRepository {
+	gl := &GitlabRepository{
 		baseURI:    uri,
 		projectID:  projectID,
 		privateKey: key,
-		client:     client,
+		tokenKind:  DetectTokenKind(key),
 	}
+	gl.client = common.NewClient(client, common.AuthenticatorFunc(gl.authenticate))
+	return gl
+}
+
+// SetTokenKind changes how the key is sent to GitLab, TokenAuto detects it from the key
+func (gl *GitlabRepository) SetTokenKind(kind TokenKind) {
+	if kind == TokenAuto {
+		kind = DetectTokenKind(gl.privateKey)
+	}
+	gl.tokenKind = kind
+}
+
+// SetPullRequestBranch makes Commit push changes to a separate branch (created if missing) and
+// open a merge request from it towards the payload branch
+func (gl *GitlabRepository) SetPullRequestBranch(branch string) {
+	gl.mergeRequestBranch = branch
+}
+
+// authenticate sends the key in the header matching its kind
+func (gl *GitlabRepository) authenticate(req *http.Request) error {
+	return setAuthHeader(req.Header, gl.tokenKind, gl.privateKey)
 }
 
 type CommitAction struct {
@@ -45,6 +71,7 @@ type CommitAction struct {
 
 type CommitPostData struct {
 	Branch        string         `json:"branch"`
+	StartBranch   string         `json:"start_branch,omitempty"`
 	CommitMessage string         `json:"commit_message"`
 	AuthorName    string         `json:"author_name"`
 	AuthorEmail   string         `json:"author_email"`
@@ -63,19 +90,9 @@ type FileInfo struct {
 	CommitID      string `json:"commit_id"`
 }
 
-func (gl *GitlabRepository) doRequest(method string, requestURI string, body io.Reader, headers http.Header) (*http.Response, error) {
-	// Add authentication headers
-	if headers == nil {
-		headers = make(http.Header)
-	}
-	headers.Set("PRIVATE-TOKEN", gl.privateKey)
-
-	return common.HTTPRequest(gl.client, method, requestURI, body, headers)
-}
-
 func (gl *GitlabRepository) Get(path string, ref string) ([]byte, error) {
 	requestURI := fmt.Sprintf("%s/projects/%s/repository/files/%s?ref=%s", gl.baseURI, url.PathEscape(gl.projectID), url.PathEscape(path), url.QueryEscape(ref))
-	res, err := gl.doRequest("GET", requestURI, nil, nil)
+	res, err := gl.client.Request("GET", requestURI, nil, nil)
 	if err != nil {
 		return nil, fmt.Errorf("error retrieving file from GitLab: %w", err)
 	}
@@ -118,11 +135,26 @@ func (gl *GitlabRepository) Commit(payload *targets.CommitPayload) error {
 		}
 	}
 
+	branch := payload.Branch
+	startBranch := ""
+	if gl.mergeRequestBranch != "" {
+		branch = gl.mergeRequestBranch
+		exists, err := gl.branchExists(branch)
+		if err != nil {
+			return err
+		}
+		if !exists {
+			// Create the merge request branch from the target branch
+			startBranch = payload.Branch
+		}
+	}
+
 	author, email := payload.SplitAuthor()
 
 	b := new(bytes.Buffer)
 	err := jsoniter.ConfigFastest.NewEncoder(b).Encode(CommitPostData{
-		Branch:        payload.Branch,
+		Branch:        branch,
+		StartBranch:   startBranch,
 		CommitMessage: payload.Message,
 		AuthorName:    author,
 		AuthorEmail:   email,
@@ -133,7 +165,7 @@ func (gl *GitlabRepository) Commit(payload *targets.CommitPayload) error {
 	}
 
 	requestURI := fmt.Sprintf("%s/projects/%s/repository/commits", gl.baseURI, url.PathEscape(gl.projectID))
-	res, err := gl.doRequest("POST", requestURI, b, http.Header{
+	res, err := gl.client.Request("POST", requestURI, b, http.Header{
 		"Content-Type": []string{"application/json"},
 	})
 	if err != nil {
@@ -142,6 +174,7 @@ func (gl *GitlabRepository) Commit(payload *targets.CommitPayload) error {
 	defer res.Body.Close()
 
 	var response struct {
+		ID     string `json:"id"`
 		WebURL string `json:"web_url"`
 	}
 	err = jsoniter.ConfigFastest.NewDecoder(res.Body).Decode(&response)
@@ -149,7 +182,136 @@ func (gl *GitlabRepository) Commit(payload *targets.CommitPayload) error {
 		return fmt.Errorf("error decoding response: %w", err)
 	}
 
-	log.Printf("Commit URL: %s", response.WebURL)
+	logging.Info("commit created", "commit", response.ID, "url", response.WebURL)
 
+	if gl.mergeRequestBranch != "" {
+		return gl.openMergeRequest(payload.Message, gl.mergeRequestBranch, payload.Branch)
+	}
 	return nil
 }
+
+func (gl *GitlabRepository) ResolveCommit(ref string) (string, error) {
+	requestURI := fmt.Sprintf("%s/projects/%s/repository/commits/%s", gl.baseURI, url.PathEscape(gl.projectID), url.PathEscape(ref))
+	res, err := gl.client.Request("GET", requestURI, nil, nil)
+	if err != nil {
+		return "", fmt.Errorf("error retrieving commit from GitLab: %w", err)
+	}
+	defer res.Body.Close()
+
+	var commit struct {
+		ID string `json:"id"`
+	}
+	err = jsoniter.ConfigFastest.NewDecoder(res.Body).Decode(&commit)
+	if err != nil {
+		return "", fmt.Errorf("error decoding response: %w", err)
+	}
+	return commit.ID, nil
+}
+
+// maxPageSize is the maximum number of items GitLab returns per page
+const maxPageSize = 100
+
+func (gl *GitlabRepository) History(path string, ref string, limit int) ([]targets.Commit, error) {
+	commits := []targets.Commit{}
+	for page := 1; len(commits) < limit; page++ {
+		requestURI := fmt.Sprintf("%s/projects/%s/repository/commits?ref_name=%s&path=%s&per_page=%d&page=%d", gl.baseURI, url.PathEscape(gl.projectID), url.QueryEscape(ref), url.QueryEscape(path), maxPageSize, page)
+		res, err := gl.client.Request("GET", requestURI, nil, nil)
+		if err != nil {
+			return nil, fmt.Errorf("error retrieving commits from GitLab: %w", err)
+		}
+
+		var response []struct {
+			ID            string    `json:"id"`
+			AuthorName    string    `json:"author_name"`
+			AuthorEmail   string    `json:"author_email"`
+			CommittedDate time.Time `json:"committed_date"`
+			Message       string    `json:"message"`
+		}
+		err = jsoniter.ConfigFastest.NewDecoder(res.Body).Decode(&response)
+		res.Body.Close()
+		if err != nil {
+			return nil, fmt.Errorf("error decoding response: %w", err)
+		}
+
+		for _, commit := range response {
+			commits = append(commits, targets.Commit{
+				ID:      commit.ID,
+				Author:  fmt.Sprintf("%s <%s>", commit.AuthorName, commit.AuthorEmail),
+				Date:    commit.CommittedDate,
+				Message: commit.Message,
+			})
+		}
+		if len(response) < maxPageSize {
+			break
+		}
+	}
+	if len(commits) > limit {
+		commits = commits[:limit]
+	}
+	return commits, nil
+}
+
+func (gl *GitlabRepository) GetRevision(path string, commitID string) ([]byte, error) {
+	return gl.Get(path, commitID)
+}
+
+// branchExists checks whether a branch exists in the project
+func (gl *GitlabRepository) branchExists(branch string) (bool, error) {
+	requestURI := fmt.Sprintf("%s/projects/%s/repository/branches/%s", gl.baseURI, url.PathEscape(gl.projectID), url.PathEscape(branch))
+	res, err := gl.client.Request("GET", requestURI, nil, nil)
+	if err != nil {
+		if res != nil && res.StatusCode == http.StatusNotFound {
+			return false, nil
+		}
+		return false, fmt.Errorf("error retrieving branch from GitLab: %w", err)
+	}
+	res.Body.Close()
+	return true, nil
+}
+
+// openMergeRequest creates a merge request from one branch to another, unless one is already open
+func (gl *GitlabRepository) openMergeRequest(message string, from string, to string) error {
+	// The title is the first line of the commit message, the rest is the description
+	title, description, _ := strings.Cut(message, "\n")
+
+	b := new(bytes.Buffer)
+	err := jsoniter.ConfigFastest.NewEncoder(b).Encode(mergeRequest{
+		SourceBranch: from,
+		TargetBranch: to,
+		Title:        title,
+		Description:  strings.TrimSpace(description),
+	})
+	if err != nil {
+		return fmt.Errorf("error encoding request payload: %w", err)
+	}
+
+	requestURI := fmt.Sprintf("%s/projects/%s/merge_requests", gl.baseURI, url.PathEscape(gl.projectID))
+	res, err := gl.client.Request("POST", requestURI, b, http.Header{
+		"Content-Type": []string{"application/json"},
+	})
+	if err != nil {
+		// A merge request between the two branches is already open, the new commit will show up there
+		if res != nil && res.StatusCode == http.StatusConflict {
+			logging.Info("merge request already open", "source", from, "destination", to)
+			return nil
+		}
+		return fmt.Errorf("error opening merge request: %w", err)
+	}
+	defer res.Body.Close()
+
+	var response mergeRequest
+	err = jsoniter.ConfigFastest.NewDecoder(res.Body).Decode(&response)
+	if err != nil {
+		return fmt.Errorf("error decoding response: %w", err)
+	}
+	logging.Info("merge request opened", "url", response.WebURL)
+	return nil
+}
+
+type mergeRequest struct {
+	SourceBranch string `json:"source_branch"`
+	TargetBranch string `json:"target_branch"`
+	Title        string `json:"title"`
+	Description  string `json:"description,omitempty"`
+	WebURL       string `json:"web_url,omitempty"`
+}
@@ -68,14 +68,108 @@ func TestCommit(t *testing.T) {
 		})
 	}))
 	defer server.Close()
-	target := NewAPIClient(server.URL, "test-project", testKey)
-	target.client = server.Client()
+	target := NewAPIClient(server.URL, "test-project", testKey, server.Client())
 
 	if err := target.Commit(commit); err != nil {
 		t.Fatal(err.Error())
 	}
 }
 
+func TestMergeRequest(t *testing.T) {
+	commit := targets.NewPayload("main", "test-author <author@example.com>", "Promote staging to prod\n\nSource: staging@abc123")
+	test.MustSucceed(t, commit.Files.Add(map[string][]byte{
+		"values.yaml": []byte("test file"),
+	}), "Failed adding test files")
+
+	mergeRequestOpened := false
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		switch {
+		case req.Method == "GET" && req.URL.Path == "/projects/test-project/repository/branches/deploy/test":
+			// Merge request branch does not exist yet
+			http.Error(rw, "not found", http.StatusNotFound)
+		case req.Method == "POST" && req.URL.Path == "/projects/test-project/repository/commits":
+			var payload CommitPostData
+			test.MustSucceed(t, jsoniter.ConfigFastest.NewDecoder(req.Body).Decode(&payload), "Failed decoding commit")
+			test.AssertExpected(t, payload.Branch, "deploy/test", "Commit branch must be the merge request branch")
+			test.AssertExpected(t, payload.StartBranch, "main", "Merge request branch must be created from the target branch")
+			_, _ = rw.Write([]byte(`{"id": "new-commit-id"}`))
+		case req.Method == "POST" && req.URL.Path == "/projects/test-project/merge_requests":
+			var request mergeRequest
+			test.MustSucceed(t, jsoniter.ConfigFastest.NewDecoder(req.Body).Decode(&request), "Failed decoding merge request")
+			test.AssertExpected(t, request.SourceBranch, "deploy/test", "Merge request source doesn't match expected value")
+			test.AssertExpected(t, request.TargetBranch, "main", "Merge request target doesn't match expected value")
+			test.AssertExpected(t, request.Title, "Promote staging to prod", "Merge request title must be the first line of the message")
+			test.AssertExpected(t, request.Description, "Source: staging@abc123", "Merge request description must be the rest of the message")
+			if mergeRequestOpened {
+				http.Error(rw, "already exists", http.StatusConflict)
+				return
+			}
+			mergeRequestOpened = true
+			_, _ = rw.Write([]byte(`{"web_url": "https://gitlab.com/test-project/-/merge_requests/1"}`))
+		default:
+			t.Fatalf("Unexpected request: %s %s", req.Method, req.URL.Path)
+		}
+	}))
+	defer server.Close()
+	target := NewAPIClient(server.URL, "test-project", "test-key", server.Client())
+	target.SetPullRequestBranch("deploy/test")
+
+	test.MustSucceed(t, target.Commit(commit), "Failed to commit")
+	if !mergeRequestOpened {
+		t.Fatal("Merge request was not opened")
+	}
+	test.MustSucceed(t, target.Commit(commit), "An already open merge request should not fail the commit")
+}
+
+func TestResolveCommit(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		if req.URL.Path != "/projects/test-project/repository/commits/main" {
+			http.Error(rw, "not found", http.StatusNotFound)
+			return
+		}
+		_, _ = rw.Write([]byte(`{"id": "main-commit-id", "short_id": "main-com"}`))
+	}))
+	defer server.Close()
+	target := NewAPIClient(server.URL, "test-project", "test-key", server.Client())
+
+	commitID, err := target.ResolveCommit("main")
+	test.MustSucceed(t, err, "Failed resolving branch")
+	test.AssertExpected(t, commitID, "main-commit-id", "Resolved commit doesn't match")
+
+	_, err = target.ResolveCommit("missing")
+	test.MustFail(t, err, "Resolving a missing branch succeeded")
+}
+
+func TestHistory(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		query := req.URL.Query()
+		if req.URL.Path != "/projects/test-project/repository/commits" || query.Get("ref_name") != "main" || query.Get("path") != "values.yaml" {
+			http.Error(rw, "not found", http.StatusNotFound)
+			return
+		}
+		_, _ = rw.Write([]byte(`[
+			{"id": "second", "author_name": "Jane", "author_email": "jane@example.com", "committed_date": "2022-02-03T10:00:00.000+01:00", "message": "Update api to 1.3.0"},
+			{"id": "first", "author_name": "John", "author_email": "john@example.com", "committed_date": "2022-02-01T10:00:00.000+01:00", "message": "Update api to 1.2.0"}
+		]`))
+	}))
+	defer server.Close()
+	target := NewAPIClient(server.URL, "test-project", "test-key", server.Client())
+
+	commits, err := target.History("values.yaml", "main", 10)
+	test.MustSucceed(t, err, "Failed retrieving history")
+	test.AssertExpected(t, len(commits), 2, "Unexpected number of commits")
+	test.AssertExpected(t, commits[0].ID, "second", "Commits should be newest first")
+	test.AssertExpected(t, commits[0].Author, "Jane <jane@example.com>", "Commit author doesn't match")
+	test.AssertExpected(t, commits[1].Date.Equal(time.Date(2022, 2, 1, 9, 0, 0, 0, time.UTC)), true, "Commit date doesn't match")
+
+	commits, err = target.History("values.yaml", "main", 1)
+	test.MustSucceed(t, err, "Failed retrieving history")
+	test.AssertExpected(t, len(commits), 1, "History should be limited")
+
+	_, err = target.History("values.yaml", "missing", 10)
+	test.MustFail(t, err, "Retrieving history of a missing branch succeeded")
+}
+
 func TestGet(t *testing.T) {
 	testKey := "path/to/test-key"
 	testData := []byte("hello test here")
@@ -108,8 +202,7 @@ func TestGet(t *testing.T) {
 		}
 	}))
 	defer server.Close()
-	target := NewAPIClient(server.URL, "test-project", testKey)
-	target.client = server.Client()
+	target := NewAPIClient(server.URL, "test-project", testKey, server.Client())
 
 	byt, err := target.Get(testKey, "main")
 	if err != nil {
@@ -129,8 +222,7 @@ func TestFaultyServer(t *testing.T) {
 	payload := targets.NewPayload("test-branch", "test-author <author@example.com>", "Hello")
 
 	// Test with erroring server
-	target := NewAPIClient(server.URL, "test-project", "unused")
-	target.client = server.Client()
+	target := NewAPIClient(server.URL, "test-project", "unused", server.Client())
 
 	_, err := target.Get("test", "main")
 	test.MustFail(t, err, "Request supposed to error out but Get call exited successfully")
@@ -139,9 +231,9 @@ func TestFaultyServer(t *testing.T) {
 	test.MustFail(t, err, "Request supposed to error out but Commit call exited successfully")
 
 	// Test with unreacheable target
-	target = NewAPIClient("http://0.0.0.0", "test-project", "unused")
-	target.client = server.Client()
-	target.client.Timeout = time.Millisecond // Set a low timeout since we don't want this to work anyway
+	client := server.Client()
+	client.Timeout = time.Millisecond // Set a low timeout since we don't want this to work anyway
+	target = NewAPIClient("http://0.0.0.0", "test-project", "unused", client)
 
 	_, err = target.Get("test", "main")
 	test.MustFail(t, err, "Request supposed to error out but Get call exited successfully")
@@ -2,6 +2,7 @@ package targets
 
 import (
 	"errors"
+	"time"
 )
 
 // Repository is a supported platform where we can push commits to
@@ -13,10 +14,70 @@ type Repository interface {
 	Commit(data *CommitPayload) error
 }
 
+// CommitResolver is implemented by repositories that can tell which commit a ref points to
+type CommitResolver interface {
+	// ResolveCommit returns the ID of the commit a branch, tag or commit ID points to
+	ResolveCommit(ref string) (string, error)
+}
+
+// PullRequester is implemented by repositories that can push changes to a separate branch and open a
+// pull request from it instead of committing to the payload branch
+type PullRequester interface {
+	// SetPullRequestBranch makes Commit push changes to branch and open a pull request towards the payload branch
+	SetPullRequestBranch(branch string)
+}
+
+// Commit is a commit in the history of a file
+type Commit struct {
+	ID      string    `json:"id"`
+	Author  string    `json:"author"`
+	Date    time.Time `json:"date"`
+	Message string    `json:"message"`
+}
+
+// HistoryRepository is implemented by repositories that can list the commits changing a file
+type HistoryRepository interface {
+	// History returns up to limit commits of ref changing path, newest first
+	History(path string, ref string, limit int) ([]Commit, error)
+
+	// GetRevision retrieves a file as it was at a commit returned by History
+	GetRevision(path string, commitID string) ([]byte, error)
+}
+
 var (
 	ErrFileNotFound = errors.New("file not found")
+	// ErrUnsupported happens when an optional operation is not implemented by the repository
+	ErrUnsupported = errors.New("operation not supported by the repository")
 )
 
+// ResolveCommit returns the ID of the commit ref points to, or ErrUnsupported if the repository can't tell
+func ResolveCommit(repository Repository, ref string) (string, error) {
+	resolver, ok := repository.(CommitResolver)
+	if !ok {
+		return "", ErrUnsupported
+	}
+	return resolver.ResolveCommit(ref)
+}
+
+// History returns up to limit commits of ref changing path, newest first, or ErrUnsupported if the
+// repository can't list them
+func History(repository Repository, path string, ref string, limit int) ([]Commit, error) {
+	history, ok := repository.(HistoryRepository)
+	if !ok {
+		return nil, ErrUnsupported
+	}
+	return history.History(path, ref, limit)
+}
+
+// GetRevision retrieves a file as it was at a commit, or returns ErrUnsupported if the repository can't
+func GetRevision(repository Repository, path string, commitID string) ([]byte, error) {
+	history, ok := repository.(HistoryRepository)
+	if !ok {
+		return nil, ErrUnsupported
+	}
+	return history.GetRevision(path, commitID)
+}
+
 // InMemoryRepository is an in-memory implementation of Repository for testing
 type InMemoryRepository struct {
 	Files FileList
@@ -2,10 +2,13 @@ package helm_templater
 
 import (
 	"bytes"
+	"errors"
 	"fmt"
 
+	"github.com/neosperience/shipper/logging"
 	"github.com/neosperience/shipper/patch"
 	"github.com/neosperience/shipper/targets"
+	"github.com/neosperience/shipper/templater"
 	"gopkg.in/yaml.v3"
 )
 
@@ -20,12 +23,15 @@ type HelmUpdate struct {
 type HelmProviderOptions struct {
 	Ref     string
 	Updates []HelmUpdate
+	// Policy checks that tags don't move backwards, if set
+	Policy *templater.Policy
 }
 
 func UpdateHelmChart(repository targets.Repository, options HelmProviderOptions) (targets.FileList, error) {
 	original := make(map[string][]byte)
 	files := make(map[string]map[string]any)
 	for _, update := range options.Updates {
+		logging.Debug("updating image", "file", update.ValuesFile, "image", update.Image, "tag", update.Tag)
 		if _, ok := files[update.ValuesFile]; !ok {
 			file, err := repository.Get(update.ValuesFile, options.Ref)
 			if err != nil {
@@ -39,6 +45,17 @@ func UpdateHelmChart(repository targets.Repository, options HelmProviderOptions)
 			}
 		}
 
+		// Files without a tag yet are not checked
+		current, _ := getValue(files[update.ValuesFile], update.TagPath)
+		apply, err := options.Policy.Check(update.ValuesFile, update.ImagePath, current, update.Tag)
+		if err != nil {
+			return nil, err
+		}
+		if !apply {
+			logging.Info("skipping update moving tag backwards", "file", update.ValuesFile, "image", update.Image, "from", current, "to", update.Tag)
+			continue
+		}
+
 		if err := patch.SetPath(files[update.ValuesFile], update.ImagePath, update.Image); err != nil {
 			return nil, fmt.Errorf("could not patch image for %s: %w", update.ValuesFile, err)
 		}
@@ -56,6 +73,7 @@ func UpdateHelmChart(repository targets.Repository, options HelmProviderOptions)
 
 		// Skip if there are no changes
 		if bytes.Equal(original[file], byt) {
+			logging.Info("file already up to date", "file", file)
 			continue
 		}
 
@@ -64,3 +82,63 @@ func UpdateHelmChart(repository targets.Repository, options HelmProviderOptions)
 
 	return diff, nil
 }
+
+type HelmLookup struct {
+	ValuesFile string
+	ImagePath  string
+	TagPath    string
+}
+
+type HelmGetOptions struct {
+	Ref     string
+	Lookups []HelmLookup
+}
+
+// GetHelmImages reads the images and tags currently set in values files
+func GetHelmImages(repository targets.Repository, options HelmGetOptions) ([]templater.Image, error) {
+	files := make(map[string]map[string]any)
+	images := make([]templater.Image, 0, len(options.Lookups))
+	for _, lookup := range options.Lookups {
+		if _, ok := files[lookup.ValuesFile]; !ok {
+			file, err := repository.Get(lookup.ValuesFile, options.Ref)
+			if err != nil {
+				return nil, fmt.Errorf("could not retrieve %s from repository: %w", lookup.ValuesFile, err)
+			}
+
+			files[lookup.ValuesFile] = make(map[string]any)
+			if err := yaml.Unmarshal(file, files[lookup.ValuesFile]); err != nil {
+				return nil, fmt.Errorf("could not parse YAML file %s: %w", lookup.ValuesFile, err)
+			}
+		}
+
+		image, err := getValue(files[lookup.ValuesFile], lookup.ImagePath)
+		if err != nil {
+			return nil, fmt.Errorf("could not read image from %s: %w", lookup.ValuesFile, err)
+		}
+		tag, err := getValue(files[lookup.ValuesFile], lookup.TagPath)
+		if err != nil {
+			return nil, fmt.Errorf("could not read tag from %s: %w", lookup.ValuesFile, err)
+		}
+
+		images = append(images, templater.Image{
+			File:  lookup.ValuesFile,
+			Name:  lookup.ImagePath,
+			Image: image,
+			Tag:   tag,
+		})
+	}
+
+	return images, nil
+}
+
+// getValue returns the value at path as a string, such as tags YAML parses as numbers
+func getValue(values map[string]any, path string) (string, error) {
+	value, err := patch.GetPath(values, path)
+	if errors.Is(err, patch.ErrPathNotFound) {
+		return "", fmt.Errorf("%w: no value at %s", templater.ErrImageNotFound, path)
+	}
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprint(value), nil
+}
@@ -6,6 +6,7 @@ import (
 
 	"github.com/neosperience/shipper/patch"
 	"github.com/neosperience/shipper/targets"
+	"github.com/neosperience/shipper/templater"
 	helm_templater "github.com/neosperience/shipper/templater/helm"
 	"github.com/neosperience/shipper/test"
 	"gopkg.in/yaml.v3"
@@ -309,3 +310,82 @@ func TestUpdateMultipleImages(t *testing.T) {
 	test.AssertExpected(t, parsedValues.Image.Repository, updates[2].Image, "other-values.yaml/image.repository is not as expected")
 	test.AssertExpected(t, parsedValues.Image.Tag, updates[2].Tag, "other-values.yaml/image.tag is not as expected")
 }
+
+func TestGetHelmImages(t *testing.T) {
+	repo := targets.NewInMemoryRepository(targets.FileList{
+		"path/to/values.yaml": []byte(testChart),
+		"path/to/numeric.yaml": []byte(`image:
+  repository: registry/app
+  tag: 1.20
+`),
+	})
+
+	images, err := helm_templater.GetHelmImages(repo, helm_templater.HelmGetOptions{
+		Ref: "main",
+		Lookups: []helm_templater.HelmLookup{
+			{ValuesFile: "path/to/values.yaml", ImagePath: "image.repository", TagPath: "image.tag"},
+			{ValuesFile: "path/to/numeric.yaml", ImagePath: "image.repository", TagPath: "image.tag"},
+		},
+	})
+	test.MustSucceed(t, err, "Failed reading images")
+	test.AssertExpected(t, len(images), 2, "Unexpected number of images")
+	test.AssertExpected(t, images[0], templater.Image{
+		File:  "path/to/values.yaml",
+		Name:  "image.repository",
+		Image: "somerandom.tld/org/name",
+		Tag:   "latest",
+	}, "First image doesn't match")
+	test.AssertExpected(t, images[1].Tag, "1.2", "Numeric tags should be formatted as strings")
+
+	// Values set by UpdateHelmChart are read back
+	commitData, err := helm_templater.UpdateHelmChart(repo, helm_templater.HelmProviderOptions{
+		Ref: "main",
+		Updates: []helm_templater.HelmUpdate{
+			{ValuesFile: "path/to/values.yaml", ImagePath: "sidecar.image", Image: "registry/sidecar", TagPath: "sidecar.tag", Tag: "v2"},
+		},
+	})
+	test.MustSucceed(t, err, "Failed updating values.yaml")
+	test.MustSucceed(t, repo.Commit(&targets.CommitPayload{Files: commitData}), "Failed committing values.yaml")
+	images, err = helm_templater.GetHelmImages(repo, helm_templater.HelmGetOptions{
+		Ref:     "main",
+		Lookups: []helm_templater.HelmLookup{{ValuesFile: "path/to/values.yaml", ImagePath: "sidecar.image", TagPath: "sidecar.tag"}},
+	})
+	test.MustSucceed(t, err, "Failed reading updated image")
+	test.AssertExpected(t, images[0].Image+":"+images[0].Tag, "registry/sidecar:v2", "Updated image doesn't match")
+
+	// Missing paths and files
+	_, err = helm_templater.GetHelmImages(repo, helm_templater.HelmGetOptions{
+		Ref:     "main",
+		Lookups: []helm_templater.HelmLookup{{ValuesFile: "path/to/values.yaml", ImagePath: "image.repository", TagPath: "image.digest"}},
+	})
+	test.AssertExpected(t, errors.Is(err, templater.ErrImageNotFound), true, "Missing tag path should not be found")
+	_, err = helm_templater.GetHelmImages(repo, helm_templater.HelmGetOptions{
+		Ref:     "main",
+		Lookups: []helm_templater.HelmLookup{{ValuesFile: "missing.yaml", ImagePath: "image.repository", TagPath: "image.tag"}},
+	})
+	test.AssertExpected(t, errors.Is(err, targets.ErrFileNotFound), true, "Missing file should not be found")
+}
+
+func TestUpdateHelmChartDowngrade(t *testing.T) {
+	repo := targets.NewInMemoryRepository(targets.FileList{
+		"api.yaml":    []byte("image:\n    repository: registry/api\n    tag: 1.3.0\n"),
+		"worker.yaml": []byte("image:\n    repository: registry/worker\n    tag: 2.0.0\n"),
+	})
+	updates := []helm_templater.HelmUpdate{
+		{ValuesFile: "api.yaml", Image: "registry/api", ImagePath: "image.repository", Tag: "1.2.0", TagPath: "image.tag"},
+		{ValuesFile: "worker.yaml", Image: "registry/worker", ImagePath: "image.repository", Tag: "2.1.0", TagPath: "image.tag"},
+	}
+
+	policy := templater.NewPolicy(templater.SemverOrdering, templater.ActionSkip)
+	files, err := helm_templater.UpdateHelmChart(repo, helm_templater.HelmProviderOptions{Ref: "main", Updates: updates, Policy: policy})
+	test.MustSucceed(t, err, "Failed updating values files")
+	test.AssertExpected(t, len(files), 1, "Only the upgraded file should change")
+	_, ok := files["worker.yaml"]
+	test.AssertExpected(t, ok, true, "Upgraded file should change")
+	test.AssertExpected(t, len(policy.Decisions()), 2, "Both updates should be decisions")
+	test.AssertExpected(t, policy.Decisions()[0].Outcome, templater.OutcomeDowngradeSkipped, "Downgrade should be skipped")
+
+	policy = templater.NewPolicy(templater.SemverOrdering, templater.ActionRefuse)
+	_, err = helm_templater.UpdateHelmChart(repo, helm_templater.HelmProviderOptions{Ref: "main", Updates: updates, Policy: policy})
+	test.AssertExpected(t, errors.Is(err, templater.ErrDowngrade), true, "Downgrade should be refused")
+}
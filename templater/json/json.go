@@ -5,7 +5,9 @@ import (
 	"fmt"
 
 	jsoniter "github.com/json-iterator/go"
+	"github.com/neosperience/shipper/logging"
 	"github.com/neosperience/shipper/targets"
+	"github.com/neosperience/shipper/templater"
 )
 
 type FileUpdate struct {
@@ -17,12 +19,15 @@ type FileUpdate struct {
 type JSONProviderOptions struct {
 	Ref     string
 	Updates []FileUpdate
+	// Policy checks that tags don't move backwards, if set
+	Policy *templater.Policy
 }
 
 func UpdateJSONFile(repository targets.Repository, options JSONProviderOptions) (targets.FileList, error) {
 	original := make(map[string][]byte)
 	files := make(map[string]map[string]any)
 	for _, update := range options.Updates {
+		logging.Debug("updating image", "file", update.File, "image", update.Path, "tag", update.Tag)
 		if _, ok := files[update.File]; !ok {
 			file, err := repository.Get(update.File, options.Ref)
 			if err != nil {
@@ -37,6 +42,19 @@ func UpdateJSONFile(repository targets.Repository, options JSONProviderOptions)
 			files[update.File] = data
 		}
 
+		current := ""
+		if value, ok := files[update.File][update.Path]; ok {
+			current = fmt.Sprint(value)
+		}
+		apply, err := options.Policy.Check(update.File, update.Path, current, update.Tag)
+		if err != nil {
+			return nil, err
+		}
+		if !apply {
+			logging.Info("skipping update moving tag backwards", "file", update.File, "image", update.Path, "from", current, "to", update.Tag)
+			continue
+		}
+
 		// Update the file
 		files[update.File][update.Path] = update.Tag
 	}
@@ -50,6 +68,7 @@ func UpdateJSONFile(repository targets.Repository, options JSONProviderOptions)
 
 		// Skip if there are no changes
 		if bytes.Equal(original[file], byt) {
+			logging.Info("file already up to date", "file", file)
 			continue
 		}
 
@@ -58,3 +77,45 @@ func UpdateJSONFile(repository targets.Repository, options JSONProviderOptions)
 
 	return diff, nil
 }
+
+type JSONLookup struct {
+	File string
+	Path string
+}
+
+type JSONGetOptions struct {
+	Ref     string
+	Lookups []JSONLookup
+}
+
+// GetJSONValues reads the tags currently set in JSON files
+func GetJSONValues(repository targets.Repository, options JSONGetOptions) ([]templater.Image, error) {
+	files := make(map[string]map[string]any)
+	images := make([]templater.Image, 0, len(options.Lookups))
+	for _, lookup := range options.Lookups {
+		if _, ok := files[lookup.File]; !ok {
+			file, err := repository.Get(lookup.File, options.Ref)
+			if err != nil {
+				return nil, fmt.Errorf("could not retrieve %s from repository: %w", lookup.File, err)
+			}
+
+			data := make(map[string]any)
+			if err := jsoniter.ConfigDefault.Unmarshal(file, &data); err != nil {
+				return nil, fmt.Errorf("could not parse JSON file %s: %w", lookup.File, err)
+			}
+			files[lookup.File] = data
+		}
+
+		value, ok := files[lookup.File][lookup.Path]
+		if !ok {
+			return nil, fmt.Errorf("%w: no %s key in %s", templater.ErrImageNotFound, lookup.Path, lookup.File)
+		}
+		images = append(images, templater.Image{
+			File: lookup.File,
+			Name: lookup.Path,
+			Tag:  fmt.Sprint(value),
+		})
+	}
+
+	return images, nil
+}
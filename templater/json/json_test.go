@@ -8,6 +8,7 @@ import (
 	"github.com/neosperience/shipper/targets"
 	"github.com/neosperience/shipper/test"
 
+	"github.com/neosperience/shipper/templater"
 	json_templater "github.com/neosperience/shipper/templater/json"
 )
 
@@ -167,3 +168,50 @@ func TestUpdateMultipleImages(t *testing.T) {
 	test.MustSucceed(t, jsoniter.Unmarshal(otherValuesFile, &parsedValues), "Failed parsing other-values.json")
 	test.AssertExpected(t, parsedValues.Image, updates[1].Tag, "other-values.json/image tag was not set to the new expected value")
 }
+
+func TestGetJSONValues(t *testing.T) {
+	repo := targets.NewInMemoryRepository(targets.FileList{
+		"path/to/cdk.json": []byte(testJSON),
+	})
+
+	images, err := json_templater.GetJSONValues(repo, json_templater.JSONGetOptions{
+		Ref: "main",
+		Lookups: []json_templater.JSONLookup{
+			{File: "path/to/cdk.json", Path: "image.env=build"},
+			{File: "path/to/cdk.json", Path: "fake.nested"},
+		},
+	})
+	test.MustSucceed(t, err, "Failed reading values")
+	test.AssertExpected(t, len(images), 2, "Unexpected number of values")
+	test.AssertExpected(t, images[0], templater.Image{File: "path/to/cdk.json", Name: "image.env=build", Tag: "old"}, "First value doesn't match")
+	test.AssertExpected(t, images[1].Tag, "tag", "Dotted keys should not be treated as paths")
+
+	_, err = json_templater.GetJSONValues(repo, json_templater.JSONGetOptions{
+		Ref:     "main",
+		Lookups: []json_templater.JSONLookup{{File: "path/to/cdk.json", Path: "missing"}},
+	})
+	test.AssertExpected(t, errors.Is(err, templater.ErrImageNotFound), true, "Missing key should not be found")
+}
+
+func TestUpdateJSONFileDowngrade(t *testing.T) {
+	repo := targets.NewInMemoryRepository(targets.FileList{
+		"versions.json": []byte(`{"api": "1.3.0", "worker": 41}`),
+	})
+	updates := []json_templater.FileUpdate{
+		{File: "versions.json", Path: "api", Tag: "1.2.0"},
+		{File: "versions.json", Path: "worker", Tag: "42"},
+	}
+
+	policy := templater.NewPolicy(templater.AutoOrdering, templater.ActionSkip)
+	files, err := json_templater.UpdateJSONFile(repo, json_templater.JSONProviderOptions{Ref: "main", Updates: updates, Policy: policy})
+	test.MustSucceed(t, err, "Failed updating versions.json")
+	var parsed map[string]string
+	test.MustSucceed(t, jsoniter.Unmarshal(files["versions.json"], &parsed), "Failed parsing versions.json")
+	test.AssertExpected(t, parsed["api"], "1.3.0", "Downgrade should be skipped")
+	test.AssertExpected(t, parsed["worker"], "42", "Upgrade should be applied")
+	test.AssertExpected(t, policy.Decisions()[1].Outcome, templater.OutcomeUpgrade, "Numbers should be compared as build IDs")
+
+	policy = templater.NewPolicy(templater.AutoOrdering, templater.ActionRefuse)
+	_, err = json_templater.UpdateJSONFile(repo, json_templater.JSONProviderOptions{Ref: "main", Updates: updates, Policy: policy})
+	test.AssertExpected(t, errors.Is(err, templater.ErrDowngrade), true, "Downgrade should be refused")
+}
@@ -4,7 +4,9 @@ import (
 	"bytes"
 	"fmt"
 
+	"github.com/neosperience/shipper/logging"
 	"github.com/neosperience/shipper/targets"
+	"github.com/neosperience/shipper/templater"
 	"gopkg.in/yaml.v3"
 )
 
@@ -18,12 +20,15 @@ type KustomizeUpdate struct {
 type KustomizeProviderOptions struct {
 	Ref     string
 	Updates []KustomizeUpdate
+	// Policy checks that tags don't move backwards, if set
+	Policy *templater.Policy
 }
 
 func UpdateKustomization(repository targets.Repository, options KustomizeProviderOptions) (targets.FileList, error) {
 	original := make(map[string][]byte)
 	files := make(map[string]map[string]any)
 	for _, update := range options.Updates {
+		logging.Debug("updating image", "file", update.KustomizationFile, "image", update.Image, "tag", update.NewTag)
 		if _, ok := files[update.KustomizationFile]; !ok {
 			file, err := repository.Get(update.KustomizationFile, options.Ref)
 			if err != nil {
@@ -50,24 +55,40 @@ func UpdateKustomization(repository targets.Repository, options KustomizeProvide
 		}
 
 		// Check for existing entries
-		found := false
+		var found map[string]any
 		for index := range imageList {
 			current, ok := imageList[index].(map[string]any)
 			if !ok {
 				return nil, fmt.Errorf("found invalid entry in image list")
 			}
 			if current["name"] == update.Image {
-				if update.NewImage != "" {
-					current["newImage"] = update.NewImage
-				}
-				if update.NewTag != "" {
-					current["newTag"] = update.NewTag
-				}
-				found = true
+				found = current
 				break
 			}
 		}
-		if !found {
+
+		// Entries without a tag yet are not checked
+		currentTag := ""
+		if tag, ok := found["newTag"]; ok && update.NewTag != "" {
+			currentTag = fmt.Sprint(tag)
+		}
+		apply, err := options.Policy.Check(update.KustomizationFile, update.Image, currentTag, update.NewTag)
+		if err != nil {
+			return nil, err
+		}
+		if !apply {
+			logging.Info("skipping update moving tag backwards", "file", update.KustomizationFile, "image", update.Image, "from", currentTag, "to", update.NewTag)
+			continue
+		}
+
+		if found != nil {
+			if update.NewImage != "" {
+				found["newImage"] = update.NewImage
+			}
+			if update.NewTag != "" {
+				found["newTag"] = update.NewTag
+			}
+		} else {
 			newEntry := map[string]any{
 				"name": update.Image,
 			}
@@ -92,6 +113,7 @@ func UpdateKustomization(repository targets.Repository, options KustomizeProvide
 
 		// Skip if there are no changes
 		if bytes.Equal(original[file], byt) {
+			logging.Info("file already up to date", "file", file)
 			continue
 		}
 
@@ -100,3 +122,71 @@ func UpdateKustomization(repository targets.Repository, options KustomizeProvide
 
 	return diff, nil
 }
+
+type KustomizeLookup struct {
+	KustomizationFile string
+	Image             string
+}
+
+type KustomizeGetOptions struct {
+	Ref     string
+	Lookups []KustomizeLookup
+}
+
+// GetKustomizeImages reads the images and tags currently set in kustomization files, looking up images by name
+func GetKustomizeImages(repository targets.Repository, options KustomizeGetOptions) ([]templater.Image, error) {
+	files := make(map[string]map[string]any)
+	images := make([]templater.Image, 0, len(options.Lookups))
+	for _, lookup := range options.Lookups {
+		if _, ok := files[lookup.KustomizationFile]; !ok {
+			file, err := repository.Get(lookup.KustomizationFile, options.Ref)
+			if err != nil {
+				return nil, fmt.Errorf("could not retrieve %s from repository: %w", lookup.KustomizationFile, err)
+			}
+
+			files[lookup.KustomizationFile] = make(map[string]any)
+			if err := yaml.Unmarshal(file, files[lookup.KustomizationFile]); err != nil {
+				return nil, fmt.Errorf("could not parse YAML file %s: %w", lookup.KustomizationFile, err)
+			}
+		}
+
+		imageList, ok := files[lookup.KustomizationFile]["images"].([]any)
+		if !ok {
+			return nil, fmt.Errorf("%w: %s has no .images array", templater.ErrImageNotFound, lookup.KustomizationFile)
+		}
+
+		var found *templater.Image
+		for index := range imageList {
+			current, ok := imageList[index].(map[string]any)
+			if !ok {
+				return nil, fmt.Errorf("found invalid entry in image list")
+			}
+			if current["name"] != lookup.Image {
+				continue
+			}
+
+			// Without newImage, the image keeps its name
+			image := lookup.Image
+			if newImage, ok := current["newImage"]; ok {
+				image = fmt.Sprint(newImage)
+			}
+			tag := ""
+			if newTag, ok := current["newTag"]; ok {
+				tag = fmt.Sprint(newTag)
+			}
+			found = &templater.Image{
+				File:  lookup.KustomizationFile,
+				Name:  lookup.Image,
+				Image: image,
+				Tag:   tag,
+			}
+			break
+		}
+		if found == nil {
+			return nil, fmt.Errorf("%w: %s not in %s", templater.ErrImageNotFound, lookup.Image, lookup.KustomizationFile)
+		}
+		images = append(images, *found)
+	}
+
+	return images, nil
+}
@@ -5,6 +5,7 @@ import (
 	"testing"
 
 	"github.com/neosperience/shipper/targets"
+	"github.com/neosperience/shipper/templater"
 	kustomize_templater "github.com/neosperience/shipper/templater/kustomize"
 	"github.com/neosperience/shipper/test"
 	"gopkg.in/yaml.v3"
@@ -230,3 +231,74 @@ func TestMultipleUpdatesInOneFile(t *testing.T) {
 	test.AssertExpected(t, partial.Image[2].Name, updates[1].Image, "New image name is different than expected")
 	test.AssertExpected(t, partial.Image[2].NewTag, updates[1].NewTag, "New image tag is different than expected")
 }
+
+func TestGetKustomizeImages(t *testing.T) {
+	repo := targets.NewInMemoryRepository(targets.FileList{
+		"path/to/kustomization.yaml": []byte(`images:
+- name: git.org/myorg/myrepo
+  newTag: bbaaff
+- name: git.org/myorg/secondrepo
+  newTag: other
+- name: git.org/myorg/renamed
+  newImage: registry.example.com/renamed
+  newTag: v3
+`),
+		"path/to/empty.yaml": []byte(kustomizationEmpty),
+	})
+
+	images, err := kustomize_templater.GetKustomizeImages(repo, kustomize_templater.KustomizeGetOptions{
+		Ref: "main",
+		Lookups: []kustomize_templater.KustomizeLookup{
+			{KustomizationFile: "path/to/kustomization.yaml", Image: "git.org/myorg/secondrepo"},
+			{KustomizationFile: "path/to/kustomization.yaml", Image: "git.org/myorg/renamed"},
+		},
+	})
+	test.MustSucceed(t, err, "Failed reading images")
+	test.AssertExpected(t, len(images), 2, "Unexpected number of images")
+	test.AssertExpected(t, images[0], templater.Image{
+		File:  "path/to/kustomization.yaml",
+		Name:  "git.org/myorg/secondrepo",
+		Image: "git.org/myorg/secondrepo",
+		Tag:   "other",
+	}, "Image without newImage doesn't match")
+	test.AssertExpected(t, images[1].Image+":"+images[1].Tag, "registry.example.com/renamed:v3", "Image with newImage doesn't match")
+
+	for _, lookup := range []kustomize_templater.KustomizeLookup{
+		{KustomizationFile: "path/to/kustomization.yaml", Image: "git.org/myorg/unknown"},
+		{KustomizationFile: "path/to/empty.yaml", Image: "git.org/myorg/myrepo"},
+	} {
+		_, err = kustomize_templater.GetKustomizeImages(repo, kustomize_templater.KustomizeGetOptions{
+			Ref:     "main",
+			Lookups: []kustomize_templater.KustomizeLookup{lookup},
+		})
+		test.AssertExpected(t, errors.Is(err, templater.ErrImageNotFound), true, "Missing image should not be found in "+lookup.KustomizationFile)
+	}
+}
+
+func TestUpdateKustomizationDowngrade(t *testing.T) {
+	repo := targets.NewInMemoryRepository(targets.FileList{
+		"kustomization.yaml": []byte("images:\n- name: api\n  newTag: 2024.05.01\n- name: worker\n  newTag: 2024.05.01\n"),
+	})
+	updates := []kustomize_templater.KustomizeUpdate{
+		{KustomizationFile: "kustomization.yaml", Image: "api", NewTag: "2024.04.30"},
+		{KustomizationFile: "kustomization.yaml", Image: "worker", NewTag: "2024.05.02"},
+		{KustomizationFile: "kustomization.yaml", Image: "web", NewTag: "2024.01.01"},
+	}
+
+	policy := templater.NewPolicy(templater.CalverOrdering, templater.ActionSkip)
+	files, err := kustomize_templater.UpdateKustomization(repo, kustomize_templater.KustomizeProviderOptions{Ref: "main", Updates: updates, Policy: policy})
+	test.MustSucceed(t, err, "Failed updating kustomization.yaml")
+	images, err := kustomize_templater.GetKustomizeImages(targets.NewInMemoryRepository(files), kustomize_templater.KustomizeGetOptions{
+		Ref:     "main",
+		Lookups: []kustomize_templater.KustomizeLookup{{KustomizationFile: "kustomization.yaml", Image: "api"}, {KustomizationFile: "kustomization.yaml", Image: "worker"}, {KustomizationFile: "kustomization.yaml", Image: "web"}},
+	})
+	test.MustSucceed(t, err, "Failed reading updated images")
+	test.AssertExpected(t, images[0].Tag, "2024.05.01", "Downgrade should be skipped")
+	test.AssertExpected(t, images[1].Tag, "2024.05.02", "Upgrade should be applied")
+	test.AssertExpected(t, images[2].Tag, "2024.01.01", "New image should be added")
+	test.AssertExpected(t, len(policy.Decisions()), 2, "New images should not be decisions")
+
+	policy = templater.NewPolicy(templater.CalverOrdering, templater.ActionRefuse)
+	_, err = kustomize_templater.UpdateKustomization(repo, kustomize_templater.KustomizeProviderOptions{Ref: "main", Updates: updates, Policy: policy})
+	test.AssertExpected(t, errors.Is(err, templater.ErrDowngrade), true, "Downgrade should be refused")
+}
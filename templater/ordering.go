@@ -0,0 +1,190 @@
+package templater
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+var (
+	// ErrUnordered happens when tags can't be compared, such as commit hashes
+	ErrUnordered = errors.New("tags can't be ordered")
+	// ErrInvalidOrdering happens when the tag ordering is unknown or its pattern is invalid
+	ErrInvalidOrdering = errors.New("invalid tag ordering")
+)
+
+// Ordering compares two tags, returning a negative number if a is older than b, zero if they are the same
+// version and a positive number if a is newer than b
+type Ordering func(a string, b string) (int, error)
+
+var (
+	semverPattern  = regexp.MustCompile(`^v?(\d+)\.(\d+)\.(\d+)(?:-([0-9A-Za-z.-]+))?(?:\+[0-9A-Za-z.-]+)?$`)
+	calverPattern  = regexp.MustCompile(`^v?\d+(?:[._-]\d+)*$`)
+	numericPattern = regexp.MustCompile(`^\d+$`)
+	calverSplitter = regexp.MustCompile(`[._-]`)
+)
+
+// ParseOrdering returns the ordering with the given name: "semver", "calver" (numbers separated by dots, dashes
+// or underscores, such as 2024.05.1), "numeric" (build IDs), "regex" (comparing the capture groups of pattern,
+// such as timestamps embedded in tags) or "auto" (semver, falling back to calver).
+func ParseOrdering(name string, pattern string) (Ordering, error) {
+	switch name {
+	case "auto":
+		return AutoOrdering, nil
+	case "semver":
+		return SemverOrdering, nil
+	case "calver":
+		return CalverOrdering, nil
+	case "numeric":
+		return NumericOrdering, nil
+	case "regex":
+		return RegexOrdering(pattern)
+	default:
+		return nil, fmt.Errorf("%w: %s", ErrInvalidOrdering, name)
+	}
+}
+
+// AutoOrdering compares tags as semantic versions if both are, or as calendar versions otherwise
+func AutoOrdering(a string, b string) (int, error) {
+	if semverPattern.MatchString(a) && semverPattern.MatchString(b) {
+		return SemverOrdering(a, b)
+	}
+	return CalverOrdering(a, b)
+}
+
+// SemverOrdering compares tags as semantic versions, with an optional "v" prefix. Build metadata is ignored.
+func SemverOrdering(a string, b string) (int, error) {
+	partsA := semverPattern.FindStringSubmatch(a)
+	partsB := semverPattern.FindStringSubmatch(b)
+	if partsA == nil || partsB == nil {
+		return 0, fmt.Errorf("%w: %s and %s are not both semantic versions", ErrUnordered, a, b)
+	}
+
+	for index := 1; index <= 3; index += 1 {
+		if result := compareNumbers(partsA[index], partsB[index]); result != 0 {
+			return result, nil
+		}
+	}
+
+	// A pre-release comes before its version
+	switch preA, preB := partsA[4], partsB[4]; {
+	case preA == preB:
+		return 0, nil
+	case preA == "":
+		return 1, nil
+	case preB == "":
+		return -1, nil
+	default:
+		return comparePrerelease(preA, preB), nil
+	}
+}
+
+// CalverOrdering compares tags as numbers separated by dots, dashes or underscores, with an optional "v" prefix,
+// such as calendar versions or build IDs. Missing trailing numbers count as zero.
+func CalverOrdering(a string, b string) (int, error) {
+	if !calverPattern.MatchString(a) || !calverPattern.MatchString(b) {
+		return 0, fmt.Errorf("%w: %s and %s are not both calendar versions", ErrUnordered, a, b)
+	}
+
+	numbersA := calverSplitter.Split(strings.TrimPrefix(a, "v"), -1)
+	numbersB := calverSplitter.Split(strings.TrimPrefix(b, "v"), -1)
+	for index := 0; index < len(numbersA) || index < len(numbersB); index += 1 {
+		numberA, numberB := "0", "0"
+		if index < len(numbersA) {
+			numberA = numbersA[index]
+		}
+		if index < len(numbersB) {
+			numberB = numbersB[index]
+		}
+		if result := compareNumbers(numberA, numberB); result != 0 {
+			return result, nil
+		}
+	}
+	return 0, nil
+}
+
+// NumericOrdering compares tags as whole numbers, such as CI build IDs
+func NumericOrdering(a string, b string) (int, error) {
+	if !numericPattern.MatchString(a) || !numericPattern.MatchString(b) {
+		return 0, fmt.Errorf("%w: %s and %s are not both numbers", ErrUnordered, a, b)
+	}
+	return compareNumbers(a, b), nil
+}
+
+// RegexOrdering compares the capture groups of pattern in order, as numbers if both are or as text otherwise,
+// such as `-(\d{8}T\d{6})$` for tags ending with a timestamp
+func RegexOrdering(pattern string) (Ordering, error) {
+	expression, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrInvalidOrdering, err.Error())
+	}
+	if expression.NumSubexp() < 1 {
+		return nil, fmt.Errorf("%w: %s has no capture group", ErrInvalidOrdering, pattern)
+	}
+
+	return func(a string, b string) (int, error) {
+		groupsA := expression.FindStringSubmatch(a)
+		groupsB := expression.FindStringSubmatch(b)
+		if groupsA == nil || groupsB == nil {
+			return 0, fmt.Errorf("%w: %s and %s don't both match %s", ErrUnordered, a, b, pattern)
+		}
+
+		for index := 1; index < len(groupsA); index += 1 {
+			if result := compareIdentifiers(groupsA[index], groupsB[index]); result != 0 {
+				return result, nil
+			}
+		}
+		return 0, nil
+	}, nil
+}
+
+// comparePrerelease compares the dot-separated identifiers of semver pre-releases
+func comparePrerelease(a string, b string) int {
+	identifiersA := strings.Split(a, ".")
+	identifiersB := strings.Split(b, ".")
+	for index := 0; index < len(identifiersA) && index < len(identifiersB); index += 1 {
+		identifierA, identifierB := identifiersA[index], identifiersB[index]
+		numericA, numericB := numericPattern.MatchString(identifierA), numericPattern.MatchString(identifierB)
+		switch {
+		case numericA && !numericB:
+			return -1
+		case !numericA && numericB:
+			return 1
+		}
+		if result := compareIdentifiers(identifierA, identifierB); result != 0 {
+			return result
+		}
+	}
+	// A larger set of identifiers comes after a smaller one they start with
+	return compareInts(len(identifiersA), len(identifiersB))
+}
+
+// compareIdentifiers compares a and b as numbers if both are, or as text otherwise
+func compareIdentifiers(a string, b string) int {
+	if numericPattern.MatchString(a) && numericPattern.MatchString(b) {
+		return compareNumbers(a, b)
+	}
+	return strings.Compare(a, b)
+}
+
+// compareNumbers compares strings of digits without parsing them, so build IDs and timestamps can't overflow
+func compareNumbers(a string, b string) int {
+	a = strings.TrimLeft(a, "0")
+	b = strings.TrimLeft(b, "0")
+	if result := compareInts(len(a), len(b)); result != 0 {
+		return result
+	}
+	return strings.Compare(a, b)
+}
+
+func compareInts(a int, b int) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	default:
+		return 0
+	}
+}
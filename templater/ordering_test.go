@@ -0,0 +1,75 @@
+package templater
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/neosperience/shipper/test"
+)
+
+func TestOrderings(t *testing.T) {
+	timestamp, err := RegexOrdering(`-(\d{8})T(\d{6})$`)
+	test.MustSucceed(t, err, "Failed creating regex ordering")
+
+	tests := []struct {
+		name     string
+		ordering Ordering
+		a        string
+		b        string
+		expected int
+	}{
+		{"semver patch", SemverOrdering, "1.2.10", "1.2.9", 1},
+		{"semver major", SemverOrdering, "v1.9.0", "2.0.0", -1},
+		{"semver same", SemverOrdering, "v1.2.0", "1.2.0+build.5", 0},
+		{"semver pre-release", SemverOrdering, "1.2.0-rc.1", "1.2.0", -1},
+		{"semver pre-release numbers", SemverOrdering, "1.2.0-rc.10", "1.2.0-rc.9", 1},
+		{"semver pre-release identifiers", SemverOrdering, "1.2.0-alpha", "1.2.0-alpha.1", -1},
+		{"semver pre-release text", SemverOrdering, "1.2.0-beta", "1.2.0-alpha", 1},
+		{"calver", CalverOrdering, "2024.05.01", "2024.04.30", 1},
+		{"calver dashes", CalverOrdering, "2024-05-01", "2024-10-01", -1},
+		{"calver missing numbers", CalverOrdering, "24.5", "24.5.0", 0},
+		{"numeric", NumericOrdering, "1000", "999", 1},
+		{"numeric huge", NumericOrdering, "123456789012345678901234567890", "123456789012345678901234567891", -1},
+		{"auto semver", AutoOrdering, "1.10.0-rc.1", "1.9.0", 1},
+		{"auto calver", AutoOrdering, "2024.5", "2024.10", -1},
+		{"auto build ID", AutoOrdering, "41", "42", -1},
+		{"regex", timestamp, "main-20240501T101500", "feature-20240501T093000", 1},
+		{"regex same", timestamp, "a-20240501T101500", "b-20240501T101500", 0},
+	}
+	for _, tt := range tests {
+		result, err := tt.ordering(tt.a, tt.b)
+		test.MustSucceed(t, err, "Failed comparing tags for "+tt.name)
+		test.AssertExpected(t, result, tt.expected, "Comparison doesn't match for "+tt.name)
+	}
+
+	unordered := []struct {
+		name     string
+		ordering Ordering
+		a        string
+		b        string
+	}{
+		{"semver", SemverOrdering, "1.2", "1.3.0"},
+		{"numeric", NumericOrdering, "v12", "13"},
+		{"auto commit hashes", AutoOrdering, "5f3c2a9", "0d4b17c"},
+		{"regex", timestamp, "latest", "main-20240501T101500"},
+	}
+	for _, tt := range unordered {
+		_, err := tt.ordering(tt.a, tt.b)
+		test.AssertExpected(t, errors.Is(err, ErrUnordered), true, "Tags should not be ordered for "+tt.name)
+	}
+}
+
+func TestParseOrdering(t *testing.T) {
+	ordering, err := ParseOrdering("regex", `build-(\d+)`)
+	test.MustSucceed(t, err, "Failed parsing regex ordering")
+	result, err := ordering("build-100", "build-99")
+	test.MustSucceed(t, err, "Failed comparing tags")
+	test.AssertExpected(t, result, 1, "Captured numbers should be compared as numbers")
+
+	_, err = ParseOrdering("regex", `build-\d+`)
+	test.AssertExpected(t, errors.Is(err, ErrInvalidOrdering), true, "Patterns without capture groups should be invalid")
+	_, err = ParseOrdering("regex", `build-(\d+`)
+	test.AssertExpected(t, errors.Is(err, ErrInvalidOrdering), true, "Invalid patterns should be invalid")
+	_, err = ParseOrdering("alphabetical", "")
+	test.AssertExpected(t, errors.Is(err, ErrInvalidOrdering), true, "Unknown orderings should be invalid")
+}
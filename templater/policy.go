@@ -0,0 +1,113 @@
+package templater
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/neosperience/shipper/common"
+)
+
+var (
+	// ErrDowngrade happens when an update would move a tag backwards and the policy refuses it
+	ErrDowngrade = common.Categorize(common.ErrPolicy, errors.New("update would downgrade tag"))
+	// ErrInvalidAction happens when the downgrade action is unknown
+	ErrInvalidAction = errors.New("invalid downgrade action")
+)
+
+// Action is what a policy does with updates that would move a tag backwards
+type Action string
+
+const (
+	ActionAllow  Action = "allow"
+	ActionSkip   Action = "skip"
+	ActionRefuse Action = "refuse"
+)
+
+// ParseAction returns the downgrade action with the given name
+func ParseAction(name string) (Action, error) {
+	switch action := Action(name); action {
+	case ActionAllow, ActionSkip, ActionRefuse:
+		return action, nil
+	default:
+		return "", fmt.Errorf("%w: %s", ErrInvalidAction, name)
+	}
+}
+
+// Outcome is what a policy decided for an update
+type Outcome string
+
+const (
+	// OutcomeUpgrade means the tag moves forward, or stays on the same version
+	OutcomeUpgrade Outcome = "upgrade"
+	// OutcomeUnordered means the tags couldn't be compared, so the update was applied
+	OutcomeUnordered Outcome = "unordered"
+	// OutcomeDowngradeAllowed means the tag moves backwards and the policy allowed it
+	OutcomeDowngradeAllowed Outcome = "downgrade_allowed"
+	// OutcomeDowngradeSkipped means the tag would move backwards and the update was left out
+	OutcomeDowngradeSkipped Outcome = "downgrade_skipped"
+	// OutcomeDowngradeRefused means the tag would move backwards and the whole run was refused
+	OutcomeDowngradeRefused Outcome = "downgrade_refused"
+)
+
+// Decision is the outcome of checking an update of a tag
+type Decision struct {
+	File    string
+	Name    string
+	From    string
+	To      string
+	Outcome Outcome
+}
+
+// Policy checks that updates don't move tags backwards, remembering its decisions.
+// A nil policy applies every update without comparing tags.
+type Policy struct {
+	ordering  Ordering
+	action    Action
+	decisions []Decision
+}
+
+// NewPolicy creates a policy comparing tags with ordering and handling downgrades with action
+func NewPolicy(ordering Ordering, action Action) *Policy {
+	return &Policy{ordering: ordering, action: action}
+}
+
+// Check decides whether the tag of name in file can be updated from a tag to another, returning ErrDowngrade
+// if the update is refused. Updates setting a tag for the first time or keeping it are not checked.
+func (p *Policy) Check(file string, name string, from string, to string) (bool, error) {
+	if p == nil || from == "" || from == to {
+		return true, nil
+	}
+
+	decision := Decision{File: file, Name: name, From: from, To: to, Outcome: OutcomeUpgrade}
+	result, err := p.ordering(to, from)
+	switch {
+	case errors.Is(err, ErrUnordered):
+		decision.Outcome = OutcomeUnordered
+	case err != nil:
+		return false, err
+	case result < 0 && p.action == ActionSkip:
+		decision.Outcome = OutcomeDowngradeSkipped
+	case result < 0 && p.action == ActionRefuse:
+		decision.Outcome = OutcomeDowngradeRefused
+	case result < 0:
+		decision.Outcome = OutcomeDowngradeAllowed
+	}
+	p.decisions = append(p.decisions, decision)
+
+	switch decision.Outcome {
+	case OutcomeDowngradeSkipped:
+		return false, nil
+	case OutcomeDowngradeRefused:
+		return false, fmt.Errorf("%w: %s in %s from %s to %s", ErrDowngrade, name, file, from, to)
+	default:
+		return true, nil
+	}
+}
+
+// Decisions returns the decisions taken so far, in the order updates were checked
+func (p *Policy) Decisions() []Decision {
+	if p == nil {
+		return nil
+	}
+	return p.decisions
+}
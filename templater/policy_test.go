@@ -0,0 +1,60 @@
+package templater
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/neosperience/shipper/test"
+)
+
+func TestPolicy(t *testing.T) {
+	policy := NewPolicy(AutoOrdering, ActionSkip)
+
+	apply, err := policy.Check("values.yaml", "api", "1.2.0", "1.3.0")
+	test.MustSucceed(t, err, "Failed checking upgrade")
+	test.AssertExpected(t, apply, true, "Upgrades should be applied")
+
+	apply, err = policy.Check("values.yaml", "worker", "1.2.0", "1.1.0")
+	test.MustSucceed(t, err, "Failed checking downgrade")
+	test.AssertExpected(t, apply, false, "Downgrades should be skipped")
+
+	apply, err = policy.Check("values.yaml", "cron", "5f3c2a9", "0d4b17c")
+	test.MustSucceed(t, err, "Failed checking unordered tags")
+	test.AssertExpected(t, apply, true, "Tags that can't be compared should be applied")
+
+	apply, err = policy.Check("values.yaml", "web", "", "1.0.0")
+	test.MustSucceed(t, err, "Failed checking new tag")
+	test.AssertExpected(t, apply, true, "New tags should be applied")
+
+	decisions := policy.Decisions()
+	test.AssertExpected(t, len(decisions), 3, "New tags should not be decisions")
+	test.AssertExpected(t, decisions[0].Outcome, OutcomeUpgrade, "Upgrade outcome doesn't match")
+	test.AssertExpected(t, decisions[1].Outcome, OutcomeDowngradeSkipped, "Skipped downgrade outcome doesn't match")
+	test.AssertExpected(t, decisions[2].Outcome, OutcomeUnordered, "Unordered outcome doesn't match")
+
+	policy = NewPolicy(AutoOrdering, ActionRefuse)
+	_, err = policy.Check("values.yaml", "api", "1.2.0", "1.1.0")
+	test.AssertExpected(t, errors.Is(err, ErrDowngrade), true, "Downgrades should be refused")
+	test.AssertExpected(t, policy.Decisions()[0].Outcome, OutcomeDowngradeRefused, "Refused downgrades should be decisions")
+
+	policy = NewPolicy(AutoOrdering, ActionAllow)
+	apply, err = policy.Check("values.yaml", "api", "1.2.0", "1.1.0")
+	test.MustSucceed(t, err, "Failed checking allowed downgrade")
+	test.AssertExpected(t, apply, true, "Allowed downgrades should be applied")
+	test.AssertExpected(t, policy.Decisions()[0].Outcome, OutcomeDowngradeAllowed, "Allowed downgrade outcome doesn't match")
+
+	var disabled *Policy
+	apply, err = disabled.Check("values.yaml", "api", "1.2.0", "1.1.0")
+	test.MustSucceed(t, err, "Failed checking without policy")
+	test.AssertExpected(t, apply, true, "Updates should be applied without policy")
+	test.AssertExpected(t, len(disabled.Decisions()), 0, "No decisions should be taken without policy")
+}
+
+func TestParseAction(t *testing.T) {
+	action, err := ParseAction("refuse")
+	test.MustSucceed(t, err, "Failed parsing action")
+	test.AssertExpected(t, action, ActionRefuse, "Action doesn't match")
+
+	_, err = ParseAction("ignore")
+	test.AssertExpected(t, errors.Is(err, ErrInvalidAction), true, "Unknown actions should be invalid")
+}
@@ -0,0 +1,20 @@
+package templater
+
+import "errors"
+
+var (
+	// ErrImageNotFound happens if a file doesn't reference the image being looked up
+	ErrImageNotFound = errors.New("image not found")
+)
+
+// Image is an image deployed by a file, as read by the templaters
+type Image struct {
+	// File is the path of the file in the repository
+	File string `json:"file"`
+	// Name is what the image was looked up by: the image path for Helm, the image name for Kustomize and the key for JSON
+	Name string `json:"name"`
+	// Image is the deployed image, not set by the JSON templater which only stores tags
+	Image string `json:"image,omitempty"`
+	// Tag is the deployed tag
+	Tag string `json:"tag"`
+}
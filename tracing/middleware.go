@@ -0,0 +1,49 @@
+package tracing
+
+import (
+	"net/http"
+
+	"github.com/neosperience/shipper/common"
+)
+
+// Middleware creates a client span for every HTTP request and propagates it to the server
+// with the traceparent header, so the request can be followed into services that are traced too
+func (t *Tracer) Middleware() common.Middleware {
+	return func(next http.RoundTripper) http.RoundTripper {
+		if t == nil {
+			return next
+		}
+		return common.RoundTripperFunc(func(req *http.Request) (*http.Response, error) {
+			// Query strings can carry credentials, so they are left out of the URL
+			uri := *req.URL
+			uri.RawQuery = ""
+			uri.User = nil
+			span := t.StartKind("HTTP "+req.Method, KindClient,
+				"http.request.method", req.Method,
+				"url.full", uri.String(),
+				"server.address", req.URL.Hostname(),
+			)
+
+			req = req.Clone(req.Context())
+			req.Header.Set("traceparent", span.Context.Traceparent())
+			res, err := next.RoundTrip(req)
+			if res != nil {
+				span.SetAttributes("http.response.status_code", res.StatusCode)
+				if err == nil && res.StatusCode >= 400 {
+					span.Finish(&statusError{res.Status})
+					return res, err
+				}
+			}
+			span.Finish(err)
+			return res, err
+		})
+	}
+}
+
+type statusError struct {
+	status string
+}
+
+func (e *statusError) Error() string {
+	return "request returned " + e.status
+}
@@ -0,0 +1,182 @@
+package tracing
+
+import (
+	"bytes"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	jsoniter "github.com/json-iterator/go"
+
+	"github.com/neosperience/shipper/common"
+)
+
+// OTLPExporter exports spans to an OpenTelemetry collector using OTLP/HTTP with JSON encoding
+type OTLPExporter struct {
+	endpoint string
+	headers  http.Header
+	resource []otlpAttribute
+	scope    otlpScope
+
+	client *http.Client
+}
+
+// NewOTLPExporter creates an OTLPExporter. endpoint is the collector's base URL (eg. "http://localhost:4318"),
+// spans are sent to its /v1/traces path. If client is nil, a default HTTP client is used.
+func NewOTLPExporter(endpoint string, headers http.Header, serviceName string, version string, client *http.Client) *OTLPExporter {
+	if client == nil {
+		client = &http.Client{}
+	}
+	return &OTLPExporter{
+		endpoint: strings.TrimSuffix(endpoint, "/") + "/v1/traces",
+		headers:  headers,
+		resource: otlpAttributes([]any{"service.name", serviceName, "service.version", version}),
+		scope:    otlpScope{Name: "shipper", Version: version},
+		client:   client,
+	}
+}
+
+// ParseHeaders parses headers in the OTEL_EXPORTER_OTLP_HEADERS format ("key1=value1,key2=value2")
+func ParseHeaders(value string) (http.Header, error) {
+	headers := make(http.Header)
+	for _, pair := range strings.Split(value, ",") {
+		if strings.TrimSpace(pair) == "" {
+			continue
+		}
+		key, value, ok := strings.Cut(pair, "=")
+		if !ok {
+			return nil, fmt.Errorf("invalid header %q, expected key=value", pair)
+		}
+		headers.Add(strings.TrimSpace(key), strings.TrimSpace(value))
+	}
+	return headers, nil
+}
+
+type otlpRequest struct {
+	ResourceSpans []otlpResourceSpans `json:"resourceSpans"`
+}
+
+type otlpResourceSpans struct {
+	Resource struct {
+		Attributes []otlpAttribute `json:"attributes"`
+	} `json:"resource"`
+	ScopeSpans []otlpScopeSpans `json:"scopeSpans"`
+}
+
+type otlpScopeSpans struct {
+	Scope otlpScope  `json:"scope"`
+	Spans []otlpSpan `json:"spans"`
+}
+
+type otlpScope struct {
+	Name    string `json:"name"`
+	Version string `json:"version"`
+}
+
+type otlpSpan struct {
+	TraceID           string          `json:"traceId"`
+	SpanID            string          `json:"spanId"`
+	ParentSpanID      string          `json:"parentSpanId,omitempty"`
+	Name              string          `json:"name"`
+	Kind              SpanKind        `json:"kind"`
+	StartTimeUnixNano string          `json:"startTimeUnixNano"`
+	EndTimeUnixNano   string          `json:"endTimeUnixNano"`
+	Attributes        []otlpAttribute `json:"attributes"`
+	Status            otlpStatus      `json:"status"`
+}
+
+type otlpStatus struct {
+	Code    int    `json:"code"`
+	Message string `json:"message,omitempty"`
+}
+
+// OTLP status codes
+const (
+	otlpStatusOK    = 1
+	otlpStatusError = 2
+)
+
+type otlpAttribute struct {
+	Key   string    `json:"key"`
+	Value otlpValue `json:"value"`
+}
+
+type otlpValue struct {
+	StringValue *string  `json:"stringValue,omitempty"`
+	IntValue    *string  `json:"intValue,omitempty"`
+	DoubleValue *float64 `json:"doubleValue,omitempty"`
+	BoolValue   *bool    `json:"boolValue,omitempty"`
+}
+
+func otlpAttributes(keysAndValues []any) []otlpAttribute {
+	attributes := []otlpAttribute{}
+	for index := 0; index+1 < len(keysAndValues); index += 2 {
+		var value otlpValue
+		switch typed := keysAndValues[index+1].(type) {
+		case bool:
+			value.BoolValue = &typed
+		case int:
+			text := strconv.Itoa(typed)
+			value.IntValue = &text
+		case int64:
+			text := strconv.FormatInt(typed, 10)
+			value.IntValue = &text
+		case float64:
+			value.DoubleValue = &typed
+		case error:
+			text := common.Redact(typed.Error())
+			value.StringValue = &text
+		default:
+			text := common.Redact(fmt.Sprint(typed))
+			value.StringValue = &text
+		}
+		attributes = append(attributes, otlpAttribute{Key: fmt.Sprint(keysAndValues[index]), Value: value})
+	}
+	return attributes
+}
+
+// Export sends spans to the collector
+func (e *OTLPExporter) Export(spans []*Span) error {
+	scopeSpans := otlpScopeSpans{Scope: e.scope}
+	for _, span := range spans {
+		converted := otlpSpan{
+			TraceID:           hex.EncodeToString(span.Context.TraceID[:]),
+			SpanID:            hex.EncodeToString(span.Context.SpanID[:]),
+			Name:              span.Name,
+			Kind:              span.Kind,
+			StartTimeUnixNano: strconv.FormatInt(span.Start.UnixNano(), 10),
+			EndTimeUnixNano:   strconv.FormatInt(span.End.UnixNano(), 10),
+			Attributes:        otlpAttributes(span.Attributes),
+			Status:            otlpStatus{Code: otlpStatusOK},
+		}
+		if span.ParentID != [8]byte{} {
+			converted.ParentSpanID = hex.EncodeToString(span.ParentID[:])
+		}
+		if span.Err != nil {
+			converted.Status = otlpStatus{Code: otlpStatusError, Message: common.Redact(span.Err.Error())}
+		}
+		scopeSpans.Spans = append(scopeSpans.Spans, converted)
+	}
+
+	resourceSpans := otlpResourceSpans{ScopeSpans: []otlpScopeSpans{scopeSpans}}
+	resourceSpans.Resource.Attributes = e.resource
+
+	b := new(bytes.Buffer)
+	err := jsoniter.ConfigFastest.NewEncoder(b).Encode(otlpRequest{ResourceSpans: []otlpResourceSpans{resourceSpans}})
+	if err != nil {
+		return fmt.Errorf("error encoding spans: %w", err)
+	}
+
+	headers := e.headers.Clone()
+	if headers == nil {
+		headers = make(http.Header)
+	}
+	headers.Set("Content-Type", "application/json")
+	res, err := common.HTTPRequest(e.client, "POST", e.endpoint, b, headers)
+	if err != nil {
+		return fmt.Errorf("error exporting spans: %w", err)
+	}
+	return res.Body.Close()
+}
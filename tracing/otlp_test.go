@@ -0,0 +1,137 @@
+package tracing
+
+import (
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	jsoniter "github.com/json-iterator/go"
+
+	"github.com/neosperience/shipper/common"
+	"github.com/neosperience/shipper/targets"
+	"github.com/neosperience/shipper/test"
+)
+
+// collector is a stand-in for an OpenTelemetry collector, recording received spans by name
+type collector struct {
+	server   *httptest.Server
+	requests []otlpRequest
+	spans    map[string]otlpSpan
+	headers  http.Header
+}
+
+func newCollector(t *testing.T) *collector {
+	c := &collector{spans: make(map[string]otlpSpan)}
+	c.server = httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		if req.URL.Path != "/v1/traces" || req.Header.Get("Content-Type") != "application/json" {
+			http.Error(rw, "not found", http.StatusNotFound)
+			return
+		}
+		c.headers = req.Header
+		body, _ := ioutil.ReadAll(req.Body)
+		var request otlpRequest
+		if err := jsoniter.ConfigFastest.Unmarshal(body, &request); err != nil {
+			t.Errorf("invalid OTLP request: %s", err)
+		}
+		c.requests = append(c.requests, request)
+		for _, resource := range request.ResourceSpans {
+			for _, scope := range resource.ScopeSpans {
+				for _, span := range scope.Spans {
+					c.spans[span.Name] = span
+				}
+			}
+		}
+	}))
+	return c
+}
+
+func attribute(span otlpSpan, key string) otlpValue {
+	for _, attr := range span.Attributes {
+		if attr.Key == key {
+			return attr.Value
+		}
+	}
+	return otlpValue{}
+}
+
+func TestOTLPExport(t *testing.T) {
+	collector := newCollector(t)
+	defer collector.server.Close()
+
+	var traceparent string
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		traceparent = req.Header.Get("traceparent")
+		if req.URL.Path == "/missing" {
+			http.Error(rw, "not found", http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	headers, err := ParseHeaders("Authorization=Bearer collector-token, X-Tenant = shipper")
+	test.MustSucceed(t, err, "Failed parsing headers")
+	tracer := NewTracer(NewOTLPExporter(collector.server.URL+"/", headers, "shipper", "1.2.3", nil))
+	parent, _ := ParseTraceparent("00-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-01")
+	tracer.SetParent(parent)
+
+	client := &http.Client{Transport: common.Chain(server.Client().Transport, tracer.Middleware())}
+	repository := WrapRepository(tracer, targets.NewInMemoryRepository(targets.FileList{}))
+
+	run := tracer.Start("shipper", "target", "gitlab")
+	_, err = repository.Get("missing.yaml", "main")
+	test.MustFail(t, err, "Getting missing file succeeded")
+	_, err = common.HTTPRequest(client, "GET", server.URL+"/missing?private_token=secret", nil, nil)
+	test.MustFail(t, err, "Request to missing path succeeded")
+	err = repository.Commit(&targets.CommitPayload{Branch: "main", Files: targets.FileList{"a.yaml": []byte("a")}})
+	test.MustSucceed(t, err, "Failed committing")
+	run.Finish(errors.New("deployment failed"))
+
+	test.MustSucceed(t, tracer.Flush(), "Failed exporting spans")
+	test.AssertExpected(t, len(collector.requests), 1, "Spans were not sent in a single request")
+	test.AssertExpected(t, collector.headers.Get("Authorization"), "Bearer collector-token", "Exporter headers were not sent")
+	test.AssertExpected(t, collector.headers.Get("X-Tenant"), "shipper", "Exporter headers were not sent")
+	test.AssertExpected(t, len(collector.spans), 4, "Unexpected number of spans")
+	test.AssertExpected(t, *collector.requests[0].ResourceSpans[0].Resource.Attributes[0].Value.StringValue, "shipper", "Service name doesn't match")
+
+	root := collector.spans["shipper"]
+	test.AssertExpected(t, root.TraceID, "0af7651916cd43dd8448eb211c80319c", "Run span didn't join the CI trace")
+	test.AssertExpected(t, root.ParentSpanID, "b7ad6b7169203331", "Run span is not a child of the CI span")
+	test.AssertExpected(t, root.Status.Code, otlpStatusError, "Run span is not marked as failed")
+	test.AssertExpected(t, root.Status.Message, "deployment failed", "Run span status message doesn't match")
+	test.AssertExpected(t, *attribute(root, "target").StringValue, "gitlab", "Run span attribute doesn't match")
+
+	get := collector.spans["repository get"]
+	test.AssertExpected(t, get.ParentSpanID, root.SpanID, "Get span is not a child of the run span")
+	test.AssertExpected(t, get.Status.Code, otlpStatusOK, "Missing files should not fail the get span")
+	test.AssertExpected(t, *attribute(get, "found").BoolValue, false, "Get span attribute doesn't match")
+
+	request := collector.spans["HTTP GET"]
+	test.AssertExpected(t, request.Kind, KindClient, "HTTP span is not a client span")
+	test.AssertExpected(t, request.Status.Code, otlpStatusError, "Failed HTTP request is not marked as failed")
+	test.AssertExpected(t, *attribute(request, "http.response.status_code").IntValue, "404", "HTTP status code attribute doesn't match")
+	test.AssertExpected(t, *attribute(request, "url.full").StringValue, server.URL+"/missing", "Query string was not removed from the URL")
+	test.AssertExpected(t, traceparent, "00-"+request.TraceID+"-"+request.SpanID+"-01", "Trace context was not propagated to the server")
+
+	commit := collector.spans["repository commit"]
+	test.AssertExpected(t, *attribute(commit, "files").IntValue, "1", "Commit span attribute doesn't match")
+}
+
+func TestOTLPExportFaultyCollector(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		http.Error(rw, "unavailable", http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+
+	tracer := NewTracer(NewOTLPExporter(server.URL, nil, "shipper", "dev", server.Client()))
+	tracer.Start("shipper").Finish(nil)
+	test.MustFail(t, tracer.Flush(), "Exporting to a faulty collector succeeded")
+}
+
+func TestParseHeaders(t *testing.T) {
+	_, err := ParseHeaders("invalid")
+	test.MustFail(t, err, "Parsing invalid headers succeeded")
+	headers, err := ParseHeaders("")
+	test.MustSucceed(t, err, "Failed parsing empty headers")
+	test.AssertExpected(t, len(headers), 0, "Empty headers are not empty")
+}
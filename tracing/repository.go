@@ -0,0 +1,81 @@
+package tracing
+
+import (
+	"errors"
+
+	"github.com/neosperience/shipper/targets"
+)
+
+// tracedRepository creates a span for every operation on a repository
+type tracedRepository struct {
+	repository targets.Repository
+	tracer     *Tracer
+}
+
+// WrapRepository returns a repository creating spans for its operations, or repository itself if tracer is nil
+func WrapRepository(tracer *Tracer, repository targets.Repository) targets.Repository {
+	if tracer == nil {
+		return repository
+	}
+	return &tracedRepository{repository: repository, tracer: tracer}
+}
+
+func (r *tracedRepository) Get(path string, ref string) ([]byte, error) {
+	span := r.tracer.Start("repository get", "file", path, "ref", ref)
+	byt, err := r.repository.Get(path, ref)
+	span.SetAttributes("found", err == nil)
+	if errors.Is(err, targets.ErrFileNotFound) {
+		// Missing files are expected, templaters create them
+		span.Finish(nil)
+	} else {
+		span.Finish(err)
+	}
+	return byt, err
+}
+
+func (r *tracedRepository) Commit(payload *targets.CommitPayload) error {
+	span := r.tracer.Start("repository commit",
+		"branch", payload.Branch,
+		"files", len(payload.Files),
+		"author", payload.Author,
+	)
+	err := r.repository.Commit(payload)
+	span.Finish(err)
+	return err
+}
+
+func (r *tracedRepository) ResolveCommit(ref string) (string, error) {
+	span := r.tracer.Start("repository resolve commit", "ref", ref)
+	commitID, err := targets.ResolveCommit(r.repository, ref)
+	span.SetAttributes("commit", commitID)
+	if errors.Is(err, targets.ErrUnsupported) {
+		span.Finish(nil)
+	} else {
+		span.Finish(err)
+	}
+	return commitID, err
+}
+
+func (r *tracedRepository) History(path string, ref string, limit int) ([]targets.Commit, error) {
+	span := r.tracer.Start("repository history", "file", path, "ref", ref, "limit", limit)
+	commits, err := targets.History(r.repository, path, ref, limit)
+	span.SetAttributes("commits", len(commits))
+	if errors.Is(err, targets.ErrUnsupported) {
+		span.Finish(nil)
+	} else {
+		span.Finish(err)
+	}
+	return commits, err
+}
+
+func (r *tracedRepository) GetRevision(path string, commitID string) ([]byte, error) {
+	span := r.tracer.Start("repository get revision", "file", path, "commit", commitID)
+	byt, err := targets.GetRevision(r.repository, path, commitID)
+	span.SetAttributes("found", err == nil)
+	if errors.Is(err, targets.ErrFileNotFound) || errors.Is(err, targets.ErrUnsupported) {
+		span.Finish(nil)
+	} else {
+		span.Finish(err)
+	}
+	return byt, err
+}
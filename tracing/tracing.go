@@ -0,0 +1,219 @@
+package tracing
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"regexp"
+	"sync"
+	"time"
+)
+
+// SpanKind tells whether a span is internal to shipper or a request to another service
+type SpanKind int
+
+// Values follow the OTLP SpanKind enumeration
+const (
+	KindInternal SpanKind = 1
+	KindClient   SpanKind = 3
+)
+
+var (
+	ErrInvalidTraceparent = errors.New("invalid traceparent")
+
+	// W3C Trace Context traceparent header: version-traceid-parentid-flags
+	traceparentRegex = regexp.MustCompile(`^([0-9a-f]{2})-([0-9a-f]{32})-([0-9a-f]{16})-([0-9a-f]{2})$`)
+)
+
+// SpanContext identifies a span within a trace
+type SpanContext struct {
+	TraceID [16]byte
+	SpanID  [8]byte
+	Sampled bool
+}
+
+// IsValid checks that the span context has non-zero IDs
+func (sc SpanContext) IsValid() bool {
+	return sc.TraceID != [16]byte{} && sc.SpanID != [8]byte{}
+}
+
+// Traceparent returns the span context as a W3C traceparent header value
+func (sc SpanContext) Traceparent() string {
+	flags := "00"
+	if sc.Sampled {
+		flags = "01"
+	}
+	return fmt.Sprintf("00-%s-%s-%s", hex.EncodeToString(sc.TraceID[:]), hex.EncodeToString(sc.SpanID[:]), flags)
+}
+
+// ParseTraceparent parses a W3C traceparent header value, such as the TRACEPARENT variable set by CI systems
+func ParseTraceparent(value string) (SpanContext, error) {
+	match := traceparentRegex.FindStringSubmatch(value)
+	if match == nil || match[1] == "ff" {
+		return SpanContext{}, fmt.Errorf("%w: %q", ErrInvalidTraceparent, value)
+	}
+
+	var sc SpanContext
+	_, _ = hex.Decode(sc.TraceID[:], []byte(match[2]))
+	_, _ = hex.Decode(sc.SpanID[:], []byte(match[3]))
+	flags, _ := hex.DecodeString(match[4])
+	sc.Sampled = flags[0]&1 == 1
+	if !sc.IsValid() {
+		return SpanContext{}, fmt.Errorf("%w: %q", ErrInvalidTraceparent, value)
+	}
+	return sc, nil
+}
+
+// Span is a timed operation within a trace
+type Span struct {
+	tracer *Tracer
+
+	Name     string
+	Kind     SpanKind
+	Context  SpanContext
+	ParentID [8]byte
+	Start    time.Time
+	End      time.Time
+
+	// Attributes as alternating keys and values
+	Attributes []any
+
+	// Err is set if the operation failed
+	Err error
+}
+
+// SetAttributes adds attributes, as alternating keys and values, to the span
+func (s *Span) SetAttributes(keysAndValues ...any) {
+	if s == nil {
+		return
+	}
+	s.tracer.mutex.Lock()
+	defer s.tracer.mutex.Unlock()
+	s.Attributes = append(s.Attributes, keysAndValues...)
+}
+
+// Finish ends the span, marking it as failed if err is not nil
+func (s *Span) Finish(err error) {
+	if s == nil {
+		return
+	}
+	s.tracer.finish(s, err)
+}
+
+// Exporter sends finished spans to a tracing backend
+type Exporter interface {
+	Export(spans []*Span) error
+}
+
+// Tracer creates spans and exports them when flushed. Since shipper does one thing at a time, spans are nested
+// under the innermost span that is still running, without having to pass it around.
+// All methods can be called on a nil Tracer, which doesn't trace anything.
+type Tracer struct {
+	exporter Exporter
+
+	mutex    sync.Mutex
+	parent   SpanContext
+	active   []*Span
+	finished []*Span
+}
+
+// NewTracer creates a Tracer exporting spans with exporter
+func NewTracer(exporter Exporter) *Tracer {
+	return &Tracer{exporter: exporter}
+}
+
+// SetParent makes spans without a running parent join an existing trace, such as the CI pipeline's
+func (t *Tracer) SetParent(parent SpanContext) {
+	if t == nil {
+		return
+	}
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	t.parent = parent
+}
+
+// Start starts an internal span with attributes as alternating keys and values
+func (t *Tracer) Start(name string, keysAndValues ...any) *Span {
+	return t.StartKind(name, KindInternal, keysAndValues...)
+}
+
+// StartKind starts a span of the given kind with attributes as alternating keys and values
+func (t *Tracer) StartKind(name string, kind SpanKind, keysAndValues ...any) *Span {
+	if t == nil {
+		return nil
+	}
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	span := &Span{
+		tracer:     t,
+		Name:       name,
+		Kind:       kind,
+		Start:      time.Now(),
+		Attributes: keysAndValues,
+	}
+	parent := t.parent
+	if len(t.active) > 0 {
+		parent = t.active[len(t.active)-1].Context
+	}
+	if parent.IsValid() {
+		span.Context.TraceID = parent.TraceID
+		span.Context.Sampled = parent.Sampled
+		span.ParentID = parent.SpanID
+	} else {
+		_, _ = rand.Read(span.Context.TraceID[:])
+		span.Context.Sampled = true
+	}
+	_, _ = rand.Read(span.Context.SpanID[:])
+
+	t.active = append(t.active, span)
+	return span
+}
+
+func (t *Tracer) finish(span *Span, err error) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	span.End = time.Now()
+	span.Err = err
+	for index, active := range t.active {
+		if active == span {
+			t.active = append(t.active[:index], t.active[index+1:]...)
+			break
+		}
+	}
+	// Spans of traces not sampled upstream still propagate the trace, but are never exported
+	if span.Context.Sampled {
+		t.finished = append(t.finished, span)
+	}
+}
+
+// Current returns the innermost running span, or nil if there is none
+func (t *Tracer) Current() *Span {
+	if t == nil {
+		return nil
+	}
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	if len(t.active) == 0 {
+		return nil
+	}
+	return t.active[len(t.active)-1]
+}
+
+// Flush exports the spans finished so far
+func (t *Tracer) Flush() error {
+	if t == nil {
+		return nil
+	}
+	t.mutex.Lock()
+	spans := t.finished
+	t.finished = nil
+	t.mutex.Unlock()
+
+	if len(spans) == 0 {
+		return nil
+	}
+	return t.exporter.Export(spans)
+}
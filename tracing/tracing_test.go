@@ -0,0 +1,89 @@
+package tracing
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/neosperience/shipper/test"
+)
+
+type memoryExporter struct {
+	spans []*Span
+}
+
+func (m *memoryExporter) Export(spans []*Span) error {
+	m.spans = append(m.spans, spans...)
+	return nil
+}
+
+func TestParseTraceparent(t *testing.T) {
+	sc, err := ParseTraceparent("00-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-01")
+	test.MustSucceed(t, err, "Failed parsing valid traceparent")
+	test.AssertExpected(t, sc.Sampled, true, "Sampled flag doesn't match")
+	test.AssertExpected(t, sc.Traceparent(), "00-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-01", "Traceparent doesn't round trip")
+
+	for _, value := range []string{
+		"",
+		"00-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331",
+		"00-00000000000000000000000000000000-b7ad6b7169203331-01",
+		"00-0af7651916cd43dd8448eb211c80319c-0000000000000000-01",
+		"ff-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-01",
+		"00-0AF7651916CD43DD8448EB211C80319C-b7ad6b7169203331-01",
+	} {
+		_, err := ParseTraceparent(value)
+		test.MustFail(t, err, "Parsing invalid traceparent succeeded: "+value)
+		test.AssertExpected(t, errors.Is(err, ErrInvalidTraceparent), true, "Error is not ErrInvalidTraceparent")
+	}
+}
+
+func TestTracerNesting(t *testing.T) {
+	exporter := &memoryExporter{}
+	tracer := NewTracer(exporter)
+	parent, _ := ParseTraceparent("00-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-01")
+	tracer.SetParent(parent)
+
+	run := tracer.Start("run")
+	step := tracer.Start("step", "key", "value")
+	test.AssertExpected(t, tracer.Current(), step, "Current span is not the innermost one")
+	step.Finish(errors.New("failed"))
+	test.AssertExpected(t, tracer.Current(), run, "Current span didn't go back to the parent")
+	sibling := tracer.Start("sibling")
+	sibling.Finish(nil)
+	run.Finish(nil)
+	test.AssertExpected(t, tracer.Current() == nil, true, "Spans are still running")
+
+	test.MustSucceed(t, tracer.Flush(), "Failed flushing spans")
+	test.AssertExpected(t, len(exporter.spans), 3, "Unexpected number of exported spans")
+	test.AssertExpected(t, run.Context.TraceID, parent.TraceID, "Run span didn't join the parent trace")
+	test.AssertExpected(t, run.ParentID, parent.SpanID, "Run span is not a child of the parent span")
+	test.AssertExpected(t, step.ParentID, run.Context.SpanID, "Step span is not a child of the run span")
+	test.AssertExpected(t, sibling.ParentID, run.Context.SpanID, "Sibling span is not a child of the run span")
+	test.AssertExpected(t, step.Err.Error(), "failed", "Step span error doesn't match")
+
+	// Spans are only exported once
+	test.MustSucceed(t, tracer.Flush(), "Failed flushing spans")
+	test.AssertExpected(t, len(exporter.spans), 3, "Spans were exported twice")
+}
+
+func TestTracerUnsampled(t *testing.T) {
+	exporter := &memoryExporter{}
+	tracer := NewTracer(exporter)
+	parent, _ := ParseTraceparent("00-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-00")
+	tracer.SetParent(parent)
+
+	run := tracer.Start("run")
+	tracer.Start("step").Finish(nil)
+	run.Finish(nil)
+	test.AssertExpected(t, run.Context.Sampled, false, "Span should inherit the sampling decision of its parent")
+
+	test.MustSucceed(t, tracer.Flush(), "Failed flushing spans")
+	test.AssertExpected(t, len(exporter.spans), 0, "Spans of an unsampled trace were exported")
+}
+
+func TestNilTracer(t *testing.T) {
+	var tracer *Tracer
+	span := tracer.Start("run")
+	span.SetAttributes("key", "value")
+	span.Finish(nil)
+	test.MustSucceed(t, tracer.Flush(), "Flushing nil tracer failed")
+}